@@ -32,6 +32,7 @@ const (
 	DELETE
 	EXPIRE
 	COPY
+	ENTRIES
 )
 
 type (
@@ -40,6 +41,13 @@ type (
 		atime time.Time
 		value interface{}
 	}
+	// Entry is a copy of a cached value, with the times it was
+	// set and last read.
+	Entry struct {
+		Value interface{}
+		Ctime time.Time
+		Atime time.Time
+	}
 	Cache struct {
 		cache          map[interface{}]*Value
 		ctimeExpiry    time.Duration
@@ -57,6 +65,7 @@ type (
 		error
 		existingValue interface{}
 		mapCopy       map[interface{}]interface{}
+		entries       map[interface{}]Entry
 		value         interface{}
 	}
 )
@@ -139,6 +148,12 @@ func (c *Cache) service() {
 				resp.mapCopy[k] = v.value
 			}
 			req.responseChannel <- resp
+		case ENTRIES:
+			resp.entries = make(map[interface{}]Entry)
+			for k, v := range c.cache {
+				resp.entries[k] = Entry{Value: v.value, Ctime: v.ctime, Atime: v.atime}
+			}
+			req.responseChannel <- resp
 		default:
 			resp.error = fission.MakeError(fission.ErrorInvalidArgument,
 				fmt.Sprintf("invalid request type: %v", req.requestType))
@@ -193,6 +208,18 @@ func (c *Cache) Copy() map[interface{}]interface{} {
 	return resp.mapCopy
 }
 
+// Entries is like Copy, but also returns when each value was set and
+// last read.
+func (c *Cache) Entries() map[interface{}]Entry {
+	respChannel := make(chan *response)
+	c.requestChannel <- &request{
+		requestType:     ENTRIES,
+		responseChannel: respChannel,
+	}
+	resp := <-respChannel
+	return resp.entries
+}
+
 func (c *Cache) expiryService() {
 	for {
 		time.Sleep(time.Minute)
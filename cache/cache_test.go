@@ -45,6 +45,14 @@ func TestCache(t *testing.T) {
 		log.Panicf("expected 2 items")
 	}
 
+	entries := c.Entries()
+	if len(entries) != 2 || entries["a"].Value != "b" {
+		log.Panicf("entries %v", entries)
+	}
+	if entries["a"].Atime.Before(entries["a"].Ctime) || entries["p"].Atime != entries["p"].Ctime {
+		log.Panicf("wrong entry times: %v", entries)
+	}
+
 	err = c.Delete("a")
 	checkErr(err)
 
@@ -17,9 +17,46 @@ limitations under the License.
 package fission
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
+	"math"
+	"net/http"
+	"strings"
+	"time"
 )
 
+// METHOD_ANY in HTTPTrigger.Methods matches any HTTP method.
+const METHOD_ANY = "ANY"
+
+// How requests are grouped for HTTP trigger rate limits: by client
+// IP, by the value of a request header (e.g. an API key), or all
+// together.
+const (
+	RATE_LIMIT_KEY_IP     = "ip"
+	RATE_LIMIT_KEY_HEADER = "header"
+	RATE_LIMIT_KEY_GLOBAL = "global"
+)
+
+// Auth policy types of HTTP triggers
+const (
+	AUTH_TYPE_APIKEY = "apikey"
+	AUTH_TYPE_JWT    = "jwt"
+)
+
+// Default request header carrying API keys
+const DEFAULT_APIKEY_HEADER = "X-Api-Key"
+
+// Minimum length of API keys
+const MIN_APIKEY_LENGTH = 16
+
+// CORS_ORIGIN_ANY in CORSPolicy.AllowOrigins allows any origin.
+const CORS_ORIGIN_ANY = "*"
+
+// Functions get another instance when a router has more than this
+// many requests in flight per instance.
+const TARGET_INFLIGHT_PER_INSTANCE = 10
+
 func UrlForFunction(m *Metadata) string {
 	prefix := "/fission-function"
 	if len(m.Uid) > 0 {
@@ -28,3 +65,84 @@ func UrlForFunction(m *Metadata) string {
 		return fmt.Sprintf("%v/%v", prefix, m.Name)
 	}
 }
+
+// FunctionResources returns the resources of function f, using the
+// defaults from its environment for fields f doesn't set.
+func FunctionResources(f *Function, env *Environment) Resources {
+	r := f.Resources
+	if len(r.CpuRequest) == 0 {
+		r.CpuRequest = env.Resources.CpuRequest
+	}
+	if len(r.CpuLimit) == 0 {
+		r.CpuLimit = env.Resources.CpuLimit
+	}
+	if len(r.MemoryRequest) == 0 {
+		r.MemoryRequest = env.Resources.MemoryRequest
+	}
+	if len(r.MemoryLimit) == 0 {
+		r.MemoryLimit = env.Resources.MemoryLimit
+	}
+	return r
+}
+
+// FunctionTimeout returns the execution timeout of function f, or
+// its environment's default if f doesn't set one.  Zero means no
+// limit.
+func FunctionTimeout(f *Function, env *Environment) time.Duration {
+	timeout := f.Timeout
+	if timeout <= 0 && env != nil {
+		timeout = env.Timeout
+	}
+	if timeout <= 0 {
+		return 0
+	}
+	return time.Duration(timeout) * time.Second
+}
+
+// TriggerMethods returns the HTTP methods matched by trigger t, or
+// nil if it matches any method.
+func TriggerMethods(t *HTTPTrigger) []string {
+	methods := t.Methods
+	if len(methods) == 0 && len(t.Method) > 0 {
+		methods = []string{t.Method}
+	}
+	for _, m := range methods {
+		if strings.ToUpper(m) == METHOD_ANY {
+			return nil
+		}
+	}
+	return methods
+}
+
+// RateLimitBurst returns the number of requests a rate limit allows
+// at once.
+func RateLimitBurst(r *RateLimit) int {
+	if r.Burst > 0 {
+		return r.Burst
+	}
+	return int(math.Ceil(r.RequestsPerSecond))
+}
+
+// FallbackStatus returns the status of a fallback policy's static
+// response.
+func FallbackStatus(p *FallbackPolicy) int {
+	if p.StatusCode > 0 {
+		return p.StatusCode
+	}
+	return http.StatusServiceUnavailable
+}
+
+// HashAPIKey returns the hash APIKeys are stored and compared by.
+func HashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// APIKeyHeader returns the request header carrying API keys for an
+// auth policy.
+func APIKeyHeader(p *AuthPolicy) string {
+	if len(p.APIKeyHeader) > 0 {
+		return p.APIKeyHeader
+	}
+	return DEFAULT_APIKEY_HEADER
+}
@@ -21,12 +21,14 @@ import (
 	"net/http"
 	"os"
 	"runtime/debug"
+	"time"
 
 	log "github.com/Sirupsen/logrus"
 	"github.com/gorilla/handlers"
 	"github.com/gorilla/mux"
 
 	"github.com/fission/fission"
+	poolmgrClient "github.com/fission/fission/poolmgr/client"
 )
 
 type API struct {
@@ -34,14 +36,23 @@ type API struct {
 	HTTPTriggerStore
 	EnvironmentStore
 	WatchStore
+	BuildStore
+	APIKeyStore
+
+	builder BuildRunner           // nil if builds aren't supported
+	poolmgr *poolmgrClient.Client // nil if pool status isn't available
 }
 
-func MakeAPI(rs *ResourceStore) *API {
+func MakeAPI(rs *ResourceStore, builder BuildRunner, poolmgr *poolmgrClient.Client) *API {
 	api := &API{
 		FunctionStore:    FunctionStore{ResourceStore: *rs},
 		HTTPTriggerStore: HTTPTriggerStore{ResourceStore: *rs},
 		EnvironmentStore: EnvironmentStore{ResourceStore: *rs},
 		WatchStore:       WatchStore{ResourceStore: *rs},
+		BuildStore:       BuildStore{ResourceStore: *rs},
+		APIKeyStore:      APIKeyStore{ResourceStore: *rs},
+		builder:          builder,
+		poolmgr:          poolmgr,
 	}
 	return api
 }
@@ -67,7 +78,9 @@ func (api *API) HomeHandler(w http.ResponseWriter, r *http.Request) {
 	fmt.Fprintf(w, "{\"message\": \"Fission API\", \"version\": \"0.1.0\"}\n")
 }
 
-func (api *API) Serve(port int) {
+// Serve serves the controller API until stop is closed, then waits
+// up to shutdownTimeout for in-flight requests to finish.
+func (api *API) Serve(port int, stop <-chan struct{}, shutdownTimeout time.Duration) error {
 	r := mux.NewRouter()
 	r.HandleFunc("/", api.HomeHandler)
 
@@ -88,6 +101,7 @@ func (api *API) Serve(port int) {
 	r.HandleFunc("/v1/environments/{environment}", api.EnvironmentApiGet).Methods("GET")
 	r.HandleFunc("/v1/environments/{environment}", api.EnvironmentApiUpdate).Methods("PUT")
 	r.HandleFunc("/v1/environments/{environment}", api.EnvironmentApiDelete).Methods("DELETE")
+	r.HandleFunc("/v1/environments/{environment}/pools", api.EnvironmentApiPools).Methods("GET")
 
 	r.HandleFunc("/v1/watches", api.WatchApiList).Methods("GET")
 	r.HandleFunc("/v1/watches", api.WatchApiCreate).Methods("POST")
@@ -95,8 +109,22 @@ func (api *API) Serve(port int) {
 	r.HandleFunc("/v1/watches/{watch}", api.WatchApiUpdate).Methods("PUT")
 	r.HandleFunc("/v1/watches/{watch}", api.WatchApiDelete).Methods("DELETE")
 
-	address := fmt.Sprintf(":%v", port)
+	r.HandleFunc("/v1/builds", api.BuildApiList).Methods("GET")
+	r.HandleFunc("/v1/builds", api.BuildApiCreate).Methods("POST")
+	r.HandleFunc("/v1/builds/{build}", api.BuildApiGet).Methods("GET")
+	r.HandleFunc("/v1/builds/{build}", api.BuildApiDelete).Methods("DELETE")
+
+	r.HandleFunc("/v1/apikeys", api.APIKeyApiList).Methods("GET")
+	r.HandleFunc("/v1/apikeys", api.APIKeyApiCreate).Methods("POST")
+	r.HandleFunc("/v1/apikeys/{apiKey}", api.APIKeyApiGet).Methods("GET")
+	r.HandleFunc("/v1/apikeys/{apiKey}", api.APIKeyApiUpdate).Methods("PUT")
+	r.HandleFunc("/v1/apikeys/{apiKey}", api.APIKeyApiDelete).Methods("DELETE")
+
+	server := &http.Server{
+		Addr:    fmt.Sprintf(":%v", port),
+		Handler: handlers.LoggingHandler(os.Stdout, r),
+	}
 
 	log.WithFields(log.Fields{"port": port}).Info("Server started")
-	log.Fatal(http.ListenAndServe(address, handlers.LoggingHandler(os.Stdout, r)))
+	return fission.ServeUntilStopped(server, stop, shutdownTimeout)
 }
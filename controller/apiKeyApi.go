@@ -0,0 +1,157 @@
+/*
+Copyright 2016 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/gorilla/mux"
+
+	"github.com/fission/fission"
+)
+
+func (api *API) APIKeyApiList(w http.ResponseWriter, r *http.Request) {
+	keys, err := api.APIKeyStore.List()
+	if err != nil {
+		api.respondWithError(w, err)
+		return
+	}
+
+	resp, err := json.Marshal(keys)
+	if err != nil {
+		api.respondWithError(w, err)
+		return
+	}
+
+	api.respondWithSuccess(w, resp)
+}
+
+func (api *API) APIKeyApiCreate(w http.ResponseWriter, r *http.Request) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		api.respondWithError(w, err)
+		return
+	}
+
+	var k fission.APIKey
+	err = json.Unmarshal(body, &k)
+	if err != nil {
+		api.respondWithError(w, err)
+		return
+	}
+
+	if len(k.Value) == 0 {
+		api.respondWithError(w, fission.MakeError(fission.ErrorInvalidArgument, "API key needs a value"))
+		return
+	}
+	err = validateAPIKey(&k)
+	if err != nil {
+		api.respondWithError(w, err)
+		return
+	}
+
+	uid, err := api.APIKeyStore.Create(&k)
+	if err != nil {
+		api.respondWithError(w, err)
+		return
+	}
+
+	m := &fission.Metadata{Name: k.Metadata.Name, Uid: uid}
+	resp, err := json.Marshal(m)
+	if err != nil {
+		api.respondWithError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	api.respondWithSuccess(w, resp)
+}
+
+func (api *API) APIKeyApiGet(w http.ResponseWriter, r *http.Request) {
+	var m fission.Metadata
+	m.Name = mux.Vars(r)["apiKey"]
+
+	k, err := api.APIKeyStore.Get(&m)
+	if err != nil {
+		api.respondWithError(w, err)
+		return
+	}
+
+	resp, err := json.Marshal(k)
+	if err != nil {
+		api.respondWithError(w, err)
+		return
+	}
+
+	api.respondWithSuccess(w, resp)
+}
+
+func (api *API) APIKeyApiUpdate(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["apiKey"]
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		api.respondWithError(w, err)
+		return
+	}
+
+	var k fission.APIKey
+	err = json.Unmarshal(body, &k)
+	if err != nil {
+		api.respondWithError(w, err)
+		return
+	}
+
+	if name != k.Metadata.Name {
+		api.respondWithError(w, fission.MakeError(fission.ErrorInvalidArgument, "API key name doesn't match URL"))
+		return
+	}
+	err = validateAPIKey(&k)
+	if err != nil {
+		api.respondWithError(w, err)
+		return
+	}
+
+	uid, err := api.APIKeyStore.Update(&k)
+	if err != nil {
+		api.respondWithError(w, err)
+		return
+	}
+
+	m := &fission.Metadata{Name: k.Metadata.Name, Uid: uid}
+	resp, err := json.Marshal(m)
+	if err != nil {
+		api.respondWithError(w, err)
+		return
+	}
+	api.respondWithSuccess(w, resp)
+}
+
+func (api *API) APIKeyApiDelete(w http.ResponseWriter, r *http.Request) {
+	var m fission.Metadata
+	m.Name = mux.Vars(r)["apiKey"]
+
+	err := api.APIKeyStore.Delete(m)
+	if err != nil {
+		api.respondWithError(w, err)
+		return
+	}
+
+	api.respondWithSuccess(w, []byte(""))
+}
@@ -0,0 +1,100 @@
+/*
+Copyright 2016 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"github.com/satori/go.uuid"
+
+	"github.com/fission/fission"
+)
+
+// APIKeyStore keeps API keys.  Keys are hashed before they're
+// stored; only the hash is ever read back.
+type APIKeyStore struct {
+	ResourceStore
+}
+
+func (ks *APIKeyStore) Create(k *fission.APIKey) (string, error) {
+	hashAPIKey(k)
+	k.Metadata.Uid = uuid.NewV4().String()
+	return k.Metadata.Uid, ks.ResourceStore.create(k)
+}
+
+func (ks *APIKeyStore) Get(m *fission.Metadata) (*fission.APIKey, error) {
+	var k fission.APIKey
+	err := ks.ResourceStore.read(m.Name, &k)
+	if err != nil {
+		return nil, err
+	}
+	return &k, nil
+}
+
+// Update replaces an API key's description, and its value if one is
+// given.
+func (ks *APIKeyStore) Update(k *fission.APIKey) (string, error) {
+	if len(k.Value) == 0 {
+		old, err := ks.Get(&k.Metadata)
+		if err != nil {
+			return "", err
+		}
+		k.KeyHash = old.KeyHash
+	}
+	hashAPIKey(k)
+	k.Metadata.Uid = uuid.NewV4().String()
+	return k.Metadata.Uid, ks.ResourceStore.update(k)
+}
+
+func (ks *APIKeyStore) Delete(m fission.Metadata) error {
+	typeName, err := getTypeName(fission.APIKey{})
+	if err != nil {
+		return err
+	}
+	return ks.ResourceStore.delete(typeName, m.Name)
+}
+
+func (ks *APIKeyStore) List() ([]fission.APIKey, error) {
+	typeName, err := getTypeName(fission.APIKey{})
+	if err != nil {
+		return nil, err
+	}
+
+	bufs, err := ks.ResourceStore.getAll(typeName)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make([]fission.APIKey, 0, len(bufs))
+	js := JsonSerializer{}
+	for _, buf := range bufs {
+		var k fission.APIKey
+		err = js.deserialize([]byte(buf), &k)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, k)
+	}
+
+	return keys, nil
+}
+
+// hashAPIKey replaces the value of a key, if set, with its hash.
+func hashAPIKey(k *fission.APIKey) {
+	if len(k.Value) > 0 {
+		k.KeyHash = fission.HashAPIKey(k.Value)
+		k.Value = ""
+	}
+}
@@ -17,10 +17,13 @@ limitations under the License.
 package controller
 
 import (
+	"errors"
 	"flag"
 	"io/ioutil"
 	"net/http"
 	"os"
+	"reflect"
+	"strings"
 	"testing"
 	"time"
 
@@ -34,6 +37,7 @@ import (
 
 var g struct {
 	client *client.Client
+	rs     *ResourceStore
 }
 
 func assertNameReuseFails(err error, name string) {
@@ -63,6 +67,10 @@ func TestFunctionApi(t *testing.T) {
 			Uid:  "xxx",
 		},
 		Code: "code1",
+		EnvVars: []fission.EnvVar{
+			{Name: "DB_URL", Value: "postgres://db"},
+		},
+		Secrets: []string{"db-password"},
 	}
 	_, err := g.client.FunctionGet(&fission.Metadata{Name: "foo"})
 	assertNotFoundFails(err, "function")
@@ -75,6 +83,14 @@ func TestFunctionApi(t *testing.T) {
 	_, err = g.client.FunctionCreate(testFunc)
 	assertNameReuseFails(err, "function")
 
+	badFunc := *testFunc
+	badFunc.Metadata.Name = "badenv"
+	badFunc.EnvVars = []fission.EnvVar{{Name: "A=B", Value: "x"}}
+	_, err = g.client.FunctionCreate(&badFunc)
+	assert(err != nil, "creating a function with an invalid env var name must fail")
+	fe, ok := err.(fission.Error)
+	assert(ok && fe.Code == fission.ErrorInvalidArgument, "error must be an invalid argument error")
+
 	code, err := g.client.FunctionGetRaw(m)
 	panicIf(err)
 	assert(string(code) == testFunc.Code, "code from FunctionGetRaw must match created function")
@@ -93,7 +109,7 @@ func TestFunctionApi(t *testing.T) {
 	testFunc.Metadata.Uid = m.Uid
 	//log.Printf("f = %#v", f)
 	//log.Printf("testFunc = %#v", testFunc)
-	assert(*f == *testFunc, "first version should match when read by uid")
+	assert(reflect.DeepEqual(f, testFunc), "first version should match when read by uid")
 
 	m.Uid = uid2
 	testFunc.Metadata.Uid = m.Uid
@@ -101,7 +117,7 @@ func TestFunctionApi(t *testing.T) {
 	f, err = g.client.FunctionGet(m)
 	panicIf(err)
 
-	assert(*f == *testFunc, "second version should match when read by uid")
+	assert(reflect.DeepEqual(f, testFunc), "second version should match when read by uid")
 
 	m.Uid = ""
 	testFunc.Metadata.Uid = uid2
@@ -109,7 +125,7 @@ func TestFunctionApi(t *testing.T) {
 	f, err = g.client.FunctionGet(m)
 	panicIf(err)
 
-	assert(*f == *testFunc, "second version should match when read as latest")
+	assert(reflect.DeepEqual(f, testFunc), "second version should match when read as latest")
 
 	testFunc.Metadata.Name = "bar"
 	m, err = g.client.FunctionCreate(testFunc)
@@ -220,7 +236,7 @@ func TestHTTPTriggerApi(t *testing.T) {
 	tr, err := g.client.HTTPTriggerGet(m)
 	panicIf(err)
 	testTrigger.Metadata.Uid = m.Uid
-	assert(*testTrigger == *tr, "trigger should match after reading")
+	assert(reflect.DeepEqual(testTrigger, tr), "trigger should match after reading")
 
 	testTrigger.UrlPattern = "/hi"
 	m2, err := g.client.HTTPTriggerUpdate(testTrigger)
@@ -230,7 +246,7 @@ func TestHTTPTriggerApi(t *testing.T) {
 	tr, err = g.client.HTTPTriggerGet(m)
 	panicIf(err)
 	testTrigger.Metadata.Uid = m.Uid
-	assert(*testTrigger == *tr, "trigger should match after reading")
+	assert(reflect.DeepEqual(testTrigger, tr), "trigger should match after reading")
 
 	testTrigger.Metadata.Name = "yyy"
 	m, err = g.client.HTTPTriggerCreate(testTrigger)
@@ -241,9 +257,80 @@ func TestHTTPTriggerApi(t *testing.T) {
 	panicIf(err)
 	defer g.client.HTTPTriggerDelete(m)
 
+	hostTrigger := &fission.HTTPTrigger{
+		Metadata:   fission.Metadata{Name: "zzz"},
+		Host:       "api.example.com",
+		PathPrefix: "/v2/orders/",
+		Methods:    []string{"GET", "POST"},
+		Function:   fission.Metadata{Name: "foo"},
+	}
+	m, err = g.client.HTTPTriggerCreate(hostTrigger)
+	panicIf(err)
+	defer g.client.HTTPTriggerDelete(m)
+
+	hostTrigger.Metadata.Name = "zzz2"
+	hostTrigger.Methods = []string{"ANY"}
+	_, err = g.client.HTTPTriggerCreate(hostTrigger)
+	assert(err != nil, "trigger with overlapping methods should not be allowed")
+
+	hostTrigger.UrlPattern = "/v2"
+	_, err = g.client.HTTPTriggerCreate(hostTrigger)
+	assert(err != nil, "trigger with both URL pattern and path prefix should not be allowed")
+
+	hostTrigger.UrlPattern = ""
+	hostTrigger.PathPrefix = "/v3/"
+	hostTrigger.RateLimit = &fission.RateLimit{RequestsPerSecond: 10, Key: fission.RATE_LIMIT_KEY_HEADER}
+	_, err = g.client.HTTPTriggerCreate(hostTrigger)
+	assert(err != nil, "rate limit by header without a header name should not be allowed")
+
+	hostTrigger.RateLimit = &fission.RateLimit{RequestsPerSecond: 0}
+	_, err = g.client.HTTPTriggerCreate(hostTrigger)
+	assert(err != nil, "zero rate limit should not be allowed")
+
+	hostTrigger.RateLimit = nil
+	hostTrigger.CORS = &fission.CORSPolicy{AllowOrigins: []string{"*"}, AllowCredentials: true}
+	_, err = g.client.HTTPTriggerCreate(hostTrigger)
+	assert(err != nil, "CORS credentials from any origin should not be allowed")
+
+	hostTrigger.CORS = &fission.CORSPolicy{AllowOrigins: []string{"https://example.com/app"}}
+	_, err = g.client.HTTPTriggerCreate(hostTrigger)
+	assert(err != nil, "CORS origin with a path should not be allowed")
+
+	hostTrigger.CORS = nil
+	hostTrigger.Cache = &fission.CachePolicy{TTL: 0}
+	_, err = g.client.HTTPTriggerCreate(hostTrigger)
+	assert(err != nil, "zero cache TTL should not be allowed")
+
+	hostTrigger.Cache = nil
+	hostTrigger.Shadow = &fission.ShadowPolicy{Function: hostTrigger.Function, SampleRate: 0.5}
+	_, err = g.client.HTTPTriggerCreate(hostTrigger)
+	assert(err != nil, "shadowing a trigger's function with itself should not be allowed")
+
+	hostTrigger.Shadow = &fission.ShadowPolicy{Function: fission.Metadata{Name: "next"}, SampleRate: 1.5}
+	_, err = g.client.HTTPTriggerCreate(hostTrigger)
+	assert(err != nil, "shadow sample rate above 1 should not be allowed")
+
+	hostTrigger.Shadow = nil
+	hostTrigger.Fallback = &fission.FallbackPolicy{Function: &fission.Metadata{Name: "fallback"}, Body: "sorry"}
+	_, err = g.client.HTTPTriggerCreate(hostTrigger)
+	assert(err != nil, "fallback with both a function and a static response should not be allowed")
+
+	hostTrigger.Fallback = &fission.FallbackPolicy{StatusCode: 99}
+	_, err = g.client.HTTPTriggerCreate(hostTrigger)
+	assert(err != nil, "invalid fallback status should not be allowed")
+
+	hostTrigger.Fallback = nil
+	hostTrigger.Retry = &fission.RetryPolicy{MaxAttempts: 0}
+	_, err = g.client.HTTPTriggerCreate(hostTrigger)
+	assert(err != nil, "retry policy without attempts should not be allowed")
+
+	hostTrigger.Retry = &fission.RetryPolicy{MaxAttempts: 3, RetryOn: []int{200}}
+	_, err = g.client.HTTPTriggerCreate(hostTrigger)
+	assert(err != nil, "retrying successful responses should not be allowed")
+
 	ts, err := g.client.HTTPTriggerList()
 	panicIf(err)
-	assert(len(ts) == 2, "created two triggers, but didn't find them")
+	assert(len(ts) == 3, "created three triggers, but didn't find them")
 }
 
 func TestEnvironmentApi(t *testing.T) {
@@ -253,6 +340,12 @@ func TestEnvironmentApi(t *testing.T) {
 			Uid:  "yyy",
 		},
 		RunContainerImageUrl: "gcr.io/xyz",
+		PoolSize:             2,
+		ImagePullPolicy:      "Always",
+		ImagePullSecrets:     []string{"registry-key"},
+		ContainerEnvVars: []fission.EnvVar{
+			{Name: "LOG_LEVEL", Value: "debug"},
+		},
 	}
 	_, err := g.client.EnvironmentGet(&fission.Metadata{Name: "foo"})
 	assertNotFoundFails(err, "environment")
@@ -267,7 +360,7 @@ func TestEnvironmentApi(t *testing.T) {
 	tr, err := g.client.EnvironmentGet(m)
 	panicIf(err)
 	testEnv.Metadata.Uid = m.Uid
-	assert(*testEnv == *tr, "env should match after reading")
+	assert(reflect.DeepEqual(testEnv, tr), "env should match after reading")
 
 	testEnv.RunContainerImageUrl = "/hi"
 	m2, err := g.client.EnvironmentUpdate(testEnv)
@@ -277,7 +370,7 @@ func TestEnvironmentApi(t *testing.T) {
 	tr, err = g.client.EnvironmentGet(m)
 	panicIf(err)
 	testEnv.Metadata.Uid = m.Uid
-	assert(*testEnv == *tr, "env should match after reading")
+	assert(reflect.DeepEqual(testEnv, tr), "env should match after reading")
 
 	testEnv.Metadata.Name = "yyy"
 	m, err = g.client.EnvironmentCreate(testEnv)
@@ -289,6 +382,166 @@ func TestEnvironmentApi(t *testing.T) {
 	assert(len(ts) == 2, "created two envs, but didn't find them")
 }
 
+func TestAPIKeyApi(t *testing.T) {
+	testKey := &fission.APIKey{
+		Metadata:    fission.Metadata{Name: "ci"},
+		Value:       "0123456789abcdef0123",
+		Description: "CI jobs",
+	}
+	_, err := g.client.APIKeyCreate(&fission.APIKey{
+		Metadata: fission.Metadata{Name: "short"},
+		Value:    "abc",
+	})
+	assert(err != nil, "short API key must fail")
+
+	m, err := g.client.APIKeyCreate(testKey)
+	panicIf(err)
+	defer g.client.APIKeyDelete(m)
+
+	_, err = g.client.APIKeyCreate(testKey)
+	assertNameReuseFails(err, "apikey")
+
+	k, err := g.client.APIKeyGet(m)
+	panicIf(err)
+	assert(len(k.Value) == 0, "API key value must not be returned")
+	assert(k.KeyHash == fission.HashAPIKey(testKey.Value), "API key should be stored hashed")
+
+	// updating the description keeps the key
+	k.Description = "CI and CD jobs"
+	_, err = g.client.APIKeyUpdate(k)
+	panicIf(err)
+	k, err = g.client.APIKeyGet(m)
+	panicIf(err)
+	assert(k.Description == "CI and CD jobs", "API key description should be updated")
+	assert(k.KeyHash == fission.HashAPIKey(testKey.Value), "API key should be unchanged")
+
+	// triggers can only use keys that exist
+	trigger := &fission.HTTPTrigger{
+		Metadata:   fission.Metadata{Name: "authed"},
+		Method:     http.MethodGet,
+		UrlPattern: "/authed",
+		Function:   fission.Metadata{Name: "foo", Uid: "yyy"},
+		Auth:       &fission.AuthPolicy{Type: fission.AUTH_TYPE_APIKEY, APIKeys: []string{"nope"}},
+	}
+	_, err = g.client.HTTPTriggerCreate(trigger)
+	assert(err != nil, "trigger with an unknown API key must fail")
+	trigger.Auth.APIKeys = []string{"ci"}
+	tm, err := g.client.HTTPTriggerCreate(trigger)
+	panicIf(err)
+	g.client.HTTPTriggerDelete(tm)
+
+	ks, err := g.client.APIKeyList()
+	panicIf(err)
+	assert(len(ks) == 1, "created an API key, but didn't find it")
+}
+
+// testBuildRunner "compiles" source by prefixing it, and fails on
+// source containing "error".
+type testBuildRunner struct{}
+
+func (tb *testBuildRunner) Build(env *fission.Environment, source []byte) ([]byte, string, error) {
+	if strings.Contains(string(source), "error") {
+		return nil, "syntax error", errors.New("build failed")
+	}
+	return []byte("built:" + string(source)), "ok", nil
+}
+
+func waitForBuild(m *fission.Metadata) *fission.Build {
+	for i := 0; i < 50; i++ {
+		b, err := g.client.BuildGet(m)
+		panicIf(err)
+		if b.Status == fission.BuildStatusSucceeded || b.Status == fission.BuildStatusFailed {
+			return b
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	panic("build didn't finish")
+}
+
+func TestBuildApi(t *testing.T) {
+	testEnv := &fission.Environment{
+		Metadata:             fission.Metadata{Name: "gobuild"},
+		RunContainerImageUrl: "fission/go-env",
+		BuilderImageUrl:      "fission/go-builder",
+	}
+	em, err := g.client.EnvironmentCreate(testEnv)
+	panicIf(err)
+	defer g.client.EnvironmentDelete(em)
+
+	testBuild := &fission.Build{
+		Function: fission.Function{
+			Metadata:    fission.Metadata{Name: "built"},
+			Environment: fission.Metadata{Name: "gobuild"},
+		},
+	}
+	m, err := g.client.BuildCreate(testBuild, []byte("src1"))
+	panicIf(err)
+	defer g.client.BuildDelete(m)
+
+	b := waitForBuild(m)
+	assert(b.Status == fission.BuildStatusSucceeded, "build should succeed")
+	assert(len(b.Function.Metadata.Uid) > 0, "build should record the function version")
+	assert(len(b.Source) == 0, "build source must not be returned")
+
+	code, err := g.client.FunctionGetRaw(&b.Function.Metadata)
+	panicIf(err)
+	assert(string(code) == "built:src1", "function code should be the build output")
+	defer g.client.FunctionDelete(&fission.Metadata{Name: "built"})
+
+	// builds create functions unless they're updates
+	_, err = g.client.BuildCreate(testBuild, []byte("src2"))
+	assertNameReuseFails(err, "function")
+	testBuild.Update = true
+	_, err = g.client.BuildCreate(&fission.Build{Function: fission.Function{
+		Metadata:    fission.Metadata{Name: "unbuilt"},
+		Environment: fission.Metadata{Name: "gobuild"},
+	}, Update: true}, []byte("src"))
+	assertNotFoundFails(err, "function")
+
+	m, err = g.client.BuildCreate(testBuild, []byte("error"))
+	panicIf(err)
+	defer g.client.BuildDelete(m)
+
+	b = waitForBuild(m)
+	assert(b.Status == fission.BuildStatusFailed, "build should fail")
+	assert(strings.Contains(b.Log, "syntax error"), "failed build should keep the build log")
+
+	f, err := g.client.FunctionGet(&fission.Metadata{Name: "built"})
+	panicIf(err)
+	assert(f.Code == "built:src1", "failed build must not change the function")
+
+	testBuild.Function.Environment.Name = "xxx"
+	_, err = g.client.BuildCreate(testBuild, []byte("src"))
+	assert(err != nil, "build with an environment without builder must fail")
+}
+
+func TestFailInterruptedBuilds(t *testing.T) {
+	// A controller that stopped mid-build leaves the build running.
+	bs := BuildStore{ResourceStore: *g.rs}
+	running := &fission.Build{
+		Function: fission.Function{
+			Metadata:    fission.Metadata{Name: "interrupted"},
+			Environment: fission.Metadata{Name: "gobuild"},
+		},
+	}
+	_, err := bs.Create(running, []byte("src"))
+	panicIf(err)
+	defer g.client.BuildDelete(&running.Metadata)
+	running.Status = fission.BuildStatusRunning
+	panicIf(bs.Update(running))
+
+	// The restarted controller fails it on startup.
+	err = MakeAPI(g.rs, &testBuildRunner{}, nil).FailInterruptedBuilds()
+	panicIf(err)
+
+	b, err := g.client.BuildGet(&running.Metadata)
+	panicIf(err)
+	assert(b.Status == fission.BuildStatusFailed, "interrupted build should fail")
+	assert(strings.Contains(b.Log, "controller restart"), "interrupted build log should say why it failed")
+	_, err = bs.GetSource(running)
+	assert(err != nil, "interrupted build source should be deleted")
+}
+
 func TestWatchApi(t *testing.T) {
 	testWatch := &fission.Watch{
 		Metadata: fission.Metadata{
@@ -337,15 +590,18 @@ func TestMain(m *testing.M) {
 	fileStore, ks, rs := getTestResourceStore()
 	defer os.RemoveAll(fileStore.root)
 
-	api := MakeAPI(rs)
+	api := MakeAPI(rs, &testBuildRunner{}, nil)
 	g.client = client.MakeClient("http://localhost:8888")
+	g.rs = rs
 
 	ks.Delete(context.Background(), "Function", &etcdClient.DeleteOptions{Recursive: true})
 	ks.Delete(context.Background(), "HTTPTrigger", &etcdClient.DeleteOptions{Recursive: true})
 	ks.Delete(context.Background(), "Environment", &etcdClient.DeleteOptions{Recursive: true})
 	ks.Delete(context.Background(), "Watch", &etcdClient.DeleteOptions{Recursive: true})
+	ks.Delete(context.Background(), "Build", &etcdClient.DeleteOptions{Recursive: true})
+	ks.Delete(context.Background(), "APIKey", &etcdClient.DeleteOptions{Recursive: true})
 
-	go api.Serve(8888)
+	go api.Serve(8888, nil, 0)
 	time.Sleep(500 * time.Millisecond)
 
 	resp, err := http.Get("http://localhost:8888/")
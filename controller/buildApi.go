@@ -0,0 +1,236 @@
+/*
+Copyright 2016 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/gorilla/mux"
+
+	"github.com/fission/fission"
+)
+
+func (api *API) BuildApiList(w http.ResponseWriter, r *http.Request) {
+	builds, err := api.BuildStore.List()
+	if err != nil {
+		api.respondWithError(w, err)
+		return
+	}
+
+	resp, err := json.Marshal(builds)
+	if err != nil {
+		api.respondWithError(w, err)
+		return
+	}
+
+	api.respondWithSuccess(w, resp)
+}
+
+func (api *API) BuildApiCreate(w http.ResponseWriter, r *http.Request) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		api.respondWithError(w, err)
+		return
+	}
+	defer r.Body.Close()
+
+	var b fission.Build
+	err = json.Unmarshal(body, &b)
+	if err != nil {
+		api.respondWithError(w, err)
+		return
+	}
+
+	if api.builder == nil {
+		err = fission.MakeError(fission.ErrorNotImplmented, "This controller can't run builds")
+		api.respondWithError(w, err)
+		return
+	}
+
+	if len(b.Function.Metadata.Name) == 0 {
+		err = fission.MakeError(fission.ErrorInvalidArgument, "Build needs a function name")
+		api.respondWithError(w, err)
+		return
+	}
+	err = validateFunctionConfig(&b.Function)
+	if err != nil {
+		api.respondWithError(w, err)
+		return
+	}
+
+	// Fail early if the function can't be created or updated; the
+	// build checks again when it stores the function.
+	_, err = api.FunctionStore.Get(&fission.Metadata{Name: b.Function.Metadata.Name})
+	if !b.Update {
+		if err == nil {
+			err = fission.MakeError(fission.ErrorNameExists,
+				fmt.Sprintf("Function %v already exists", b.Function.Metadata.Name))
+		} else if fe, ok := err.(fission.Error); ok && fe.Code == fission.ErrorNotFound {
+			err = nil
+		}
+	}
+	if err != nil {
+		api.respondWithError(w, err)
+		return
+	}
+
+	env, err := api.EnvironmentStore.Get(&b.Function.Environment)
+	if err != nil {
+		api.respondWithError(w, err)
+		return
+	}
+	if len(env.BuilderImageUrl) == 0 {
+		err = fission.MakeError(fission.ErrorInvalidArgument,
+			fmt.Sprintf("Environment %v has no builder image", env.Metadata.Name))
+		api.respondWithError(w, err)
+		return
+	}
+
+	source, err := base64.StdEncoding.DecodeString(b.Source)
+	if err != nil {
+		api.respondWithError(w, err)
+		return
+	}
+	b.Function.Code = ""
+
+	_, err = api.BuildStore.Create(&b, source)
+	if err != nil {
+		api.respondWithError(w, err)
+		return
+	}
+
+	go api.runBuild(b, env)
+
+	resp, err := json.Marshal(b.Metadata)
+	if err != nil {
+		api.respondWithError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	api.respondWithSuccess(w, resp)
+}
+
+func (api *API) BuildApiGet(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	m := fission.Metadata{Name: vars["build"]}
+
+	b, err := api.BuildStore.Get(&m)
+	if err != nil {
+		api.respondWithError(w, err)
+		return
+	}
+
+	resp, err := json.Marshal(b)
+	if err != nil {
+		api.respondWithError(w, err)
+		return
+	}
+	api.respondWithSuccess(w, resp)
+}
+
+func (api *API) BuildApiDelete(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	m := fission.Metadata{Name: vars["build"]}
+
+	err := api.BuildStore.Delete(m)
+	if err != nil {
+		api.respondWithError(w, err)
+		return
+	}
+	api.respondWithSuccess(w, []byte(""))
+}
+
+// runBuild builds b's source and stores the resulting package as a
+// new version of b's function, recording the outcome in b.
+func (api *API) runBuild(b fission.Build, env *fission.Environment) {
+	b.Status = fission.BuildStatusRunning
+	err := api.BuildStore.Update(&b)
+	if err != nil {
+		log.Errorf("Failed to update build %v: %v", b.Metadata.Name, err)
+	}
+
+	err = api.build(&b, env)
+	if err != nil {
+		log.WithFields(log.Fields{"build": b.Metadata.Name, "function": b.Function.Metadata.Name}).Errorf("Build failed: %v", err)
+		b.Status = fission.BuildStatusFailed
+		b.Log += fmt.Sprintf("\n%v\n", err)
+	} else {
+		b.Status = fission.BuildStatusSucceeded
+	}
+
+	err = api.BuildStore.Update(&b)
+	if err != nil {
+		log.Errorf("Failed to update build %v: %v", b.Metadata.Name, err)
+	}
+	api.BuildStore.DeleteSource(&b) // ignore errors
+}
+
+// FailInterruptedBuilds marks builds that were pending or running
+// when the controller last stopped as failed. Their goroutines and
+// build pods went away with the controller, so nothing would ever
+// finish them otherwise. Call it on startup, before serving.
+func (api *API) FailInterruptedBuilds() error {
+	builds, err := api.BuildStore.List()
+	if err != nil {
+		return err
+	}
+	for i := range builds {
+		b := &builds[i]
+		if b.Status != fission.BuildStatusPending && b.Status != fission.BuildStatusRunning {
+			continue
+		}
+		log.WithFields(log.Fields{"build": b.Metadata.Name, "function": b.Function.Metadata.Name}).Warn("Failing build interrupted by a controller restart")
+		b.Status = fission.BuildStatusFailed
+		b.Log += "\nBuild interrupted by a controller restart\n"
+		err = api.BuildStore.Update(b)
+		if err != nil {
+			log.Errorf("Failed to update build %v: %v", b.Metadata.Name, err)
+			continue
+		}
+		api.BuildStore.DeleteSource(b) // ignore errors
+	}
+	return nil
+}
+
+func (api *API) build(b *fission.Build, env *fission.Environment) error {
+	source, err := api.BuildStore.GetSource(b)
+	if err != nil {
+		return err
+	}
+
+	pkg, buildLog, err := api.builder.Build(env, source)
+	b.Log = buildLog
+	if err != nil {
+		return err
+	}
+
+	f := b.Function
+	f.Code = string(pkg)
+
+	if b.Update {
+		b.Function.Metadata.Uid, err = api.FunctionStore.Update(&f)
+	} else {
+		b.Function.Metadata.Uid, err = api.FunctionStore.Create(&f)
+	}
+	return err
+}
@@ -0,0 +1,112 @@
+/*
+Copyright 2016 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"github.com/satori/go.uuid"
+
+	"github.com/fission/fission"
+)
+
+// BuildStore keeps build records in etcd and build sources in the
+// file store, until the build has run.
+type BuildStore struct {
+	ResourceStore
+}
+
+func (bs *BuildStore) Create(b *fission.Build, source []byte) (string, error) {
+	b.Metadata.Name = uuid.NewV4().String()
+	b.Metadata.Uid = b.Metadata.Name
+	b.Status = fission.BuildStatusPending
+	b.Source = ""
+
+	err := bs.ResourceStore.FileStore.write(bs.sourceFile(b), source)
+	if err != nil {
+		return "", err
+	}
+
+	err = bs.ResourceStore.create(b)
+	if err != nil {
+		bs.ResourceStore.FileStore.delete(bs.sourceFile(b)) // ignore errors
+		return "", err
+	}
+	return b.Metadata.Name, nil
+}
+
+func (bs *BuildStore) Get(m *fission.Metadata) (*fission.Build, error) {
+	var b fission.Build
+	err := bs.ResourceStore.read(m.Name, &b)
+	if err != nil {
+		return nil, err
+	}
+	return &b, nil
+}
+
+func (bs *BuildStore) Update(b *fission.Build) error {
+	return bs.ResourceStore.update(b)
+}
+
+// GetSource returns the source uploaded with build b.
+func (bs *BuildStore) GetSource(b *fission.Build) ([]byte, error) {
+	return bs.ResourceStore.FileStore.read(bs.sourceFile(b))
+}
+
+// DeleteSource removes the source of build b, once it's no longer
+// needed.
+func (bs *BuildStore) DeleteSource(b *fission.Build) error {
+	return bs.ResourceStore.FileStore.delete(bs.sourceFile(b))
+}
+
+func (bs *BuildStore) Delete(m fission.Metadata) error {
+	typeName, err := getTypeName(fission.Build{})
+	if err != nil {
+		return err
+	}
+	err = bs.ResourceStore.FileStore.delete(bs.sourceFile(&fission.Build{Metadata: m}))
+	if err != nil {
+		return err
+	}
+	return bs.ResourceStore.delete(typeName, m.Name)
+}
+
+func (bs *BuildStore) List() ([]fission.Build, error) {
+	typeName, err := getTypeName(fission.Build{})
+	if err != nil {
+		return nil, err
+	}
+
+	bufs, err := bs.ResourceStore.getAll(typeName)
+	if err != nil {
+		return nil, err
+	}
+
+	builds := make([]fission.Build, 0, len(bufs))
+	js := JsonSerializer{}
+	for _, buf := range bufs {
+		var b fission.Build
+		err = js.deserialize([]byte(buf), &b)
+		if err != nil {
+			return nil, err
+		}
+		builds = append(builds, b)
+	}
+	return builds, nil
+}
+
+func (bs *BuildStore) sourceFile(b *fission.Build) string {
+	return "build-" + b.Metadata.Name
+}
@@ -0,0 +1,202 @@
+/*
+Copyright 2016 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/dchest/uniuri"
+	"k8s.io/client-go/1.5/kubernetes"
+	"k8s.io/client-go/1.5/pkg/api/v1"
+	"k8s.io/client-go/1.5/rest"
+
+	"github.com/fission/fission"
+)
+
+// Builder images serve POST /build on this port.  The request body
+// is the function source; the response is a buildResponse, with a
+// non-200 status if the build failed.
+const BUILDER_PORT = 8001
+
+const (
+	BUILD_POD_READY_TIMEOUT = 5 * time.Minute
+	BUILD_TIMEOUT           = 10 * time.Minute
+)
+
+type (
+	// BuildRunner compiles function source into a deployable
+	// package with an environment's builder image.  It returns
+	// the package and the build log; on failure, the log (if
+	// any) says why.
+	BuildRunner interface {
+		Build(env *fission.Environment, source []byte) ([]byte, string, error)
+	}
+
+	// KubernetesBuildRunner runs each build in its own pod,
+	// which is deleted once the build is done.
+	KubernetesBuildRunner struct {
+		kubernetesClient *kubernetes.Clientset
+		namespace        string
+	}
+
+	buildResponse struct {
+		Package []byte `json:"package"`
+		Log     string `json:"log"`
+	}
+)
+
+// MakeKubernetesBuildRunner returns a build runner using the pod's
+// service account, so it only works inside a kubernetes cluster.
+func MakeKubernetesBuildRunner(namespace string) (*KubernetesBuildRunner, error) {
+	config, err := rest.InClusterConfig()
+	if err != nil {
+		return nil, err
+	}
+	clientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	return &KubernetesBuildRunner{
+		kubernetesClient: clientset,
+		namespace:        namespace,
+	}, nil
+}
+
+func (kb *KubernetesBuildRunner) Build(env *fission.Environment, source []byte) ([]byte, string, error) {
+	pod, err := kb.createBuildPod(env)
+	if err != nil {
+		return nil, "", err
+	}
+	defer func() {
+		err := kb.kubernetesClient.Core().Pods(kb.namespace).Delete(pod.ObjectMeta.Name, nil)
+		if err != nil {
+			log.Errorf("Failed to delete build pod %v: %v", pod.ObjectMeta.Name, err)
+		}
+	}()
+
+	podIP, err := kb.waitForPod(pod.ObjectMeta.Name)
+	if err != nil {
+		return nil, "", err
+	}
+
+	buildUrl := fmt.Sprintf("http://%v:%v/build", podIP, BUILDER_PORT)
+	client := &http.Client{Timeout: BUILD_TIMEOUT}
+
+	// retry a few times in case the builder server hasn't come up yet
+	var resp *http.Response
+	maxRetries := 10
+	for i := 0; i < maxRetries; i++ {
+		resp, err = client.Post(buildUrl, "application/octet-stream", bytes.NewReader(source))
+		if err == nil {
+			break
+		}
+		if urlErr, ok := err.(*url.Error); ok {
+			if netErr, ok := urlErr.Err.(*net.OpError); ok && netErr.Op == "dial" && i < maxRetries-1 {
+				time.Sleep(500 * time.Duration(2*i) * time.Millisecond)
+				continue
+			}
+		}
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, "", err
+	}
+	var br buildResponse
+	err = json.Unmarshal(body, &br)
+	if err != nil {
+		return nil, string(body), fmt.Errorf("Invalid response from builder (%v): %v", resp.Status, err)
+	}
+	if resp.StatusCode != 200 {
+		return nil, br.Log, fmt.Errorf("Build failed: %v", resp.Status)
+	}
+	return br.Package, br.Log, nil
+}
+
+func (kb *KubernetesBuildRunner) createBuildPod(env *fission.Environment) (*v1.Pod, error) {
+	pullPolicy := v1.PullIfNotPresent
+	if len(env.ImagePullPolicy) > 0 {
+		pullPolicy = v1.PullPolicy(env.ImagePullPolicy)
+	}
+	pullSecrets := make([]v1.LocalObjectReference, 0, len(env.ImagePullSecrets))
+	for _, name := range env.ImagePullSecrets {
+		pullSecrets = append(pullSecrets, v1.LocalObjectReference{Name: name})
+	}
+
+	pod := &v1.Pod{
+		ObjectMeta: v1.ObjectMeta{
+			Name: fmt.Sprintf("build-%v-%v", env.Metadata.Name, strings.ToLower(uniuri.NewLen(8))),
+			Labels: map[string]string{
+				"buildEnvName": env.Metadata.Name,
+				"buildEnvUid":  env.Metadata.Uid,
+			},
+		},
+		Spec: v1.PodSpec{
+			RestartPolicy:    v1.RestartPolicyNever,
+			ImagePullSecrets: pullSecrets,
+			Containers: []v1.Container{
+				{
+					Name:                   "builder",
+					Image:                  env.BuilderImageUrl,
+					ImagePullPolicy:        pullPolicy,
+					TerminationMessagePath: "/dev/termination-log",
+				},
+			},
+		},
+	}
+	return kb.kubernetesClient.Core().Pods(kb.namespace).Create(pod)
+}
+
+// waitForPod polls until the build pod is running and ready, and
+// returns its IP.
+func (kb *KubernetesBuildRunner) waitForPod(name string) (string, error) {
+	startTime := time.Now()
+	for {
+		pod, err := kb.kubernetesClient.Core().Pods(kb.namespace).Get(name)
+		if err != nil {
+			return "", err
+		}
+		if pod.Status.Phase == v1.PodFailed || pod.Status.Phase == v1.PodSucceeded {
+			return "", fmt.Errorf("Build pod exited (%v)", pod.Status.Phase)
+		}
+		if len(pod.Status.PodIP) > 0 && pod.Status.Phase == v1.PodRunning {
+			ready := true
+			for _, cs := range pod.Status.ContainerStatuses {
+				ready = ready && cs.Ready
+			}
+			if ready {
+				return pod.Status.PodIP, nil
+			}
+		}
+		if time.Now().Sub(startTime) > BUILD_POD_READY_TIMEOUT {
+			return "", errors.New("timeout: waited too long for build pod to be ready")
+		}
+		time.Sleep(time.Second)
+	}
+}
@@ -442,6 +442,28 @@ func (c *Client) EnvironmentList() ([]fission.Environment, error) {
 	return envs, nil
 }
 
+// EnvironmentPools returns the status of the pools of generic pods
+// for an environment.
+func (c *Client) EnvironmentPools(m *fission.Metadata) ([]fission.PoolStatus, error) {
+	resp, err := http.Get(c.url(fmt.Sprintf("environments/%v/pools", m.Name)))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := c.handleResponse(resp)
+	if err != nil {
+		return nil, err
+	}
+
+	pools := make([]fission.PoolStatus, 0)
+	err = json.Unmarshal(body, &pools)
+	if err != nil {
+		return nil, err
+	}
+	return pools, nil
+}
+
 func (c *Client) WatchCreate(w *fission.Watch) (*fission.Metadata, error) {
 	reqbody, err := json.Marshal(w)
 	if err != nil {
@@ -528,3 +550,178 @@ func (c *Client) WatchList() ([]fission.Watch, error) {
 
 	return watches, err
 }
+
+// BuildCreate starts a build of source for function b.Function; the
+// returned metadata identifies the build.
+func (c *Client) BuildCreate(b *fission.Build, source []byte) (*fission.Metadata, error) {
+	b.Source = base64.StdEncoding.EncodeToString(source)
+	defer func() { b.Source = "" }()
+
+	reqbody, err := json.Marshal(b)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := http.Post(c.url("builds"), "application/json", bytes.NewReader(reqbody))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := c.handleCreateResponse(resp)
+	if err != nil {
+		return nil, err
+	}
+
+	var m fission.Metadata
+	err = json.Unmarshal(body, &m)
+	if err != nil {
+		return nil, err
+	}
+
+	return &m, nil
+}
+
+func (c *Client) BuildGet(m *fission.Metadata) (*fission.Build, error) {
+	resp, err := http.Get(c.url(fmt.Sprintf("builds/%v", m.Name)))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := c.handleResponse(resp)
+	if err != nil {
+		return nil, err
+	}
+
+	var b fission.Build
+	err = json.Unmarshal(body, &b)
+	if err != nil {
+		return nil, err
+	}
+
+	return &b, nil
+}
+
+func (c *Client) BuildDelete(m *fission.Metadata) error {
+	return c.delete(fmt.Sprintf("builds/%v", m.Name))
+}
+
+func (c *Client) BuildList() ([]fission.Build, error) {
+	resp, err := http.Get(c.url("builds"))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := c.handleResponse(resp)
+	if err != nil {
+		return nil, err
+	}
+
+	builds := make([]fission.Build, 0)
+	err = json.Unmarshal(body, &builds)
+	if err != nil {
+		return nil, err
+	}
+
+	return builds, nil
+}
+
+// APIKeyCreate stores an API key.  Its value is hashed by the
+// controller, and can't be read back.
+func (c *Client) APIKeyCreate(k *fission.APIKey) (*fission.Metadata, error) {
+	reqbody, err := json.Marshal(k)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := http.Post(c.url("apikeys"), "application/json", bytes.NewReader(reqbody))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := c.handleCreateResponse(resp)
+	if err != nil {
+		return nil, err
+	}
+
+	var m fission.Metadata
+	err = json.Unmarshal(body, &m)
+	if err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+func (c *Client) APIKeyGet(m *fission.Metadata) (*fission.APIKey, error) {
+	resp, err := http.Get(c.url(fmt.Sprintf("apikeys/%v", m.Name)))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := c.handleResponse(resp)
+	if err != nil {
+		return nil, err
+	}
+
+	var k fission.APIKey
+	err = json.Unmarshal(body, &k)
+	if err != nil {
+		return nil, err
+	}
+	return &k, nil
+}
+
+// APIKeyUpdate updates an API key's description, and its value if
+// k.Value is set.
+func (c *Client) APIKeyUpdate(k *fission.APIKey) (*fission.Metadata, error) {
+	reqbody, err := json.Marshal(k)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.put(fmt.Sprintf("apikeys/%v", k.Metadata.Name), "application/json", reqbody)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := c.handleResponse(resp)
+	if err != nil {
+		return nil, err
+	}
+
+	var m fission.Metadata
+	err = json.Unmarshal(body, &m)
+	if err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+func (c *Client) APIKeyDelete(m *fission.Metadata) error {
+	return c.delete(fmt.Sprintf("apikeys/%v", m.Name))
+}
+
+func (c *Client) APIKeyList() ([]fission.APIKey, error) {
+	resp, err := http.Get(c.url("apikeys"))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := c.handleResponse(resp)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make([]fission.APIKey, 0)
+	err = json.Unmarshal(body, &keys)
+	if err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
@@ -58,6 +58,12 @@ func (api *API) EnvironmentApiCreate(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	err = validateEnvironment(&env)
+	if err != nil {
+		api.respondWithError(w, err)
+		return
+	}
+
 	uid, err := api.EnvironmentStore.Create(&env)
 	if err != nil {
 		api.respondWithError(w, err)
@@ -119,6 +125,12 @@ func (api *API) EnvironmentApiUpdate(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	err = validateEnvironment(&env)
+	if err != nil {
+		api.respondWithError(w, err)
+		return
+	}
+
 	uid, err := api.EnvironmentStore.Update(&env)
 	if err != nil {
 		api.respondWithError(w, err)
@@ -152,3 +164,36 @@ func (api *API) EnvironmentApiDelete(w http.ResponseWriter, r *http.Request) {
 
 	api.respondWithSuccess(w, []byte(""))
 }
+
+// EnvironmentApiPools returns the pools of generic pods for an
+// environment, from poolmgr.  While an update rolls out, the old
+// version's pool is listed as draining.
+func (api *API) EnvironmentApiPools(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	m := fission.Metadata{Name: vars["environment"]}
+
+	if api.poolmgr == nil {
+		err := fission.MakeError(fission.ErrorNotImplmented, "Pool status is not available")
+		api.respondWithError(w, err)
+		return
+	}
+
+	_, err := api.EnvironmentStore.Get(&m)
+	if err != nil {
+		api.respondWithError(w, err)
+		return
+	}
+
+	pools, err := api.poolmgr.PoolStatus(m.Name)
+	if err != nil {
+		api.respondWithError(w, err)
+		return
+	}
+
+	resp, err := json.Marshal(pools)
+	if err != nil {
+		api.respondWithError(w, err)
+		return
+	}
+	api.respondWithSuccess(w, resp)
+}
@@ -57,6 +57,12 @@ func (api *API) FunctionApiCreate(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	err = validateFunctionConfig(&f)
+	if err != nil {
+		api.respondWithError(w, err)
+		return
+	}
+
 	dec, err := base64.StdEncoding.DecodeString(f.Code)
 	if err != nil {
 		api.respondWithError(w, err)
@@ -131,6 +137,12 @@ func (api *API) FunctionApiUpdate(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	err = validateFunctionConfig(&f)
+	if err != nil {
+		api.respondWithError(w, err)
+		return
+	}
+
 	dec, err := base64.StdEncoding.DecodeString(f.Code)
 	if err != nil {
 		api.respondWithError(w, err)
@@ -83,6 +83,11 @@ func (fs *FunctionStore) Update(f *fission.Function) (string, error) {
 
 	fnew.Metadata.Uid = uid
 	fnew.Environment = f.Environment
+	fnew.EnvVars = f.EnvVars
+	fnew.Secrets = f.Secrets
+	fnew.ConfigMaps = f.ConfigMaps
+	fnew.Resources = f.Resources
+	fnew.Timeout = f.Timeout
 
 	err = fs.ResourceStore.update(fnew)
 	if err != nil {
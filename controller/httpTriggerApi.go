@@ -18,6 +18,7 @@ package controller
 
 import (
 	"encoding/json"
+	"fmt"
 	"io/ioutil"
 	"net/http"
 
@@ -27,6 +28,37 @@ import (
 	"github.com/fission/fission"
 )
 
+// checkHTTPTrigger validates t, makes sure the API keys it accepts
+// exist, and that no other trigger matches the same requests.
+func (api *API) checkHTTPTrigger(t *fission.HTTPTrigger) error {
+	err := validateHTTPTrigger(t)
+	if err != nil {
+		return err
+	}
+
+	if t.Auth != nil {
+		for _, name := range t.Auth.APIKeys {
+			_, err := api.APIKeyStore.Get(&fission.Metadata{Name: name})
+			if err != nil {
+				return fission.MakeError(fission.ErrorInvalidArgument,
+					fmt.Sprintf("API key '%v' doesn't exist", name))
+			}
+		}
+	}
+
+	triggers, err := api.HTTPTriggerStore.List()
+	if err != nil {
+		return err
+	}
+	for _, other := range triggers {
+		if other.Metadata.Name != t.Metadata.Name && triggersConflict(&other, t) {
+			return fission.MakeError(fission.ErrorNameExists,
+				"HTTPTrigger with same host, URL & method already exists")
+		}
+	}
+	return nil
+}
+
 func (api *API) HTTPTriggerApiList(w http.ResponseWriter, r *http.Request) {
 	triggers, err := api.HTTPTriggerStore.List()
 	if err != nil {
@@ -56,19 +88,11 @@ func (api *API) HTTPTriggerApiCreate(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	triggers, err := api.HTTPTriggerStore.List()
+	err = api.checkHTTPTrigger(&t)
 	if err != nil {
 		api.respondWithError(w, err)
 		return
 	}
-	for _, url := range triggers {
-		if url.UrlPattern == t.UrlPattern && url.Method == t.Method {
-			err = fission.MakeError(fission.ErrorNameExists,
-				"HTTPTrigger with same URL & method already exists")
-			api.respondWithError(w, err)
-			return
-		}
-	}
 
 	uid, err := api.HTTPTriggerStore.Create(&t)
 	if err != nil {
@@ -131,6 +155,12 @@ func (api *API) HTTPTriggerApiUpdate(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	err = api.checkHTTPTrigger(&t)
+	if err != nil {
+		api.respondWithError(w, err)
+		return
+	}
+
 	uid, err := api.HTTPTriggerStore.Update(&t)
 	if err != nil {
 		api.respondWithError(w, err)
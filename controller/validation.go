@@ -0,0 +1,406 @@
+/*
+Copyright 2016 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	k8sResource "k8s.io/client-go/1.5/pkg/api/resource"
+
+	"github.com/fission/fission"
+)
+
+// validateFunctionConfig checks the env vars, secret/configmap
+// references, resources and timeout of a function.
+func validateFunctionConfig(f *fission.Function) error {
+	err := validateEnvVars(f.EnvVars)
+	if err != nil {
+		return err
+	}
+	for _, ref := range append(append([]string{}, f.Secrets...), f.ConfigMaps...) {
+		if len(ref) == 0 {
+			return fission.MakeError(fission.ErrorInvalidArgument,
+				"Secret and configmap references must not be empty")
+		}
+	}
+	if f.Timeout < 0 {
+		return fission.MakeError(fission.ErrorInvalidArgument, "Timeout must not be negative")
+	}
+	return validateResources(&f.Resources)
+}
+
+// validateEnvVars checks for invalid or duplicate env var names.
+func validateEnvVars(envVars []fission.EnvVar) error {
+	names := make(map[string]bool)
+	for _, ev := range envVars {
+		if len(ev.Name) == 0 || strings.ContainsAny(ev.Name, "= \t\n") {
+			return fission.MakeError(fission.ErrorInvalidArgument,
+				fmt.Sprintf("Invalid environment variable name '%v'", ev.Name))
+		}
+		if names[ev.Name] {
+			return fission.MakeError(fission.ErrorInvalidArgument,
+				fmt.Sprintf("Duplicate environment variable '%v'", ev.Name))
+		}
+		names[ev.Name] = true
+	}
+	return nil
+}
+
+// validateResources checks that all set resource fields are valid
+// Kubernetes quantities.
+func validateResources(r *fission.Resources) error {
+	quantities := map[string]string{
+		"cpu request":    r.CpuRequest,
+		"cpu limit":      r.CpuLimit,
+		"memory request": r.MemoryRequest,
+		"memory limit":   r.MemoryLimit,
+	}
+	for name, q := range quantities {
+		if len(q) == 0 {
+			continue
+		}
+		_, err := k8sResource.ParseQuantity(q)
+		if err != nil {
+			return fission.MakeError(fission.ErrorInvalidArgument,
+				fmt.Sprintf("Invalid %v '%v': %v", name, q, err))
+		}
+	}
+	return nil
+}
+
+func validateEnvironment(env *fission.Environment) error {
+	if env.Timeout < 0 || env.PodReadyTimeout < 0 || env.IdlePodReapTime < 0 {
+		return fission.MakeError(fission.ErrorInvalidArgument, "Timeouts must not be negative")
+	}
+	if env.PoolSize < 0 {
+		return fission.MakeError(fission.ErrorInvalidArgument, "Pool size must not be negative")
+	}
+	switch env.ImagePullPolicy {
+	case "", "Always", "IfNotPresent", "Never":
+	default:
+		return fission.MakeError(fission.ErrorInvalidArgument,
+			fmt.Sprintf("Invalid image pull policy '%v'", env.ImagePullPolicy))
+	}
+	for _, s := range env.ImagePullSecrets {
+		if len(s) == 0 {
+			return fission.MakeError(fission.ErrorInvalidArgument,
+				"Image pull secret names must not be empty")
+		}
+	}
+	err := validateEnvVars(env.ContainerEnvVars)
+	if err != nil {
+		return err
+	}
+	return validateResources(&env.Resources)
+}
+
+// validateHTTPTrigger checks that a trigger has exactly one of a URL
+// pattern and a path prefix, only known HTTP methods, and a valid
+// rate limit and auth policy if any.
+func validateHTTPTrigger(t *fission.HTTPTrigger) error {
+	if (len(t.UrlPattern) == 0) == (len(t.PathPrefix) == 0) {
+		return fission.MakeError(fission.ErrorInvalidArgument,
+			"HTTPTrigger needs either a URL pattern or a path prefix")
+	}
+	if len(t.PathPrefix) > 0 && !strings.HasPrefix(t.PathPrefix, "/") {
+		return fission.MakeError(fission.ErrorInvalidArgument,
+			fmt.Sprintf("Path prefix '%v' must start with /", t.PathPrefix))
+	}
+	for _, m := range append([]string{t.Method}, t.Methods...) {
+		switch strings.ToUpper(m) {
+		case "", fission.METHOD_ANY, "GET", "HEAD", "POST", "PUT", "PATCH", "DELETE", "CONNECT", "OPTIONS", "TRACE":
+		default:
+			return fission.MakeError(fission.ErrorInvalidArgument,
+				fmt.Sprintf("Invalid HTTP method '%v'", m))
+		}
+	}
+	if t.RateLimit != nil {
+		err := validateRateLimit(t.RateLimit)
+		if err != nil {
+			return err
+		}
+	}
+	if t.Auth != nil {
+		err := validateAuthPolicy(t.Auth)
+		if err != nil {
+			return err
+		}
+	}
+	if t.CORS != nil {
+		err := validateCORSPolicy(t.CORS)
+		if err != nil {
+			return err
+		}
+	}
+	if t.Cache != nil {
+		err := validateCachePolicy(t.Cache, fission.TriggerMethods(t))
+		if err != nil {
+			return err
+		}
+	}
+	if t.Shadow != nil {
+		err := validateShadowPolicy(t.Shadow, &t.Function)
+		if err != nil {
+			return err
+		}
+	}
+	if t.Retry != nil {
+		err := validateRetryPolicy(t.Retry)
+		if err != nil {
+			return err
+		}
+	}
+	if t.Fallback != nil {
+		return validateFallbackPolicy(t.Fallback, &t.Function)
+	}
+	return nil
+}
+
+// Max attempts of a retry policy, so a failing function can't
+// hold requests for too long.
+const MAX_RETRY_ATTEMPTS = 10
+
+func validateRetryPolicy(p *fission.RetryPolicy) error {
+	if p.MaxAttempts < 1 || p.MaxAttempts > MAX_RETRY_ATTEMPTS {
+		return fission.MakeError(fission.ErrorInvalidArgument,
+			fmt.Sprintf("Retry attempts must be between 1 and %v, got %v", MAX_RETRY_ATTEMPTS, p.MaxAttempts))
+	}
+	if p.InitialBackoffMs < 0 || p.MaxBackoffMs < 0 {
+		return fission.MakeError(fission.ErrorInvalidArgument, "Retry backoff must not be negative")
+	}
+	if p.MaxBackoffMs > 0 && p.MaxBackoffMs < p.InitialBackoffMs {
+		return fission.MakeError(fission.ErrorInvalidArgument,
+			"Max retry backoff must not be less than the initial backoff")
+	}
+	for _, status := range p.RetryOn {
+		if status < 400 || status > 599 {
+			return fission.MakeError(fission.ErrorInvalidArgument,
+				fmt.Sprintf("Retried status %v must be a 4xx or 5xx status", status))
+		}
+	}
+	return nil
+}
+
+// validateFallbackPolicy checks that a fallback is either another
+// function or a static response.
+func validateFallbackPolicy(p *fission.FallbackPolicy, function *fission.Metadata) error {
+	if p.Function != nil {
+		if len(p.Function.Name) == 0 {
+			return fission.MakeError(fission.ErrorInvalidArgument, "Fallback function needs a name")
+		}
+		if *p.Function == *function {
+			return fission.MakeError(fission.ErrorInvalidArgument,
+				"Fallback must be another function, or another version of the trigger's function")
+		}
+		if p.StatusCode != 0 || len(p.ContentType) > 0 || len(p.Body) > 0 {
+			return fission.MakeError(fission.ErrorInvalidArgument,
+				"Fallback needs either a function or a static response, not both")
+		}
+		return nil
+	}
+	if p.StatusCode != 0 && (p.StatusCode < 200 || p.StatusCode > 599) {
+		return fission.MakeError(fission.ErrorInvalidArgument,
+			fmt.Sprintf("Fallback status %v must be between 200 and 599", p.StatusCode))
+	}
+	return nil
+}
+
+// validateShadowPolicy checks a trigger's request mirroring.  The
+// shadow must be another function, or another version of the
+// trigger's.
+func validateShadowPolicy(p *fission.ShadowPolicy, function *fission.Metadata) error {
+	if len(p.Function.Name) == 0 {
+		return fission.MakeError(fission.ErrorInvalidArgument, "Shadow needs a function name")
+	}
+	if p.Function.Name == function.Name && p.Function.Uid == function.Uid {
+		return fission.MakeError(fission.ErrorInvalidArgument,
+			"Shadow must be another function, or another version of the trigger's function")
+	}
+	if !(p.SampleRate > 0 && p.SampleRate <= 1) {
+		return fission.MakeError(fission.ErrorInvalidArgument,
+			fmt.Sprintf("Shadow sample rate must be more than 0 and at most 1, got %v", p.SampleRate))
+	}
+	return nil
+}
+
+// validateCachePolicy checks a trigger's response caching.  Only
+// responses to GET requests are cached, so the trigger must match GET.
+func validateCachePolicy(p *fission.CachePolicy, methods []string) error {
+	if p.TTL <= 0 {
+		return fission.MakeError(fission.ErrorInvalidArgument, "Cache TTL must be positive")
+	}
+	if methods != nil && !containsMethod(methods, "GET") {
+		return fission.MakeError(fission.ErrorInvalidArgument, "Response caching needs a trigger matching GET")
+	}
+	for _, h := range p.VaryHeaders {
+		if len(h) == 0 || strings.ContainsAny(h, " ,:") {
+			return fission.MakeError(fission.ErrorInvalidArgument,
+				fmt.Sprintf("Invalid cache vary header '%v'", h))
+		}
+	}
+	for _, q := range p.VaryQuery {
+		if len(q) == 0 {
+			return fission.MakeError(fission.ErrorInvalidArgument, "Cache vary query parameters must not be empty")
+		}
+	}
+	return nil
+}
+
+func containsMethod(methods []string, method string) bool {
+	for _, m := range methods {
+		if strings.ToUpper(m) == method {
+			return true
+		}
+	}
+	return false
+}
+
+// validateCORSPolicy checks that origins are "*" or scheme://host[:port],
+// with at most a leading "*." wildcard in the host.
+func validateCORSPolicy(p *fission.CORSPolicy) error {
+	if len(p.AllowOrigins) == 0 {
+		return fission.MakeError(fission.ErrorInvalidArgument, "CORS policy needs at least one allowed origin")
+	}
+	for _, origin := range p.AllowOrigins {
+		if origin == fission.CORS_ORIGIN_ANY {
+			if p.AllowCredentials {
+				return fission.MakeError(fission.ErrorInvalidArgument,
+					"CORS policy can't allow credentials from any origin")
+			}
+			continue
+		}
+		u, err := url.Parse(origin)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || len(u.Host) == 0 ||
+			len(u.Path) > 0 || len(u.RawQuery) > 0 || len(u.Fragment) > 0 || u.User != nil ||
+			strings.Contains(strings.TrimPrefix(u.Host, "*."), "*") {
+			return fission.MakeError(fission.ErrorInvalidArgument,
+				fmt.Sprintf("Invalid CORS origin '%v', use scheme://host[:port] or *", origin))
+		}
+	}
+	for _, m := range p.AllowMethods {
+		switch strings.ToUpper(m) {
+		case "GET", "HEAD", "POST", "PUT", "PATCH", "DELETE", "CONNECT", "OPTIONS", "TRACE":
+		default:
+			return fission.MakeError(fission.ErrorInvalidArgument,
+				fmt.Sprintf("Invalid CORS method '%v'", m))
+		}
+	}
+	for _, h := range append(p.AllowHeaders, p.ExposeHeaders...) {
+		if len(h) == 0 || strings.ContainsAny(h, " ,:") {
+			return fission.MakeError(fission.ErrorInvalidArgument,
+				fmt.Sprintf("Invalid CORS header '%v'", h))
+		}
+	}
+	if p.MaxAge < 0 {
+		return fission.MakeError(fission.ErrorInvalidArgument, "CORS max age must not be negative")
+	}
+	return nil
+}
+
+// validateAuthPolicy checks that a policy has the settings of its
+// type, and only those.
+func validateAuthPolicy(p *fission.AuthPolicy) error {
+	switch p.Type {
+	case fission.AUTH_TYPE_APIKEY:
+		if p.JWT != nil {
+			return fission.MakeError(fission.ErrorInvalidArgument, "API key auth policy must not have JWT settings")
+		}
+		for _, name := range p.APIKeys {
+			if len(name) == 0 {
+				return fission.MakeError(fission.ErrorInvalidArgument, "API key names must not be empty")
+			}
+		}
+	case fission.AUTH_TYPE_JWT:
+		if len(p.APIKeys) > 0 || len(p.APIKeyHeader) > 0 {
+			return fission.MakeError(fission.ErrorInvalidArgument, "JWT auth policy must not have API key settings")
+		}
+		if p.JWT == nil || (len(p.JWT.JWKSUrl) == 0) == (len(p.JWT.Secret) == 0) {
+			return fission.MakeError(fission.ErrorInvalidArgument,
+				"JWT auth policy needs either a JWKS URL or a shared secret")
+		}
+		if len(p.JWT.JWKSUrl) > 0 {
+			u, err := url.Parse(p.JWT.JWKSUrl)
+			if err != nil || (u.Scheme != "http" && u.Scheme != "https") || len(u.Host) == 0 {
+				return fission.MakeError(fission.ErrorInvalidArgument,
+					fmt.Sprintf("Invalid JWKS URL '%v'", p.JWT.JWKSUrl))
+			}
+		}
+	default:
+		return fission.MakeError(fission.ErrorInvalidArgument,
+			fmt.Sprintf("Invalid auth policy type '%v'", p.Type))
+	}
+	return nil
+}
+
+// validateAPIKey checks an API key's name, and its value if it's
+// being set.
+func validateAPIKey(k *fission.APIKey) error {
+	if len(k.Metadata.Name) == 0 {
+		return fission.MakeError(fission.ErrorInvalidArgument, "API key needs a name")
+	}
+	if len(k.Value) > 0 && len(k.Value) < fission.MIN_APIKEY_LENGTH {
+		return fission.MakeError(fission.ErrorInvalidArgument,
+			fmt.Sprintf("API key must be at least %v characters", fission.MIN_APIKEY_LENGTH))
+	}
+	return nil
+}
+
+func validateRateLimit(r *fission.RateLimit) error {
+	if r.RequestsPerSecond <= 0 {
+		return fission.MakeError(fission.ErrorInvalidArgument, "Rate limit must be positive")
+	}
+	if r.Burst < 0 {
+		return fission.MakeError(fission.ErrorInvalidArgument, "Rate limit burst must not be negative")
+	}
+	switch r.Key {
+	case "", fission.RATE_LIMIT_KEY_IP, fission.RATE_LIMIT_KEY_GLOBAL:
+		if len(r.Header) > 0 {
+			return fission.MakeError(fission.ErrorInvalidArgument,
+				fmt.Sprintf("Rate limit header is only used with key '%v'", fission.RATE_LIMIT_KEY_HEADER))
+		}
+	case fission.RATE_LIMIT_KEY_HEADER:
+		if len(r.Header) == 0 {
+			return fission.MakeError(fission.ErrorInvalidArgument, "Rate limit by header needs a header name")
+		}
+	default:
+		return fission.MakeError(fission.ErrorInvalidArgument,
+			fmt.Sprintf("Invalid rate limit key '%v'", r.Key))
+	}
+	return nil
+}
+
+// triggersConflict returns true if some request would match both
+// triggers equally: same host, path and an overlapping method.
+func triggersConflict(a, b *fission.HTTPTrigger) bool {
+	if a.Host != b.Host || a.UrlPattern != b.UrlPattern || a.PathPrefix != b.PathPrefix {
+		return false
+	}
+	am, bm := fission.TriggerMethods(a), fission.TriggerMethods(b)
+	if am == nil || bm == nil {
+		return true
+	}
+	for _, x := range am {
+		for _, y := range bm {
+			if strings.ToUpper(x) == strings.ToUpper(y) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -1,6 +1,8 @@
 using Fission.DotNetCore.Compiler;
 using Fission.DotNetCore.Api;
 using System.Collections.Generic;
+using System.Runtime.Serialization;
+using System.Runtime.Serialization.Json;
 using Nancy;
 using System.IO;
 using System;
@@ -28,8 +30,50 @@ namespace Fission.DotNetCore
             Delete("/", _ => Run());
         }
 
+        // Body of the specialize request sent by poolmgr.
+        [DataContract]
+        public class SpecializeRequest
+        {
+            [DataMember(Name = "envVars")]
+            public Dictionary<string, string> EnvVars { get; set; }
+        }
+
+        // Applies the function's configuration (env vars, secrets and
+        // configmaps) to this process, before the function is compiled.
+        private void SetEnvVars()
+        {
+            if (Request.Body == null || Request.Body.Length == 0)
+            {
+                return;
+            }
+            var serializer = new DataContractJsonSerializer(typeof(SpecializeRequest),
+                new DataContractJsonSerializerSettings { UseSimpleDictionaryFormat = true });
+            var request = (SpecializeRequest)serializer.ReadObject(Request.Body);
+            if (request.EnvVars == null)
+            {
+                return;
+            }
+            foreach (var envVar in request.EnvVars)
+            {
+                Environment.SetEnvironmentVariable(envVar.Key, envVar.Value);
+            }
+        }
+
         private object Specialize()
         {
+            try
+            {
+                SetEnvVars();
+            }
+            catch (Exception e)
+            {
+                var errstr = $"Invalid specialize request: {e.Message}";
+                _logger.WriteError(errstr);
+                var response = (Response)errstr;
+                response.StatusCode = HttpStatusCode.BadRequest;
+                return response;
+            }
+
             var errors = new List<string>();
             if (File.Exists(CODE_PATH))
             {
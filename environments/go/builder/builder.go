@@ -0,0 +1,80 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+)
+
+// The fission controller posts function source to /build and
+// stores the returned package as a new version of the function.
+// The plugin is built with the same Go toolchain as the runtime
+// image, which the plugin package requires.
+
+type buildResponse struct {
+	Package []byte `json:"package"`
+	Log     string `json:"log"`
+}
+
+func respond(w http.ResponseWriter, status int, resp *buildResponse) {
+	body, err := json.Marshal(resp)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(body)
+}
+
+func buildHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	source, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		respond(w, http.StatusBadRequest, &buildResponse{Log: err.Error()})
+		return
+	}
+
+	dir, err := ioutil.TempDir("", "build")
+	if err != nil {
+		respond(w, http.StatusInternalServerError, &buildResponse{Log: err.Error()})
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	err = ioutil.WriteFile(filepath.Join(dir, "function.go"), source, 0644)
+	if err != nil {
+		respond(w, http.StatusInternalServerError, &buildResponse{Log: err.Error()})
+		return
+	}
+
+	cmd := exec.Command("go", "build", "-buildmode=plugin", "-o", "function.so", "function.go")
+	cmd.Dir = dir
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		respond(w, http.StatusBadRequest, &buildResponse{Log: fmt.Sprintf("%s\n%v", out, err)})
+		return
+	}
+
+	pkg, err := ioutil.ReadFile(filepath.Join(dir, "function.so"))
+	if err != nil {
+		respond(w, http.StatusInternalServerError, &buildResponse{Log: string(out) + err.Error()})
+		return
+	}
+	respond(w, http.StatusOK, &buildResponse{Package: pkg, Log: string(out)})
+}
+
+func main() {
+	http.HandleFunc("/build", buildHandler)
+
+	fmt.Println("Listening on 8001 ...")
+	http.ListenAndServe(":8001", nil)
+}
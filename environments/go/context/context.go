@@ -1,5 +1,26 @@
 package context
 
+import (
+	"net/http"
+	"net/url"
+)
+
+// Headers set by the fission router on requests to functions.
+const (
+	HeaderOriginalPath = "X-Fission-Original-Path"
+	HeaderTriggerName  = "X-Fission-Trigger-Name"
+	HeaderRouteParams  = "X-Fission-Route-Params"
+	// W3C trace context of the router's request to the function
+	HeaderTraceparent = "traceparent"
+)
+
+const (
+	keyOriginalPath = "originalPath"
+	keyTriggerName  = "triggerName"
+	keyRouteParams  = "routeParams"
+	keyTraceparent  = "traceparent"
+)
+
 type (
 	Context map[string]interface{}
 )
@@ -8,3 +29,55 @@ func New() Context {
 	ctx := make(map[string]interface{})
 	return ctx
 }
+
+// FromRequest returns a context describing how the router invoked
+// the function for this request.
+func FromRequest(r *http.Request) Context {
+	ctx := New()
+	ctx[keyOriginalPath] = r.Header.Get(HeaderOriginalPath)
+	ctx[keyTriggerName] = r.Header.Get(HeaderTriggerName)
+	params := make(map[string]string)
+	values, err := url.ParseQuery(r.Header.Get(HeaderRouteParams))
+	if err == nil {
+		for k := range values {
+			params[k] = values.Get(k)
+		}
+	}
+	ctx[keyRouteParams] = params
+	ctx[keyTraceparent] = r.Header.Get(HeaderTraceparent)
+	return ctx
+}
+
+// OriginalPath returns the request path before the router rewrote
+// it, e.g. "/users/42".
+func (c Context) OriginalPath() string {
+	s, _ := c[keyOriginalPath].(string)
+	return s
+}
+
+// TriggerName returns the name of the HTTP trigger that matched the
+// request, or "" if the function was invoked by its internal route.
+func (c Context) TriggerName() string {
+	s, _ := c[keyTriggerName].(string)
+	return s
+}
+
+// Params returns the variables of the trigger's URL pattern, e.g.
+// {"id": "42"} for "/users/{id}".
+func (c Context) Params() map[string]string {
+	params, _ := c[keyRouteParams].(map[string]string)
+	return params
+}
+
+// Param returns a single URL pattern variable, or "" if it isn't set.
+func (c Context) Param(name string) string {
+	return c.Params()[name]
+}
+
+// Traceparent returns the W3C trace context of the invocation, or ""
+// if it isn't traced.  Pass it on in the traceparent header of
+// outgoing requests to make them part of the same trace.
+func (c Context) Traceparent() string {
+	s, _ := c[keyTraceparent].(string)
+	return s
+}
@@ -0,0 +1,51 @@
+package context
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// EventStream sends server-sent events.  The router passes each event
+// on to the client as soon as it's sent.
+type EventStream struct {
+	w       http.ResponseWriter
+	flusher http.Flusher
+}
+
+// NewEventStream starts a text/event-stream response on w.
+func NewEventStream(w http.ResponseWriter) (*EventStream, error) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		return nil, errors.New("response can't be streamed")
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+	return &EventStream{w: w, flusher: flusher}, nil
+}
+
+// Send sends an event of type event ("" for the default, "message")
+// and flushes it.  Multi-line data is sent as several data lines.
+func (s *EventStream) Send(event string, data string) error {
+	if len(event) > 0 {
+		_, err := fmt.Fprintf(s.w, "event: %v\n", event)
+		if err != nil {
+			return err
+		}
+	}
+	for _, line := range strings.Split(data, "\n") {
+		_, err := fmt.Fprintf(s.w, "data: %v\n", line)
+		if err != nil {
+			return err
+		}
+	}
+	_, err := fmt.Fprint(s.w, "\n")
+	if err != nil {
+		return err
+	}
+	s.flusher.Flush()
+	return nil
+}
@@ -1,7 +1,9 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
+	"io/ioutil"
 	"net/http"
 	"os"
 	"plugin"
@@ -15,6 +17,35 @@ const (
 
 var userFunc http.HandlerFunc
 
+// Body of the specialize request sent by poolmgr.
+type specializeRequest struct {
+	EnvVars map[string]string `json:"envVars"`
+}
+
+// setEnvVars applies the function's configuration to this process,
+// before the plugin is loaded so that its init() can see it.
+func setEnvVars(r *http.Request) error {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return err
+	}
+	if len(body) == 0 {
+		return nil
+	}
+	var req specializeRequest
+	err = json.Unmarshal(body, &req)
+	if err != nil {
+		return err
+	}
+	for k, v := range req.EnvVars {
+		err = os.Setenv(k, v)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func loadPlugin() http.HandlerFunc {
 	p, err := plugin.Open(CODE_PATH)
 	if err != nil {
@@ -34,7 +65,7 @@ func loadPlugin() http.HandlerFunc {
 		return h
 	case func(context.Context, http.ResponseWriter, *http.Request):
 		return func(w http.ResponseWriter, r *http.Request) {
-			c := context.New()
+			c := context.FromRequest(r)
 			h(c, w, r)
 		}
 	default:
@@ -60,6 +91,13 @@ func specializeHandler(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	err = setEnvVars(r)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(fmt.Sprintf("Invalid specialize request: %v", err)))
+		return
+	}
+
 	fmt.Println("Specializing ...")
 	userFunc = loadPlugin()
 	fmt.Println("Done")
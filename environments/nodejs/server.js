@@ -45,6 +45,12 @@ function specialize(req, res) {
         return;
     }
 
+    // Function configuration (env vars, secrets and configmaps) is
+    // sent by poolmgr in the specialize request.
+    if (req.body && req.body.envVars) {
+        Object.assign(process.env, req.body.envVars);
+    }
+
     // Read and load the code. It's placed there securely by the fission runtime.
     try {
         var startTime = process.hrtime();
@@ -27,7 +27,15 @@ class Server {
                 $path = parse_url($request->getUri(), PHP_URL_PATH);
 
                 if($path == "/specialize" && $request->getMethod() == "POST"){
-                    //Nothing to do
+                    //Function configuration (env vars, secrets and configmaps) is
+                    //sent by poolmgr in the specialize request. The built-in server
+                    //runs in a single process, so it's kept for later requests.
+                    $body = json_decode((string)$request->getBody(), true);
+                    if(is_array($body) && isset($body["envVars"]) && is_array($body["envVars"])){
+                        foreach($body["envVars"] as $name => $value){
+                            putenv("$name=$value");
+                        }
+                    }
                     return new Response\EmptyResponse(201);
                 }else{
                     if(!file_exists($codepath)){
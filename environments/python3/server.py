@@ -1,6 +1,7 @@
 #!/usr/bin/env python
 
 import logging
+import os
 import sys
 import imp
 
@@ -15,6 +16,10 @@ userfunc = None
 @app.route('/specialize', methods=['POST'])
 def load():
     global userfunc
+    # Function configuration (env vars, secrets and configmaps) is
+    # sent by poolmgr in the specialize request.
+    body = request.get_json(silent=True) or {}
+    os.environ.update(body.get('envVars') or {})
     userfunc = (imp.load_source('user', codepath)).main
     return ""
 
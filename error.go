@@ -40,7 +40,7 @@ func MakeErrorFromHTTP(resp *http.Response) error {
 	switch resp.StatusCode {
 	case 400:
 		errCode = ErrorInvalidArgument
-	case 403:
+	case 401, 403:
 		errCode = ErrorNotAuthorized
 	case 404:
 		errCode = ErrorNotFound
@@ -2,17 +2,23 @@ package main
 
 import (
 	"log"
+	"os"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/docopt/docopt-go"
+	"github.com/fission/fission"
 	"github.com/fission/fission/controller"
 	"github.com/fission/fission/kubewatcher"
 	"github.com/fission/fission/logger"
 	"github.com/fission/fission/poolmgr"
+	poolmgrClient "github.com/fission/fission/poolmgr/client"
 	"github.com/fission/fission/router"
+	"github.com/fission/fission/tracing"
 )
 
-func runController(port int, etcdUrl string, filepath string) {
+func runController(port int, etcdUrl string, filepath string, namespace string, poolmgrUrl string, shutdownTimeout time.Duration) {
 	// filePath will be created if it doesn't exist.
 	fileStore := controller.MakeFileStore(filepath)
 	if fileStore == nil {
@@ -24,20 +30,40 @@ func runController(port int, etcdUrl string, filepath string) {
 		log.Fatalf("Error: %v", err)
 	}
 
-	api := controller.MakeAPI(rs)
-	api.Serve(port)
-	log.Fatalf("Error: Controller exited.")
+	// Builds run in pods, so they're only available in a cluster.
+	var builder controller.BuildRunner
+	kb, err := controller.MakeKubernetesBuildRunner(namespace)
+	if err != nil {
+		log.Printf("Builds disabled: %v", err)
+	} else {
+		builder = kb
+	}
+
+	api := controller.MakeAPI(rs, builder, poolmgrClient.MakeClient(poolmgrUrl))
+	err = api.FailInterruptedBuilds()
+	if err != nil {
+		log.Printf("Failed to fail interrupted builds: %v", err)
+	}
+	err = api.Serve(port, fission.ShutdownSignal(), shutdownTimeout)
+	if err != nil {
+		log.Fatalf("Error: Controller exited: %v", err)
+	}
+	log.Printf("Controller stopped")
 }
 
-func runRouter(port int, controllerUrl string, poolmgrUrl string) {
-	router.Start(port, controllerUrl, poolmgrUrl)
-	log.Fatalf("Error: Router exited.")
+func runRouter(port int, controllerUrl string, poolmgrUrl string, config router.Config) {
+	tracing.InitFromEnv("fission-router")
+	err := router.Start(port, controllerUrl, poolmgrUrl, config)
+	if err != nil {
+		log.Fatalf("Error: Router exited: %v", err)
+	}
 }
 
-func runPoolmgr(port int, controllerUrl string, namespace string) {
-	err := poolmgr.StartPoolmgr(controllerUrl, namespace, port)
+func runPoolmgr(port int, controllerUrl string, namespace string, shutdownTimeout time.Duration) {
+	tracing.InitFromEnv("fission-poolmgr")
+	err := poolmgr.StartPoolmgr(controllerUrl, namespace, port, shutdownTimeout)
 	if err != nil {
-		log.Fatalf("Error starting poolmgr: %v", err)
+		log.Fatalf("Error: Poolmgr exited: %v", err)
 	}
 }
 
@@ -62,6 +88,65 @@ func getPort(portArg interface{}) int {
 	return port
 }
 
+// getShutdownTimeout returns how long servers wait for in-flight
+// requests when shutting down.
+func getShutdownTimeout(arguments map[string]interface{}) time.Duration {
+	if arguments["--shutdownTimeout"] == nil {
+		return fission.DEFAULT_SHUTDOWN_TIMEOUT
+	}
+	timeout, err := time.ParseDuration(arguments["--shutdownTimeout"].(string))
+	if err != nil || timeout < 0 {
+		log.Fatalf("Error: invalid shutdown timeout '%v'", arguments["--shutdownTimeout"])
+	}
+	return timeout
+}
+
+func getRouterConfig(arguments map[string]interface{}) router.Config {
+	config := router.DefaultConfig()
+	config.ShutdownTimeout = getShutdownTimeout(arguments)
+	config.AdminToken = os.Getenv(router.ENV_ADMIN_TOKEN)
+	if arguments["--coldStartQueueDepth"] != nil {
+		depth, err := strconv.Atoi(arguments["--coldStartQueueDepth"].(string))
+		if err != nil || depth < 0 {
+			log.Fatalf("Error: invalid cold start queue depth '%v'", arguments["--coldStartQueueDepth"])
+		}
+		config.ColdStartQueueDepth = depth
+	}
+	if arguments["--coldStartTimeout"] != nil {
+		timeout, err := time.ParseDuration(arguments["--coldStartTimeout"].(string))
+		if err != nil || timeout < 0 {
+			log.Fatalf("Error: invalid cold start timeout '%v'", arguments["--coldStartTimeout"])
+		}
+		config.ColdStartTimeout = timeout
+	}
+	if arguments["--responseCacheSize"] != nil {
+		size, err := strconv.ParseInt(arguments["--responseCacheSize"].(string), 10, 64)
+		if err != nil || size < 0 {
+			log.Fatalf("Error: invalid response cache size '%v'", arguments["--responseCacheSize"])
+		}
+		config.ResponseCacheSize = size
+	}
+	if arguments["--internalPort"] != nil {
+		config.InternalPort = getPort(arguments["--internalPort"])
+	}
+	if arguments["--tlsPort"] != nil {
+		config.TLSPort = getPort(arguments["--tlsPort"])
+		if arguments["--tlsCertDir"] != nil {
+			config.TLSCertDir = arguments["--tlsCertDir"].(string)
+		}
+		if arguments["--tlsSecrets"] != nil {
+			config.TLSSecrets = strings.Split(arguments["--tlsSecrets"].(string), ",")
+		}
+		if len(config.TLSCertDir) == 0 && len(config.TLSSecrets) == 0 {
+			log.Fatalf("Error: TLS needs certificates, use --tlsCertDir or --tlsSecrets")
+		}
+		if arguments["--httpsRedirectPort"] != nil {
+			config.HTTPSRedirectPort = getPort(arguments["--httpsRedirectPort"])
+		}
+	}
+	return config
+}
+
 func getStringArgWithDefault(arg interface{}, defaultValue string) string {
 	if arg != nil {
 		return arg.(string)
@@ -81,10 +166,17 @@ Use it to start one or more of the fission servers:
 
  Router implements HTTP triggers: it routes to running instances, working with the controller and poolmgr.
 
+ The router and poolmgr export trace spans if FISSION_TRACE_EXPORTER is set: to "otlp" to send them to the
+ OpenTelemetry collector at OTEL_EXPORTER_OTLP_ENDPOINT, or to "log" to write them to stdout.
+
+ The router serves an admin API under /fission-admin/ (route table, cached services, route resolution) if
+ FISSION_ROUTER_ADMIN_TOKEN is set; clients authenticate with the token as a bearer token. Use HTTPS or the
+ internal port for it: with --httpsRedirectPort, plain HTTP requests to the public port are redirected.
+
 Usage:
-  fission-bundle --controllerPort=<port> [--etcdUrl=<etcdUrl>] --filepath=<filepath>
-  fission-bundle --routerPort=<port> [--controllerUrl=<url> --poolmgrUrl=<url>]
-  fission-bundle --poolmgrPort=<port> [--controllerUrl=<url> --namespace=<namespace>]
+  fission-bundle --controllerPort=<port> [--etcdUrl=<etcdUrl>] --filepath=<filepath> [--namespace=<namespace> --poolmgrUrl=<url> --shutdownTimeout=<duration>]
+  fission-bundle --routerPort=<port> [--controllerUrl=<url> --poolmgrUrl=<url> --coldStartQueueDepth=<n> --coldStartTimeout=<duration> --responseCacheSize=<bytes> --internalPort=<port> --tlsPort=<port> --tlsCertDir=<dir> --tlsSecrets=<secrets> --httpsRedirectPort=<port> --shutdownTimeout=<duration>]
+  fission-bundle --poolmgrPort=<port> [--controllerUrl=<url> --namespace=<namespace> --shutdownTimeout=<duration>]
   fission-bundle --kubewatcher [--controllerUrl=<url> --routerUrl=<url>]
   fission-bundle --logger
 Options:
@@ -93,10 +185,19 @@ Options:
   --poolmgrPort=<port>     Port that the poolmgr should listen on.
   --controllerUrl=<url>    Controller URL. Not required if --controllerPort is specified.
   --poolmgrUrl=<url>       Poolmgr URL. Not required if --poolmgrPort is specified.
-  --routerUrl=<url>        Router URL.
+  --routerUrl=<url>        Router URL, at its internal port.
+  --coldStartQueueDepth=<n>        Max requests per function waiting for it to start; 0 for no limit. Defaults to 100.
+  --coldStartTimeout=<duration>    Max time a request waits for its function to start, e.g. 30s; 0 for no limit. Defaults to 30s.
+  --responseCacheSize=<bytes>      Max size of responses the router caches for triggers with a cache policy; 0 disables caching. Defaults to 64MB.
+  --internalPort=<port>            Port for the router's internal function routes, used by the kubewatcher. Keep it cluster-internal. Defaults to 8889.
+  --tlsPort=<port>                 Port that the router should serve HTTPS on.
+  --tlsCertDir=<dir>               Directory with certificates for HTTPS: NAME.crt and NAME.key pairs, or mounted TLS secrets. Reloaded when they change.
+  --tlsSecrets=<secrets>           Comma-separated Kubernetes TLS secrets (namespace/name) with certificates for HTTPS. Reloaded when they change.
+  --httpsRedirectPort=<port>       Redirect HTTP requests to HTTPS on this port, e.g. 443.
+  --shutdownTimeout=<duration>     On SIGTERM, max time to wait for in-flight requests before exiting. Defaults to 25s.
   --etcdUrl=<etcdUrl>      Etcd URL.
   --filepath=<filepath>    Directory to store functions in.
-  --namespace=<namespace>  Kubernetes namespace in which to run function containers. Defaults to 'fission-function'.
+  --namespace=<namespace>  Kubernetes namespace in which to run function and build containers. Defaults to 'fission-function'.
   --kubewatcher            Start Kubernetes events watcher.
   --logger                 Start logger.
 `
@@ -110,21 +211,24 @@ Options:
 	controllerUrl := getStringArgWithDefault(arguments["--controllerUrl"], "http://controller.fission")
 	etcdUrl := getStringArgWithDefault(arguments["--etcdUrl"], "http://etcd:2379")
 	poolmgrUrl := getStringArgWithDefault(arguments["--poolmgrUrl"], "http://poolmgr.fission")
-	routerUrl := getStringArgWithDefault(arguments["--routerUrl"], "http://router.fission")
+	routerUrl := getStringArgWithDefault(arguments["--routerUrl"], "http://router-internal.fission")
 
 	if arguments["--controllerPort"] != nil {
 		port := getPort(arguments["--controllerPort"])
-		runController(port, etcdUrl, arguments["--filepath"].(string))
+		runController(port, etcdUrl, arguments["--filepath"].(string), namespace, poolmgrUrl, getShutdownTimeout(arguments))
+		return
 	}
 
 	if arguments["--routerPort"] != nil {
 		port := getPort(arguments["--routerPort"])
-		runRouter(port, controllerUrl, poolmgrUrl)
+		runRouter(port, controllerUrl, poolmgrUrl, getRouterConfig(arguments))
+		return
 	}
 
 	if arguments["--poolmgrPort"] != nil {
 		port := getPort(arguments["--poolmgrPort"])
-		runPoolmgr(port, controllerUrl, namespace)
+		runPoolmgr(port, controllerUrl, namespace, getShutdownTimeout(arguments))
+		return
 	}
 
 	if arguments["--kubewatcher"] == true {
@@ -0,0 +1,123 @@
+/*
+Copyright 2016 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/urfave/cli"
+
+	"github.com/fission/fission"
+)
+
+// generateAPIKey returns a random 256-bit key.
+func generateAPIKey() string {
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	checkErr(err, "generate API key")
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func apiKeyCreate(c *cli.Context) error {
+	client := getClient(c.GlobalString("server"))
+
+	name := c.String("name")
+	if len(name) == 0 {
+		fatal("Need a name for the API key, use --name")
+	}
+	value := c.String("value")
+	if len(value) == 0 {
+		value = generateAPIKey()
+	}
+
+	k := &fission.APIKey{
+		Metadata:    fission.Metadata{Name: name},
+		Value:       value,
+		Description: c.String("description"),
+	}
+	_, err := client.APIKeyCreate(k)
+	checkErr(err, "create API key")
+
+	fmt.Printf("API key '%v' created\n", name)
+	if !c.IsSet("value") {
+		fmt.Printf("key: %v\n(store it now, it can't be shown again)\n", value)
+	}
+	return nil
+}
+
+func apiKeyUpdate(c *cli.Context) error {
+	client := getClient(c.GlobalString("server"))
+
+	name := c.String("name")
+	if len(name) == 0 {
+		fatal("Need name of API key, use --name")
+	}
+	k, err := client.APIKeyGet(&fission.Metadata{Name: name})
+	checkErr(err, "get API key")
+
+	if c.IsSet("description") {
+		k.Description = c.String("description")
+	}
+	if c.IsSet("value") {
+		k.Value = c.String("value")
+	} else if c.Bool("rotate") {
+		k.Value = generateAPIKey()
+	}
+
+	_, err = client.APIKeyUpdate(k)
+	checkErr(err, "update API key")
+
+	fmt.Printf("API key '%v' updated\n", name)
+	if c.Bool("rotate") && !c.IsSet("value") {
+		fmt.Printf("key: %v\n(store it now, it can't be shown again)\n", k.Value)
+	}
+	return nil
+}
+
+func apiKeyDelete(c *cli.Context) error {
+	client := getClient(c.GlobalString("server"))
+
+	name := c.String("name")
+	if len(name) == 0 {
+		fatal("Need name of API key to delete, use --name")
+	}
+	err := client.APIKeyDelete(&fission.Metadata{Name: name})
+	checkErr(err, "delete API key")
+
+	fmt.Printf("API key '%v' deleted\n", name)
+	return nil
+}
+
+func apiKeyList(c *cli.Context) error {
+	client := getClient(c.GlobalString("server"))
+
+	keys, err := client.APIKeyList()
+	checkErr(err, "list API keys")
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 1, ' ', 0)
+	fmt.Fprintf(w, "%v\t%v\n", "NAME", "DESCRIPTION")
+	for _, k := range keys {
+		fmt.Fprintf(w, "%v\t%v\n", k.Metadata.Name, k.Description)
+	}
+	w.Flush()
+
+	return nil
+}
@@ -0,0 +1,98 @@
+/*
+Copyright 2016 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/urfave/cli"
+
+	"github.com/fission/fission"
+	"github.com/fission/fission/controller/client"
+)
+
+// fnBuild builds source into function f, creating it or, if update is
+// true, making a new version of it.  It waits for the build to finish
+// and prints its log, and exits if the build fails.
+func fnBuild(client *client.Client, f *fission.Function, source []byte, update bool) *fission.Build {
+	f.Code = ""
+	m, err := client.BuildCreate(&fission.Build{Function: *f, Update: update}, source)
+	checkErr(err, "start build")
+
+	fmt.Printf("building function '%v' (build %v) ...\n", f.Metadata.Name, m.Name)
+	var b *fission.Build
+	for {
+		b, err = client.BuildGet(m)
+		checkErr(err, "get build status")
+		if b.Status == fission.BuildStatusSucceeded || b.Status == fission.BuildStatusFailed {
+			break
+		}
+		time.Sleep(time.Second)
+	}
+
+	if len(b.Log) > 0 {
+		fmt.Println(b.Log)
+	}
+	if b.Status == fission.BuildStatusFailed {
+		fatal(fmt.Sprintf("Build of function '%v' failed", f.Metadata.Name))
+	}
+	return b
+}
+
+func buildGet(c *cli.Context) error {
+	client := getClient(c.GlobalString("server"))
+
+	buildName := c.String("name")
+	if len(buildName) == 0 {
+		fatal("Need name of build, use --name")
+	}
+
+	b, err := client.BuildGet(&fission.Metadata{Name: buildName})
+	checkErr(err, "get build")
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 1, ' ', 0)
+	fmt.Fprintf(w, "%v\t%v\t%v\t%v\n", "NAME", "FUNCTION", "FUNCTION UID", "STATUS")
+	fmt.Fprintf(w, "%v\t%v\t%v\t%v\n",
+		b.Metadata.Name, b.Function.Metadata.Name, b.Function.Metadata.Uid, b.Status)
+	w.Flush()
+
+	if len(b.Log) > 0 {
+		fmt.Println()
+		fmt.Println(b.Log)
+	}
+	return err
+}
+
+func buildList(c *cli.Context) error {
+	client := getClient(c.GlobalString("server"))
+
+	builds, err := client.BuildList()
+	checkErr(err, "list builds")
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 1, ' ', 0)
+	fmt.Fprintf(w, "%v\t%v\t%v\t%v\n", "NAME", "FUNCTION", "FUNCTION UID", "STATUS")
+	for _, b := range builds {
+		fmt.Fprintf(w, "%v\t%v\t%v\t%v\n",
+			b.Metadata.Name, b.Function.Metadata.Name, b.Function.Metadata.Uid, b.Status)
+	}
+	w.Flush()
+
+	return err
+}
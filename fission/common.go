@@ -21,7 +21,11 @@ import (
 	"os"
 	"strings"
 
+	"github.com/urfave/cli"
+
+	"github.com/fission/fission"
 	"github.com/fission/fission/controller/client"
+	routerClient "github.com/fission/fission/router/client"
 )
 
 func fatal(msg string) {
@@ -45,8 +49,121 @@ func getClient(serverUrl string) *client.Client {
 	return client.MakeClient(serverUrl)
 }
 
+func getRouterClient(routerUrl string, adminToken string) *routerClient.Client {
+	if len(routerUrl) == 0 {
+		fatal("Need --router or FISSION_ROUTER set to your fission router.")
+	}
+	if len(adminToken) == 0 {
+		fatal("Need --admin-token or FISSION_ROUTER_ADMIN_TOKEN set to the router's admin token.")
+	}
+	if !strings.HasPrefix(routerUrl, "http://") && !strings.HasPrefix(routerUrl, "https://") {
+		routerUrl = "http://" + routerUrl
+	}
+	return routerClient.MakeClient(routerUrl, adminToken)
+}
+
 func checkErr(err error, msg string) {
 	if err != nil {
 		fatal(fmt.Sprintf("Failed to %v: %v", msg, err))
 	}
 }
+
+// updateResources sets the resources and timeout given on the
+// command line, leaving the others unchanged.
+func updateResources(c *cli.Context, r *fission.Resources, timeout *int) {
+	if c.IsSet("cpu-request") {
+		r.CpuRequest = c.String("cpu-request")
+	}
+	if c.IsSet("cpu-limit") {
+		r.CpuLimit = c.String("cpu-limit")
+	}
+	if c.IsSet("mem-request") {
+		r.MemoryRequest = c.String("mem-request")
+	}
+	if c.IsSet("mem-limit") {
+		r.MemoryLimit = c.String("mem-limit")
+	}
+	if c.IsSet("timeout") {
+		*timeout = c.Int("timeout")
+	}
+}
+
+// parseEnvVars parses KEY=VALUE pairs given on the command line.
+func parseEnvVars(pairs []string) []fission.EnvVar {
+	envVars := make([]fission.EnvVar, 0, len(pairs))
+	for _, pair := range pairs {
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 || len(kv[0]) == 0 {
+			fatal(fmt.Sprintf("Invalid env var '%v', use KEY=VALUE", pair))
+		}
+		envVars = append(envVars, fission.EnvVar{Name: kv[0], Value: kv[1]})
+	}
+	return envVars
+}
+
+// mergeEnvVars sets each of newVars in envVars, replacing any
+// existing variable with the same name.
+func mergeEnvVars(envVars []fission.EnvVar, newVars []fission.EnvVar) []fission.EnvVar {
+	for _, nv := range newVars {
+		found := false
+		for i := range envVars {
+			if envVars[i].Name == nv.Name {
+				envVars[i].Value = nv.Value
+				found = true
+				break
+			}
+		}
+		if !found {
+			envVars = append(envVars, nv)
+		}
+	}
+	return envVars
+}
+
+// mergeNames appends each of newNames not already in names.
+func mergeNames(names []string, newNames []string) []string {
+	for _, nn := range newNames {
+		found := false
+		for _, n := range names {
+			if n == nn {
+				found = true
+				break
+			}
+		}
+		if !found {
+			names = append(names, nn)
+		}
+	}
+	return names
+}
+
+// removeEnvVars drops the variables named in names from envVars.
+func removeEnvVars(envVars []fission.EnvVar, names []string) []fission.EnvVar {
+	kept := make([]fission.EnvVar, 0, len(envVars))
+	for _, ev := range envVars {
+		if !containsName(names, ev.Name) {
+			kept = append(kept, ev)
+		}
+	}
+	return kept
+}
+
+// removeNames drops each of removed from names.
+func removeNames(names []string, removed []string) []string {
+	kept := make([]string, 0, len(names))
+	for _, n := range names {
+		if !containsName(removed, n) {
+			kept = append(kept, n)
+		}
+	}
+	return kept
+}
+
+func containsName(names []string, name string) bool {
+	for _, n := range names {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
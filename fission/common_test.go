@@ -0,0 +1,46 @@
+/*
+Copyright 2016 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/fission/fission"
+)
+
+func TestUpdateEnvVars(t *testing.T) {
+	envVars := []fission.EnvVar{{Name: "A", Value: "1"}, {Name: "B", Value: "2"}}
+	envVars = removeEnvVars(envVars, []string{"A", "C"})
+	envVars = mergeEnvVars(envVars, []fission.EnvVar{{Name: "B", Value: "3"}, {Name: "D", Value: "4"}})
+	expected := []fission.EnvVar{{Name: "B", Value: "3"}, {Name: "D", Value: "4"}}
+	if !reflect.DeepEqual(envVars, expected) {
+		t.Errorf("expected %v, got %v", expected, envVars)
+	}
+}
+
+func TestUpdateNames(t *testing.T) {
+	secrets := mergeNames([]string{"db", "api"}, []string{"api", "cache"})
+	secrets = removeNames(secrets, []string{"db"})
+	expected := []string{"api", "cache"}
+	if !reflect.DeepEqual(secrets, expected) {
+		t.Errorf("expected %v, got %v", expected, secrets)
+	}
+	if len(removeNames(secrets, secrets)) != 0 {
+		t.Errorf("expected all names to be removed")
+	}
+}
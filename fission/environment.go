@@ -18,14 +18,41 @@ package main
 
 import (
 	"fmt"
+	"os"
 	"text/tabwriter"
+	"time"
 
 	"github.com/urfave/cli"
 
 	"github.com/fission/fission"
-	"os"
 )
 
+// envUpdateSpec sets the pool and container settings given on the
+// command line, leaving the others unchanged.
+func envUpdateSpec(c *cli.Context, env *fission.Environment) {
+	updateResources(c, &env.Resources, &env.Timeout)
+	if c.IsSet("builder") {
+		env.BuilderImageUrl = c.String("builder")
+	}
+	if c.IsSet("poolsize") {
+		env.PoolSize = c.Int("poolsize")
+	}
+	if c.IsSet("pod-ready-timeout") {
+		env.PodReadyTimeout = c.Int("pod-ready-timeout")
+	}
+	if c.IsSet("idle-timeout") {
+		env.IdlePodReapTime = c.Int("idle-timeout")
+	}
+	if c.IsSet("image-pull-policy") {
+		env.ImagePullPolicy = c.String("image-pull-policy")
+	}
+	// Removals come first, as for functions.
+	env.ImagePullSecrets = removeNames(env.ImagePullSecrets, c.StringSlice("remove-image-pull-secret"))
+	env.ContainerEnvVars = removeEnvVars(env.ContainerEnvVars, c.StringSlice("remove-container-env"))
+	env.ImagePullSecrets = mergeNames(env.ImagePullSecrets, c.StringSlice("image-pull-secret"))
+	env.ContainerEnvVars = mergeEnvVars(env.ContainerEnvVars, parseEnvVars(c.StringSlice("container-env")))
+}
+
 func envCreate(c *cli.Context) error {
 	client := getClient(c.GlobalString("server"))
 
@@ -45,6 +72,7 @@ func envCreate(c *cli.Context) error {
 		},
 		RunContainerImageUrl: envImg,
 	}
+	envUpdateSpec(c, env)
 
 	_, err := client.EnvironmentCreate(env)
 	checkErr(err, "create environment")
@@ -66,9 +94,9 @@ func envGet(c *cli.Context) error {
 	checkErr(err, "get environment")
 
 	w := tabwriter.NewWriter(os.Stdout, 0, 0, 1, ' ', 0)
-	fmt.Fprintf(w, "%v\t%v\t%v\n", "NAME", "UID", "IMAGE")
-	fmt.Fprintf(w, "%v\t%v\t%v\n",
-		env.Metadata.Name, env.Metadata.Uid, env.RunContainerImageUrl)
+	fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%v\n", "NAME", "UID", "IMAGE", "POOLSIZE", "PULLPOLICY")
+	fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%v\n",
+		env.Metadata.Name, env.Metadata.Uid, env.RunContainerImageUrl, env.PoolSize, env.ImagePullPolicy)
 	w.Flush()
 	return nil
 }
@@ -81,25 +109,70 @@ func envUpdate(c *cli.Context) error {
 		fatal("Need a name, use --name.")
 	}
 
-	envImg := c.String("image")
-	if len(envImg) == 0 {
-		fatal("Need an image, use --image.")
-	}
+	env, err := client.EnvironmentGet(&fission.Metadata{Name: envName})
+	checkErr(err, "get environment")
 
-	env := &fission.Environment{
-		Metadata: fission.Metadata{
-			Name: envName,
-		},
-		RunContainerImageUrl: envImg,
+	envImg := c.String("image")
+	if len(envImg) > 0 {
+		env.RunContainerImageUrl = envImg
 	}
+	envUpdateSpec(c, env)
 
-	_, err := client.EnvironmentUpdate(env)
+	_, err = client.EnvironmentUpdate(env)
 	checkErr(err, "update environment")
 
 	fmt.Printf("environment '%v' updated\n", envName)
+	fmt.Printf("pods are rolled out to the new version; see 'fission env status --name %v'\n", envName)
 	return nil
 }
 
+// envStatus shows the pools of an environment.  After an update, the
+// old version's pool drains while the new one takes over; --wait
+// waits until the new version has ready pods.
+func envStatus(c *cli.Context) error {
+	client := getClient(c.GlobalString("server"))
+
+	envName := c.String("name")
+	if len(envName) == 0 {
+		fatal("Need a name, use --name.")
+	}
+	m := &fission.Metadata{Name: envName}
+
+	var pools []fission.PoolStatus
+	for {
+		env, err := client.EnvironmentGet(m)
+		checkErr(err, "get environment")
+
+		pools, err = client.EnvironmentPools(m)
+		checkErr(err, "get environment pools")
+
+		if !c.Bool("wait") || envRolledOut(env, pools) {
+			break
+		}
+		time.Sleep(time.Second)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 1, ' ', 0)
+	fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%v\n", "UID", "STATE", "READY", "REPLICAS", "SPECIALIZED")
+	for _, p := range pools {
+		fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%v\n",
+			p.Environment.Uid, p.State, p.ReadyReplicas, p.Replicas, p.SpecializedPods)
+	}
+	w.Flush()
+	return nil
+}
+
+// envRolledOut returns true if env's current version has a pool
+// with ready pods.
+func envRolledOut(env *fission.Environment, pools []fission.PoolStatus) bool {
+	for _, p := range pools {
+		if p.Environment.Uid == env.Metadata.Uid && p.State == fission.PoolStateActive && p.ReadyReplicas > 0 {
+			return true
+		}
+	}
+	return false
+}
+
 func envDelete(c *cli.Context) error {
 	client := getClient(c.GlobalString("server"))
 
@@ -75,47 +75,52 @@ func fnCreate(c *cli.Context) error {
 		fatal("Need --env argument.")
 	}
 
+	srcName := c.String("src")
 	fileName := c.String("code")
 	if len(fileName) == 0 {
 		fileName = c.String("package")
-		if len(fileName) == 0 {
-			fatal("Need --code or --package argument.")
-		}
 	}
-
-	code := fnFetchCode(fileName)
+	if len(fileName) == 0 && len(srcName) == 0 {
+		fatal("Need --code, --package or --src argument.")
+	}
 
 	function := &fission.Function{
 		Metadata:    fission.Metadata{Name: fnName},
 		Environment: fission.Metadata{Name: envName},
-		Code:        string(code),
+		EnvVars:     parseEnvVars(c.StringSlice("env-var")),
+		Secrets:     c.StringSlice("secret"),
+		ConfigMaps:  c.StringSlice("configmap"),
 	}
+	updateResources(c, &function.Resources, &function.Timeout)
 
-	_, err := client.FunctionCreate(function)
-	checkErr(err, "create function")
+	var err error
+	if len(srcName) > 0 {
+		b := fnBuild(client, function, fnFetchCode(srcName), false)
+		fmt.Printf("function '%v' created (uid %v)\n", fnName, b.Function.Metadata.Uid)
+	} else {
+		function.Code = string(fnFetchCode(fileName))
+		_, err = client.FunctionCreate(function)
+		checkErr(err, "create function")
 
-	fmt.Printf("function '%v' created\n", fnName)
+		fmt.Printf("function '%v' created\n", fnName)
+	}
 
 	// Allow the user to specify an HTTP trigger while creating a function.
 	triggerUrl := c.String("url")
 	if len(triggerUrl) == 0 {
 		return nil
 	}
-	method := c.String("method")
-	if len(method) == 0 {
-		method = "GET"
-	}
 	triggerName := uuid.NewV4().String()
 	ht := &fission.HTTPTrigger{
 		Metadata: fission.Metadata{
 			Name: triggerName,
 		},
 		UrlPattern: triggerUrl,
-		Method:     getMethod(method),
 		Function: fission.Metadata{
 			Name: fnName,
 		},
 	}
+	method := setTriggerMethods(c, ht)
 	_, err = client.HTTPTriggerCreate(ht)
 	checkErr(err, "create HTTP trigger")
 	fmt.Printf("route created: %v %v -> %v\n", method, triggerUrl, fnName)
@@ -159,6 +164,22 @@ func fnGetMeta(c *cli.Context) error {
 	fmt.Fprintf(w, "%v\t%v\t%v\n",
 		f.Metadata.Name, f.Metadata.Uid, f.Environment.Name)
 	w.Flush()
+
+	if len(f.EnvVars) > 0 || len(f.Secrets) > 0 || len(f.ConfigMaps) > 0 {
+		fmt.Println()
+		w = tabwriter.NewWriter(os.Stdout, 0, 0, 1, ' ', 0)
+		fmt.Fprintf(w, "%v\t%v\n", "CONFIG", "VALUE")
+		for _, ev := range f.EnvVars {
+			fmt.Fprintf(w, "%v\t%v\n", "env-var", fmt.Sprintf("%v=%v", ev.Name, ev.Value))
+		}
+		for _, s := range f.Secrets {
+			fmt.Fprintf(w, "%v\t%v\n", "secret", s)
+		}
+		for _, cm := range f.ConfigMaps {
+			fmt.Fprintf(w, "%v\t%v\n", "configmap", cm)
+		}
+		w.Flush()
+	}
 	return err
 }
 
@@ -185,6 +206,23 @@ func fnUpdate(c *cli.Context) error {
 		function.Code = string(code)
 	}
 
+	// Removals come first, so a variable can be replaced by
+	// removing and setting it in one update.
+	function.EnvVars = removeEnvVars(function.EnvVars, c.StringSlice("remove-env"))
+	function.Secrets = removeNames(function.Secrets, c.StringSlice("remove-secret"))
+	function.ConfigMaps = removeNames(function.ConfigMaps, c.StringSlice("remove-configmap"))
+	function.EnvVars = mergeEnvVars(function.EnvVars, parseEnvVars(c.StringSlice("env-var")))
+	function.Secrets = mergeNames(function.Secrets, c.StringSlice("secret"))
+	function.ConfigMaps = mergeNames(function.ConfigMaps, c.StringSlice("configmap"))
+	updateResources(c, &function.Resources, &function.Timeout)
+
+	srcName := c.String("src")
+	if len(srcName) > 0 {
+		b := fnBuild(client, function, fnFetchCode(srcName), true)
+		fmt.Printf("function '%v' updated (uid %v)\n", fnName, b.Function.Metadata.Uid)
+		return nil
+	}
+
 	_, err = client.FunctionUpdate(function)
 	checkErr(err, "update function")
 
@@ -20,6 +20,7 @@ import (
 	"fmt"
 	"net/http"
 	"os"
+	"sort"
 	"strings"
 	"text/tabwriter"
 
@@ -50,11 +51,341 @@ func getMethod(method string) string {
 		return http.MethodOptions
 	case "TRACE":
 		return http.MethodTrace
+	case fission.METHOD_ANY:
+		return fission.METHOD_ANY
 	}
 	fatal(fmt.Sprintf("Invalid HTTP Method %v", method))
 	return ""
 }
 
+// setTriggerMethods applies the (repeatable) --method flag to a
+// trigger, defaulting to GET.  A single method is stored in the
+// Method field so older routers keep matching it.
+func setTriggerMethods(c *cli.Context, ht *fission.HTTPTrigger) string {
+	methods := c.StringSlice("method")
+	if len(methods) == 0 {
+		methods = []string{"GET"}
+	}
+	for i, m := range methods {
+		methods[i] = getMethod(m)
+	}
+	if len(methods) == 1 {
+		ht.Method = methods[0]
+	} else {
+		ht.Methods = methods
+	}
+	return strings.Join(methods, ",")
+}
+
+// updateRateLimit applies the rate limit flags to a trigger, leaving
+// the settings that aren't given unchanged.  A rate of 0 removes the
+// limit.
+func updateRateLimit(c *cli.Context, ht *fission.HTTPTrigger) {
+	if c.IsSet("rate") {
+		rate := c.Float64("rate")
+		if rate < 0 {
+			fatal("Rate must not be negative")
+		}
+		if rate == 0 {
+			ht.RateLimit = nil
+		} else {
+			if ht.RateLimit == nil {
+				ht.RateLimit = &fission.RateLimit{}
+			}
+			ht.RateLimit.RequestsPerSecond = rate
+		}
+	}
+	if !c.IsSet("burst") && !c.IsSet("rate-key") {
+		return
+	}
+	if ht.RateLimit == nil {
+		fatal("Need a rate limit to set its burst or key, use --rate")
+	}
+	if c.IsSet("burst") {
+		ht.RateLimit.Burst = c.Int("burst")
+	}
+	if c.IsSet("rate-key") {
+		key := c.String("rate-key")
+		ht.RateLimit.Key = key
+		ht.RateLimit.Header = ""
+		if strings.HasPrefix(key, fission.RATE_LIMIT_KEY_HEADER+":") {
+			ht.RateLimit.Key = fission.RATE_LIMIT_KEY_HEADER
+			ht.RateLimit.Header = strings.TrimPrefix(key, fission.RATE_LIMIT_KEY_HEADER+":")
+		}
+	}
+}
+
+// updateAuthPolicy applies the auth flags to a trigger, leaving the
+// settings that aren't given unchanged.  "--auth none" removes the
+// policy.
+func updateAuthPolicy(c *cli.Context, ht *fission.HTTPTrigger) {
+	if c.IsSet("auth") {
+		authType := strings.ToLower(c.String("auth"))
+		switch authType {
+		case "none":
+			ht.Auth = nil
+		case fission.AUTH_TYPE_APIKEY, fission.AUTH_TYPE_JWT:
+			if ht.Auth == nil || ht.Auth.Type != authType {
+				ht.Auth = &fission.AuthPolicy{Type: authType}
+			}
+		default:
+			fatal(fmt.Sprintf("Invalid auth policy '%v', use apikey, jwt or none", authType))
+		}
+	}
+
+	apiKeyFlags := []string{"apikey", "apikey-header"}
+	jwtFlags := []string{"jwks-url", "jwt-secret", "jwt-issuer", "jwt-audience", "jwt-claim"}
+	for _, flag := range append(apiKeyFlags, jwtFlags...) {
+		if c.IsSet(flag) && ht.Auth == nil {
+			fatal(fmt.Sprintf("Need an auth policy to use --%v, use --auth", flag))
+		}
+	}
+	if ht.Auth == nil {
+		return
+	}
+
+	if ht.Auth.Type == fission.AUTH_TYPE_APIKEY {
+		for _, flag := range jwtFlags {
+			if c.IsSet(flag) {
+				fatal(fmt.Sprintf("--%v only applies to jwt auth", flag))
+			}
+		}
+		if c.IsSet("apikey") {
+			ht.Auth.APIKeys = c.StringSlice("apikey")
+		}
+		if c.IsSet("apikey-header") {
+			ht.Auth.APIKeyHeader = c.String("apikey-header")
+		}
+		return
+	}
+
+	for _, flag := range apiKeyFlags {
+		if c.IsSet(flag) {
+			fatal(fmt.Sprintf("--%v only applies to apikey auth", flag))
+		}
+	}
+	if ht.Auth.JWT == nil {
+		ht.Auth.JWT = &fission.JWTPolicy{}
+	}
+	jwt := ht.Auth.JWT
+	if c.IsSet("jwks-url") {
+		jwt.JWKSUrl = c.String("jwks-url")
+		jwt.Secret = ""
+	}
+	if c.IsSet("jwt-secret") {
+		jwt.Secret = c.String("jwt-secret")
+		jwt.JWKSUrl = ""
+	}
+	if c.IsSet("jwt-issuer") {
+		jwt.Issuer = c.String("jwt-issuer")
+	}
+	if c.IsSet("jwt-audience") {
+		jwt.Audience = c.String("jwt-audience")
+	}
+	if c.IsSet("jwt-claim") {
+		jwt.RequiredClaims = make(map[string]string)
+		for _, claim := range c.StringSlice("jwt-claim") {
+			kv := strings.SplitN(claim, "=", 2)
+			if len(kv) != 2 || len(kv[0]) == 0 {
+				fatal(fmt.Sprintf("Invalid JWT claim '%v', use NAME=VALUE", claim))
+			}
+			jwt.RequiredClaims[kv[0]] = kv[1]
+		}
+	}
+}
+
+// updateCORSPolicy applies the CORS flags to a trigger, leaving the
+// settings that aren't given unchanged.  "--cors-origin none" removes
+// the policy.
+func updateCORSPolicy(c *cli.Context, ht *fission.HTTPTrigger) {
+	if c.IsSet("cors-origin") {
+		origins := c.StringSlice("cors-origin")
+		if len(origins) == 1 && origins[0] == "none" {
+			ht.CORS = nil
+		} else {
+			if ht.CORS == nil {
+				ht.CORS = &fission.CORSPolicy{}
+			}
+			ht.CORS.AllowOrigins = origins
+		}
+	}
+
+	for _, flag := range []string{"cors-method", "cors-header", "cors-expose-header", "cors-credentials", "cors-max-age"} {
+		if c.IsSet(flag) && ht.CORS == nil {
+			fatal(fmt.Sprintf("Need a CORS policy to use --%v, use --cors-origin", flag))
+		}
+	}
+	if ht.CORS == nil {
+		return
+	}
+	if c.IsSet("cors-method") {
+		ht.CORS.AllowMethods = c.StringSlice("cors-method")
+	}
+	if c.IsSet("cors-header") {
+		ht.CORS.AllowHeaders = c.StringSlice("cors-header")
+	}
+	if c.IsSet("cors-expose-header") {
+		ht.CORS.ExposeHeaders = c.StringSlice("cors-expose-header")
+	}
+	if c.IsSet("cors-credentials") {
+		ht.CORS.AllowCredentials = c.Bool("cors-credentials")
+	}
+	if c.IsSet("cors-max-age") {
+		ht.CORS.MaxAge = c.Int("cors-max-age")
+	}
+}
+
+// updateCachePolicy applies the response caching flags to a trigger.
+// "--cache-ttl 0" disables caching.
+func updateCachePolicy(c *cli.Context, ht *fission.HTTPTrigger) {
+	if c.IsSet("cache-ttl") {
+		ttl := c.Int("cache-ttl")
+		if ttl < 0 {
+			fatal("Cache TTL must not be negative")
+		}
+		if ttl == 0 {
+			ht.Cache = nil
+		} else {
+			if ht.Cache == nil {
+				ht.Cache = &fission.CachePolicy{}
+			}
+			ht.Cache.TTL = ttl
+		}
+	}
+	if !c.IsSet("cache-vary-header") && !c.IsSet("cache-vary-query") {
+		return
+	}
+	if ht.Cache == nil {
+		fatal("Need response caching to set what responses vary by, use --cache-ttl")
+	}
+	if c.IsSet("cache-vary-header") {
+		ht.Cache.VaryHeaders = c.StringSlice("cache-vary-header")
+	}
+	if c.IsSet("cache-vary-query") {
+		ht.Cache.VaryQuery = c.StringSlice("cache-vary-query")
+	}
+}
+
+// updateShadowPolicy applies the request mirroring flags to a trigger.
+// "--shadow-function none" stops mirroring.
+func updateShadowPolicy(c *cli.Context, ht *fission.HTTPTrigger) {
+	if c.IsSet("shadow-function") {
+		name := c.String("shadow-function")
+		if name == "none" {
+			ht.Shadow = nil
+		} else {
+			if ht.Shadow == nil {
+				ht.Shadow = &fission.ShadowPolicy{SampleRate: 1}
+			}
+			ht.Shadow.Function = fission.Metadata{Name: name}
+		}
+	}
+	if !c.IsSet("shadow-uid") && !c.IsSet("shadow-rate") {
+		return
+	}
+	if ht.Shadow == nil {
+		fatal("Need a shadow function to mirror requests to, use --shadow-function")
+	}
+	if c.IsSet("shadow-uid") {
+		ht.Shadow.Function.Uid = c.String("shadow-uid")
+	}
+	if c.IsSet("shadow-rate") {
+		ht.Shadow.SampleRate = c.Float64("shadow-rate")
+	}
+}
+
+// updateRetryPolicy applies the retry flags to a trigger.
+// "--retry-attempts 0" disables retries.
+func updateRetryPolicy(c *cli.Context, ht *fission.HTTPTrigger) {
+	if c.IsSet("retry-attempts") {
+		attempts := c.Int("retry-attempts")
+		if attempts < 0 {
+			fatal("Retry attempts must not be negative")
+		}
+		if attempts == 0 {
+			ht.Retry = nil
+		} else {
+			if ht.Retry == nil {
+				ht.Retry = &fission.RetryPolicy{}
+			}
+			ht.Retry.MaxAttempts = attempts
+		}
+	}
+
+	for _, flag := range []string{"retry-backoff", "retry-max-backoff", "retry-status", "retry-non-idempotent"} {
+		if c.IsSet(flag) && ht.Retry == nil {
+			fatal(fmt.Sprintf("Need a retry policy to use --%v, use --retry-attempts", flag))
+		}
+	}
+	if ht.Retry == nil {
+		return
+	}
+	if c.IsSet("retry-backoff") {
+		ht.Retry.InitialBackoffMs = c.Int("retry-backoff")
+	}
+	if c.IsSet("retry-max-backoff") {
+		ht.Retry.MaxBackoffMs = c.Int("retry-max-backoff")
+	}
+	if c.IsSet("retry-status") {
+		ht.Retry.RetryOn = c.IntSlice("retry-status")
+	}
+	if c.IsSet("retry-non-idempotent") {
+		ht.Retry.RetryNonIdempotent = c.Bool("retry-non-idempotent")
+	}
+}
+
+// updateFallbackPolicy applies the fallback flags to a trigger: a
+// fallback function, or a static response.  "--fallback-function
+// none" removes the fallback.
+func updateFallbackPolicy(c *cli.Context, ht *fission.HTTPTrigger) {
+	static := c.IsSet("fallback-status") || c.IsSet("fallback-body") || c.IsSet("fallback-content-type")
+	if c.IsSet("fallback-function") {
+		name := c.String("fallback-function")
+		if name == "none" {
+			if static {
+				fatal("Can't set a fallback response with --fallback-function none")
+			}
+			ht.Fallback = nil
+			return
+		}
+		if static {
+			fatal("Need either a fallback function or a static fallback response, not both")
+		}
+		ht.Fallback = &fission.FallbackPolicy{Function: &fission.Metadata{Name: name}}
+		return
+	}
+	if !static {
+		return
+	}
+	if ht.Fallback == nil || ht.Fallback.Function != nil {
+		ht.Fallback = &fission.FallbackPolicy{}
+	}
+	if c.IsSet("fallback-status") {
+		ht.Fallback.StatusCode = c.Int("fallback-status")
+	}
+	if c.IsSet("fallback-body") {
+		ht.Fallback.Body = c.String("fallback-body")
+	}
+	if c.IsSet("fallback-content-type") {
+		ht.Fallback.ContentType = c.String("fallback-content-type")
+	}
+}
+
+// formatRateLimit describes a trigger's rate limit for htList.
+func formatRateLimit(r *fission.RateLimit) string {
+	if r == nil {
+		return ""
+	}
+	s := fmt.Sprintf("%v/s burst %v", r.RequestsPerSecond, fission.RateLimitBurst(r))
+	switch r.Key {
+	case fission.RATE_LIMIT_KEY_GLOBAL:
+		return s + " global"
+	case fission.RATE_LIMIT_KEY_HEADER:
+		return s + " by " + r.Header
+	}
+	return s + " by ip"
+}
+
 func htCreate(c *cli.Context) error {
 	client := getClient(c.GlobalString("server"))
 
@@ -64,12 +395,9 @@ func htCreate(c *cli.Context) error {
 	}
 	fnUid := c.String("uid")
 	triggerUrl := c.String("url")
-	if len(triggerUrl) == 0 {
-		fatal("Need a trigger URL, use --url")
-	}
-	method := c.String("method")
-	if len(method) == 0 {
-		method = "GET"
+	prefix := c.String("prefix")
+	if len(triggerUrl) == 0 && len(prefix) == 0 {
+		fatal("Need a trigger URL or path prefix, use --url or --prefix")
 	}
 
 	// just name triggers by uuid.
@@ -80,12 +408,21 @@ func htCreate(c *cli.Context) error {
 			Name: triggerName,
 		},
 		UrlPattern: triggerUrl,
-		Method:     getMethod(method),
+		PathPrefix: prefix,
+		Host:       c.String("host"),
 		Function: fission.Metadata{
 			Name: fnName,
 			Uid:  fnUid,
 		},
 	}
+	setTriggerMethods(c, ht)
+	updateRateLimit(c, ht)
+	updateAuthPolicy(c, ht)
+	updateCORSPolicy(c, ht)
+	updateCachePolicy(c, ht)
+	updateShadowPolicy(c, ht)
+	updateRetryPolicy(c, ht)
+	updateFallbackPolicy(c, ht)
 
 	_, err := client.HTTPTriggerCreate(ht)
 	checkErr(err, "create HTTP trigger")
@@ -94,10 +431,129 @@ func htCreate(c *cli.Context) error {
 	return err
 }
 
+// htGet shows a trigger given by name, or the trigger the router
+// routes a request to.
 func htGet(c *cli.Context) error {
+	client := getClient(c.GlobalString("server"))
+
+	htName := c.String("name")
+	if len(htName) == 0 {
+		if len(c.String("url")) == 0 {
+			fatal("Need a trigger name or a request URL, use --name or --url.")
+		}
+		res := resolveRoute(c)
+		if res.Route == nil || len(res.Route.Trigger) == 0 {
+			fatal(fmt.Sprintf("No trigger matches %v %v", requestMethod(c), c.String("url")))
+		}
+		htName = res.Route.Trigger
+	}
+
+	ht, err := client.HTTPTriggerGet(&fission.Metadata{Name: htName})
+	checkErr(err, "get HTTP trigger")
+
+	printTriggers([]fission.HTTPTrigger{*ht})
 	return nil
 }
 
+// htResolve shows which route the router uses for a request.
+func htResolve(c *cli.Context) error {
+	res := resolveRoute(c)
+	if !res.Matched {
+		fmt.Printf("No route matches %v %v (router generation %v)\n", requestMethod(c), c.String("url"), res.Generation)
+		return nil
+	}
+	if res.Route == nil {
+		fmt.Printf("%v %v is handled by the router itself (router generation %v)\n", requestMethod(c), c.String("url"), res.Generation)
+		return nil
+	}
+
+	r := res.Route
+	trigger := r.Trigger
+	if len(trigger) == 0 {
+		trigger = "(function route)"
+	}
+	method := fission.METHOD_ANY
+	if len(r.Methods) > 0 {
+		method = strings.Join(r.Methods, ",")
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 1, ' ', 0)
+	fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\n", "TRIGGER", "METHOD", "HOST", "URL", "PREFIX", "FUNCTION_NAME", "FUNCTION_UID", "GENERATION")
+	fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\n",
+		trigger, method, r.Host, r.UrlPattern, r.PathPrefix, r.Function.Name, r.Function.Uid, res.Generation)
+	w.Flush()
+	return nil
+}
+
+// htShadows shows how the responses of triggers' functions compare
+// with their shadows', for the requests the router mirrored.
+func htShadows(c *cli.Context) error {
+	client := getRouterClient(c.GlobalString("router"), c.String("admin-token"))
+	stats, err := client.ShadowStats()
+	checkErr(err, "get shadow stats")
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 1, ' ', 0)
+	fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\n", "TRIGGER", "FUNCTION", "SHADOW", "MIRRORED", "DROPPED",
+		"STATUS_MATCHES", "STATUS_MISMATCHES", "LATENCY_MS_MEAN/MAX", "SHADOW_LATENCY_MS_MEAN/MAX")
+	for _, s := range stats {
+		mismatches := make([]string, 0, len(s.StatusMismatches))
+		for statuses, count := range s.StatusMismatches {
+			mismatches = append(mismatches, fmt.Sprintf("%v:%v", statuses, count))
+		}
+		sort.Strings(mismatches)
+		fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%v\t%v\t%v\t%.1f/%.1f\t%.1f/%.1f\n",
+			s.Trigger, formatFunction(s.Function), formatFunction(s.Shadow), s.Mirrored, s.Dropped,
+			s.StatusMatches, strings.Join(mismatches, ","),
+			s.FunctionLatency.MeanMs, s.FunctionLatency.MaxMs, s.ShadowLatency.MeanMs, s.ShadowLatency.MaxMs)
+	}
+	w.Flush()
+	return nil
+}
+
+// htPurge drops a trigger's cached responses from the router.
+func htPurge(c *cli.Context) error {
+	name := c.String("name")
+	if len(name) == 0 {
+		fatal("Need name of trigger, use --name")
+	}
+	client := getRouterClient(c.GlobalString("router"), c.String("admin-token"))
+	purge, err := client.CachePurge(name)
+	checkErr(err, "purge cached responses")
+
+	fmt.Printf("purged %v cached responses of trigger '%v'\n", purge.Purged, purge.Trigger)
+	return nil
+}
+
+func formatFunction(m fission.Metadata) string {
+	return m.Name + "@" + m.Uid
+}
+
+// requestMethod returns the method of the request given with
+// --method, defaulting to GET.
+func requestMethod(c *cli.Context) string {
+	method := c.String("method")
+	if len(method) == 0 {
+		return http.MethodGet
+	}
+	method = getMethod(method)
+	if method == fission.METHOD_ANY {
+		fatal("Need a request method, not ANY")
+	}
+	return method
+}
+
+// resolveRoute asks the router which route the request given with
+// --method, --url and --host takes.
+func resolveRoute(c *cli.Context) *fission.RouteResolution {
+	requestUrl := c.String("url")
+	if len(requestUrl) == 0 {
+		fatal("Need a request URL, use --url, e.g. --url /foo")
+	}
+	client := getRouterClient(c.GlobalString("router"), c.String("admin-token"))
+	res, err := client.Resolve(requestMethod(c), requestUrl, c.String("host"))
+	checkErr(err, "resolve route")
+	return res
+}
+
 func htUpdate(c *cli.Context) error {
 	client := getClient(c.GlobalString("server"))
 	htName := c.String("name")
@@ -110,6 +566,13 @@ func htUpdate(c *cli.Context) error {
 
 	newUid := c.String("uid")
 	ht.Function.Uid = newUid
+	updateRateLimit(c, ht)
+	updateAuthPolicy(c, ht)
+	updateCORSPolicy(c, ht)
+	updateCachePolicy(c, ht)
+	updateShadowPolicy(c, ht)
+	updateRetryPolicy(c, ht)
+	updateFallbackPolicy(c, ht)
 
 	_, err = client.HTTPTriggerUpdate(ht)
 	checkErr(err, "update HTTP trigger")
@@ -138,14 +601,26 @@ func htList(c *cli.Context) error {
 	hts, err := client.HTTPTriggerList()
 	checkErr(err, "list HTTP triggers")
 
+	printTriggers(hts)
+	return nil
+}
+
+func printTriggers(hts []fission.HTTPTrigger) {
 	w := tabwriter.NewWriter(os.Stdout, 0, 0, 1, ' ', 0)
 
-	fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%v\n", "NAME", "METHOD", "URL", "FUNCTION_NAME", "FUNCTION_UID")
+	fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\n", "NAME", "METHOD", "HOST", "URL", "PREFIX", "FUNCTION_NAME", "FUNCTION_UID", "RATE_LIMIT", "AUTH")
 	for _, ht := range hts {
-		fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%v\n",
-			ht.Metadata.Name, ht.Method, ht.UrlPattern, ht.Function.Name, ht.Function.Uid)
+		method := fission.METHOD_ANY
+		if methods := fission.TriggerMethods(&ht); len(methods) > 0 {
+			method = strings.Join(methods, ",")
+		}
+		authType := ""
+		if ht.Auth != nil {
+			authType = ht.Auth.Type
+		}
+		fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\n",
+			ht.Metadata.Name, method, ht.Host, ht.UrlPattern, ht.PathPrefix, ht.Function.Name, ht.Function.Uid,
+			formatRateLimit(ht.RateLimit), authType)
 	}
 	w.Flush()
-
-	return nil
 }
@@ -29,17 +29,66 @@ func main() {
 
 	app.Flags = []cli.Flag{
 		cli.StringFlag{Name: "server", Usage: "Fission server URL", EnvVar: "FISSION_URL"},
+		cli.StringFlag{Name: "router", Usage: "Fission router URL, for the router's admin API", EnvVar: "FISSION_ROUTER"},
 	}
 
 	// trigger method and url flags (used in function and route CLIs)
-	htMethodFlag := cli.StringFlag{Name: "method", Usage: "HTTP Method: GET|POST|PUT|DELETE|HEAD; defaults to GET"}
+	htMethodFlag := cli.StringSliceFlag{Name: "method", Usage: "HTTP Method: GET|POST|PUT|DELETE|HEAD|ANY; repeat for multiple methods; defaults to GET"}
 	htUrlFlag := cli.StringFlag{Name: "url", Usage: "URL pattern (See gorilla/mux supported patterns)"}
+	htHostFlag := cli.StringFlag{Name: "host", Usage: "Host to match, e.g. api.example.com; defaults to any host"}
+	htPrefixFlag := cli.StringFlag{Name: "prefix", Usage: "Path prefix to match instead of a URL pattern, e.g. /api/"}
+	htRateFlag := cli.Float64Flag{Name: "rate", Usage: "requests per second each client may make; 0 for no limit (the default)"}
+	htBurstFlag := cli.IntFlag{Name: "burst", Usage: "requests a client may make at once; defaults to the rate"}
+	htAuthFlag := cli.StringFlag{Name: "auth", Usage: "auth policy: apikey, jwt, or none to remove it"}
+	htAPIKeyFlag := cli.StringSliceFlag{Name: "apikey", Usage: "name of an API key accepted by an apikey policy (can be repeated); defaults to any key"}
+	htAPIKeyHeaderFlag := cli.StringFlag{Name: "apikey-header", Usage: "request header carrying the API key; defaults to X-Api-Key"}
+	htJWKSUrlFlag := cli.StringFlag{Name: "jwks-url", Usage: "URL of the JSON Web Key Set that JWTs are signed with"}
+	htJWTSecretFlag := cli.StringFlag{Name: "jwt-secret", Usage: "shared secret that JWTs are signed with (HS256/384/512), instead of --jwks-url"}
+	htJWTIssuerFlag := cli.StringFlag{Name: "jwt-issuer", Usage: "required JWT issuer (iss)"}
+	htJWTAudienceFlag := cli.StringFlag{Name: "jwt-audience", Usage: "required JWT audience (aud)"}
+	htJWTClaimFlag := cli.StringSliceFlag{Name: "jwt-claim", Usage: "required JWT claim, NAME=VALUE (can be repeated)"}
+	htAuthFlags := []cli.Flag{htAuthFlag, htAPIKeyFlag, htAPIKeyHeaderFlag, htJWKSUrlFlag, htJWTSecretFlag, htJWTIssuerFlag, htJWTAudienceFlag, htJWTClaimFlag}
+	htCORSOriginFlag := cli.StringSliceFlag{Name: "cors-origin", Usage: "origin allowed to call the trigger from browsers, e.g. https://app.example.com, or * for any (can be repeated); none to disallow CORS"}
+	htCORSMethodFlag := cli.StringSliceFlag{Name: "cors-method", Usage: "method allowed for CORS requests (can be repeated); defaults to the trigger's methods"}
+	htCORSHeaderFlag := cli.StringSliceFlag{Name: "cors-header", Usage: "request header allowed for CORS requests, or * for any (can be repeated)"}
+	htCORSExposeHeaderFlag := cli.StringSliceFlag{Name: "cors-expose-header", Usage: "response header browsers may read (can be repeated)"}
+	htCORSCredentialsFlag := cli.BoolFlag{Name: "cors-credentials", Usage: "allow CORS requests with cookies or HTTP auth"}
+	htCORSMaxAgeFlag := cli.IntFlag{Name: "cors-max-age", Usage: "seconds browsers may cache preflight responses"}
+	htCacheTTLFlag := cli.IntFlag{Name: "cache-ttl", Usage: "seconds the router caches responses to GET requests for; 0 to disable caching (the default)"}
+	htCacheVaryHeaderFlag := cli.StringSliceFlag{Name: "cache-vary-header", Usage: "request header that responses depend on, e.g. Accept-Language (can be repeated)"}
+	htCacheVaryQueryFlag := cli.StringSliceFlag{Name: "cache-vary-query", Usage: "query parameter that responses depend on (can be repeated); defaults to the whole query string"}
+	htCacheFlags := []cli.Flag{htCacheTTLFlag, htCacheVaryHeaderFlag, htCacheVaryQueryFlag}
+	htShadowFunctionFlag := cli.StringFlag{Name: "shadow-function", Usage: "function to mirror a sample of requests to, discarding its responses; none to stop mirroring"}
+	htShadowUidFlag := cli.StringFlag{Name: "shadow-uid", Usage: "shadow function UID (optional; uses latest if unspecified)"}
+	htShadowRateFlag := cli.Float64Flag{Name: "shadow-rate", Usage: "fraction of requests mirrored to the shadow function, more than 0 and up to 1; defaults to 1"}
+	htShadowFlags := []cli.Flag{htShadowFunctionFlag, htShadowUidFlag, htShadowRateFlag}
+	htFallbackFunctionFlag := cli.StringFlag{Name: "fallback-function", Usage: "function called when the trigger's function fails (can't start, times out or returns 5xx); none to remove the fallback"}
+	htFallbackStatusFlag := cli.IntFlag{Name: "fallback-status", Usage: "status of the static response sent when the trigger's function fails, instead of a fallback function; defaults to 503"}
+	htFallbackBodyFlag := cli.StringFlag{Name: "fallback-body", Usage: "body of the static response sent when the trigger's function fails"}
+	htFallbackContentTypeFlag := cli.StringFlag{Name: "fallback-content-type", Usage: "content type of the static fallback response; defaults to text/plain"}
+	htFallbackFlags := []cli.Flag{htFallbackFunctionFlag, htFallbackStatusFlag, htFallbackBodyFlag, htFallbackContentTypeFlag}
+	htRetryAttemptsFlag := cli.IntFlag{Name: "retry-attempts", Usage: "attempts to make for requests that fail with a retryable status, including the first; 0 to disable retries (the default)"}
+	htRetryBackoffFlag := cli.IntFlag{Name: "retry-backoff", Usage: "milliseconds before the first retry, doubled for each one after; defaults to 100"}
+	htRetryMaxBackoffFlag := cli.IntFlag{Name: "retry-max-backoff", Usage: "max milliseconds between retries; defaults to 5000"}
+	htRetryStatusFlag := cli.IntSliceFlag{Name: "retry-status", Usage: "response status retried (can be repeated); defaults to 502, 503 and 504"}
+	htRetryNonIdempotentFlag := cli.BoolFlag{Name: "retry-non-idempotent", Usage: "also retry requests that may not be safe to repeat, e.g. POST"}
+	htRetryFlags := []cli.Flag{htRetryAttemptsFlag, htRetryBackoffFlag, htRetryMaxBackoffFlag, htRetryStatusFlag, htRetryNonIdempotentFlag}
+	htCORSFlags := []cli.Flag{htCORSOriginFlag, htCORSMethodFlag, htCORSHeaderFlag, htCORSExposeHeaderFlag, htCORSCredentialsFlag, htCORSMaxAgeFlag}
+	htRateKeyFlag := cli.StringFlag{Name: "rate-key", Usage: "how clients are told apart for the rate limit: ip (the default), global, or header:NAME (e.g. header:X-Api-Key)"}
+
+	// resource and timeout flags (used in function and environment CLIs)
+	cpuRequestFlag := cli.StringFlag{Name: "cpu-request", Usage: "CPU request of the function container, e.g. 250m"}
+	cpuLimitFlag := cli.StringFlag{Name: "cpu-limit", Usage: "CPU limit of the function container, e.g. 1"}
+	memRequestFlag := cli.StringFlag{Name: "mem-request", Usage: "memory request of the function container, e.g. 64Mi"}
+	memLimitFlag := cli.StringFlag{Name: "mem-limit", Usage: "memory limit of the function container, e.g. 256Mi"}
+	timeoutFlag := cli.IntFlag{Name: "timeout", Usage: "maximum execution time of a function call, in seconds (0 for no limit)"}
 
 	// functions
 	fnNameFlag := cli.StringFlag{Name: "name", Usage: "function name"}
 	fnEnvNameFlag := cli.StringFlag{Name: "env", Usage: "environment name for function"}
 	fnCodeFlag := cli.StringFlag{Name: "code", Usage: "local path or URL for source code"}
 	fnPackageFlag := cli.StringFlag{Name: "package", Usage: "local path or URL for binary package"}
+	fnSrcFlag := cli.StringFlag{Name: "src", Usage: "local path or URL for source code to build with the environment's builder"}
 	fnUidFlag := cli.StringFlag{Name: "uid", Usage: "function uid, optional (use latest if unspecified)"}
 	fnPodFlag := cli.StringFlag{Name: "pod", Usage: "function pod name, optional (use latest if unspecified)"}
 	fnFollowFlag := cli.BoolFlag{Name: "follow, f", Usage: "specify if the logs should be streamed"}
@@ -48,12 +97,18 @@ func main() {
 	fnLogDBTypeFlag := cli.StringFlag{Name: "dbtype", Usage: "log database type, e.g. influxdb (currently only influxdb is supported)"}
 	fnUserNameFlag := cli.StringFlag{Name: "username, u", Usage: "username for connecting log database"}
 	fnPasswordFlag := cli.StringFlag{Name: "password, p", Usage: "password for connecting log database"}
+	fnEnvVarFlag := cli.StringSliceFlag{Name: "env-var", Usage: "environment variable for the function, KEY=VALUE (can be repeated)"}
+	fnSecretFlag := cli.StringSliceFlag{Name: "secret", Usage: "name of a Kubernetes secret in the function namespace to expose as env vars (can be repeated)"}
+	fnConfigMapFlag := cli.StringSliceFlag{Name: "configmap", Usage: "name of a Kubernetes configmap in the function namespace to expose as env vars (can be repeated)"}
+	fnRemoveEnvVarFlag := cli.StringSliceFlag{Name: "remove-env", Usage: "name of an environment variable to remove from the function (can be repeated)"}
+	fnRemoveSecretFlag := cli.StringSliceFlag{Name: "remove-secret", Usage: "name of a secret to stop exposing to the function (can be repeated)"}
+	fnRemoveConfigMapFlag := cli.StringSliceFlag{Name: "remove-configmap", Usage: "name of a configmap to stop exposing to the function (can be repeated)"}
 	fnSubcommands := []cli.Command{
-		{Name: "create", Usage: "Create new function (and optionally, an HTTP route to it)", Flags: []cli.Flag{fnNameFlag, fnEnvNameFlag, fnCodeFlag, fnPackageFlag, htUrlFlag, htMethodFlag}, Action: fnCreate},
+		{Name: "create", Usage: "Create new function (and optionally, an HTTP route to it)", Flags: []cli.Flag{fnNameFlag, fnEnvNameFlag, fnCodeFlag, fnPackageFlag, fnSrcFlag, htUrlFlag, htMethodFlag, fnEnvVarFlag, fnSecretFlag, fnConfigMapFlag, cpuRequestFlag, cpuLimitFlag, memRequestFlag, memLimitFlag, timeoutFlag}, Action: fnCreate},
 		{Name: "get", Usage: "Get function source code", Flags: []cli.Flag{fnNameFlag, fnUidFlag}, Action: fnGet},
 		{Name: "edit", Usage: "Edit function source code in $EDITOR", Flags: []cli.Flag{fnNameFlag, fnUidFlag}, Action: fnEdit},
 		{Name: "getmeta", Usage: "Get function metadata", Flags: []cli.Flag{fnNameFlag, fnUidFlag}, Action: fnGetMeta},
-		{Name: "update", Usage: "Update function source code", Flags: []cli.Flag{fnNameFlag, fnEnvNameFlag, fnCodeFlag, fnPackageFlag}, Action: fnUpdate},
+		{Name: "update", Usage: "Update function source code", Flags: []cli.Flag{fnNameFlag, fnEnvNameFlag, fnCodeFlag, fnPackageFlag, fnSrcFlag, fnEnvVarFlag, fnSecretFlag, fnConfigMapFlag, fnRemoveEnvVarFlag, fnRemoveSecretFlag, fnRemoveConfigMapFlag, cpuRequestFlag, cpuLimitFlag, memRequestFlag, memLimitFlag, timeoutFlag}, Action: fnUpdate},
 		{Name: "delete", Usage: "Delete function", Flags: []cli.Flag{fnNameFlag, fnUidFlag}, Action: fnDelete},
 		{Name: "list", Usage: "List all functions", Flags: []cli.Flag{}, Action: fnList},
 		{Name: "logs", Usage: "Display funtion logs", Flags: []cli.Flag{fnNameFlag, fnPodFlag, fnFollowFlag, fnDetailFlag, fnLogDBHostFlag, fnLogDBTypeFlag, fnUserNameFlag, fnPasswordFlag}, Action: fnLogs},
@@ -64,23 +119,44 @@ func main() {
 	htNameFlag := cli.StringFlag{Name: "name", Usage: "HTTP Trigger name"}
 	htFnNameFlag := cli.StringFlag{Name: "function", Usage: "Function name"}
 	htFnUidFlag := cli.StringFlag{Name: "uid", Usage: "Function UID (optional; uses latest if unspecified)"}
+	htRequestMethodFlag := cli.StringFlag{Name: "method", Usage: "request method; defaults to GET"}
+	htRequestUrlFlag := cli.StringFlag{Name: "url", Usage: "request URL path, e.g. /foo"}
+	htRequestHostFlag := cli.StringFlag{Name: "host", Usage: "request host, e.g. api.example.com"}
+	htAdminTokenFlag := cli.StringFlag{Name: "admin-token", Usage: "token for the router's admin API", EnvVar: "FISSION_ROUTER_ADMIN_TOKEN"}
+	htResolveFlags := []cli.Flag{htRequestMethodFlag, htRequestUrlFlag, htRequestHostFlag, htAdminTokenFlag}
 	htSubcommands := []cli.Command{
-		{Name: "create", Aliases: []string{"add"}, Usage: "Create HTTP trigger", Flags: []cli.Flag{htMethodFlag, htUrlFlag, htFnNameFlag, htFnUidFlag}, Action: htCreate},
-		{Name: "get", Usage: "Get HTTP trigger", Flags: []cli.Flag{htMethodFlag, htUrlFlag}, Action: htGet},
-		{Name: "update", Usage: "Update HTTP trigger", Flags: []cli.Flag{htNameFlag, htFnNameFlag, htFnUidFlag}, Action: htUpdate},
+		{Name: "create", Aliases: []string{"add"}, Usage: "Create HTTP trigger", Flags: append([]cli.Flag{htMethodFlag, htUrlFlag, htHostFlag, htPrefixFlag, htFnNameFlag, htFnUidFlag, htRateFlag, htBurstFlag, htRateKeyFlag}, append(append(append(htAuthFlags, htCORSFlags...), htCacheFlags...), append(append(htShadowFlags, htRetryFlags...), htFallbackFlags...)...)...), Action: htCreate},
+		{Name: "get", Usage: "Get HTTP trigger, by name or by a request URL it matches", Flags: append([]cli.Flag{htNameFlag}, htResolveFlags...), Action: htGet},
+		{Name: "update", Usage: "Update HTTP trigger", Flags: append([]cli.Flag{htNameFlag, htFnNameFlag, htFnUidFlag, htRateFlag, htBurstFlag, htRateKeyFlag}, append(append(append(htAuthFlags, htCORSFlags...), htCacheFlags...), append(append(htShadowFlags, htRetryFlags...), htFallbackFlags...)...)...), Action: htUpdate},
 		{Name: "delete", Usage: "Delete HTTP trigger", Flags: []cli.Flag{htNameFlag}, Action: htDelete},
 		{Name: "list", Usage: "List HTTP triggers", Flags: []cli.Flag{}, Action: htList},
+		{Name: "resolve", Usage: "Show which route the router uses for a request", Flags: htResolveFlags, Action: htResolve},
+		{Name: "shadows", Usage: "Compare triggers' functions with the shadows their requests are mirrored to", Flags: []cli.Flag{htAdminTokenFlag}, Action: htShadows},
+		{Name: "purge", Usage: "Drop a trigger's cached responses from the router", Flags: []cli.Flag{htNameFlag, htAdminTokenFlag}, Action: htPurge},
 	}
 
 	// environments
 	envNameFlag := cli.StringFlag{Name: "name", Usage: "Environment name"}
 	envImageFlag := cli.StringFlag{Name: "image", Usage: "Environment image URL"}
+	envBuilderFlag := cli.StringFlag{Name: "builder", Usage: "Builder image URL, used to build functions created with --src"}
+	envPoolSizeFlag := cli.IntFlag{Name: "poolsize", Usage: "number of idle pods kept ready for this environment"}
+	envPodReadyTimeoutFlag := cli.IntFlag{Name: "pod-ready-timeout", Usage: "seconds to wait for a pod to become ready"}
+	envIdleTimeoutFlag := cli.IntFlag{Name: "idle-timeout", Usage: "seconds after which idle function pods are deleted"}
+	envPullPolicyFlag := cli.StringFlag{Name: "image-pull-policy", Usage: "image pull policy: Always|IfNotPresent|Never"}
+	envPullSecretFlag := cli.StringSliceFlag{Name: "image-pull-secret", Usage: "name of a Kubernetes secret used to pull the image (can be repeated)"}
+	envContainerEnvFlag := cli.StringSliceFlag{Name: "container-env", Usage: "environment variable for the runtime container, KEY=VALUE (can be repeated)"}
+	envRemovePullSecretFlag := cli.StringSliceFlag{Name: "remove-image-pull-secret", Usage: "name of an image pull secret to remove from the environment (can be repeated)"}
+	envRemoveContainerEnvFlag := cli.StringSliceFlag{Name: "remove-container-env", Usage: "name of a runtime container environment variable to remove (can be repeated)"}
+	envWaitFlag := cli.BoolFlag{Name: "wait", Usage: "wait until the latest version of the environment has ready pods"}
+	envFlags := []cli.Flag{envNameFlag, envImageFlag, envBuilderFlag, cpuRequestFlag, cpuLimitFlag, memRequestFlag, memLimitFlag, timeoutFlag,
+		envPoolSizeFlag, envPodReadyTimeoutFlag, envIdleTimeoutFlag, envPullPolicyFlag, envPullSecretFlag, envContainerEnvFlag}
 	envSubcommands := []cli.Command{
-		{Name: "create", Aliases: []string{"add"}, Usage: "Add an environment", Flags: []cli.Flag{envNameFlag, envImageFlag}, Action: envCreate},
+		{Name: "create", Aliases: []string{"add"}, Usage: "Add an environment", Flags: envFlags, Action: envCreate},
 		{Name: "get", Usage: "Get environment details", Flags: []cli.Flag{envNameFlag}, Action: envGet},
-		{Name: "update", Usage: "Update environment", Flags: []cli.Flag{envNameFlag, envImageFlag}, Action: envUpdate},
+		{Name: "update", Usage: "Update environment", Flags: append(envFlags, envRemovePullSecretFlag, envRemoveContainerEnvFlag), Action: envUpdate},
 		{Name: "delete", Usage: "Delete environment", Flags: []cli.Flag{envNameFlag}, Action: envDelete},
 		{Name: "list", Usage: "List all environments", Flags: []cli.Flag{}, Action: envList},
+		{Name: "status", Usage: "Show environment pools and rollout status", Flags: []cli.Flag{envNameFlag, envWaitFlag}, Action: envStatus},
 	}
 
 	// watches
@@ -98,11 +174,32 @@ func main() {
 		{Name: "list", Usage: "List all watches", Flags: []cli.Flag{}, Action: wList},
 	}
 
+	// API keys
+	akNameFlag := cli.StringFlag{Name: "name", Usage: "API key name"}
+	akValueFlag := cli.StringFlag{Name: "value", Usage: "the key; a random one is generated if unspecified"}
+	akDescriptionFlag := cli.StringFlag{Name: "description", Usage: "what the key is for"}
+	akRotateFlag := cli.BoolFlag{Name: "rotate", Usage: "replace the key with a new random one"}
+	akSubcommands := []cli.Command{
+		{Name: "create", Aliases: []string{"add"}, Usage: "Create an API key for HTTP triggers", Flags: []cli.Flag{akNameFlag, akValueFlag, akDescriptionFlag}, Action: apiKeyCreate},
+		{Name: "update", Usage: "Update or rotate an API key", Flags: []cli.Flag{akNameFlag, akValueFlag, akDescriptionFlag, akRotateFlag}, Action: apiKeyUpdate},
+		{Name: "delete", Usage: "Delete an API key", Flags: []cli.Flag{akNameFlag}, Action: apiKeyDelete},
+		{Name: "list", Usage: "List API keys", Flags: []cli.Flag{}, Action: apiKeyList},
+	}
+
+	// builds
+	buildNameFlag := cli.StringFlag{Name: "name", Usage: "Build name"}
+	buildSubcommands := []cli.Command{
+		{Name: "get", Usage: "Get build status and log", Flags: []cli.Flag{buildNameFlag}, Action: buildGet},
+		{Name: "list", Usage: "List all builds", Flags: []cli.Flag{}, Action: buildList},
+	}
+
 	app.Commands = []cli.Command{
 		{Name: "function", Aliases: []string{"fn"}, Usage: "Create, update and manage functions", Subcommands: fnSubcommands},
 		{Name: "httptrigger", Aliases: []string{"ht", "route"}, Usage: "Manage HTTP triggers (routes) for functions", Subcommands: htSubcommands},
 		{Name: "environment", Aliases: []string{"env"}, Usage: "Manage environments", Subcommands: envSubcommands},
 		{Name: "watch", Aliases: []string{"w"}, Usage: "Manage watches", Subcommands: wSubCommands},
+		{Name: "build", Usage: "Show function builds", Subcommands: buildSubcommands},
+		{Name: "apikey", Usage: "Manage API keys for HTTP triggers", Subcommands: akSubcommands},
 
 		// Misc commands
 		{
@@ -17,13 +17,16 @@ limitations under the License.
 package poolmgr
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"log"
 	"net/http"
 	"os"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/gorilla/handlers"
@@ -32,8 +35,12 @@ import (
 	"github.com/fission/fission"
 	"github.com/fission/fission/cache"
 	controllerclient "github.com/fission/fission/controller/client"
+	"github.com/fission/fission/tracing"
 )
 
+// Max instances poolmgr runs for a function version.
+const MAX_INSTANCES_PER_FUNCTION = 10
+
 type funcSvc struct {
 	function    *fission.Metadata    // function this pod/service is for
 	environment *fission.Environment // env it was obtained from
@@ -44,12 +51,22 @@ type funcSvc struct {
 	atime time.Time
 }
 
+// functionEnv is a function (without its code) along with the
+// environment it runs in.
+type functionEnv struct {
+	function    *fission.Function
+	environment *fission.Environment
+}
+
 type API struct {
 	poolMgr     *GenericPoolManager
-	functionEnv *cache.Cache // map[fission.Metadata]fission.Environment
+	functionEnv *cache.Cache // map[fission.Metadata]*functionEnv
 	fsCache     *functionServiceCache
 	controller  *controllerclient.Client
 
+	scalingLock sync.Mutex
+	scaling     map[fission.Metadata]bool // functions getting another instance
+
 	//functionService *cache.Cache // map[fission.Metadata]*funcSvc
 	//urlFuncSvc      *cache.Cache // map[string]*funcSvc
 }
@@ -60,6 +77,7 @@ func MakeAPI(gpm *GenericPoolManager, controller *controllerclient.Client, fsCac
 		functionEnv: cache.MakeCache(time.Minute, 0),
 		fsCache:     fsCache,
 		controller:  controller,
+		scaling:     make(map[fission.Metadata]bool),
 	}
 }
 
@@ -78,89 +96,183 @@ func (api *API) getServiceForFunctionApi(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	serviceName, err := api.getServiceForFunction(&m)
+	addresses, err := api.getServicesForFunction(r.Context(), &m, 0)
 	if err != nil {
 		code, msg := fission.GetHTTPError(err)
 		log.Printf("Error: %v: %v", code, msg)
 		http.Error(w, msg, code)
+		return
 	}
 
-	w.Write([]byte(serviceName))
+	w.Write([]byte(addresses[0]))
 }
 
-func (api *API) getFunctionEnv(m *fission.Metadata) (*fission.Environment, error) {
-	var env *fission.Environment
+// getServicesForFunctionApi returns all instances of a function, as
+// fission.FunctionServices.
+func (api *API) getServicesForFunctionApi(w http.ResponseWriter, r *http.Request) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "Failed to read request", 500)
+		return
+	}
+
+	req := fission.FunctionServiceRequest{}
+	err = json.Unmarshal(body, &req)
+	if err != nil {
+		http.Error(w, "Failed to parse request", 400)
+		return
+	}
+
+	span, ctx := tracing.StartSpan(tracing.Extract(r.Context(), r.Header), "poolmgr.getServicesForFunction")
+	defer span.Finish()
+	span.SetAttribute("function", req.Function.Name)
+	span.SetAttribute("functionUid", req.Function.Uid)
+	span.SetAttribute("inFlight", req.InFlight)
+
+	addresses, err := api.getServicesForFunction(ctx, &req.Function, req.InFlight)
+	if err != nil {
+		span.SetError(err)
+		code, msg := fission.GetHTTPError(err)
+		log.Printf("Error: %v: %v", code, msg)
+		http.Error(w, msg, code)
+		return
+	}
+
+	resp, err := json.Marshal(fission.FunctionServices{Function: req.Function, Addresses: addresses})
+	if err != nil {
+		http.Error(w, "Failed to marshal function services", 500)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Write(resp)
+}
+
+func (api *API) getFunctionEnv(ctx context.Context, m *fission.Metadata) (*functionEnv, error) {
+	span, _ := tracing.StartSpan(ctx, "poolmgr.getFunctionEnv")
+	defer span.Finish()
 
 	// Cached ?
 	result, err := api.functionEnv.Get(*m)
+	span.SetAttribute("cached", err == nil)
 	if err == nil {
-		env = result.(*fission.Environment)
-		return env, nil
+		return result.(*functionEnv), nil
 	}
 
 	// Cache miss -- get func from controller
 	log.Printf("[%v] getting function from controller", m)
 	f, err := api.controller.FunctionGet(m)
 	if err != nil {
+		span.SetError(err)
 		return nil, err
 	}
+	// poolmgr only needs the function's metadata and config;
+	// fetcher downloads the code separately.
+	f.Code = ""
 
 	// Get env from metadata
 	log.Printf("[%v] getting env from controller", m)
-	env, err = api.controller.EnvironmentGet(&f.Environment)
+	env, err := api.controller.EnvironmentGet(&f.Environment)
 	if err != nil {
+		span.SetError(err)
 		return nil, err
 	}
 
 	// cache for future
-	api.functionEnv.Set(*m, env)
+	fe := &functionEnv{function: f, environment: env}
+	api.functionEnv.Set(*m, fe)
 
-	return env, nil
+	return fe, nil
 }
 
-func (api *API) getServiceForFunction(m *fission.Metadata) (string, error) {
+// getServicesForFunction returns the addresses of a function's
+// instances, specializing one if there are none.  If the caller has
+// more than TARGET_INFLIGHT_PER_INSTANCE requests in flight per
+// instance, another instance is added in the background.
+func (api *API) getServicesForFunction(ctx context.Context, m *fission.Metadata, inFlight int) ([]string, error) {
 	// Make sure we have the full metadata.  This ensures that
 	// poolmgr does not implicitly interpret empty-UID as latest
 	// version.
 	if len(m.Uid) == 0 {
-		return "", fission.MakeError(fission.ErrorInvalidArgument,
+		return nil, fission.MakeError(fission.ErrorInvalidArgument,
 			fmt.Sprintf("invalid metadata for function %v", m.Name))
 	}
 
 	// Check function -> svc cache
 	log.Printf("[%v] Checking for cached function service", m.Name)
-	fsvc, err := api.fsCache.GetByFunction(m)
+	fsvcs, err := api.fsCache.GetByFunction(m)
 	if err == nil {
-		// Cached, return svc address
-		return fsvc.address, nil
+		// Cached, return svc addresses
+		addresses := make([]string, 0, len(fsvcs))
+		for _, fsvc := range fsvcs {
+			addresses = append(addresses, fsvc.address)
+		}
+		if inFlight > fission.TARGET_INFLIGHT_PER_INSTANCE*len(fsvcs) &&
+			len(fsvcs) < MAX_INSTANCES_PER_FUNCTION {
+			go api.scaleUp(m, len(fsvcs)+1)
+		}
+		return addresses, nil
 	}
 
 	// None exists, so create a new funcSvc:
 	log.Printf("[%v] No cached function service found, creating one", m.Name)
+	fsvc, err := api.addFunctionService(ctx, m, 1)
+	if err != nil {
+		return nil, err
+	}
+	return []string{fsvc.address}, nil
+}
 
+// addFunctionService specializes a pod for a function, making it one
+// of up to limit instances.
+func (api *API) addFunctionService(ctx context.Context, m *fission.Metadata, limit int) (*funcSvc, error) {
 	// from Func -> get Env
 	log.Printf("[%v] getting environment for function", m.Name)
-	env, err := api.getFunctionEnv(m)
+	fe, err := api.getFunctionEnv(ctx, m)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
 	// from Env -> get GenericPool
 	log.Printf("[%v] getting generic pool for env", m.Name)
-	pool, err := api.poolMgr.GetPool(env)
+	resources := fission.FunctionResources(fe.function, fe.environment)
+	pool, err := api.poolMgr.GetPool(fe.environment, resources)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
 	// from GenericPool -> get one function container
 	// (this also adds to the cache)
 	log.Printf("[%v] getting function service from pool", m.Name)
-	funcSvc, err := pool.GetFuncSvc(m)
+	return pool.GetFuncSvc(ctx, fe.function, limit)
+}
+
+// scaleUp adds an instance of a function, unless one is already
+// being added.
+func (api *API) scaleUp(m *fission.Metadata, limit int) {
+	api.scalingLock.Lock()
+	if api.scaling[*m] {
+		api.scalingLock.Unlock()
+		return
+	}
+	api.scaling[*m] = true
+	api.scalingLock.Unlock()
+
+	defer func() {
+		api.scalingLock.Lock()
+		delete(api.scaling, *m)
+		api.scalingLock.Unlock()
+	}()
+
+	log.Printf("[%v] Adding instance %v of function", m.Name, limit)
+	span, ctx := tracing.StartSpan(context.Background(), "poolmgr.scaleUp")
+	defer span.Finish()
+	span.SetAttribute("function", m.Name)
+	span.SetAttribute("instances", limit)
+	_, err := api.addFunctionService(ctx, m, limit)
 	if err != nil {
-		return "", err
+		span.SetError(err)
+		log.Printf("[%v] Failed to add function instance: %v", m.Name, err)
 	}
-
-	return funcSvc.address, nil
 }
 
 // find funcSvc and update its atime
@@ -182,12 +294,74 @@ func (api *API) tapService(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 }
 
-func (api *API) Serve(port int) {
+// poolStatusApi returns the active and draining pools of the
+// environment named by the "env" query parameter.
+func (api *API) poolStatusApi(w http.ResponseWriter, r *http.Request) {
+	envName := r.FormValue("env")
+	if len(envName) == 0 {
+		http.Error(w, "Need env parameter", 400)
+		return
+	}
+
+	resp, err := json.Marshal(api.poolMgr.PoolStatus(envName))
+	if err != nil {
+		http.Error(w, "Failed to marshal pool status", 500)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Write(resp)
+}
+
+// evictionsApi returns the addresses of function services removed
+// after the sequence number in the "since" query parameter.  Routers
+// poll it to stop using services of reaped pods.
+func (api *API) evictionsApi(w http.ResponseWriter, r *http.Request) {
+	var since uint64
+	if s := r.FormValue("since"); len(s) > 0 {
+		var err error
+		since, err = strconv.ParseUint(s, 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid since parameter", 400)
+			return
+		}
+	}
+
+	resp, err := json.Marshal(api.fsCache.ListEvictions(since))
+	if err != nil {
+		http.Error(w, "Failed to marshal evictions", 500)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Write(resp)
+}
+
+// functionServicesApi returns the instances of all functions.
+func (api *API) functionServicesApi(w http.ResponseWriter, r *http.Request) {
+	resp, err := json.Marshal(api.fsCache.ListFunctions())
+	if err != nil {
+		http.Error(w, "Failed to marshal function services", 500)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Write(resp)
+}
+
+// Serve serves the poolmgr API until stop is closed, then waits up to
+// shutdownTimeout for in-flight requests (such as specializations) to
+// finish.
+func (api *API) Serve(port int, stop <-chan struct{}, shutdownTimeout time.Duration) error {
 	r := mux.NewRouter()
 	r.HandleFunc("/v1/getServiceForFunction", api.getServiceForFunctionApi).Methods("POST")
+	r.HandleFunc("/v1/getServicesForFunction", api.getServicesForFunctionApi).Methods("POST")
+	r.HandleFunc("/v1/functionServices", api.functionServicesApi).Methods("GET")
 	r.HandleFunc("/v1/tapService", api.tapService).Methods("POST")
+	r.HandleFunc("/v1/pools", api.poolStatusApi).Methods("GET")
+	r.HandleFunc("/v1/evictions", api.evictionsApi).Methods("GET")
 
-	address := fmt.Sprintf(":%v", port)
+	server := &http.Server{
+		Addr:    fmt.Sprintf(":%v", port),
+		Handler: handlers.LoggingHandler(os.Stdout, r),
+	}
 	log.Printf("starting poolmgr at port %v", port)
-	log.Fatal(http.ListenAndServe(address, handlers.LoggingHandler(os.Stdout, r)))
+	return fission.ServeUntilStopped(server, stop, shutdownTimeout)
 }
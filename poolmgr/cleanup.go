@@ -24,6 +24,13 @@ import (
 	"k8s.io/client-go/1.5/pkg/api"
 )
 
+// Pods might still be running user functions after we stop routing
+// to them, so we give them a few minutes before terminating them.
+// This time is the maximum function runtime, plus the time a router
+// might still route to an old instance, i.e. router cache expiry
+// time.
+const OLD_POD_GRACE_PERIOD = 6 * time.Minute
+
 // cleanupOldPoolmgrResources looks for resources created by an old
 // poolmgr instance and cleans them up.
 func cleanupOldPoolmgrResources(client *kubernetes.Clientset, namespace string, instanceId string) {
@@ -53,12 +60,8 @@ func cleanup(client *kubernetes.Clientset, namespace string, instanceId string)
 		return err
 	}
 
-	// Pods might still be running user functions, so we give them
-	// a few minutes before terminating them.  This time is the
-	// maximum function runtime, plus the time a router might
-	// still route to an old instance, i.e. router cache expiry
-	// time.
-	time.Sleep(6 * time.Minute)
+	// Pods might still be running user functions.
+	time.Sleep(OLD_POD_GRACE_PERIOD)
 
 	err = cleanupPods(client, namespace, instanceId)
 	if err != nil {
@@ -17,12 +17,15 @@ limitations under the License.
 package client
 
 import (
+	"context"
+	"fmt"
 	"net/http"
 	"strings"
 
 	"bytes"
 	"encoding/json"
 	"github.com/fission/fission"
+	"github.com/fission/fission/tracing"
 	"io/ioutil"
 	"net/url"
 )
@@ -60,6 +63,75 @@ func (c *Client) GetServiceForFunction(metadata *fission.Metadata) (string, erro
 	return string(svcName), nil
 }
 
+// GetServicesForFunction returns the addresses of a function's
+// instances.  inFlight is the number of requests the caller has
+// outstanding to the function, which poolmgr uses to decide whether
+// to add instances.  The trace context in ctx is passed on, so
+// poolmgr's spans join the caller's trace.
+func (c *Client) GetServicesForFunction(ctx context.Context, metadata *fission.Metadata, inFlight int) ([]string, error) {
+	body, err := json.Marshal(fission.FunctionServiceRequest{
+		Function: *metadata,
+		InFlight: inFlight,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequest("POST", c.poolmgrUrl+"/v1/getServicesForFunction", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	tracing.Inject(ctx, req.Header)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		return nil, fission.MakeErrorFromHTTP(resp)
+	}
+
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	fs := fission.FunctionServices{}
+	err = json.Unmarshal(respBody, &fs)
+	if err != nil {
+		return nil, err
+	}
+	return fs.Addresses, nil
+}
+
+// FunctionServices returns the instances of all functions.
+func (c *Client) FunctionServices() ([]fission.FunctionServices, error) {
+	resp, err := http.Get(c.poolmgrUrl + "/v1/functionServices")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		return nil, fission.MakeErrorFromHTTP(resp)
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	functions := make([]fission.FunctionServices, 0)
+	err = json.Unmarshal(body, &functions)
+	if err != nil {
+		return nil, err
+	}
+	return functions, nil
+}
+
 func (c *Client) TapService(serviceUrl *url.URL) error {
 	url := c.poolmgrUrl + "/v1/tapService"
 
@@ -75,3 +147,54 @@ func (c *Client) TapService(serviceUrl *url.URL) error {
 	}
 	return nil
 }
+
+// PoolStatus returns the pools poolmgr keeps for an environment.
+func (c *Client) PoolStatus(envName string) ([]fission.PoolStatus, error) {
+	resp, err := http.Get(c.poolmgrUrl + "/v1/pools?env=" + url.QueryEscape(envName))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		return nil, fission.MakeErrorFromHTTP(resp)
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	pools := make([]fission.PoolStatus, 0)
+	err = json.Unmarshal(body, &pools)
+	if err != nil {
+		return nil, err
+	}
+	return pools, nil
+}
+
+// ServiceEvictions returns the addresses of function services
+// poolmgr removed after sequence number since.
+func (c *Client) ServiceEvictions(since uint64) (*fission.ServiceEvictions, error) {
+	resp, err := http.Get(fmt.Sprintf("%v/v1/evictions?since=%v", c.poolmgrUrl, since))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		return nil, fission.MakeErrorFromHTTP(resp)
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	evictions := &fission.ServiceEvictions{}
+	err = json.Unmarshal(body, evictions)
+	if err != nil {
+		return nil, err
+	}
+	return evictions, nil
+}
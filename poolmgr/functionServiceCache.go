@@ -17,6 +17,7 @@ limitations under the License.
 package poolmgr
 
 import (
+	"fmt"
 	"log"
 	"time"
 
@@ -31,26 +32,55 @@ const (
 	LISTOLD
 	LOG
 	DELETE_BY_POD
+	LIST_BY_ENV
+	LIST_EVICTIONS
+	GET_BY_FUNCTION
+	ADD
+	LIST_FUNCTIONS
 )
 
+// Number of evicted service addresses remembered for routers.
+const MAX_EVICTIONS = 1000
+
 type (
+	// functionServiceCache keeps the instances (specialized pods)
+	// of each function.  All access goes through the service
+	// goroutine.
 	functionServiceCache struct {
-		byFunction *cache.Cache // function -> funcSvc : map[fission.Metadata]*funcSvc
+		byFunction *cache.Cache // function -> instances : map[fission.Metadata]*functionInstances
 		byAddress  *cache.Cache // address -> function : map[string]fission.Metadata
 		byPod      *cache.Cache // podname -> function : map[string]fission.Metadata
 
+		// addresses of the last MAX_EVICTIONS deleted services;
+		// evictions[i] has sequence number evictionSeq-len(evictions)+i+1
+		evictions   []string
+		evictionSeq uint64
+
 		requestChannel chan *fscRequest
 	}
+	// functionInstances are the services of a function, oldest
+	// first.
+	functionInstances struct {
+		svcs []*funcSvc
+	}
 	fscRequest struct {
 		requestType     fscRequestType
+		function        *fission.Metadata
+		fsvc            *funcSvc
+		limit           int
 		address         string
 		podName         string
+		envUid          string
+		seq             uint64
 		age             time.Duration
 		responseChannel chan *fscResponse
 	}
 	fscResponse struct {
-		podNames []string
-		deleted  bool
+		podNames  []string
+		deleted   bool
+		evictions *fission.ServiceEvictions
+		fsvcs     []*funcSvc
+		functions []fission.FunctionServices
 		error
 	}
 )
@@ -76,64 +106,142 @@ func (fsc *functionServiceCache) service() {
 			resp.error = fsc._touchByAddress(req.address)
 		case LISTOLD:
 			// get svcs idle for > req.age
-			byPodCopy := fsc.byPod.Copy()
 			pods := make([]string, 0)
-			for podNameI, mI := range byPodCopy {
-				m := mI.(fission.Metadata)
-				fsvcI, err := fsc.byFunction.Get(m)
-				if err != nil {
-					resp.error = err
-				} else {
-					fsvc := fsvcI.(*funcSvc)
-					if time.Now().Sub(fsvc.atime) > req.age {
-						podName := podNameI.(string)
-						pods = append(pods, podName)
-					}
+			for _, fsvc := range fsc._all() {
+				if time.Now().Sub(fsvc.atime) > req.age {
+					pods = append(pods, fsvc.podName)
 				}
 			}
 			resp.podNames = pods
 		case LOG:
 			funcCopy := fsc.byFunction.Copy()
 			log.Printf("Cache has %v entries", len(funcCopy))
-			for mI, fsvcI := range funcCopy {
+			for mI, instancesI := range funcCopy {
 				m := mI.(fission.Metadata)
-				fsvc := fsvcI.(*funcSvc)
-				log.Printf("%v:%v\t%v", m.Name, m.Uid, fsvc.podName)
+				for _, fsvc := range instancesI.(*functionInstances).svcs {
+					log.Printf("%v:%v\t%v", m.Name, m.Uid, fsvc.podName)
+				}
 			}
 		case DELETE_BY_POD:
 			resp.deleted, resp.error = fsc._deleteByPod(req.podName, req.age)
+		case LIST_BY_ENV:
+			// get svcs specialized from an env version
+			pods := make([]string, 0)
+			for _, fsvc := range fsc._all() {
+				if fsvc.environment.Metadata.Uid == req.envUid {
+					pods = append(pods, fsvc.podName)
+				}
+			}
+			resp.podNames = pods
+		case LIST_EVICTIONS:
+			resp.evictions = fsc._listEvictions(req.seq)
+		case GET_BY_FUNCTION:
+			resp.fsvcs, resp.error = fsc._getByFunction(req.function)
+		case ADD:
+			resp.fsvcs, resp.error = fsc._add(req.fsvc, req.limit)
+		case LIST_FUNCTIONS:
+			functions := make([]fission.FunctionServices, 0)
+			for mI, instancesI := range fsc.byFunction.Copy() {
+				fs := fission.FunctionServices{
+					Function:  mI.(fission.Metadata),
+					Addresses: make([]string, 0),
+				}
+				for _, fsvc := range instancesI.(*functionInstances).svcs {
+					fs.Addresses = append(fs.Addresses, fsvc.address)
+				}
+				functions = append(functions, fs)
+			}
+			resp.functions = functions
 		}
 		req.responseChannel <- resp
 	}
 }
 
-func (fsc *functionServiceCache) GetByFunction(m *fission.Metadata) (*funcSvc, error) {
-	fsvcI, err := fsc.byFunction.Get(*m)
+// _all returns all function services.
+func (fsc *functionServiceCache) _all() []*funcSvc {
+	fsvcs := make([]*funcSvc, 0)
+	for _, instancesI := range fsc.byFunction.Copy() {
+		fsvcs = append(fsvcs, instancesI.(*functionInstances).svcs...)
+	}
+	return fsvcs
+}
+
+// _find returns the instances of function m, and the index of the
+// first one that match is true for.
+func (fsc *functionServiceCache) _find(m fission.Metadata, match func(*funcSvc) bool) (*functionInstances, int, error) {
+	instancesI, err := fsc.byFunction.Get(m)
 	if err != nil {
-		return nil, err
+		return nil, 0, err
+	}
+	instances := instancesI.(*functionInstances)
+	for i, fsvc := range instances.svcs {
+		if match(fsvc) {
+			return instances, i, nil
+		}
 	}
-	// update atime
-	fsvc := fsvcI.(*funcSvc)
-	fsvc.atime = time.Now()
+	return nil, 0, fission.MakeError(fission.ErrorNotFound,
+		fmt.Sprintf("service not found for function %v", m))
+}
 
-	fsvcCopy := *fsvc
-	return &fsvcCopy, nil
+// GetByFunction returns the instances of a function, oldest first.
+func (fsc *functionServiceCache) GetByFunction(m *fission.Metadata) ([]*funcSvc, error) {
+	responseChannel := make(chan *fscResponse)
+	fsc.requestChannel <- &fscRequest{
+		requestType:     GET_BY_FUNCTION,
+		function:        m,
+		responseChannel: responseChannel,
+	}
+	resp := <-responseChannel
+	return resp.fsvcs, resp.error
 }
 
-func (fsc *functionServiceCache) Add(fsvc funcSvc) (error, *funcSvc) {
-	err, existing := fsc.byFunction.Set(*fsvc.function, &fsvc)
+func (fsc *functionServiceCache) _getByFunction(m *fission.Metadata) ([]*funcSvc, error) {
+	instancesI, err := fsc.byFunction.Get(*m)
 	if err != nil {
-		if existing != nil {
-			f := existing.(*funcSvc)
-			err2 := fsc.TouchByAddress(f.address)
-			if err2 != nil {
-				return err2, nil
-			}
-			fCopy := *f
-			return err, &fCopy
-		}
-		return err, nil
+		return nil, err
+	}
+	fsvcs := make([]*funcSvc, 0)
+	for _, fsvc := range instancesI.(*functionInstances).svcs {
+		fsvcCopy := *fsvc
+		fsvcs = append(fsvcs, &fsvcCopy)
+	}
+	return fsvcs, nil
+}
+
+// Add adds an instance of a function, unless the function already
+// has limit instances.  In that case, an error and the oldest
+// existing instance are returned.
+func (fsc *functionServiceCache) Add(fsvc funcSvc, limit int) (error, *funcSvc) {
+	responseChannel := make(chan *fscResponse)
+	fsc.requestChannel <- &fscRequest{
+		requestType:     ADD,
+		fsvc:            &fsvc,
+		limit:           limit,
+		responseChannel: responseChannel,
+	}
+	resp := <-responseChannel
+	if len(resp.fsvcs) > 0 {
+		return resp.error, resp.fsvcs[0]
+	}
+	return resp.error, nil
+}
+
+func (fsc *functionServiceCache) _add(fsvc *funcSvc, limit int) ([]*funcSvc, error) {
+	var instances *functionInstances
+	instancesI, err := fsc.byFunction.Get(*fsvc.function)
+	if err == nil {
+		instances = instancesI.(*functionInstances)
+	} else {
+		instances = &functionInstances{}
+	}
+	if len(instances.svcs) >= limit && len(instances.svcs) > 0 {
+		existing := *instances.svcs[0]
+		existing.atime = time.Now()
+		instances.svcs[0].atime = existing.atime
+		return []*funcSvc{&existing},
+			fmt.Errorf("function %v already has %v instances", fsvc.function, len(instances.svcs))
 	}
+
 	now := time.Now()
 	fsvc.ctime = now
 	fsvc.atime = now
@@ -141,12 +249,17 @@ func (fsc *functionServiceCache) Add(fsvc funcSvc) (error, *funcSvc) {
 	err, _ = fsc.byAddress.Set(fsvc.address, *fsvc.function)
 	if err != nil {
 		log.Printf("error caching fsvc: %v", err)
-		return err, nil
+		return nil, err
 	}
 	err, _ = fsc.byPod.Set(fsvc.podName, *fsvc.function)
 	if err != nil {
 		log.Printf("error caching fsvc: %v", err)
-		return err, nil
+		fsc.byAddress.Delete(fsvc.address)
+		return nil, err
+	}
+	instances.svcs = append(instances.svcs, fsvc)
+	if len(instances.svcs) == 1 {
+		fsc.byFunction.Set(*fsvc.function, instances)
 	}
 	return nil, nil
 }
@@ -167,13 +280,13 @@ func (fsc *functionServiceCache) _touchByAddress(address string) error {
 	if err != nil {
 		return err
 	}
-	m := mI.(fission.Metadata)
-	fsvcI, err := fsc.byFunction.Get(m)
+	instances, i, err := fsc._find(mI.(fission.Metadata), func(fsvc *funcSvc) bool {
+		return fsvc.address == address
+	})
 	if err != nil {
 		return err
 	}
-	fsvc := fsvcI.(*funcSvc)
-	fsvc.atime = time.Now()
+	instances.svcs[i].atime = time.Now()
 	return nil
 }
 
@@ -189,30 +302,70 @@ func (fsc *functionServiceCache) DeleteByPod(podName string, minAge time.Duratio
 	return resp.deleted, resp.error
 }
 
-// _deleteByPod deletes the entry keyed by podName, but only if it is
-// at least minAge old.
+// _deleteByPod deletes the instance running in podName, but only if
+// it is at least minAge old.
 func (fsc *functionServiceCache) _deleteByPod(podName string, minAge time.Duration) (bool, error) {
 	mI, err := fsc.byPod.Get(podName)
 	if err != nil {
 		return false, err
 	}
 	m := mI.(fission.Metadata)
-	fsvcI, err := fsc.byFunction.Get(m)
+	instances, i, err := fsc._find(m, func(fsvc *funcSvc) bool {
+		return fsvc.podName == podName
+	})
 	if err != nil {
 		return false, err
 	}
-	fsvc := fsvcI.(*funcSvc)
+	fsvc := instances.svcs[i]
 
 	if time.Now().Sub(fsvc.atime) < minAge {
 		return false, nil
 	}
 
-	fsc.byFunction.Delete(m)
+	instances.svcs = append(instances.svcs[:i], instances.svcs[i+1:]...)
+	if len(instances.svcs) == 0 {
+		fsc.byFunction.Delete(m)
+	}
 	fsc.byAddress.Delete(fsvc.address)
 	fsc.byPod.Delete(podName)
+
+	fsc.evictions = append(fsc.evictions, fsvc.address)
+	if len(fsc.evictions) > MAX_EVICTIONS {
+		fsc.evictions = fsc.evictions[len(fsc.evictions)-MAX_EVICTIONS:]
+	}
+	fsc.evictionSeq++
 	return true, nil
 }
 
+// ListEvictions returns the addresses of services deleted after
+// sequence number since.
+func (fsc *functionServiceCache) ListEvictions(since uint64) *fission.ServiceEvictions {
+	responseChannel := make(chan *fscResponse)
+	fsc.requestChannel <- &fscRequest{
+		requestType:     LIST_EVICTIONS,
+		seq:             since,
+		responseChannel: responseChannel,
+	}
+	resp := <-responseChannel
+	return resp.evictions
+}
+
+func (fsc *functionServiceCache) _listEvictions(since uint64) *fission.ServiceEvictions {
+	evictions := &fission.ServiceEvictions{
+		Since:     since,
+		Seq:       fsc.evictionSeq,
+		Addresses: make([]string, 0),
+	}
+	oldest := fsc.evictionSeq - uint64(len(fsc.evictions)) // seq before the first one we know
+	if since > fsc.evictionSeq || since < oldest {
+		// poolmgr restarted, or the caller missed evictions
+		evictions.Reset = true
+		return evictions
+	}
+	evictions.Addresses = append(evictions.Addresses, fsc.evictions[since-oldest:]...)
+	return evictions
+}
+
 func (fsc *functionServiceCache) ListOld(age time.Duration) ([]string, error) {
 	responseChannel := make(chan *fscResponse)
 	fsc.requestChannel <- &fscRequest{
@@ -224,6 +377,30 @@ func (fsc *functionServiceCache) ListOld(age time.Duration) ([]string, error) {
 	return resp.podNames, resp.error
 }
 
+// ListByEnv returns the pods of function services specialized from
+// the environment version with uid envUid.
+func (fsc *functionServiceCache) ListByEnv(envUid string) ([]string, error) {
+	responseChannel := make(chan *fscResponse)
+	fsc.requestChannel <- &fscRequest{
+		requestType:     LIST_BY_ENV,
+		envUid:          envUid,
+		responseChannel: responseChannel,
+	}
+	resp := <-responseChannel
+	return resp.podNames, resp.error
+}
+
+// ListFunctions returns the instances of all functions.
+func (fsc *functionServiceCache) ListFunctions() []fission.FunctionServices {
+	responseChannel := make(chan *fscResponse)
+	fsc.requestChannel <- &fscRequest{
+		requestType:     LIST_FUNCTIONS,
+		responseChannel: responseChannel,
+	}
+	resp := <-responseChannel
+	return resp.functions
+}
+
 func (fsc *functionServiceCache) Log() {
 	log.Printf("--- FunctionService Cache Contents")
 	responseChannel := make(chan *fscResponse)
@@ -34,17 +34,18 @@ func TestFunctionServiceCache(t *testing.T) {
 		ctime:   now,
 		atime:   now,
 	}
-	err, _ := fsc.Add(*fsvc)
+	err, _ := fsc.Add(*fsvc, 1)
 	if err != nil {
 		fsc.Log()
 		log.Panicf("Failed to add fsvc: %v", err)
 	}
 
-	f, err := fsc.GetByFunction(fsvc.function)
-	if err != nil {
+	fsvcs, err := fsc.GetByFunction(fsvc.function)
+	if err != nil || len(fsvcs) != 1 {
 		fsc.Log()
-		log.Panicf("Failed to get fsvc: %v", err)
+		log.Panicf("Failed to get fsvc: %v (%v instances)", err, len(fsvcs))
 	}
+	f := fsvcs[0]
 	fsvc.atime = f.atime
 	fsvc.ctime = f.ctime
 	if *f != *fsvc {
@@ -52,13 +53,53 @@ func TestFunctionServiceCache(t *testing.T) {
 		log.Panicf("Incorrect fsvc \n(expected: %#v)\n (found: %#v)", fsvc, f)
 	}
 
+	// a second instance is only added if the limit allows it
+	fsvc2 := *fsvc
+	fsvc2.address = "xxx2"
+	fsvc2.podName = "yyy2"
+	err, existing := fsc.Add(fsvc2, 1)
+	if err == nil || existing == nil || existing.podName != fsvc.podName {
+		fsc.Log()
+		log.Panicf("Expected existing instance over the limit, got %v (err %v)", existing, err)
+	}
+	err, _ = fsc.Add(fsvc2, 2)
+	if err != nil {
+		fsc.Log()
+		log.Panicf("Failed to add second instance: %v", err)
+	}
+	fsvcs, err = fsc.GetByFunction(fsvc.function)
+	if err != nil || len(fsvcs) != 2 || fsvcs[1].address != fsvc2.address {
+		fsc.Log()
+		log.Panicf("Expected two instances: %v (err %v)", fsvcs, err)
+	}
+	functions := fsc.ListFunctions()
+	if len(functions) != 1 || len(functions[0].Addresses) != 2 {
+		log.Panicf("Incorrect function services: %#v", functions)
+	}
+	deleted, err := fsc.DeleteByPod(fsvc2.podName, 0)
+	if err != nil || !deleted {
+		fsc.Log()
+		log.Panicf("Failed to delete second instance: %v", err)
+	}
+
 	err = fsc.TouchByAddress(fsvc.address)
 	if err != nil {
 		fsc.Log()
 		log.Panicf("Failed to touch fsvc: %v", err)
 	}
 
-	deleted, err := fsc.DeleteByPod(fsvc.podName, 0)
+	pods, err := fsc.ListByEnv(fsvc.environment.Metadata.Uid)
+	if err != nil || len(pods) != 1 || pods[0] != fsvc.podName {
+		fsc.Log()
+		log.Panicf("Incorrect pods for env: %v (err %v)", pods, err)
+	}
+	pods, err = fsc.ListByEnv("other")
+	if err != nil || len(pods) != 0 {
+		fsc.Log()
+		log.Panicf("Expected no pods for other env: %v (err %v)", pods, err)
+	}
+
+	deleted, err = fsc.DeleteByPod(fsvc.podName, 0)
 	if err != nil {
 		fsc.Log()
 		log.Panicf("Failed to delete fsvc: %v", err)
@@ -68,6 +109,19 @@ func TestFunctionServiceCache(t *testing.T) {
 		log.Panicf("Did not delete fsvc")
 	}
 
+	ev := fsc.ListEvictions(0)
+	if ev.Seq != 2 || ev.Reset || len(ev.Addresses) != 2 || ev.Addresses[1] != fsvc.address {
+		log.Panicf("Incorrect evictions since 0: %#v", ev)
+	}
+	ev = fsc.ListEvictions(2)
+	if ev.Seq != 2 || ev.Reset || len(ev.Addresses) != 0 {
+		log.Panicf("Expected no evictions since 2: %#v", ev)
+	}
+	ev = fsc.ListEvictions(5)
+	if !ev.Reset {
+		log.Panicf("Expected reset for unknown sequence number: %#v", ev)
+	}
+
 	_, err = fsc.GetByFunction(fsvc.function)
 	if err == nil {
 		fsc.Log()
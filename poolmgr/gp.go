@@ -18,6 +18,7 @@ package poolmgr
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -32,6 +33,7 @@ import (
 	"github.com/dchest/uniuri"
 	"k8s.io/client-go/1.5/kubernetes"
 	"k8s.io/client-go/1.5/pkg/api"
+	"k8s.io/client-go/1.5/pkg/api/resource"
 	"k8s.io/client-go/1.5/pkg/api/v1"
 	"k8s.io/client-go/1.5/pkg/apis/extensions/v1beta1"
 	"k8s.io/client-go/1.5/pkg/labels"
@@ -39,16 +41,27 @@ import (
 
 	"github.com/fission/fission"
 	"github.com/fission/fission/logger"
+	"github.com/fission/fission/tracing"
 )
 
 const POOLMGR_INSTANCEID_LABEL string = "poolmgrInstanceId"
 const POD_PHASE_RUNNING string = "Running"
+const POOL_INSTANCEID_LABEL string = "poolInstanceId"
+
+// Pool defaults, used when an environment doesn't specify them.
+const (
+	DEFAULT_POOL_SIZE          int32         = 3
+	DEFAULT_POD_READY_TIMEOUT  time.Duration = 5 * time.Minute
+	DEFAULT_IDLE_POD_REAP_TIME time.Duration = 3 * time.Minute
+)
 
 type (
 	GenericPool struct {
 		env              *fission.Environment
+		resources        fission.Resources   // resources of the function container
 		replicas         int32               // num idle pods
 		deployment       *v1beta1.Deployment // kubernetes deployment
+		deploymentName   string              // name of the deployment
 		namespace        string              // namespace to keep our resources
 		podReadyTimeout  time.Duration       // timeout for generic pods to become ready
 		controllerUrl    string
@@ -60,10 +73,13 @@ type (
 		instanceId       string // poolmgr instance id
 		labelsForPool    map[string]string
 		requestChannel   chan *choosePodRequest
+		done             chan bool       // closed when the pool is destroyed
+		stop             <-chan struct{} // closed when poolmgr shuts down
 	}
 
 	// serialize the choosing of pods so that choices don't conflict
 	choosePodRequest struct {
+		ctx             context.Context // trace context of the caller
 		newLabels       map[string]string
 		responseChannel chan *choosePodResponse
 	}
@@ -71,41 +87,60 @@ type (
 		pod *v1.Pod
 		error
 	}
+
+	// specializeRequest is the body of the specialize call to
+	// the function run container.
+	specializeRequest struct {
+		EnvVars map[string]string `json:"envVars,omitempty"`
+	}
 )
 
 func MakeGenericPool(
 	controllerUrl string,
 	kubernetesClient *kubernetes.Clientset,
 	env *fission.Environment,
+	resources fission.Resources,
 	initialReplicas int32,
 	namespace string,
 	fsCache *functionServiceCache,
-	instanceId string) (*GenericPool, error) {
+	instanceId string,
+	stop <-chan struct{}) (*GenericPool, error) {
 
 	log.Printf("Creating pool for environment %v", env.Metadata)
-	// TODO: in general we need to provide the user a way to configure pools.  Initial
-	// replicas, autoscaling params, various timeouts, etc.
+	// TODO: autoscaling params
 	gp := &GenericPool{
 		env:              env,
-		replicas:         initialReplicas, // TODO make this an env param instead?
+		resources:        resources,
+		replicas:         initialReplicas,
 		requestChannel:   make(chan *choosePodRequest),
+		done:             make(chan bool),
+		stop:             stop,
 		kubernetesClient: kubernetesClient,
 		namespace:        namespace,
-		podReadyTimeout:  5 * time.Minute, // TODO make this an env param?
+		podReadyTimeout:  DEFAULT_POD_READY_TIMEOUT,
 		controllerUrl:    controllerUrl,
-		idlePodReapTime:  3 * time.Minute, // TODO make this configurable
+		idlePodReapTime:  DEFAULT_IDLE_POD_REAP_TIME,
 		fsCache:          fsCache,
 		poolInstanceId:   uniuri.NewLen(8),
 		instanceId:       instanceId,
 
 		useSvc: false, // defaults off -- svc takes a second or more to become routable, slowing cold start
 	}
+	if env.PodReadyTimeout > 0 {
+		gp.podReadyTimeout = time.Duration(env.PodReadyTimeout) * time.Second
+	}
+	if env.IdlePodReapTime > 0 {
+		gp.idlePodReapTime = time.Duration(env.IdlePodReapTime) * time.Second
+	}
 
-	// Labels for generic deployment/RS/pods.
+	// Labels for generic deployment/RS/pods.  There may be more
+	// than one pool per environment (with different resources), so
+	// the pool instance id keeps their pods apart.
 	gp.labelsForPool = map[string]string{
 		"environmentName":        gp.env.Metadata.Name,
 		"environmentUid":         gp.env.Metadata.Uid,
 		POOLMGR_INSTANCEID_LABEL: gp.instanceId,
+		POOL_INSTANCEID_LABEL:    strings.ToLower(gp.poolInstanceId),
 	}
 
 	// create the pool
@@ -122,12 +157,17 @@ func MakeGenericPool(
 	return gp, nil
 }
 
-// choosePodService serializes the choosing of pods
+// choosePodService serializes the choosing of pods, until the pool is
+// destroyed or poolmgr shuts down.
 func (gp *GenericPool) choosePodService() {
 	for {
 		select {
+		case <-gp.done:
+			return
+		case <-gp.stop:
+			return
 		case req := <-gp.requestChannel:
-			pod, err := gp._choosePod(req.newLabels)
+			pod, err := gp._choosePod(req.ctx, req.newLabels)
 			if err != nil {
 				req.responseChannel <- &choosePodResponse{error: err}
 				continue
@@ -139,18 +179,37 @@ func (gp *GenericPool) choosePodService() {
 
 // choosePod picks a ready pod from the pool and relabels it, waiting if necessary.
 // returns the pod API object.
-func (gp *GenericPool) choosePod(newLabels map[string]string) (*v1.Pod, error) {
+func (gp *GenericPool) choosePod(ctx context.Context, newLabels map[string]string) (*v1.Pod, error) {
+	span, ctx := tracing.StartSpan(ctx, "poolmgr.choosePod")
+	defer span.Finish()
+
 	req := &choosePodRequest{
+		ctx:             ctx,
 		newLabels:       newLabels,
 		responseChannel: make(chan *choosePodResponse),
 	}
-	gp.requestChannel <- req
+	select {
+	case gp.requestChannel <- req:
+	case <-gp.done:
+		err := fmt.Errorf("pool for environment %v was destroyed", gp.env.Metadata)
+		span.SetError(err)
+		return nil, err
+	case <-gp.stop:
+		err := fmt.Errorf("poolmgr is shutting down")
+		span.SetError(err)
+		return nil, err
+	}
 	resp := <-req.responseChannel
+	if resp.error != nil {
+		span.SetError(resp.error)
+	} else {
+		span.SetAttribute("pod", resp.pod.ObjectMeta.Name)
+	}
 	return resp.pod, resp.error
 }
 
 // _choosePod is called serially by choosePodService
-func (gp *GenericPool) _choosePod(newLabels map[string]string) (*v1.Pod, error) {
+func (gp *GenericPool) _choosePod(ctx context.Context, newLabels map[string]string) (*v1.Pod, error) {
 	startTime := time.Now()
 	for {
 		// Retries took too long, error out.
@@ -210,7 +269,11 @@ func (gp *GenericPool) _choosePod(newLabels map[string]string) (*v1.Pod, error)
 		// retry.
 		chosenPod.ObjectMeta.Labels = newLabels
 		log.Printf("relabeling pod: [%v]", chosenPod.ObjectMeta.Name)
+		span, _ := tracing.StartSpan(ctx, "poolmgr.relabel")
+		span.SetAttribute("pod", chosenPod.ObjectMeta.Name)
 		_, err = gp.kubernetesClient.Core().Pods(gp.namespace).Update(chosenPod)
+		span.SetError(err)
+		span.Finish()
 		if err != nil {
 			log.Printf("failed to relabel pod [%v]: %v", chosenPod.ObjectMeta.Name, err)
 			continue
@@ -224,11 +287,28 @@ func (gp *GenericPool) labelsForFunction(metadata *fission.Metadata) map[string]
 	return map[string]string{
 		"functionName":           metadata.Name,
 		"functionUid":            metadata.Uid,
+		"environmentUid":         gp.env.Metadata.Uid,
 		"unmanaged":              "true", // this allows us to easily find pods not managed by the deployment
 		POOLMGR_INSTANCEID_LABEL: gp.instanceId,
 	}
 }
 
+// specializedPods lists the pods specialized from this pool's
+// environment version.
+func (gp *GenericPool) specializedPods() ([]v1.Pod, error) {
+	podList, err := gp.kubernetesClient.Core().Pods(gp.namespace).List(api.ListOptions{
+		LabelSelector: labels.Set(map[string]string{
+			"environmentUid":         gp.env.Metadata.Uid,
+			"unmanaged":              "true",
+			POOLMGR_INSTANCEID_LABEL: gp.instanceId,
+		}).AsSelector(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return podList.Items, nil
+}
+
 func (gp *GenericPool) scheduleDeletePod(name string) {
 	go func() {
 		// The sleep allows debugging or collecting logs from the pod before it's
@@ -240,16 +320,62 @@ func (gp *GenericPool) scheduleDeletePod(name string) {
 	}()
 }
 
+// getFunctionEnvVars builds the environment variables for a
+// function: all keys of its configmaps and secrets, overridden by its
+// explicitly set env vars.
+func (gp *GenericPool) getFunctionEnvVars(fn *fission.Function) (map[string]string, error) {
+	envVars := make(map[string]string)
+	for _, name := range fn.ConfigMaps {
+		cm, err := gp.kubernetesClient.Core().ConfigMaps(gp.namespace).Get(name)
+		if err != nil {
+			return nil, fmt.Errorf("error getting configmap '%v': %v", name, err)
+		}
+		for k, v := range cm.Data {
+			envVars[k] = v
+		}
+	}
+	for _, name := range fn.Secrets {
+		secret, err := gp.kubernetesClient.Core().Secrets(gp.namespace).Get(name)
+		if err != nil {
+			return nil, fmt.Errorf("error getting secret '%v': %v", name, err)
+		}
+		for k, v := range secret.Data {
+			envVars[k] = string(v)
+		}
+	}
+	for _, ev := range fn.EnvVars {
+		envVars[ev.Name] = ev.Value
+	}
+	return envVars, nil
+}
+
 // specializePod chooses a pod, copies the required user-defined function to that pod
 // (via fetcher), and calls the function-run container to load it, resulting in a
 // specialized pod.
-func (gp *GenericPool) specializePod(pod *v1.Pod, metadata *fission.Metadata) error {
+func (gp *GenericPool) specializePod(ctx context.Context, pod *v1.Pod, fn *fission.Function) error {
+	metadata := &fn.Metadata
+
+	span, ctx := tracing.StartSpan(ctx, "poolmgr.specializePod")
+	defer span.Finish()
+	span.SetAttribute("pod", pod.ObjectMeta.Name)
+
 	// for fetcher we don't need to create a service, just talk to the pod directly
 	podIP := pod.Status.PodIP
 	if len(podIP) == 0 {
 		return errors.New("Pod has no IP")
 	}
 
+	// Resolve the function's config before doing any work on the
+	// pod, so that a missing secret fails fast.
+	envVars, err := gp.getFunctionEnvVars(fn)
+	if err != nil {
+		return err
+	}
+	specializeBody, err := json.Marshal(specializeRequest{EnvVars: envVars})
+	if err != nil {
+		return err
+	}
+
 	// tell fetcher to get the function.
 	fetcherUrl := fmt.Sprintf("http://%v:8000/", podIP)
 	functionUrl := fmt.Sprintf("%v/v1/functions/%v?uid=%v&raw=1",
@@ -257,15 +383,24 @@ func (gp *GenericPool) specializePod(pod *v1.Pod, metadata *fission.Metadata) er
 	fetcherRequest := fmt.Sprintf("{\"url\": \"%v\", \"filename\": \"user\"}", functionUrl)
 
 	log.Printf("[%v] calling fetcher to copy function", metadata)
-	resp, err := http.Post(fetcherUrl, "application/json", bytes.NewReader([]byte(fetcherRequest)))
+	fetchSpan, fetchCtx := tracing.StartSpan(ctx, "poolmgr.fetch")
+	resp, err := tracedPost(fetchCtx, fetcherUrl, []byte(fetcherRequest))
 	if err != nil {
 		// TODO we should retry this call in case fetcher hasn't come up yet
+		fetchSpan.SetError(err)
+		fetchSpan.Finish()
+		span.SetError(err)
 		return err
 	}
 	defer resp.Body.Close()
 	if resp.StatusCode != 200 {
-		return errors.New(fmt.Sprintf("Error from fetcher: %v", resp.Status))
+		err = errors.New(fmt.Sprintf("Error from fetcher: %v", resp.Status))
+		fetchSpan.SetError(err)
+		fetchSpan.Finish()
+		span.SetError(err)
+		return err
 	}
+	fetchSpan.Finish()
 
 	// Tell logging helper about this function invocation
 	gp.setupLogging(pod, metadata)
@@ -273,11 +408,14 @@ func (gp *GenericPool) specializePod(pod *v1.Pod, metadata *fission.Metadata) er
 	// get function run container to specialize
 	log.Printf("[%v] specializing pod", metadata)
 	specializeUrl := fmt.Sprintf("http://%v:8888/specialize", podIP)
+	specializeSpan, specializeCtx := tracing.StartSpan(ctx, "poolmgr.specialize")
+	defer specializeSpan.Finish()
 
 	// retry the specialize call a few times in case the env server hasn't come up yet
 	maxRetries := 20
 	for i := 0; i < maxRetries; i++ {
-		resp2, err := http.Post(specializeUrl, "text/plain", bytes.NewReader([]byte{}))
+		specializeSpan.SetAttribute("attempts", i+1)
+		resp2, err := tracedPost(specializeCtx, specializeUrl, specializeBody)
 		if err == nil && resp2.StatusCode < 300 {
 			// Success
 			resp2.Body.Close()
@@ -301,22 +439,85 @@ func (gp *GenericPool) specializePod(pod *v1.Pod, metadata *fission.Metadata) er
 			err = fission.MakeErrorFromHTTP(resp2)
 		}
 		log.Printf("Failed to specialize pod: %v", err)
+		specializeSpan.SetError(err)
+		span.SetError(err)
 		return err
 	}
 
 	return nil
 }
 
+// tracedPost posts a JSON body, passing on the trace context in ctx.
+func tracedPost(ctx context.Context, target string, body []byte) (*http.Response, error) {
+	req, err := http.NewRequest("POST", target, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	tracing.Inject(ctx, req.Header)
+	return http.DefaultClient.Do(req)
+}
+
+// resourceRequirements converts fission resources to the
+// kubernetes container resource spec.
+func resourceRequirements(r fission.Resources) (v1.ResourceRequirements, error) {
+	req := v1.ResourceRequirements{
+		Requests: v1.ResourceList{},
+		Limits:   v1.ResourceList{},
+	}
+	quantities := []struct {
+		value string
+		name  v1.ResourceName
+		list  v1.ResourceList
+	}{
+		{r.CpuRequest, v1.ResourceCPU, req.Requests},
+		{r.CpuLimit, v1.ResourceCPU, req.Limits},
+		{r.MemoryRequest, v1.ResourceMemory, req.Requests},
+		{r.MemoryLimit, v1.ResourceMemory, req.Limits},
+	}
+	for _, q := range quantities {
+		if len(q.value) == 0 {
+			continue
+		}
+		quantity, err := resource.ParseQuantity(q.value)
+		if err != nil {
+			return req, err
+		}
+		q.list[q.name] = quantity
+	}
+	return req, nil
+}
+
 // A pool is a deployment of generic containers for an env.  This
 // creates the pool but doesn't wait for any pods to be ready.
 func (gp *GenericPool) createPool() error {
-	poolDeploymentName := fmt.Sprintf("%v-%v-%v",
+	gp.deploymentName = fmt.Sprintf("%v-%v-%v",
 		gp.env.Metadata.Name, gp.env.Metadata.Uid, strings.ToLower(gp.poolInstanceId))
 
+	resources, err := resourceRequirements(gp.resources)
+	if err != nil {
+		return err
+	}
+
+	pullPolicy := v1.PullIfNotPresent
+	if len(gp.env.ImagePullPolicy) > 0 {
+		pullPolicy = v1.PullPolicy(gp.env.ImagePullPolicy)
+	}
+
+	pullSecrets := make([]v1.LocalObjectReference, 0, len(gp.env.ImagePullSecrets))
+	for _, name := range gp.env.ImagePullSecrets {
+		pullSecrets = append(pullSecrets, v1.LocalObjectReference{Name: name})
+	}
+
+	envVars := make([]v1.EnvVar, 0, len(gp.env.ContainerEnvVars))
+	for _, ev := range gp.env.ContainerEnvVars {
+		envVars = append(envVars, v1.EnvVar{Name: ev.Name, Value: ev.Value})
+	}
+
 	sharedMountPath := "/userfunc"
 	deployment := &v1beta1.Deployment{
 		ObjectMeta: v1.ObjectMeta{
-			Name:   poolDeploymentName,
+			Name:   gp.deploymentName,
 			Labels: gp.labelsForPool,
 		},
 		Spec: v1beta1.DeploymentSpec{
@@ -329,6 +530,7 @@ func (gp *GenericPool) createPool() error {
 					Labels: gp.labelsForPool,
 				},
 				Spec: v1.PodSpec{
+					ImagePullSecrets: pullSecrets,
 					Volumes: []v1.Volume{
 						{
 							Name: "userfunc",
@@ -341,8 +543,10 @@ func (gp *GenericPool) createPool() error {
 						{
 							Name:                   gp.env.Metadata.Name,
 							Image:                  gp.env.RunContainerImageUrl,
-							ImagePullPolicy:        v1.PullIfNotPresent,
+							ImagePullPolicy:        pullPolicy,
 							TerminationMessagePath: "/dev/termination-log",
+							Resources:              resources,
+							Env:                    envVars,
 							VolumeMounts: []v1.VolumeMount{
 								{
 									Name:      "userfunc",
@@ -418,16 +622,26 @@ func (gp *GenericPool) createSvc(name string, labels map[string]string) (*v1.Ser
 	return svc, err
 }
 
-func (gp *GenericPool) GetFuncSvc(m *fission.Metadata) (*funcSvc, error) {
+// GetFuncSvc specializes a pod for function fn, making it one of up
+// to limit instances of the function.  If the function already has
+// limit instances, the oldest one is returned instead.
+func (gp *GenericPool) GetFuncSvc(ctx context.Context, fn *fission.Function, limit int) (*funcSvc, error) {
+	m := &fn.Metadata
+
+	if gp.useSvc && limit > 1 {
+		// the function's k8s service would select all its pods
+		return nil, fission.MakeError(fission.ErrorInvalidArgument,
+			"multiple function instances need pod addresses, not services")
+	}
 
 	log.Printf("[%v] Choosing pod from pool", m)
 	newLabels := gp.labelsForFunction(m)
-	pod, err := gp.choosePod(newLabels)
+	pod, err := gp.choosePod(ctx, newLabels)
 	if err != nil {
 		return nil, err
 	}
 
-	err = gp.specializePod(pod, m)
+	err = gp.specializePod(ctx, pod, fn)
 	if err != nil {
 		gp.scheduleDeletePod(pod.ObjectMeta.Name)
 		return nil, err
@@ -469,8 +683,8 @@ func (gp *GenericPool) GetFuncSvc(m *fission.Metadata) (*funcSvc, error) {
 		atime:       time.Now(),
 	}
 
-	err, existingFsvc := gp.fsCache.Add(*fsvc)
-	if err != nil {
+	err, existingFsvc := gp.fsCache.Add(*fsvc, limit)
+	if err != nil && existingFsvc != nil {
 		// Some other thread beat us to it -- return the other thread's fsvc and clean up
 		// our own.  TODO: this is grossly inefficient, improve it with some sort of state
 		// machine
@@ -479,6 +693,9 @@ func (gp *GenericPool) GetFuncSvc(m *fission.Metadata) (*funcSvc, error) {
 			gp.kubernetesClient.Core().Pods(gp.namespace).Delete(fsvc.podName, nil)
 		}()
 		return existingFsvc, nil
+	} else if err != nil {
+		gp.scheduleDeletePod(fsvc.podName)
+		return nil, err
 	}
 	return fsvc, nil
 }
@@ -495,6 +712,11 @@ func (gp *GenericPool) CleanupFunctionService(podName string) error {
 		return nil
 	}
 
+	return gp.deletePod(podName)
+}
+
+// deletePod stops log collection for a specialized pod and deletes it.
+func (gp *GenericPool) deletePod(podName string) error {
 	pod, err := gp.kubernetesClient.Core().Pods(gp.namespace).Get(podName)
 	if err != nil {
 		return err
@@ -523,7 +745,13 @@ func (gp *GenericPool) CleanupFunctionService(podName string) error {
 
 func (gp *GenericPool) idlePodReaper() {
 	for {
-		time.Sleep(time.Minute)
+		select {
+		case <-gp.done:
+			return
+		case <-gp.stop:
+			return
+		case <-time.After(time.Minute):
+		}
 		podNames, err := gp.fsCache.ListOld(gp.idlePodReapTime)
 		if err != nil {
 			log.Printf("Error reaping idle pods: %v", err)
@@ -541,6 +769,8 @@ func (gp *GenericPool) idlePodReaper() {
 
 // destroys the pool -- the deployment, replicaset and pods
 func (gp *GenericPool) destroy() error {
+	close(gp.done)
+
 	// Destroy deployment
 	err := gp.kubernetesClient.Extensions().Deployments(gp.namespace).Delete(gp.deployment.ObjectMeta.Name, nil)
 	if err != nil {
@@ -579,6 +809,84 @@ func (gp *GenericPool) destroy() error {
 	return nil
 }
 
+// drain retires a pool whose environment was updated.  Once the
+// replacement pool (if any) has a ready pod, the idle pods are
+// destroyed.  Function services specialized from the old env version
+// are evicted, so that their next call gets a pod from the new pool,
+// and their pods are deleted after a grace period for in-flight
+// requests and router caches.
+func (gp *GenericPool) drain(replacement *GenericPool) {
+	if replacement != nil {
+		startTime := time.Now()
+		for time.Now().Sub(startTime) < replacement.podReadyTimeout {
+			ready, err := replacement.availableReplicas()
+			if err == nil && ready > 0 {
+				break
+			}
+			time.Sleep(time.Second)
+		}
+	}
+
+	err := gp.destroy()
+	if err != nil {
+		log.Printf("[%v] Error destroying old pool: %v", gp.env.Metadata, err)
+	}
+
+	podNames, err := gp.fsCache.ListByEnv(gp.env.Metadata.Uid)
+	if err != nil {
+		log.Printf("[%v] Error listing function services: %v", gp.env.Metadata, err)
+	}
+	for _, podName := range podNames {
+		_, err = gp.fsCache.DeleteByPod(podName, 0)
+		if err != nil {
+			log.Printf("[%v] Error evicting function service %v: %v", gp.env.Metadata, podName, err)
+		}
+	}
+
+	time.Sleep(OLD_POD_GRACE_PERIOD)
+
+	pods, err := gp.specializedPods()
+	if err != nil {
+		log.Printf("[%v] Error listing specialized pods: %v", gp.env.Metadata, err)
+		return
+	}
+	for _, pod := range pods {
+		err = gp.deletePod(pod.ObjectMeta.Name)
+		if err != nil {
+			log.Printf("[%v] Error deleting pod %v: %v", gp.env.Metadata, pod.ObjectMeta.Name, err)
+		}
+	}
+}
+
+// availableReplicas returns the number of ready idle pods.
+func (gp *GenericPool) availableReplicas() (int32, error) {
+	depl, err := gp.kubernetesClient.Extensions().Deployments(gp.namespace).Get(gp.deploymentName)
+	if err != nil {
+		return 0, err
+	}
+	return depl.Status.AvailableReplicas, nil
+}
+
+func (gp *GenericPool) status(state fission.PoolState) fission.PoolStatus {
+	ps := fission.PoolStatus{
+		Environment: gp.env.Metadata,
+		Resources:   gp.resources,
+		State:       state,
+	}
+	if state == fission.PoolStateActive {
+		ps.Replicas = int(gp.replicas)
+		ready, err := gp.availableReplicas()
+		if err == nil {
+			ps.ReadyReplicas = int(ready)
+		}
+	}
+	pods, err := gp.specializedPods()
+	if err == nil {
+		ps.SpecializedPods = len(pods)
+	}
+	return ps
+}
+
 // Calls the logging daemonset pod on the node where the given pod is
 // running.
 func (gp *GenericPool) setupLogging(pod *v1.Pod, metadata *fission.Metadata) {
@@ -0,0 +1,50 @@
+/*
+Copyright 2016 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package poolmgr
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/fission/fission"
+)
+
+func TestChoosePodServiceStops(t *testing.T) {
+	gp := &GenericPool{
+		env:            &fission.Environment{Metadata: fission.Metadata{Name: "env", Uid: "1"}},
+		requestChannel: make(chan *choosePodRequest),
+		done:           make(chan bool),
+	}
+	stopped := make(chan bool)
+	go func() {
+		gp.choosePodService()
+		close(stopped)
+	}()
+
+	close(gp.done)
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatalf("choosePodService didn't stop when the pool was destroyed")
+	}
+
+	_, err := gp.choosePod(context.Background(), nil)
+	if err == nil {
+		t.Fatalf("expected an error choosing a pod from a destroyed pool")
+	}
+}
@@ -17,6 +17,7 @@ limitations under the License.
 package poolmgr
 
 import (
+	"fmt"
 	"log"
 	"time"
 
@@ -31,11 +32,27 @@ type requestType int
 const (
 	GET_POOL requestType = iota
 	CLEANUP_POOLS
+	POOL_DRAINED
+	LIST_POOLS
+)
+
+const (
+	// Max time to wait for the controller when checking which
+	// version of an environment is current.
+	ENV_CHECK_TIMEOUT = 10 * time.Second
+	// Replaced versions remembered per environment; requests for
+	// older ones ask the controller again.
+	MAX_RETIRED_ENV_VERSIONS = 16
 )
 
 type (
 	GenericPoolManager struct {
-		pools            map[fission.Environment]*GenericPool
+		pools            map[poolKey]*GenericPool
+		drainingPools    map[*GenericPool]bool           // pools of replaced env versions
+		envs             map[string]*fission.Environment // latest version of each env, by name
+		retiredEnvUids   map[string][]string             // env versions known to be replaced, by env name
+		envCheckSeq      uint64                          // numbers controller checks of env versions
+		envChecked       map[string]uint64               // latest check applied to each env, by name
 		kubernetesClient *kubernetes.Clientset
 		namespace        string
 		controllerUrl    string
@@ -43,16 +60,37 @@ type (
 		fsCache          *functionServiceCache
 		instanceId       string
 		requestChannel   chan *request
+		stop             <-chan struct{} // closed when poolmgr shuts down
+	}
+	// Pools are per environment and container resources: functions
+	// that need other resources than their environment's defaults
+	// get pods from a separate pool.  Only the latest version of
+	// an environment has pools in this map; when the environment
+	// is updated its pools are replaced and drained.
+	poolKey struct {
+		envName   string
+		resources fission.Resources
 	}
 	request struct {
 		requestType
 		env             *fission.Environment
+		resources       fission.Resources
+		checkSeq        uint64               // nonzero once the controller was asked about env
+		currentEnv      *fission.Environment // controller's version of env; nil if the check failed
 		envList         []fission.Environment
+		envName         string
+		pool            *GenericPool
 		responseChannel chan *response
 	}
 	response struct {
 		error
-		pool *GenericPool
+		pool     *GenericPool
+		pools    []poolWithState
+		checkSeq uint64 // nonzero if the controller must be asked about the env
+	}
+	poolWithState struct {
+		pool  *GenericPool
+		state fission.PoolState
 	}
 )
 
@@ -61,10 +99,15 @@ func MakeGenericPoolManager(
 	kubernetesClient *kubernetes.Clientset,
 	namespace string,
 	fsCache *functionServiceCache,
-	instanceId string) *GenericPoolManager {
+	instanceId string,
+	stop <-chan struct{}) *GenericPoolManager {
 
 	gpm := &GenericPoolManager{
-		pools:            make(map[fission.Environment]*GenericPool),
+		pools:            make(map[poolKey]*GenericPool),
+		drainingPools:    make(map[*GenericPool]bool),
+		envs:             make(map[string]*fission.Environment),
+		retiredEnvUids:   make(map[string][]string),
+		envChecked:       make(map[string]uint64),
 		kubernetesClient: kubernetesClient,
 		namespace:        namespace,
 		controllerUrl:    controllerUrl,
@@ -72,6 +115,7 @@ func MakeGenericPoolManager(
 		fsCache:          fsCache,
 		instanceId:       instanceId,
 		requestChannel:   make(chan *request),
+		stop:             stop,
 	}
 	go gpm.service()
 	go gpm.eagerPoolCreator()
@@ -84,49 +128,224 @@ func (gpm *GenericPoolManager) service() {
 		req := <-gpm.requestChannel
 		switch req.requestType {
 		case GET_POOL:
-			var err error
-			pool, ok := gpm.pools[*req.env]
-			if !ok {
-				pool, err = MakeGenericPool(
-					gpm.controllerUrl, gpm.kubernetesClient, req.env,
-					3, // TODO configurable/autoscalable
-					gpm.namespace, gpm.fsCache, gpm.instanceId)
-				if err != nil {
-					req.responseChannel <- &response{error: err}
-					continue
-				}
-				gpm.pools[*req.env] = pool
+			env, checkSeq := gpm.latestEnv(req)
+			if checkSeq > 0 {
+				// GetPool asks the controller, and retries.
+				req.responseChannel <- &response{checkSeq: checkSeq}
+				continue
+			}
+			gpm.envs[env.Metadata.Name] = env
+
+			key := poolKey{envName: env.Metadata.Name, resources: req.resources}
+			pool, ok := gpm.pools[key]
+			if ok && pool.env.Metadata.Uid == env.Metadata.Uid {
+				req.responseChannel <- &response{pool: pool}
+				continue
 			}
-			req.responseChannel <- &response{pool: pool}
+
+			// Keep pools for function-specific resources small,
+			// since they're only used by a few functions.
+			replicas := DEFAULT_POOL_SIZE // TODO autoscalable
+			if env.PoolSize > 0 {
+				replicas = int32(env.PoolSize)
+			}
+			if req.resources != env.Resources {
+				replicas = 1
+			}
+			newPool, err := MakeGenericPool(
+				gpm.controllerUrl, gpm.kubernetesClient, env,
+				req.resources, replicas,
+				gpm.namespace, gpm.fsCache, gpm.instanceId, gpm.stop)
+			if err != nil {
+				req.responseChannel <- &response{error: err}
+				continue
+			}
+			gpm.pools[key] = newPool
+			if ok {
+				// The env was updated: new specializations
+				// use the new pool, and the old one is drained.
+				log.Printf("Rolling out environment %v (replacing %v)", env.Metadata, pool.env.Metadata)
+				gpm.retire(pool, newPool)
+			}
+			req.responseChannel <- &response{pool: newPool}
 		case CLEANUP_POOLS:
-			uids := make(map[string]bool)
-			for _, env := range req.envList {
-				uids[env.Metadata.Uid] = true
+			latest := make(map[string]*fission.Environment)
+			for i := range req.envList {
+				latest[req.envList[i].Metadata.Name] = &req.envList[i]
 			}
-			for env, pool := range gpm.pools {
-				_, ok := uids[env.Metadata.Uid]
+			for key, pool := range gpm.pools {
+				env, ok := latest[key.envName]
 				if !ok {
 					// Env no longer exists -- remove our cache
-					log.Printf("Destroying generic pool for environment [%v]", env)
-					delete(gpm.pools, env)
+					log.Printf("Destroying generic pool for environment [%v]", pool.env.Metadata)
+					delete(gpm.pools, key)
+					gpm.forgetEnv(key.envName)
 
 					// and delete the pool asynchronously.
 					go pool.destroy()
+				} else if env.Metadata.Uid != pool.env.Metadata.Uid {
+					// Env was updated, and nothing asked
+					// for a replacement of this pool yet.
+					delete(gpm.pools, key)
+					gpm.envs[key.envName] = env
+					gpm.retire(pool, nil)
+				}
+			}
+			for name := range gpm.envs {
+				if _, ok := latest[name]; !ok {
+					gpm.forgetEnv(name)
 				}
 			}
 			// no response, caller doesn't wait
+		case POOL_DRAINED:
+			log.Printf("Drained generic pool for environment [%v]", req.pool.env.Metadata)
+			delete(gpm.drainingPools, req.pool)
+			// no response, caller doesn't wait
+		case LIST_POOLS:
+			pools := make([]poolWithState, 0)
+			for _, pool := range gpm.pools {
+				if pool.env.Metadata.Name == req.envName {
+					pools = append(pools, poolWithState{pool: pool, state: fission.PoolStateActive})
+				}
+			}
+			for pool := range gpm.drainingPools {
+				if pool.env.Metadata.Name == req.envName {
+					pools = append(pools, poolWithState{pool: pool, state: fission.PoolStateDraining})
+				}
+			}
+			req.responseChannel <- &response{pools: pools}
 		}
 	}
 }
 
-func (gpm *GenericPoolManager) GetPool(env *fission.Environment) (*GenericPool, error) {
-	c := make(chan *response)
-	gpm.requestChannel <- &request{
+// latestEnv returns the env version to use for a GET_POOL request.
+// Requests can carry an older version than the one we know (e.g. from
+// a cached function), and env uids aren't ordered, so when they differ
+// the controller decides which one is current: latestEnv returns a
+// check number, and GetPool asks the controller and retries with the
+// answer.  An older version is never used, so it can't roll back the
+// pool.  Must be called from the service goroutine.
+func (gpm *GenericPoolManager) latestEnv(req *request) (*fission.Environment, uint64) {
+	env := req.env
+	name := env.Metadata.Name
+	knownEnv, ok := gpm.envs[name]
+	if !ok || knownEnv.Metadata.Uid == env.Metadata.Uid {
+		return env, 0
+	}
+	if gpm.isRetired(env.Metadata) {
+		return knownEnv, 0
+	}
+	if req.checkSeq == 0 {
+		gpm.envCheckSeq++
+		return nil, gpm.envCheckSeq
+	}
+
+	// Answers can arrive out of order; a check started before the
+	// last one applied may be stale.
+	if req.currentEnv == nil || req.checkSeq < gpm.envChecked[name] {
+		return knownEnv, 0
+	}
+	gpm.envChecked[name] = req.checkSeq
+	if req.currentEnv.Metadata.Uid != env.Metadata.Uid {
+		// Remember the stale version, so later requests for it
+		// don't ask the controller again.
+		gpm.addRetired(env.Metadata)
+		if gpm.isRetired(req.currentEnv.Metadata) {
+			return knownEnv, 0
+		}
+	}
+	return req.currentEnv, 0
+}
+
+// isRetired returns true if env version m is known to be replaced.
+func (gpm *GenericPoolManager) isRetired(m fission.Metadata) bool {
+	for _, uid := range gpm.retiredEnvUids[m.Name] {
+		if uid == m.Uid {
+			return true
+		}
+	}
+	return false
+}
+
+// addRetired remembers that env version m was replaced, forgetting
+// the oldest versions beyond MAX_RETIRED_ENV_VERSIONS.
+func (gpm *GenericPoolManager) addRetired(m fission.Metadata) {
+	if gpm.isRetired(m) {
+		return
+	}
+	uids := append(gpm.retiredEnvUids[m.Name], m.Uid)
+	if len(uids) > MAX_RETIRED_ENV_VERSIONS {
+		uids = uids[len(uids)-MAX_RETIRED_ENV_VERSIONS:]
+	}
+	gpm.retiredEnvUids[m.Name] = uids
+}
+
+// forgetEnv drops what we know about a deleted env.
+func (gpm *GenericPoolManager) forgetEnv(name string) {
+	delete(gpm.envs, name)
+	delete(gpm.retiredEnvUids, name)
+	delete(gpm.envChecked, name)
+}
+
+// getCurrentEnv asks the controller for the current version of env
+// name, waiting up to ENV_CHECK_TIMEOUT.
+func (gpm *GenericPoolManager) getCurrentEnv(name string) (*fission.Environment, error) {
+	type result struct {
+		env *fission.Environment
+		err error
+	}
+	c := make(chan result, 1)
+	go func() {
+		env, err := gpm.controllerClient.EnvironmentGet(&fission.Metadata{Name: name})
+		c <- result{env: env, err: err}
+	}()
+	select {
+	case r := <-c:
+		return r.env, r.err
+	case <-time.After(ENV_CHECK_TIMEOUT):
+		return nil, fmt.Errorf("timed out after %v", ENV_CHECK_TIMEOUT)
+	}
+}
+
+// retire drains pool, which was replaced by replacement (nil if the
+// pool's env was updated but has no replacement for these resources
+// yet).  Must be called from the service goroutine.
+func (gpm *GenericPoolManager) retire(pool *GenericPool, replacement *GenericPool) {
+	gpm.drainingPools[pool] = true
+	gpm.addRetired(pool.env.Metadata)
+	go func() {
+		pool.drain(replacement)
+		gpm.requestChannel <- &request{
+			requestType: POOL_DRAINED,
+			pool:        pool,
+		}
+	}()
+}
+
+// GetPool returns the pool of pods for env with the given container
+// resources, creating it if necessary.  If env is a newer version of
+// an environment than the pool's, the pool is replaced by a new one.
+func (gpm *GenericPoolManager) GetPool(env *fission.Environment, resources fission.Resources) (*GenericPool, error) {
+	req := &request{
 		requestType:     GET_POOL,
 		env:             env,
-		responseChannel: c,
+		resources:       resources,
+		responseChannel: make(chan *response),
+	}
+	gpm.requestChannel <- req
+	resp := <-req.responseChannel
+	if resp.checkSeq > 0 {
+		// Ask the controller outside the service goroutine, so
+		// a slow controller doesn't block other requests.
+		currentEnv, err := gpm.getCurrentEnv(env.Metadata.Name)
+		if err != nil {
+			log.Printf("Failed to get environment %v, keeping the known version: %v", env.Metadata.Name, err)
+		}
+		req.checkSeq = resp.checkSeq
+		req.currentEnv = currentEnv
+		gpm.requestChannel <- req
+		resp = <-req.responseChannel
 	}
-	resp := <-c
 	return resp.pool, resp.error
 }
 
@@ -137,12 +356,36 @@ func (gpm *GenericPoolManager) CleanupPools(envs []fission.Environment) {
 	}
 }
 
+// PoolStatus returns the active and draining pools of an
+// environment.
+func (gpm *GenericPoolManager) PoolStatus(envName string) []fission.PoolStatus {
+	c := make(chan *response)
+	gpm.requestChannel <- &request{
+		requestType:     LIST_POOLS,
+		envName:         envName,
+		responseChannel: c,
+	}
+	resp := <-c
+
+	status := make([]fission.PoolStatus, 0, len(resp.pools))
+	for _, p := range resp.pools {
+		status = append(status, p.pool.status(p.state))
+	}
+	return status
+}
+
+// eagerPoolCreator creates pools for all environments, until poolmgr
+// shuts down.
 func (gpm *GenericPoolManager) eagerPoolCreator() {
 	failureCount := 0
 	maxFailures := 5
 	pollSleep := time.Duration(2 * time.Second)
 	for {
-		time.Sleep(pollSleep)
+		select {
+		case <-gpm.stop:
+			return
+		case <-time.After(pollSleep):
+		}
 
 		// get list of envs from controller
 		envs, err := gpm.controllerClient.EnvironmentList()
@@ -158,7 +401,7 @@ func (gpm *GenericPoolManager) eagerPoolCreator() {
 		// to keep these eagerly created pools smaller than the ones created when there are
 		// actual function calls.
 		for _, env := range envs {
-			_, err := gpm.GetPool(&env)
+			_, err := gpm.GetPool(&env, env.Resources)
 			if err != nil {
 				log.Printf("eager-create pool failed: %v", err)
 			}
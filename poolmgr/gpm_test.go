@@ -0,0 +1,129 @@
+/*
+Copyright 2016 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package poolmgr
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/fission/fission"
+	"github.com/fission/fission/controller/client"
+)
+
+func TestLatestEnv(t *testing.T) {
+	current := fission.Environment{Metadata: fission.Metadata{Name: "env", Uid: "new"}}
+	gets := 0
+	controller := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gets++
+		json.NewEncoder(w).Encode(current)
+	}))
+	defer controller.Close()
+
+	gpm := &GenericPoolManager{
+		envs:             make(map[string]*fission.Environment),
+		retiredEnvUids:   make(map[string][]string),
+		envChecked:       make(map[string]uint64),
+		controllerClient: client.MakeClient(controller.URL),
+	}
+	known := current
+	gpm.envs["env"] = &known
+
+	// resolve does what GetPool does: ask the controller when the
+	// service loop wants it to, and retry with the answer.
+	resolve := func(e *fission.Environment) *fission.Environment {
+		env, seq := gpm.latestEnv(&request{env: e})
+		if seq == 0 {
+			return env
+		}
+		cur, err := gpm.getCurrentEnv(e.Metadata.Name)
+		if err != nil {
+			t.Fatalf("getting current env: %v", err)
+		}
+		env, seq = gpm.latestEnv(&request{env: e, checkSeq: seq, currentEnv: cur})
+		if seq != 0 {
+			t.Fatalf("asked to check env %v twice", e.Metadata.Uid)
+		}
+		return env
+	}
+
+	// An older version that never had a pool must not replace the
+	// current one.
+	old := &fission.Environment{Metadata: fission.Metadata{Name: "env", Uid: "old"}}
+	env := resolve(old)
+	if env.Metadata.Uid != "new" || gets != 1 {
+		t.Fatalf("old env: got version %v after %v gets", env.Metadata.Uid, gets)
+	}
+
+	// The stale version is remembered.
+	env = resolve(old)
+	if env.Metadata.Uid != "new" || gets != 1 {
+		t.Fatalf("old env again: got version %v after %v gets", env.Metadata.Uid, gets)
+	}
+
+	// A newer version is accepted once the controller confirms it.
+	newer := &fission.Environment{Metadata: fission.Metadata{Name: "env", Uid: "newer"}}
+	current = *newer
+	env = resolve(newer)
+	if env.Metadata.Uid != "newer" || gets != 2 {
+		t.Fatalf("newer env: got version %v after %v gets", env.Metadata.Uid, gets)
+	}
+	gpm.envs["env"] = env
+
+	// An answer to a check started before the last applied one is
+	// stale, and ignored.
+	x := &fission.Environment{Metadata: fission.Metadata{Name: "env", Uid: "x"}}
+	newest := &fission.Environment{Metadata: fission.Metadata{Name: "env", Uid: "newest"}}
+	_, seq1 := gpm.latestEnv(&request{env: x})
+	_, seq2 := gpm.latestEnv(&request{env: newest})
+	env, _ = gpm.latestEnv(&request{env: newest, checkSeq: seq2, currentEnv: newest})
+	if env.Metadata.Uid != "newest" {
+		t.Fatalf("check %v: got version %v", seq2, env.Metadata.Uid)
+	}
+	gpm.envs["env"] = env
+	env, _ = gpm.latestEnv(&request{env: x, checkSeq: seq1, currentEnv: x})
+	if env.Metadata.Uid != "newest" {
+		t.Fatalf("stale check %v: got version %v", seq1, env.Metadata.Uid)
+	}
+}
+
+func TestRetiredEnvs(t *testing.T) {
+	gpm := &GenericPoolManager{
+		envs:           make(map[string]*fission.Environment),
+		retiredEnvUids: make(map[string][]string),
+		envChecked:     make(map[string]uint64),
+	}
+	for i := 0; i <= MAX_RETIRED_ENV_VERSIONS; i++ {
+		gpm.addRetired(fission.Metadata{Name: "env", Uid: fmt.Sprintf("v%v", i)})
+	}
+	if n := len(gpm.retiredEnvUids["env"]); n != MAX_RETIRED_ENV_VERSIONS {
+		t.Fatalf("remembered %v versions, want %v", n, MAX_RETIRED_ENV_VERSIONS)
+	}
+	if gpm.isRetired(fission.Metadata{Name: "env", Uid: "v0"}) {
+		t.Fatalf("oldest version wasn't forgotten")
+	}
+	if !gpm.isRetired(fission.Metadata{Name: "env", Uid: "v1"}) {
+		t.Fatalf("newer version was forgotten")
+	}
+
+	gpm.forgetEnv("env")
+	if len(gpm.retiredEnvUids) != 0 {
+		t.Fatalf("deleted env's versions weren't forgotten")
+	}
+}
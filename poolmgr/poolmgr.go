@@ -19,11 +19,13 @@ package poolmgr
 import (
 	"log"
 	"strings"
+	"time"
 
 	"github.com/dchest/uniuri"
 	"k8s.io/client-go/1.5/kubernetes"
 	"k8s.io/client-go/1.5/rest"
 
+	"github.com/fission/fission"
 	controllerclient "github.com/fission/fission/controller/client"
 )
 
@@ -47,7 +49,12 @@ func getKubernetesClient() (*kubernetes.Clientset, error) {
 	return clientset, nil
 }
 
-func StartPoolmgr(controllerUrl string, namespace string, port int) error {
+// StartPoolmgr runs poolmgr until the process gets SIGTERM.  It then
+// stops creating pools and reaping idle pods, and waits up to
+// shutdownTimeout for in-flight requests before returning.
+func StartPoolmgr(controllerUrl string, namespace string, port int, shutdownTimeout time.Duration) error {
+	stop := fission.ShutdownSignal()
+
 	controllerUrl = strings.TrimSuffix(controllerUrl, "/")
 	controllerClient := controllerclient.MakeClient(controllerUrl)
 
@@ -61,10 +68,13 @@ func StartPoolmgr(controllerUrl string, namespace string, port int) error {
 	cleanupOldPoolmgrResources(kubernetesClient, namespace, instanceId)
 
 	fsCache := MakeFunctionServiceCache()
-	gpm := MakeGenericPoolManager(controllerUrl, kubernetesClient, namespace, fsCache, instanceId)
+	gpm := MakeGenericPoolManager(controllerUrl, kubernetesClient, namespace, fsCache, instanceId, stop)
 
 	api := MakeAPI(gpm, controllerClient, fsCache)
-	go api.Serve(port)
-
+	err = api.Serve(port, stop, shutdownTimeout)
+	if err != nil {
+		return err
+	}
+	log.Printf("Poolmgr stopped")
 	return nil
 }
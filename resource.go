@@ -31,3 +31,11 @@ func (ht HTTPTrigger) Key() string {
 func (w Watch) Key() string {
 	return w.Metadata.Name
 }
+
+func (b Build) Key() string {
+	return b.Metadata.Name
+}
+
+func (k APIKey) Key() string {
+	return k.Metadata.Name
+}
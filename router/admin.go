@@ -0,0 +1,219 @@
+/*
+Copyright 2016 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package router
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/gorilla/mux"
+
+	"github.com/fission/fission"
+)
+
+const (
+	// Prefix of the admin API, which is only served if the router
+	// has an admin token.
+	ADMIN_URL_PREFIX = "/fission-admin"
+
+	// Environment variable with the admin token
+	ENV_ADMIN_TOKEN = "FISSION_ROUTER_ADMIN_TOKEN"
+)
+
+// routeTable describes the routes of a router made by getRouter.
+type routeTable struct {
+	router *mux.Router
+	routes []fission.RouteInfo
+	byName map[string]int // mux route name -> index in routes
+	// Mirrors of triggers with a shadow, in match order
+	shadows []*shadowMirror
+	// Names of triggers with a cache policy
+	cachedTriggers map[string]bool
+}
+
+// add records a named route in the table.
+func (rt *routeTable) add(name string, route fission.RouteInfo) {
+	rt.byName[name] = len(rt.routes)
+	rt.routes = append(rt.routes, route)
+}
+
+// adminHandler serves the admin API to requests with the admin token
+// as a bearer token, and passes other requests to next.  GET
+// /fission-admin/routes returns the route table in match order,
+// /fission-admin/services the cached function services,
+// /fission-admin/shadows how triggers' functions compare with their
+// shadows, and /fission-admin/resolve?method=GET&url=/foo (with an
+// optional host parameter) the route a request would take.  DELETE
+// /fission-admin/cache/{trigger} purges a trigger's cached responses
+// from this router.
+func (ts *HTTPTriggerSet) adminHandler(token string, next http.Handler) http.Handler {
+	api := mux.NewRouter()
+	api.HandleFunc(ADMIN_URL_PREFIX+"/routes", ts.routesApi).Methods("GET")
+	api.HandleFunc(ADMIN_URL_PREFIX+"/services", ts.servicesApi).Methods("GET")
+	api.HandleFunc(ADMIN_URL_PREFIX+"/shadows", ts.shadowsApi).Methods("GET")
+	api.HandleFunc(ADMIN_URL_PREFIX+"/resolve", ts.resolveApi).Methods("GET")
+	api.HandleFunc(ADMIN_URL_PREFIX+"/cache/{trigger}", ts.cachePurgeApi).Methods("DELETE")
+
+	return http.HandlerFunc(func(responseWriter http.ResponseWriter, request *http.Request) {
+		if !strings.HasPrefix(request.URL.Path, ADMIN_URL_PREFIX+"/") {
+			next.ServeHTTP(responseWriter, request)
+			return
+		}
+		auth := request.Header.Get("Authorization")
+		if len(auth) < 7 || !strings.EqualFold(auth[:7], "Bearer ") {
+			unauthorized(responseWriter, "Bearer", "", "Missing admin token (fission)")
+			return
+		}
+		if subtle.ConstantTimeCompare([]byte(strings.TrimSpace(auth[7:])), []byte(token)) != 1 {
+			unauthorized(responseWriter, "Bearer", "invalid_token", "Invalid admin token (fission)")
+			return
+		}
+		api.ServeHTTP(responseWriter, request)
+	})
+}
+
+func (ts *HTTPTriggerSet) currentRoutes() *routeTable {
+	rt, _ := ts.routes.Load().(*routeTable)
+	return rt
+}
+
+func (ts *HTTPTriggerSet) routesApi(responseWriter http.ResponseWriter, request *http.Request) {
+	table := fission.RouteTable{
+		Generation: ts.mutableRouter.getGeneration(),
+		Routes:     make([]fission.RouteInfo, 0),
+	}
+	if rt := ts.currentRoutes(); rt != nil {
+		table.Routes = rt.routes
+	}
+	respondWithJson(responseWriter, table)
+}
+
+func (ts *HTTPTriggerSet) servicesApi(responseWriter http.ResponseWriter, request *http.Request) {
+	respondWithJson(responseWriter, ts.functionServiceMap.services())
+}
+
+func (ts *HTTPTriggerSet) shadowsApi(responseWriter http.ResponseWriter, request *http.Request) {
+	stats := make([]fission.ShadowStats, 0)
+	if rt := ts.currentRoutes(); rt != nil {
+		for _, sm := range rt.shadows {
+			stats = append(stats, sm.getStats())
+		}
+	}
+	respondWithJson(responseWriter, stats)
+}
+
+// cachePurgeApi purges the cached responses of a trigger with a
+// cache policy.
+func (ts *HTTPTriggerSet) cachePurgeApi(responseWriter http.ResponseWriter, request *http.Request) {
+	trigger := mux.Vars(request)["trigger"]
+	rt := ts.currentRoutes()
+	if ts.cache == nil || rt == nil || !rt.cachedTriggers[trigger] {
+		http.Error(responseWriter, "Trigger "+trigger+" has no cache (fission)", http.StatusNotFound)
+		return
+	}
+	respondWithJson(responseWriter, fission.CachePurge{
+		Trigger: trigger,
+		Purged:  ts.cache.purge(trigger),
+	})
+}
+
+func (ts *HTTPTriggerSet) resolveApi(responseWriter http.ResponseWriter, request *http.Request) {
+	query := request.URL.Query()
+	method := strings.ToUpper(query.Get("method"))
+	if len(method) == 0 {
+		method = "GET"
+	}
+	u, err := url.Parse(query.Get("url"))
+	if err != nil || len(u.Path) == 0 {
+		http.Error(responseWriter, "Need a URL path, e.g. url=/foo", http.StatusBadRequest)
+		return
+	}
+	req, err := http.NewRequest(method, u.String(), nil)
+	if err != nil {
+		http.Error(responseWriter, "Invalid request: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if host := query.Get("host"); len(host) > 0 {
+		req.Host = host
+	}
+	respondWithJson(responseWriter, ts.resolve(req))
+}
+
+// resolve returns the route the current router uses for a request.
+// Only routing is considered, not auth policies or rate limits.
+// Requests are resolved as on the internal port, which also has the
+// internal function routes.
+func (ts *HTTPTriggerSet) resolve(request *http.Request) *fission.RouteResolution {
+	res := &fission.RouteResolution{Generation: ts.mutableRouter.getGeneration()}
+	rt := ts.currentRoutes()
+	if rt == nil {
+		return res
+	}
+	request = request.WithContext(context.WithValue(request.Context(), internalKey{}, true))
+	var match mux.RouteMatch
+	if !rt.router.Match(request, &match) || match.Route == nil {
+		return res
+	}
+	res.Matched = true
+	if i, ok := rt.byName[match.Route.GetName()]; ok {
+		route := rt.routes[i]
+		res.Route = &route
+	}
+	return res
+}
+
+// services returns the cached instances of each function version.
+func (fmap *functionServiceMap) services() []fission.RouterFunctionServices {
+	entries := fmap.cache.Entries()
+	services := make([]fission.RouterFunctionServices, 0, len(entries))
+	for key, entry := range entries {
+		urls := entry.Value.([]*url.URL)
+		addresses := make([]string, 0, len(urls))
+		for _, u := range urls {
+			addresses = append(addresses, u.String())
+		}
+		services = append(services, fission.RouterFunctionServices{
+			Function:  key.(fission.Metadata),
+			Addresses: addresses,
+			Created:   entry.Ctime,
+			LastUsed:  entry.Atime,
+		})
+	}
+	sort.Slice(services, func(i, j int) bool {
+		a, b := services[i].Function, services[j].Function
+		if a.Name != b.Name {
+			return a.Name < b.Name
+		}
+		return a.Uid < b.Uid
+	})
+	return services
+}
+
+func respondWithJson(responseWriter http.ResponseWriter, v interface{}) {
+	resp, err := json.Marshal(v)
+	if err != nil {
+		http.Error(responseWriter, "Failed to marshal response", http.StatusInternalServerError)
+		return
+	}
+	responseWriter.Header().Set("Content-Type", "application/json; charset=utf-8")
+	responseWriter.Write(resp)
+}
@@ -0,0 +1,138 @@
+/*
+Copyright 2016 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package router
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/gorilla/mux"
+
+	"github.com/fission/fission"
+)
+
+func TestAdminApi(t *testing.T) {
+	fmap := makeFunctionServiceMap(0)
+	serviceUrl, _ := url.Parse("http://10.0.0.1:8888")
+	fmap.assign(&fission.Metadata{Name: "orders", Uid: "orders1"}, []*url.URL{serviceUrl})
+
+	triggers := makeHTTPTriggerSet(fmap, nil, nil, nil, nil, nil)
+	triggers.mutableRouter = NewMutableRouter(mux.NewRouter())
+	triggers.update([]fission.HTTPTrigger{
+		{Metadata: fission.Metadata{Name: "t1"}, PathPrefix: "/v2/orders/", Methods: []string{"GET", "POST"}, Function: fission.Metadata{Name: "orders"}},
+		{Metadata: fission.Metadata{Name: "t2"}, UrlPattern: "/v2/orders/special", Method: "GET", Function: fission.Metadata{Name: "special"}},
+	}, map[string]functionRoute{
+		"orders":  {uid: "orders1"},
+		"special": {uid: "special1"},
+	})
+
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	})
+	handler := triggers.adminHandler("secret", next)
+	get := func(path string, token string, result interface{}) int {
+		req := httptest.NewRequest("GET", path, nil)
+		if len(token) > 0 {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		if w.Code == 200 && result != nil {
+			err := json.Unmarshal(w.Body.Bytes(), result)
+			if err != nil {
+				t.Fatalf("%v: bad response %q: %v", path, w.Body.String(), err)
+			}
+		}
+		return w.Code
+	}
+
+	if code := get("/v2/orders/1", "", nil); code != http.StatusTeapot {
+		t.Fatalf("other requests must be passed on, got %v", code)
+	}
+	if code := get("/fission-admin/routes", "", nil); code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 without a token, got %v", code)
+	}
+	if code := get("/fission-admin/routes", "wrong", nil); code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 with a wrong token, got %v", code)
+	}
+
+	// Routes are listed in match order, with resolved versions.
+	table := fission.RouteTable{}
+	if code := get("/fission-admin/routes", "secret", &table); code != 200 {
+		t.Fatalf("expected 200, got %v", code)
+	}
+	triggerNames := make([]string, 0)
+	for _, r := range table.Routes {
+		if len(r.Trigger) > 0 {
+			triggerNames = append(triggerNames, r.Trigger)
+		}
+	}
+	if len(triggerNames) != 2 || triggerNames[0] != "t2" || triggerNames[1] != "t1" {
+		t.Fatalf("expected triggers t2, t1 in match order, got %v", triggerNames)
+	}
+	if table.Routes[0].Function.Uid != "special1" || table.Generation != triggers.mutableRouter.getGeneration() {
+		t.Fatalf("unexpected route table %+v", table)
+	}
+
+	tests := []struct {
+		query    string
+		matched  bool
+		trigger  string
+		function string
+	}{
+		{"method=GET&url=/v2/orders/special", true, "t2", "special1"},
+		{"method=post&url=/v2/orders/42", true, "t1", "orders1"},
+		{"method=DELETE&url=/v2/orders/42", false, "", ""},
+		{"url=/fission-function/orders", true, "", "orders1"},
+		{"url=/", true, "", ""},
+		{"url=/nothing", false, "", ""},
+	}
+	for _, test := range tests {
+		res := fission.RouteResolution{}
+		if code := get("/fission-admin/resolve?"+test.query, "secret", &res); code != 200 {
+			t.Fatalf("%v: expected 200, got %v", test.query, code)
+		}
+		if res.Matched != test.matched {
+			t.Errorf("%v: expected matched=%v, got %+v", test.query, test.matched, res)
+			continue
+		}
+		if len(test.function) == 0 {
+			if res.Route != nil {
+				t.Errorf("%v: expected no function route, got %+v", test.query, res.Route)
+			}
+			continue
+		}
+		if res.Route == nil || res.Route.Trigger != test.trigger || res.Route.Function.Uid != test.function {
+			t.Errorf("%v: expected %v -> %v, got %+v", test.query, test.trigger, test.function, res.Route)
+		}
+	}
+	if code := get("/fission-admin/resolve?method=GET", "secret", nil); code != http.StatusBadRequest {
+		t.Fatalf("expected 400 without a URL, got %v", code)
+	}
+
+	services := make([]fission.RouterFunctionServices, 0)
+	if code := get("/fission-admin/services", "secret", &services); code != 200 {
+		t.Fatalf("expected 200, got %v", code)
+	}
+	if len(services) != 1 || services[0].Function.Uid != "orders1" ||
+		services[0].Addresses[0] != serviceUrl.String() || services[0].Created.IsZero() {
+		t.Fatalf("unexpected services %+v", services)
+	}
+}
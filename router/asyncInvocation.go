@@ -0,0 +1,450 @@
+/*
+Copyright 2016 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package router
+
+import (
+	"bytes"
+	"container/list"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/satori/go.uuid"
+
+	"github.com/fission/fission"
+)
+
+const (
+	// Request header asking for a function to be run in the
+	// background; its value must be INVOKE_ASYNC.
+	HEADER_INVOKE = "X-Fission-Invoke"
+	INVOKE_ASYNC  = "async"
+	// Response header with the id of an async invocation
+	HEADER_INVOCATION_ID = "X-Fission-Invocation-Id"
+
+	// Path prefixes for async invocations and their results
+	ASYNC_URL_PREFIX       = "/fission-async"
+	INVOCATIONS_URL_PREFIX = "/fission-invocations"
+
+	// Attempts to run an async invocation; it's retried if the
+	// function can't be reached.
+	ASYNC_MAX_ATTEMPTS = 3
+	// Delay before the first retry, doubled for each one after.
+	ASYNC_RETRY_DELAY = 1 * time.Second
+	// Max size of request and response bodies of async invocations
+	ASYNC_MAX_BODY_SIZE = 10 * 1024 * 1024
+	// Max async invocations running at once; others wait.
+	ASYNC_MAX_RUNNING = 100
+	// Max async invocations waiting or running, since each holds its
+	// request body; more are rejected with 503.
+	ASYNC_MAX_PENDING = 2 * ASYNC_MAX_RUNNING
+	// How long invocations and their results are kept
+	INVOCATION_EXPIRY = 1 * time.Hour
+	// Max size of stored invocations; when it's reached, the oldest
+	// results are dropped.
+	INVOCATION_STORE_SIZE = 256 * 1024 * 1024
+	// Size counted for an invocation besides its response body
+	INVOCATION_OVERHEAD = 1024
+)
+
+type invocationStatus string
+
+const (
+	invocationQueued    invocationStatus = "queued"
+	invocationRunning   invocationStatus = "running"
+	invocationSucceeded invocationStatus = "succeeded" // the function responded, with any status
+	invocationFailed    invocationStatus = "failed"    // the function couldn't be run
+)
+
+type (
+	// invocation is the state and result of an async invocation.
+	invocation struct {
+		Id         string           `json:"id"`
+		Function   fission.Metadata `json:"function"`
+		Status     invocationStatus `json:"status"`
+		Attempts   int              `json:"attempts"`
+		StatusCode int              `json:"statusCode,omitempty"`
+		Header     http.Header      `json:"header,omitempty"`
+		Body       []byte           `json:"body,omitempty"`
+		Error      string           `json:"error,omitempty"`
+		Created    time.Time        `json:"created"`
+		Updated    time.Time        `json:"updated"`
+
+		// Auth policy of the trigger the invocation came through,
+		// and the client that made it; only that client can get
+		// the result.  Without auth, the id is the only secret.
+		auth   *triggerAuth
+		caller string
+	}
+
+	// invocationStore keeps async invocations until their results
+	// are retrieved.  Implementations must be safe for concurrent
+	// use.
+	invocationStore interface {
+		create(inv *invocation) error
+		get(id string) (*invocation, error)
+		update(inv *invocation) error
+	}
+
+	// memoryInvocationStore keeps invocations in memory, so
+	// results are lost when the router restarts and are only
+	// available from the router that ran the invocation.  Past
+	// maxBytes, the oldest finished invocations are dropped.
+	memoryInvocationStore struct {
+		lock     sync.Mutex
+		expiry   time.Duration
+		maxBytes int64
+		bytes    int64
+		entries  map[string]*list.Element
+		byAge    *list.List // of *storedInvocation, oldest first
+	}
+	storedInvocation struct {
+		inv    invocation
+		stored time.Time
+		size   int64
+	}
+
+	// asyncInvoker runs requests to functions in the background.
+	asyncInvoker struct {
+		store   invocationStore
+		pending chan bool // semaphore, ASYNC_MAX_PENDING slots
+		running chan bool // semaphore, ASYNC_MAX_RUNNING slots
+	}
+
+	// bufferedResponseWriter collects a function's response.
+	bufferedResponseWriter struct {
+		header     http.Header
+		statusCode int
+		body       bytes.Buffer
+		truncated  bool
+	}
+)
+
+// makeMemoryInvocationStore creates a store keeping invocations for
+// expiry, and up to maxBytes of them.
+func makeMemoryInvocationStore(expiry time.Duration, maxBytes int64) *memoryInvocationStore {
+	return &memoryInvocationStore{
+		expiry:   expiry,
+		maxBytes: maxBytes,
+		entries:  make(map[string]*list.Element),
+		byAge:    list.New(),
+	}
+}
+
+func (s *memoryInvocationStore) create(inv *invocation) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if _, ok := s.entries[inv.Id]; ok {
+		return fission.MakeError(fission.ErrorNameExists,
+			fmt.Sprintf("invocation '%v' already exists", inv.Id))
+	}
+	si := &storedInvocation{inv: *inv, stored: time.Now()}
+	s.entries[inv.Id] = s.byAge.PushBack(si)
+	s.resize(si)
+	return nil
+}
+
+func (s *memoryInvocationStore) get(id string) (*invocation, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.expire()
+	elem, ok := s.entries[id]
+	if !ok {
+		return nil, fission.MakeError(fission.ErrorNotFound,
+			fmt.Sprintf("invocation '%v' not found", id))
+	}
+	inv := elem.Value.(*storedInvocation).inv
+	return &inv, nil
+}
+
+func (s *memoryInvocationStore) update(inv *invocation) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	elem, ok := s.entries[inv.Id]
+	if !ok {
+		return fission.MakeError(fission.ErrorNotFound,
+			fmt.Sprintf("invocation '%v' not found", inv.Id))
+	}
+	si := elem.Value.(*storedInvocation)
+	si.inv = *inv
+	s.resize(si)
+	return nil
+}
+
+// resize updates the store's size after si changed, and drops
+// expired invocations and, while it's too big, the oldest finished
+// ones.  Unfinished invocations are kept; there are at most
+// ASYNC_MAX_PENDING of them, and they have no response body yet.
+// Callers hold s.lock.
+func (s *memoryInvocationStore) resize(si *storedInvocation) {
+	size := int64(INVOCATION_OVERHEAD + len(si.inv.Body))
+	s.bytes += size - si.size
+	si.size = size
+
+	s.expire()
+	for elem := s.byAge.Front(); elem != nil && s.bytes > s.maxBytes; {
+		next := elem.Next()
+		old := elem.Value.(*storedInvocation)
+		if old.inv.Status == invocationSucceeded || old.inv.Status == invocationFailed {
+			log.Printf("Invocation store full, dropping invocation %v", old.inv.Id)
+			s.remove(elem)
+		}
+		elem = next
+	}
+}
+
+// expire drops invocations stored longer than s.expiry, if it's
+// nonzero.  Callers hold s.lock.
+func (s *memoryInvocationStore) expire() {
+	if s.expiry == 0 {
+		return
+	}
+	now := time.Now()
+	for elem := s.byAge.Front(); elem != nil; elem = s.byAge.Front() {
+		if now.Sub(elem.Value.(*storedInvocation).stored) <= s.expiry {
+			break
+		}
+		s.remove(elem)
+	}
+}
+
+// remove drops a stored invocation.  Callers hold s.lock.
+func (s *memoryInvocationStore) remove(elem *list.Element) {
+	si := elem.Value.(*storedInvocation)
+	s.byAge.Remove(elem)
+	delete(s.entries, si.inv.Id)
+	s.bytes -= si.size
+}
+
+func makeAsyncInvoker(store invocationStore) *asyncInvoker {
+	return &asyncInvoker{
+		store:   store,
+		pending: make(chan bool, ASYNC_MAX_PENDING),
+		running: make(chan bool, ASYNC_MAX_RUNNING),
+	}
+}
+
+// invoke queues a request to a function and responds with 202 and
+// the invocation's id, or with 503 if the queue is full.
+func (ai *asyncInvoker) invoke(fh *functionHandler, responseWriter http.ResponseWriter, request *http.Request) {
+	select {
+	case ai.pending <- true:
+	default:
+		responseWriter.Header().Set("Retry-After", "1")
+		http.Error(responseWriter, "Too many pending async invocations (fission)",
+			http.StatusServiceUnavailable)
+		return
+	}
+	queued := false
+	defer func() {
+		if !queued {
+			<-ai.pending
+		}
+	}()
+
+	body, err := ioutil.ReadAll(io.LimitReader(request.Body, ASYNC_MAX_BODY_SIZE+1))
+	if err != nil {
+		http.Error(responseWriter, "Failed to read request", http.StatusBadRequest)
+		return
+	}
+	if len(body) > ASYNC_MAX_BODY_SIZE {
+		http.Error(responseWriter, "Request body too large for async invocation",
+			http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	now := time.Now()
+	inv := &invocation{
+		Id:       uuid.NewV4().String(),
+		Function: fh.Function,
+		Status:   invocationQueued,
+		Created:  now,
+		Updated:  now,
+		auth:     fh.auth,
+		caller:   callerIdentity(request),
+	}
+	err = ai.store.create(inv)
+	if err != nil {
+		log.Printf("Failed to store invocation of %v: %v", fh.Function, err)
+		http.Error(responseWriter, "Internal server error (fission)", 500)
+		return
+	}
+
+	// The background request mustn't depend on the client's.
+	req, err := http.NewRequest(request.Method, request.URL.String(), nil)
+	if err != nil {
+		http.Error(responseWriter, "Invalid request", http.StatusBadRequest)
+		return
+	}
+	req.Host = request.Host
+	req.Header = cloneHeader(request.Header)
+	req.Header.Del(HEADER_INVOKE)
+
+	resp, err := json.Marshal(inv)
+	if err != nil {
+		http.Error(responseWriter, "Failed to marshal invocation", 500)
+		return
+	}
+	queued = true
+	go ai.run(fh, inv, req, body)
+
+	responseWriter.Header().Set("Content-Type", "application/json; charset=utf-8")
+	responseWriter.Header().Set("Location", INVOCATIONS_URL_PREFIX+"/"+inv.Id)
+	responseWriter.Header().Set(HEADER_INVOCATION_ID, inv.Id)
+	responseWriter.WriteHeader(http.StatusAccepted)
+	responseWriter.Write(resp)
+}
+
+// run sends a queued request to the function, retrying if it can't
+// be reached, and stores the response.
+func (ai *asyncInvoker) run(fh *functionHandler, inv *invocation, request *http.Request, body []byte) {
+	defer func() { <-ai.pending }()
+	ai.running <- true
+	defer func() { <-ai.running }()
+
+	delay := ASYNC_RETRY_DELAY
+	for {
+		inv.Status = invocationRunning
+		inv.Attempts++
+		ai.save(inv)
+
+		req := *request
+		req.Header = cloneHeader(request.Header)
+		req.Body = ioutil.NopCloser(bytes.NewReader(body))
+		req.ContentLength = int64(len(body))
+
+		w := makeBufferedResponseWriter()
+		fh.serve(w, &req)
+
+		if isRetryableStatus(w.statusCode) && inv.Attempts < ASYNC_MAX_ATTEMPTS {
+			log.Printf("Async invocation %v of %v got status %v, retrying in %v",
+				inv.Id, fh.Function, w.statusCode, delay)
+			time.Sleep(delay)
+			delay *= 2
+			continue
+		}
+
+		inv.StatusCode = w.statusCode
+		inv.Header = w.header
+		inv.Body = w.body.Bytes()
+		if isRetryableStatus(w.statusCode) {
+			inv.Status = invocationFailed
+			inv.Error = fmt.Sprintf("function unavailable after %v attempts", inv.Attempts)
+		} else if w.truncated {
+			inv.Status = invocationFailed
+			inv.Error = fmt.Sprintf("response larger than %v bytes", ASYNC_MAX_BODY_SIZE)
+		} else {
+			inv.Status = invocationSucceeded
+		}
+		ai.save(inv)
+		return
+	}
+}
+
+func (ai *asyncInvoker) save(inv *invocation) {
+	inv.Updated = time.Now()
+	err := ai.store.update(inv)
+	if err != nil {
+		log.Printf("Failed to update invocation %v: %v", inv.Id, err)
+	}
+}
+
+// statusHandler returns an invocation's status, and its response
+// once it's done.  Invocations through a trigger with an auth policy
+// are only returned to the client that made them.
+func (ai *asyncInvoker) statusHandler(responseWriter http.ResponseWriter, request *http.Request) {
+	id := mux.Vars(request)["id"]
+	inv, err := ai.store.get(id)
+	if err != nil {
+		http.Error(responseWriter, "Invocation not found", http.StatusNotFound)
+		return
+	}
+	if inv.auth != nil {
+		removeAuthHeaders(request)
+		if !inv.auth.authenticate(responseWriter, request) {
+			return
+		}
+		if callerIdentity(request) != inv.caller {
+			http.Error(responseWriter, "Invocation not found", http.StatusNotFound)
+			return
+		}
+	}
+	resp, err := json.Marshal(inv)
+	if err != nil {
+		http.Error(responseWriter, "Failed to marshal invocation", 500)
+		return
+	}
+	responseWriter.Header().Set("Content-Type", "application/json; charset=utf-8")
+	responseWriter.Write(resp)
+}
+
+// callerIdentity returns the client an authenticated request came
+// from, or "" if it's anonymous.
+func callerIdentity(request *http.Request) string {
+	if key := request.Header.Get(HEADER_AUTH_APIKEY); len(key) > 0 {
+		return "apikey:" + key
+	}
+	if sub := request.Header.Get(HEADER_AUTH_SUBJECT); len(sub) > 0 {
+		return "sub:" + sub
+	}
+	return ""
+}
+
+// isRetryableStatus returns true for responses meaning the request
+// didn't reach the function: it couldn't be started or connected to.
+func isRetryableStatus(statusCode int) bool {
+	return statusCode == http.StatusBadGateway || statusCode == http.StatusServiceUnavailable
+}
+
+func cloneHeader(h http.Header) http.Header {
+	h2 := make(http.Header, len(h))
+	for k, v := range h {
+		h2[k] = append([]string(nil), v...)
+	}
+	return h2
+}
+
+func makeBufferedResponseWriter() *bufferedResponseWriter {
+	return &bufferedResponseWriter{
+		header:     make(http.Header),
+		statusCode: http.StatusOK,
+	}
+}
+
+func (w *bufferedResponseWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *bufferedResponseWriter) WriteHeader(statusCode int) {
+	w.statusCode = statusCode
+}
+
+func (w *bufferedResponseWriter) Write(b []byte) (int, error) {
+	room := ASYNC_MAX_BODY_SIZE - w.body.Len()
+	if len(b) > room {
+		w.truncated = true
+		w.body.Write(b[:room])
+		return len(b), nil
+	}
+	return w.body.Write(b)
+}
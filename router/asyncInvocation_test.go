@@ -0,0 +1,236 @@
+/*
+Copyright 2016 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package router
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.com/fission/fission"
+)
+
+// waitForInvocation polls the router for an invocation until it's done.
+func waitForInvocation(t *testing.T, router http.Handler, id string) *invocation {
+	for i := 0; i < 100; i++ {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("GET", INVOCATIONS_URL_PREFIX+"/"+id, nil))
+		if w.Code != 200 {
+			t.Fatalf("failed to get invocation %v: %v %v", id, w.Code, w.Body.String())
+		}
+		inv := &invocation{}
+		err := json.Unmarshal(w.Body.Bytes(), inv)
+		if err != nil {
+			t.Fatalf("failed to parse invocation: %v", err)
+		}
+		if inv.Status == invocationSucceeded || inv.Status == invocationFailed {
+			return inv
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	t.Fatalf("invocation %v didn't finish", id)
+	return nil
+}
+
+func TestAsyncInvocation(t *testing.T) {
+	// the function is unavailable on the first attempt
+	var calls int32
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		if len(r.Header.Get(HEADER_INVOKE)) > 0 {
+			t.Errorf("invoke header must not be passed to the function")
+		}
+		body, _ := ioutil.ReadAll(r.Body)
+		w.Write([]byte("got " + string(body)))
+	}))
+	defer backend.Close()
+	backendURL, _ := url.Parse(backend.URL)
+
+	fmap := makeFunctionServiceMap(0)
+	fmap.assign(&fission.Metadata{Name: "foo", Uid: "foo1"}, []*url.URL{backendURL})
+	invoker := makeAsyncInvoker(makeMemoryInvocationStore(time.Minute, INVOCATION_STORE_SIZE))
+	triggers := makeHTTPTriggerSet(fmap, nil, nil, nil, invoker, nil)
+	triggers.mutableRouter = NewMutableRouter(mux.NewRouter())
+	triggers.update([]fission.HTTPTrigger{
+		{Metadata: fission.Metadata{Name: "t1"}, UrlPattern: "/foo", Method: "POST", Function: fission.Metadata{Name: "foo"}},
+	}, map[string]functionRoute{"foo": {uid: "foo1"}})
+	router := triggers.mutableRouter
+
+	// through a trigger, with the invoke header
+	req := httptest.NewRequest("POST", "/foo", strings.NewReader("job"))
+	req.Header.Set(HEADER_INVOKE, INVOKE_ASYNC)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("expected 202, got %v %v", w.Code, w.Body.String())
+	}
+	id := w.Header().Get(HEADER_INVOCATION_ID)
+	if w.Header().Get("Location") != INVOCATIONS_URL_PREFIX+"/"+id {
+		t.Errorf("bad location header '%v'", w.Header().Get("Location"))
+	}
+	inv := waitForInvocation(t, router, id)
+	if inv.Status != invocationSucceeded || inv.Attempts != 2 || inv.StatusCode != 200 || string(inv.Body) != "got job" {
+		t.Errorf("unexpected invocation result: %#v", inv)
+	}
+
+	// through the async path
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("POST", ASYNC_URL_PREFIX+"/foo", strings.NewReader("job")))
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("expected the async path to be internal, got %v", w.Code)
+	}
+	w = httptest.NewRecorder()
+	internalHandler(router).ServeHTTP(w, httptest.NewRequest("POST", ASYNC_URL_PREFIX+"/foo", strings.NewReader("job2")))
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("expected 202, got %v %v", w.Code, w.Body.String())
+	}
+	inv = waitForInvocation(t, router, w.Header().Get(HEADER_INVOCATION_ID))
+	if inv.Status != invocationSucceeded || inv.Attempts != 1 || string(inv.Body) != "got job2" {
+		t.Errorf("unexpected invocation result: %#v", inv)
+	}
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", INVOCATIONS_URL_PREFIX+"/nonexistent", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("expected 404 for unknown invocation, got %v", w.Code)
+	}
+}
+
+func TestAsyncInvocationQueueFull(t *testing.T) {
+	invoker := makeAsyncInvoker(makeMemoryInvocationStore(time.Minute, INVOCATION_STORE_SIZE))
+	triggers := makeHTTPTriggerSet(makeFunctionServiceMap(0), nil, nil, nil, invoker, nil)
+	triggers.mutableRouter = NewMutableRouter(mux.NewRouter())
+	triggers.update(nil, map[string]functionRoute{"foo": {uid: "foo1"}})
+	for i := 0; i < ASYNC_MAX_PENDING; i++ {
+		invoker.pending <- true
+	}
+
+	w := httptest.NewRecorder()
+	internalHandler(triggers.mutableRouter).ServeHTTP(w, httptest.NewRequest("POST", ASYNC_URL_PREFIX+"/foo", strings.NewReader("job")))
+	if w.Code != http.StatusServiceUnavailable || len(w.Header().Get("Retry-After")) == 0 {
+		t.Errorf("expected 503 with Retry-After when the queue is full, got %v", w.Code)
+	}
+}
+
+func TestAsyncInvocationAuth(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("secret result"))
+	}))
+	defer backend.Close()
+	backendURL, _ := url.Parse(backend.URL)
+
+	fmap := makeFunctionServiceMap(0)
+	fmap.assign(&fission.Metadata{Name: "foo", Uid: "foo1"}, []*url.URL{backendURL})
+	invoker := makeAsyncInvoker(makeMemoryInvocationStore(time.Minute, INVOCATION_STORE_SIZE))
+	triggers := makeHTTPTriggerSet(fmap, nil, nil, nil, invoker, nil)
+	triggers.mutableRouter = NewMutableRouter(mux.NewRouter())
+	triggers.apiKeys.set([]fission.APIKey{
+		{Metadata: fission.Metadata{Name: "alice"}, KeyHash: fission.HashAPIKey("alice-secret-key-1")},
+		{Metadata: fission.Metadata{Name: "bob"}, KeyHash: fission.HashAPIKey("bob-secret-key-123")},
+	})
+	triggers.update([]fission.HTTPTrigger{
+		{Metadata: fission.Metadata{Name: "t1"}, UrlPattern: "/foo", Method: "POST", Function: fission.Metadata{Name: "foo"},
+			Auth: &fission.AuthPolicy{Type: fission.AUTH_TYPE_APIKEY}},
+	}, map[string]functionRoute{"foo": {uid: "foo1"}})
+	router := triggers.mutableRouter
+
+	req := httptest.NewRequest("POST", "/foo", strings.NewReader("job"))
+	req.Header.Set(HEADER_INVOKE, INVOKE_ASYNC)
+	req.Header.Set("X-Api-Key", "alice-secret-key-1")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("expected 202, got %v %v", w.Code, w.Body.String())
+	}
+	id := w.Header().Get(HEADER_INVOCATION_ID)
+
+	getInvocation := func(key string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", INVOCATIONS_URL_PREFIX+"/"+id, nil)
+		if len(key) > 0 {
+			req.Header.Set("X-Api-Key", key)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+	if w := getInvocation(""); w.Code != http.StatusUnauthorized {
+		t.Errorf("expected 401 without a key, got %v", w.Code)
+	}
+	if w := getInvocation("bob-secret-key-123"); w.Code != http.StatusNotFound {
+		t.Errorf("expected 404 for another client's invocation, got %v", w.Code)
+	}
+	for i := 0; ; i++ {
+		w := getInvocation("alice-secret-key-1")
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected 200 for the client's own invocation, got %v %v", w.Code, w.Body.String())
+		}
+		inv := &invocation{}
+		json.Unmarshal(w.Body.Bytes(), inv)
+		if inv.Status == invocationSucceeded && string(inv.Body) == "secret result" {
+			break
+		}
+		if i == 100 {
+			t.Fatalf("invocation didn't finish: %#v", inv)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+func TestMemoryInvocationStoreLimit(t *testing.T) {
+	store := makeMemoryInvocationStore(time.Minute, 3*INVOCATION_OVERHEAD+100)
+	invs := make([]*invocation, 3)
+	for i := range invs {
+		invs[i] = &invocation{Id: fmt.Sprintf("inv%v", i), Status: invocationRunning}
+		if err := store.create(invs[i]); err != nil {
+			t.Fatalf("failed to create invocation %v: %v", i, err)
+		}
+	}
+
+	// The oldest invocation is still running, so the oldest finished
+	// one is dropped when a result doesn't fit.
+	invs[1].Status = invocationSucceeded
+	invs[1].Body = make([]byte, 50)
+	store.update(invs[1])
+	invs[2].Status = invocationSucceeded
+	invs[2].Body = make([]byte, 60)
+	store.update(invs[2])
+
+	if _, err := store.get("inv0"); err != nil {
+		t.Errorf("running invocation was dropped: %v", err)
+	}
+	if _, err := store.get("inv1"); err == nil {
+		t.Errorf("oldest finished invocation wasn't dropped")
+	}
+	if inv, err := store.get("inv2"); err != nil || len(inv.Body) != 60 {
+		t.Errorf("newest invocation: got %v, %v", inv, err)
+	}
+	if store.bytes != 2*INVOCATION_OVERHEAD+60 {
+		t.Errorf("store size is %v, expected %v", store.bytes, 2*INVOCATION_OVERHEAD+60)
+	}
+}
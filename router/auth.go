@@ -0,0 +1,176 @@
+/*
+Copyright 2016 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package router
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/fission/fission"
+)
+
+// Headers telling the function who the client is, once the router
+// has authenticated it.  They're removed from all incoming requests,
+// so they can't be spoofed.
+const (
+	// Name of the APIKey the client used
+	HEADER_AUTH_APIKEY = "X-Fission-Auth-Key"
+	// "sub" claim of the client's JWT
+	HEADER_AUTH_SUBJECT = "X-Fission-Auth-Subject"
+	// All claims of the client's JWT, as JSON
+	HEADER_AUTH_CLAIMS = "X-Fission-Auth-Claims"
+)
+
+// apiKeySet is the router's copy of the controller's API keys.
+type apiKeySet struct {
+	lock   sync.RWMutex
+	byHash map[string]string // key hash -> key name
+}
+
+func makeAPIKeySet() *apiKeySet {
+	return &apiKeySet{byHash: make(map[string]string)}
+}
+
+func (s *apiKeySet) set(keys []fission.APIKey) {
+	byHash := make(map[string]string, len(keys))
+	for _, k := range keys {
+		if len(k.KeyHash) > 0 {
+			byHash[k.KeyHash] = k.Metadata.Name
+		}
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.byHash = byHash
+}
+
+// lookup returns the name of the API key with value key.  Keys are
+// compared by hash, so lookups take the same time whether or not
+// part of the key matches.
+func (s *apiKeySet) lookup(key string) (string, bool) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	name, ok := s.byHash[fission.HashAPIKey(key)]
+	return name, ok
+}
+
+// triggerAuth enforces a trigger's auth policy.
+type triggerAuth struct {
+	policy  fission.AuthPolicy
+	apiKeys *apiKeySet
+	jwks    *jwksCache
+}
+
+func makeTriggerAuth(policy *fission.AuthPolicy, apiKeys *apiKeySet, jwks *jwksCache) *triggerAuth {
+	return &triggerAuth{
+		policy:  *policy,
+		apiKeys: apiKeys,
+		jwks:    jwks,
+	}
+}
+
+// removeAuthHeaders drops identity headers set by the client.
+func removeAuthHeaders(request *http.Request) {
+	request.Header.Del(HEADER_AUTH_APIKEY)
+	request.Header.Del(HEADER_AUTH_SUBJECT)
+	request.Header.Del(HEADER_AUTH_CLAIMS)
+}
+
+// authenticate checks the request's credentials, and adds headers
+// identifying the client for the function.  If the credentials
+// aren't valid it responds with an error and returns false.
+func (ta *triggerAuth) authenticate(responseWriter http.ResponseWriter, request *http.Request) bool {
+	if ta.policy.Type == fission.AUTH_TYPE_APIKEY {
+		return ta.authenticateAPIKey(responseWriter, request)
+	}
+	return ta.authenticateJWT(responseWriter, request)
+}
+
+func (ta *triggerAuth) authenticateAPIKey(responseWriter http.ResponseWriter, request *http.Request) bool {
+	header := fission.APIKeyHeader(&ta.policy)
+	key := request.Header.Get(header)
+	if len(key) == 0 {
+		unauthorized(responseWriter, "ApiKey", "", "Missing API key (fission)")
+		return false
+	}
+	name, ok := ta.apiKeys.lookup(key)
+	if !ok || (len(ta.policy.APIKeys) > 0 && !containsString(ta.policy.APIKeys, name)) {
+		unauthorized(responseWriter, "ApiKey", "", "Invalid API key (fission)")
+		return false
+	}
+
+	// The function gets the key's name, not the key.
+	request.Header.Del(header)
+	request.Header.Set(HEADER_AUTH_APIKEY, name)
+	return true
+}
+
+func (ta *triggerAuth) authenticateJWT(responseWriter http.ResponseWriter, request *http.Request) bool {
+	auth := request.Header.Get("Authorization")
+	if len(auth) < 7 || !strings.EqualFold(auth[:7], "Bearer ") {
+		unauthorized(responseWriter, "Bearer", "", "Missing bearer token (fission)")
+		return false
+	}
+
+	claims, err := verifyJWT(strings.TrimSpace(auth[7:]), ta.policy.JWT, ta.jwks)
+	if err == errJWKSUnavailable {
+		log.Printf("Can't verify tokens for %v: %v", ta.policy.JWT.JWKSUrl, err)
+		http.Error(responseWriter, "Service unavailable: "+err.Error()+" (fission)",
+			http.StatusServiceUnavailable)
+		return false
+	}
+	if err != nil {
+		unauthorized(responseWriter, "Bearer", "invalid_token", "Invalid bearer token: "+err.Error()+" (fission)")
+		return false
+	}
+	err = checkClaims(claims, ta.policy.JWT)
+	if err != nil {
+		responseWriter.Header().Set("WWW-Authenticate", `Bearer error="insufficient_scope"`)
+		http.Error(responseWriter, "Forbidden: "+err.Error()+" (fission)", http.StatusForbidden)
+		return false
+	}
+
+	if sub, ok := claims["sub"].(string); ok {
+		request.Header.Set(HEADER_AUTH_SUBJECT, sub)
+	}
+	claimsJson, err := json.Marshal(claims)
+	if err == nil {
+		request.Header.Set(HEADER_AUTH_CLAIMS, string(claimsJson))
+	}
+	return true
+}
+
+func unauthorized(responseWriter http.ResponseWriter, scheme string, errorCode string, msg string) {
+	challenge := scheme
+	if len(errorCode) > 0 {
+		challenge = fmt.Sprintf(`%v error="%v"`, scheme, errorCode)
+	}
+	responseWriter.Header().Set("WWW-Authenticate", challenge)
+	http.Error(responseWriter, msg, http.StatusUnauthorized)
+}
+
+func containsString(list []string, s string) bool {
+	for _, x := range list {
+		if x == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,245 @@
+/*
+Copyright 2016 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package router
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/fission/fission"
+)
+
+// makeJWT signs claims; sign gets the signing input and returns the
+// signature.
+func makeJWT(t *testing.T, alg string, kid string, claims map[string]interface{}, sign func([]byte) []byte) string {
+	header, _ := json.Marshal(map[string]string{"alg": alg, "typ": "JWT", "kid": kid})
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		t.Fatalf("failed to marshal claims: %v", err)
+	}
+	input := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	return input + "." + base64.RawURLEncoding.EncodeToString(sign([]byte(input)))
+}
+
+func hs256(secret string) func([]byte) []byte {
+	return func(input []byte) []byte {
+		mac := hmac.New(crypto.SHA256.New, []byte(secret))
+		mac.Write(input)
+		return mac.Sum(nil)
+	}
+}
+
+func es256(t *testing.T, key *ecdsa.PrivateKey) func([]byte) []byte {
+	return func(input []byte) []byte {
+		h := crypto.SHA256.New()
+		h.Write(input)
+		r, s, err := ecdsa.Sign(rand.Reader, key, h.Sum(nil))
+		if err != nil {
+			t.Fatalf("failed to sign token: %v", err)
+		}
+		sig := make([]byte, 64)
+		rb, sb := r.Bytes(), s.Bytes()
+		copy(sig[32-len(rb):], rb)
+		copy(sig[64-len(sb):], sb)
+		return sig
+	}
+}
+
+// makeAuthServer serves a function that echoes the auth headers, behind
+// a trigger with the given auth policy.
+func makeAuthServer(policy *fission.AuthPolicy, apiKeys *apiKeySet) *httptest.Server {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "%v|%v|%v", r.Header.Get(HEADER_AUTH_APIKEY),
+			r.Header.Get(HEADER_AUTH_SUBJECT), r.Header.Get("X-Api-Key"))
+	}))
+	backendURL, _ := url.Parse(backend.URL)
+
+	fn := &fission.Metadata{Name: "foo", Uid: "xxx"}
+	fmap := makeFunctionServiceMap(0)
+	fmap.assign(fn, []*url.URL{backendURL})
+	fh := &functionHandler{
+		fmap:     fmap,
+		Function: *fn,
+		auth:     makeTriggerAuth(policy, apiKeys, makeJWKSCache()),
+	}
+	return httptest.NewServer(http.HandlerFunc(fh.handler))
+}
+
+func doAuthRequest(t *testing.T, serverURL string, header http.Header) (int, string) {
+	req, err := http.NewRequest("GET", serverURL, nil)
+	if err != nil {
+		t.Fatalf("failed to create request: %v", err)
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("failed to make request: %v", err)
+	}
+	defer resp.Body.Close()
+	body := make([]byte, 1024)
+	n, _ := resp.Body.Read(body)
+	return resp.StatusCode, string(body[:n])
+}
+
+func TestAPIKeyAuth(t *testing.T) {
+	apiKeys := makeAPIKeySet()
+	apiKeys.set([]fission.APIKey{
+		{Metadata: fission.Metadata{Name: "alice"}, KeyHash: fission.HashAPIKey("alice-secret-key-1")},
+		{Metadata: fission.Metadata{Name: "bob"}, KeyHash: fission.HashAPIKey("bob-secret-key-123")},
+	})
+	server := makeAuthServer(&fission.AuthPolicy{
+		Type:    fission.AUTH_TYPE_APIKEY,
+		APIKeys: []string{"alice"},
+	}, apiKeys)
+	defer server.Close()
+
+	status, _ := doAuthRequest(t, server.URL, http.Header{})
+	if status != http.StatusUnauthorized {
+		t.Fatalf("expected 401 without a key, got %v", status)
+	}
+	status, _ = doAuthRequest(t, server.URL, http.Header{"X-Api-Key": {"bob-secret-key-123"}})
+	if status != http.StatusUnauthorized {
+		t.Fatalf("expected 401 for a key the trigger doesn't accept, got %v", status)
+	}
+
+	// the function sees the key's name, not the key, and can't be
+	// fooled by a client-set identity header
+	status, body := doAuthRequest(t, server.URL, http.Header{
+		"X-Api-Key":          {"alice-secret-key-1"},
+		"X-Fission-Auth-Key": {"bob"},
+	})
+	if status != http.StatusOK || body != "alice||" {
+		t.Fatalf("expected 200 and 'alice||', got %v and '%v'", status, body)
+	}
+
+	// rotated keys stop working
+	apiKeys.set([]fission.APIKey{
+		{Metadata: fission.Metadata{Name: "alice"}, KeyHash: fission.HashAPIKey("alice-secret-key-2")},
+	})
+	status, _ = doAuthRequest(t, server.URL, http.Header{"X-Api-Key": {"alice-secret-key-1"}})
+	if status != http.StatusUnauthorized {
+		t.Fatalf("expected 401 for a rotated key, got %v", status)
+	}
+}
+
+func TestJWTAuthHMAC(t *testing.T) {
+	server := makeAuthServer(&fission.AuthPolicy{
+		Type: fission.AUTH_TYPE_JWT,
+		JWT: &fission.JWTPolicy{
+			Secret:         "s3cret",
+			Issuer:         "https://issuer.example.com",
+			RequiredClaims: map[string]string{"role": "admin"},
+		},
+	}, makeAPIKeySet())
+	defer server.Close()
+
+	bearer := func(token string) http.Header {
+		return http.Header{"Authorization": {"Bearer " + token}}
+	}
+	claims := map[string]interface{}{
+		"sub":  "alice",
+		"iss":  "https://issuer.example.com",
+		"role": []string{"user", "admin"},
+		"exp":  time.Now().Add(time.Hour).Unix(),
+	}
+
+	status, body := doAuthRequest(t, server.URL, bearer(makeJWT(t, "HS256", "", claims, hs256("s3cret"))))
+	if status != http.StatusOK || body != "|alice|" {
+		t.Fatalf("expected 200 and '|alice|', got %v and '%v'", status, body)
+	}
+
+	status, _ = doAuthRequest(t, server.URL, bearer(makeJWT(t, "HS256", "", claims, hs256("wrong"))))
+	if status != http.StatusUnauthorized {
+		t.Fatalf("expected 401 for a bad signature, got %v", status)
+	}
+	status, _ = doAuthRequest(t, server.URL, http.Header{})
+	if status != http.StatusUnauthorized {
+		t.Fatalf("expected 401 without a token, got %v", status)
+	}
+
+	claims["exp"] = time.Now().Add(-time.Hour).Unix()
+	status, _ = doAuthRequest(t, server.URL, bearer(makeJWT(t, "HS256", "", claims, hs256("s3cret"))))
+	if status != http.StatusUnauthorized {
+		t.Fatalf("expected 401 for an expired token, got %v", status)
+	}
+
+	claims["exp"] = time.Now().Add(time.Hour).Unix()
+	claims["role"] = "user"
+	status, _ = doAuthRequest(t, server.URL, bearer(makeJWT(t, "HS256", "", claims, hs256("s3cret"))))
+	if status != http.StatusForbidden {
+		t.Fatalf("expected 403 without the required claim, got %v", status)
+	}
+}
+
+func TestJWTAuthJWKS(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	jwksServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"keys": []map[string]string{{
+				"kty": "EC",
+				"kid": "k1",
+				"crv": "P-256",
+				"x":   base64.RawURLEncoding.EncodeToString(key.X.Bytes()),
+				"y":   base64.RawURLEncoding.EncodeToString(key.Y.Bytes()),
+			}},
+		})
+	}))
+	defer jwksServer.Close()
+
+	server := makeAuthServer(&fission.AuthPolicy{
+		Type: fission.AUTH_TYPE_JWT,
+		JWT:  &fission.JWTPolicy{JWKSUrl: jwksServer.URL, Audience: "api"},
+	}, makeAPIKeySet())
+	defer server.Close()
+
+	claims := map[string]interface{}{"sub": "bob", "aud": "api"}
+	token := makeJWT(t, "ES256", "k1", claims, es256(t, key))
+	status, body := doAuthRequest(t, server.URL, http.Header{"Authorization": {"Bearer " + token}})
+	if status != http.StatusOK || body != "|bob|" {
+		t.Fatalf("expected 200 and '|bob|', got %v and '%v'", status, body)
+	}
+
+	// HMAC tokens aren't accepted by a JWKS policy
+	token = makeJWT(t, "HS256", "k1", claims, hs256(""))
+	status, _ = doAuthRequest(t, server.URL, http.Header{"Authorization": {"Bearer " + token}})
+	if status != http.StatusUnauthorized {
+		t.Fatalf("expected 401 for an HMAC token, got %v", status)
+	}
+
+	claims["aud"] = "other"
+	token = makeJWT(t, "ES256", "k1", claims, es256(t, key))
+	status, _ = doAuthRequest(t, server.URL, http.Header{"Authorization": {"Bearer " + token}})
+	if status != http.StatusForbidden {
+		t.Fatalf("expected 403 for the wrong audience, got %v", status)
+	}
+}
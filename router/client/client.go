@@ -0,0 +1,127 @@
+/*
+Copyright 2016 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package client
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/fission/fission"
+)
+
+// Client calls the admin API of a router.
+type Client struct {
+	routerUrl  string
+	adminToken string
+}
+
+func MakeClient(routerUrl string, adminToken string) *Client {
+	return &Client{
+		routerUrl:  strings.TrimSuffix(routerUrl, "/"),
+		adminToken: adminToken,
+	}
+}
+
+func (c *Client) get(relativeUrl string, result interface{}) error {
+	return c.do("GET", relativeUrl, result)
+}
+
+func (c *Client) do(method string, relativeUrl string, result interface{}) error {
+	req, err := http.NewRequest(method, c.routerUrl+"/fission-admin/"+relativeUrl, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+c.adminToken)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		return fission.MakeErrorFromHTTP(resp)
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(body, result)
+}
+
+// Routes returns the router's route table.
+func (c *Client) Routes() (*fission.RouteTable, error) {
+	table := &fission.RouteTable{}
+	err := c.get("routes", table)
+	if err != nil {
+		return nil, err
+	}
+	return table, nil
+}
+
+// FunctionServices returns the function instances the router has
+// cached.
+func (c *Client) FunctionServices() ([]fission.RouterFunctionServices, error) {
+	services := make([]fission.RouterFunctionServices, 0)
+	err := c.get("services", &services)
+	if err != nil {
+		return nil, err
+	}
+	return services, nil
+}
+
+// ShadowStats compares the responses of triggers' functions and
+// their shadows to the requests the router mirrored.
+func (c *Client) ShadowStats() ([]fission.ShadowStats, error) {
+	stats := make([]fission.ShadowStats, 0)
+	err := c.get("shadows", &stats)
+	if err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
+
+// CachePurge drops a trigger's cached responses from the router.
+func (c *Client) CachePurge(trigger string) (*fission.CachePurge, error) {
+	purge := &fission.CachePurge{}
+	err := c.do("DELETE", "cache/"+url.PathEscape(trigger), purge)
+	if err != nil {
+		return nil, err
+	}
+	return purge, nil
+}
+
+// Resolve returns the route the router would use for a request.  host
+// is optional.
+func (c *Client) Resolve(method string, path string, host string) (*fission.RouteResolution, error) {
+	query := url.Values{}
+	query.Set("method", method)
+	query.Set("url", path)
+	if len(host) > 0 {
+		query.Set("host", host)
+	}
+	res := &fission.RouteResolution{}
+	err := c.get("resolve?"+query.Encode(), res)
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
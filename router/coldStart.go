@@ -0,0 +1,111 @@
+/*
+Copyright 2016 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package router
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/fission/fission"
+)
+
+// Seconds clients are asked to wait (in Retry-After) when a cold
+// start queue is full or they waited too long.
+const COLD_START_RETRY_AFTER = 2
+
+var (
+	errColdStartQueueFull = errors.New("too many requests waiting for function to start")
+	errColdStartTimeout   = errors.New("timed out waiting for function to start")
+)
+
+// coldStartQueue coalesces concurrent lookups of a function's
+// service on a cache miss: the first caller asks poolmgr, and the
+// others wait for its result instead of specializing more pods.
+type coldStartQueue struct {
+	lock     sync.Mutex
+	pending  map[fission.Metadata]*coldStart
+	maxDepth int           // max callers waiting per function
+	timeout  time.Duration // max time a caller waits
+}
+
+// coldStart is an in-flight service lookup for a function.
+type coldStart struct {
+	done      chan struct{} // closed when the lookup finishes
+	waiters   int
+	instances []*url.URL
+	err       error
+}
+
+func makeColdStartQueue(maxDepth int, timeout time.Duration) *coldStartQueue {
+	return &coldStartQueue{
+		pending:  make(map[fission.Metadata]*coldStart),
+		maxDepth: maxDepth,
+		timeout:  timeout,
+	}
+}
+
+// get returns the instances of function m, calling lookup unless a
+// lookup for m is already in flight.  The lookup isn't cancelled
+// if callers give up waiting, so that its result can be cached.
+func (q *coldStartQueue) get(ctx context.Context, m fission.Metadata, lookup func() ([]*url.URL, error)) ([]*url.URL, error) {
+	q.lock.Lock()
+	cs, ok := q.pending[m]
+	if !ok {
+		cs = &coldStart{done: make(chan struct{})}
+		q.pending[m] = cs
+		go q.run(m, cs, lookup)
+	} else if q.maxDepth > 0 && cs.waiters >= q.maxDepth {
+		q.lock.Unlock()
+		return nil, errColdStartQueueFull
+	}
+	cs.waiters++
+	q.lock.Unlock()
+
+	defer func() {
+		q.lock.Lock()
+		cs.waiters--
+		q.lock.Unlock()
+	}()
+
+	var timeout <-chan time.Time
+	if q.timeout > 0 {
+		timer := time.NewTimer(q.timeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	select {
+	case <-cs.done:
+		return cs.instances, cs.err
+	case <-timeout:
+		return nil, errColdStartTimeout
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (q *coldStartQueue) run(m fission.Metadata, cs *coldStart, lookup func() ([]*url.URL, error)) {
+	cs.instances, cs.err = lookup()
+
+	q.lock.Lock()
+	delete(q.pending, m)
+	q.lock.Unlock()
+	close(cs.done)
+}
@@ -0,0 +1,100 @@
+/*
+Copyright 2016 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package router
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/fission/fission"
+)
+
+func TestColdStartCoalescing(t *testing.T) {
+	q := makeColdStartQueue(0, 0)
+	m := fission.Metadata{Name: "foo", Uid: "xxx"}
+	u, _ := url.Parse("http://10.0.0.1:8888")
+
+	var lookups int32
+	release := make(chan struct{})
+	lookup := func() ([]*url.URL, error) {
+		atomic.AddInt32(&lookups, 1)
+		<-release
+		return []*url.URL{u}, nil
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			su, err := q.get(context.Background(), m, lookup)
+			if err != nil || len(su) != 1 || su[0] != u {
+				t.Errorf("expected %v, got %v (%v)", u, su, err)
+			}
+		}()
+	}
+	// let the callers queue up before the lookup finishes
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	if lookups != 1 {
+		t.Errorf("expected 1 lookup for concurrent cold starts, got %v", lookups)
+	}
+	if len(q.pending) != 0 {
+		t.Errorf("expected no pending cold starts, got %v", len(q.pending))
+	}
+}
+
+func TestColdStartQueueLimits(t *testing.T) {
+	q := makeColdStartQueue(1, 50*time.Millisecond)
+	m := fission.Metadata{Name: "foo", Uid: "xxx"}
+	release := make(chan struct{})
+	defer close(release)
+	lookup := func() ([]*url.URL, error) {
+		<-release
+		return nil, nil
+	}
+
+	errs := make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			_, err := q.get(context.Background(), m, lookup)
+			errs <- err
+		}()
+	}
+	// one caller fits in the queue and times out; the other
+	// overflows it
+	got := map[error]bool{<-errs: true, <-errs: true}
+	if !got[errColdStartQueueFull] || !got[errColdStartTimeout] {
+		t.Errorf("expected queue full and timeout errors, got %v", got)
+	}
+
+	// a handler reports both as 503, with Retry-After
+	fh := &functionHandler{fmap: makeFunctionServiceMap(0), Function: m, coldStarts: q}
+	w := httptest.NewRecorder()
+	fh.handler(w, httptest.NewRequest("GET", "http://router/", nil))
+	if w.Code != http.StatusServiceUnavailable || len(w.Header().Get("Retry-After")) == 0 {
+		t.Errorf("expected 503 with Retry-After, got %v %v", w.Code, w.Header())
+	}
+}
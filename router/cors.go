@@ -0,0 +1,218 @@
+/*
+Copyright 2016 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package router
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+
+	"github.com/gorilla/mux"
+
+	"github.com/fission/fission"
+)
+
+// CORS headers set by the router; functions' own values are replaced.
+var corsResponseHeaders = []string{
+	"Access-Control-Allow-Origin",
+	"Access-Control-Allow-Credentials",
+	"Access-Control-Expose-Headers",
+	"Access-Control-Allow-Methods",
+	"Access-Control-Allow-Headers",
+	"Access-Control-Max-Age",
+}
+
+// corsPolicy applies a trigger's CORS policy.
+type corsPolicy struct {
+	policy         fission.CORSPolicy
+	triggerMethods []string // nil if the trigger matches any method
+	anyOrigin      bool
+	anyHeader      bool
+}
+
+type corsKey struct{}
+
+func makeCORSPolicy(policy *fission.CORSPolicy, triggerMethods []string) *corsPolicy {
+	return &corsPolicy{
+		policy:         *policy,
+		triggerMethods: triggerMethods,
+		anyOrigin:      containsString(policy.AllowOrigins, fission.CORS_ORIGIN_ANY),
+		anyHeader:      containsString(policy.AllowHeaders, "*"),
+	}
+}
+
+// isPreflight returns true if request is a CORS preflight request.
+func isPreflight(request *http.Request) bool {
+	return request.Method == http.MethodOptions &&
+		len(request.Header.Get("Origin")) > 0 &&
+		len(request.Header.Get("Access-Control-Request-Method")) > 0
+}
+
+// allowsOrigin returns true if the policy allows requests from origin.
+func (cp *corsPolicy) allowsOrigin(origin string) bool {
+	if cp.anyOrigin {
+		return true
+	}
+	for _, allowed := range cp.policy.AllowOrigins {
+		if strings.EqualFold(allowed, origin) {
+			return true
+		}
+		// "https://*.example.com" matches subdomains of example.com
+		i := strings.Index(allowed, "://*.")
+		if i >= 0 {
+			scheme, suffix := allowed[:i+3], allowed[i+4:]
+			if len(origin) > len(scheme)+len(suffix) &&
+				strings.EqualFold(origin[:len(scheme)], scheme) &&
+				strings.HasSuffix(strings.ToLower(origin), strings.ToLower(suffix)) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// allowedMethods returns the methods a preflight may ask for, or nil
+// for any.
+func (cp *corsPolicy) allowedMethods() []string {
+	if len(cp.policy.AllowMethods) > 0 {
+		return cp.policy.AllowMethods
+	}
+	return cp.triggerMethods
+}
+
+// matchPreflight is a mux matcher for the preflight requests of the
+// trigger: those asking for a method the trigger matches.  Others go
+// on to the following routes, e.g. another trigger on the same path.
+func (cp *corsPolicy) matchPreflight(request *http.Request, rm *mux.RouteMatch) bool {
+	if !isPreflight(request) {
+		return false
+	}
+	return cp.triggerMethods == nil ||
+		containsMethod(cp.triggerMethods, strings.ToUpper(request.Header.Get("Access-Control-Request-Method")))
+}
+
+// preflight answers a preflight request, or rejects it with 403 if the
+// policy doesn't allow the request it's for.
+func (cp *corsPolicy) preflight(responseWriter http.ResponseWriter, request *http.Request) {
+	h := responseWriter.Header()
+	h.Add("Vary", "Origin, Access-Control-Request-Method, Access-Control-Request-Headers")
+
+	origin := request.Header.Get("Origin")
+	method := strings.ToUpper(request.Header.Get("Access-Control-Request-Method"))
+	if !cp.allowsOrigin(origin) {
+		http.Error(responseWriter, "CORS origin not allowed (fission)", http.StatusForbidden)
+		return
+	}
+	methods := cp.allowedMethods()
+	if methods != nil && !containsMethod(methods, method) {
+		http.Error(responseWriter, "CORS method not allowed (fission)", http.StatusForbidden)
+		return
+	}
+	requestHeaders := splitHeaderList(request.Header.Get("Access-Control-Request-Headers"))
+	if !cp.anyHeader {
+		for _, header := range requestHeaders {
+			if !containsHeader(cp.policy.AllowHeaders, header) {
+				http.Error(responseWriter, fmt.Sprintf("CORS header %v not allowed (fission)", header),
+					http.StatusForbidden)
+				return
+			}
+		}
+	}
+
+	cp.setOriginHeaders(responseWriter, origin)
+	if methods == nil {
+		h.Set("Access-Control-Allow-Methods", method)
+	} else {
+		h.Set("Access-Control-Allow-Methods", strings.ToUpper(strings.Join(methods, ", ")))
+	}
+	if len(requestHeaders) > 0 {
+		h.Set("Access-Control-Allow-Headers", strings.Join(requestHeaders, ", "))
+	}
+	if cp.policy.MaxAge > 0 {
+		h.Set("Access-Control-Max-Age", fmt.Sprintf("%v", cp.policy.MaxAge))
+	}
+	responseWriter.WriteHeader(http.StatusNoContent)
+}
+
+// apply adds the CORS headers to the response to an actual (not
+// preflight) request, and returns the request marked so that the
+// function's own CORS headers are dropped.
+func (cp *corsPolicy) apply(responseWriter http.ResponseWriter, request *http.Request) *http.Request {
+	if !cp.anyOrigin {
+		responseWriter.Header().Add("Vary", "Origin")
+	}
+	origin := request.Header.Get("Origin")
+	if len(origin) > 0 && cp.allowsOrigin(origin) {
+		cp.setOriginHeaders(responseWriter, origin)
+		if len(cp.policy.ExposeHeaders) > 0 {
+			responseWriter.Header().Set("Access-Control-Expose-Headers", strings.Join(cp.policy.ExposeHeaders, ", "))
+		}
+	}
+	return request.WithContext(context.WithValue(request.Context(), corsKey{}, true))
+}
+
+func (cp *corsPolicy) setOriginHeaders(responseWriter http.ResponseWriter, origin string) {
+	h := responseWriter.Header()
+	if cp.anyOrigin {
+		h.Set("Access-Control-Allow-Origin", "*")
+	} else {
+		h.Set("Access-Control-Allow-Origin", origin)
+	}
+	if cp.policy.AllowCredentials {
+		h.Set("Access-Control-Allow-Credentials", "true")
+	}
+}
+
+// removeFunctionCORSHeaders drops the CORS headers of a function's
+// response to a request the router applied a CORS policy to.
+func removeFunctionCORSHeaders(resp *http.Response) {
+	if applied, _ := resp.Request.Context().Value(corsKey{}).(bool); !applied {
+		return
+	}
+	for _, header := range corsResponseHeaders {
+		resp.Header.Del(header)
+	}
+}
+
+func sameCORS(cp *corsPolicy, policy *fission.CORSPolicy, triggerMethods []string) bool {
+	if cp == nil || policy == nil {
+		return cp == nil && policy == nil
+	}
+	return reflect.DeepEqual(cp.policy, *policy) && reflect.DeepEqual(cp.triggerMethods, triggerMethods)
+}
+
+func splitHeaderList(list string) []string {
+	headers := make([]string, 0)
+	for _, h := range strings.Split(list, ",") {
+		h = strings.TrimSpace(h)
+		if len(h) > 0 {
+			headers = append(headers, h)
+		}
+	}
+	return headers
+}
+
+func containsHeader(headers []string, header string) bool {
+	for _, h := range headers {
+		if strings.EqualFold(h, header) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,147 @@
+/*
+Copyright 2016 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package router
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/gorilla/mux"
+
+	"github.com/fission/fission"
+)
+
+func TestCORSOrigins(t *testing.T) {
+	cp := makeCORSPolicy(&fission.CORSPolicy{
+		AllowOrigins: []string{"https://app.example.com", "https://*.example.org"},
+	}, nil)
+	tests := []struct {
+		origin  string
+		allowed bool
+	}{
+		{"https://app.example.com", true},
+		{"https://APP.example.com", true},
+		{"http://app.example.com", false},
+		{"https://evil.example.com", false},
+		{"https://a.example.org", true},
+		{"https://a.b.example.org", true},
+		{"https://example.org", false},
+		{"https://evilexample.org", false},
+		{"http://a.example.org", false},
+	}
+	for _, test := range tests {
+		if cp.allowsOrigin(test.origin) != test.allowed {
+			t.Errorf("origin %v: expected allowed to be %v", test.origin, test.allowed)
+		}
+	}
+}
+
+func TestCORSTrigger(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// the router's policy replaces the function's
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		w.Write([]byte(r.Method))
+	}))
+	defer backend.Close()
+	backendURL, _ := url.Parse(backend.URL)
+
+	fmap := makeFunctionServiceMap(0)
+	fn := &fission.Metadata{Name: "orders", Uid: "orders1"}
+	fmap.assign(fn, []*url.URL{backendURL})
+	functions := map[string]functionRoute{"orders": {uid: fn.Uid}}
+
+	triggers := makeHTTPTriggerSet(fmap, nil, nil, nil, nil, nil)
+	triggers.mutableRouter = NewMutableRouter(mux.NewRouter())
+	triggers.update([]fission.HTTPTrigger{
+		{
+			Metadata:   fission.Metadata{Name: "t1"},
+			UrlPattern: "/orders",
+			Methods:    []string{"GET", "POST"},
+			Function:   fission.Metadata{Name: "orders"},
+			CORS: &fission.CORSPolicy{
+				AllowOrigins:     []string{"https://app.example.com"},
+				AllowHeaders:     []string{"Content-Type", "X-Api-Key"},
+				ExposeHeaders:    []string{"X-Request-Id"},
+				AllowCredentials: true,
+				MaxAge:           600,
+			},
+		},
+	}, functions)
+
+	serve := func(method string, header map[string]string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "http://api.example.com/orders", nil)
+		for k, v := range header {
+			req.Header.Set(k, v)
+		}
+		w := httptest.NewRecorder()
+		triggers.mutableRouter.ServeHTTP(w, req)
+		return w
+	}
+
+	// preflights are answered by the router
+	w := serve("OPTIONS", map[string]string{
+		"Origin":                         "https://app.example.com",
+		"Access-Control-Request-Method":  "POST",
+		"Access-Control-Request-Headers": "content-type, x-api-key",
+	})
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("expected preflight to get 204, got %v %v", w.Code, w.Body.String())
+	}
+	h := w.Header()
+	if h.Get("Access-Control-Allow-Origin") != "https://app.example.com" ||
+		h.Get("Access-Control-Allow-Credentials") != "true" ||
+		h.Get("Access-Control-Allow-Methods") != "GET, POST" ||
+		h.Get("Access-Control-Allow-Headers") != "content-type, x-api-key" ||
+		h.Get("Access-Control-Max-Age") != "600" {
+		t.Errorf("bad preflight response headers: %v", h)
+	}
+
+	rejected := []map[string]string{
+		{"Origin": "https://evil.example.com", "Access-Control-Request-Method": "POST"},
+		{"Origin": "https://app.example.com", "Access-Control-Request-Method": "POST", "Access-Control-Request-Headers": "X-Other"},
+	}
+	for _, header := range rejected {
+		w = serve("OPTIONS", header)
+		if w.Code != http.StatusForbidden || len(w.Header().Get("Access-Control-Allow-Origin")) > 0 {
+			t.Errorf("expected preflight %v to be rejected, got %v", header, w.Code)
+		}
+	}
+
+	// a preflight for a method the trigger doesn't match isn't routed to it
+	w = serve("OPTIONS", map[string]string{"Origin": "https://app.example.com", "Access-Control-Request-Method": "DELETE"})
+	if w.Code < 400 {
+		t.Errorf("expected preflight for DELETE to fail, got %v", w.Code)
+	}
+
+	// actual requests get the CORS headers
+	w = serve("POST", map[string]string{"Origin": "https://app.example.com"})
+	if w.Code != http.StatusOK || w.Body.String() != "POST" {
+		t.Fatalf("expected POST to reach the function, got %v %v", w.Code, w.Body.String())
+	}
+	h = w.Header()
+	if len(h["Access-Control-Allow-Origin"]) != 1 || h.Get("Access-Control-Allow-Origin") != "https://app.example.com" ||
+		h.Get("Access-Control-Expose-Headers") != "X-Request-Id" || h.Get("Vary") != "Origin" {
+		t.Errorf("bad response headers: %v", h)
+	}
+
+	w = serve("GET", map[string]string{"Origin": "https://evil.example.com"})
+	if len(w.Header().Get("Access-Control-Allow-Origin")) > 0 {
+		t.Errorf("response to a disallowed origin has CORS headers: %v", w.Header())
+	}
+}
@@ -0,0 +1,192 @@
+/*
+Copyright 2016 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package router
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"reflect"
+	"strconv"
+	"time"
+
+	"github.com/fission/fission"
+)
+
+const (
+	// Header with the status of a function's failed response, on
+	// requests to its trigger's fallback function and on fallback
+	// responses.
+	HEADER_FALLBACK = "X-Fission-Fallback"
+
+	// Requests with larger bodies don't fall back to a function.
+	FALLBACK_MAX_BODY_SIZE = 1024 * 1024
+)
+
+type (
+	// triggerFallback responds to a trigger's requests that its
+	// function fails to serve.
+	triggerFallback struct {
+		policy  fission.FallbackPolicy // with the fallback function's uid resolved
+		trigger string
+		fh      *functionHandler // serves the fallback function; nil for a static response
+	}
+
+	// interceptingResponseWriter passes a response through, unless
+	// intercept returns true for its status: then it's discarded,
+	// so that the router can respond otherwise (e.g. with a
+	// fallback, or by retrying the request).
+	interceptingResponseWriter struct {
+		responseWriter http.ResponseWriter
+		header         http.Header
+		intercept      func(statusCode int) bool
+		wroteHeader    bool
+		intercepted    int // status of a discarded response
+	}
+)
+
+func makeTriggerFallback(policy *fission.FallbackPolicy, trigger string, fh *functionHandler) *triggerFallback {
+	return &triggerFallback{
+		policy:  *policy,
+		trigger: trigger,
+		fh:      fh,
+	}
+}
+
+// serve calls next to serve a request with the trigger's function,
+// and falls back if it fails.
+func (tf *triggerFallback) serve(responseWriter http.ResponseWriter, request *http.Request, next http.HandlerFunc) {
+	if isUpgrade(request) {
+		next(responseWriter, request)
+		return
+	}
+
+	// The fallback function gets the request as the client sent it.
+	var header http.Header
+	var body []byte
+	if tf.fh != nil {
+		header = cloneHeader(request.Header)
+		if request.Body != nil && request.Body != http.NoBody {
+			var err error
+			body, err = ioutil.ReadAll(io.LimitReader(request.Body, FALLBACK_MAX_BODY_SIZE+1))
+			request.Body = struct {
+				io.Reader
+				io.Closer
+			}{io.MultiReader(bytes.NewReader(body), request.Body), request.Body}
+			if err != nil || len(body) > FALLBACK_MAX_BODY_SIZE {
+				next(responseWriter, request)
+				return
+			}
+		}
+	}
+
+	w := makeInterceptingResponseWriter(responseWriter, func(statusCode int) bool {
+		return statusCode >= 500
+	})
+	next(w, request)
+	// Don't fall back for clients that have gone away.
+	if w.intercepted == 0 || request.Context().Err() == context.Canceled {
+		return
+	}
+
+	log.Printf("Function of trigger %v failed with status %v, falling back", tf.trigger, w.intercepted)
+	status := strconv.Itoa(w.intercepted)
+	responseWriter.Header().Set(HEADER_FALLBACK, status)
+	if tf.fh == nil {
+		contentType := tf.policy.ContentType
+		if len(contentType) == 0 {
+			contentType = "text/plain; charset=utf-8"
+		}
+		responseWriter.Header().Set("Content-Type", contentType)
+		responseWriter.WriteHeader(fission.FallbackStatus(&tf.policy))
+		if request.Method != http.MethodHead {
+			io.WriteString(responseWriter, tf.policy.Body)
+		}
+		return
+	}
+
+	// Fallback responses aren't cached for the trigger.
+	req := request.WithContext(context.WithValue(request.Context(), cacheFillKey{}, (*cacheFill)(nil)))
+	req.Header = header
+	req.Header.Set(HEADER_FALLBACK, status)
+	if body != nil {
+		req.Body = ioutil.NopCloser(bytes.NewReader(body))
+		req.ContentLength = int64(len(body))
+	}
+	tf.fh.serve(responseWriter, req)
+}
+
+// sameFallback returns true if a trigger's fallback responds as
+// policy does, with the fallback function's uid resolved, running
+// with the given timeout.
+func sameFallback(tf *triggerFallback, policy *fission.FallbackPolicy, timeout time.Duration) bool {
+	if tf == nil || policy == nil {
+		return tf == nil && policy == nil
+	}
+	return reflect.DeepEqual(tf.policy, *policy) && (tf.fh == nil || tf.fh.timeout == timeout)
+}
+
+func makeInterceptingResponseWriter(responseWriter http.ResponseWriter, intercept func(int) bool) *interceptingResponseWriter {
+	return &interceptingResponseWriter{
+		responseWriter: responseWriter,
+		header:         make(http.Header),
+		intercept:      intercept,
+	}
+}
+
+func (w *interceptingResponseWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *interceptingResponseWriter) WriteHeader(statusCode int) {
+	if w.wroteHeader {
+		return
+	}
+	w.wroteHeader = true
+	if w.intercept(statusCode) {
+		w.intercepted = statusCode
+		return
+	}
+	h := w.responseWriter.Header()
+	for k, v := range w.header {
+		h[k] = v
+	}
+	w.responseWriter.WriteHeader(statusCode)
+}
+
+func (w *interceptingResponseWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.intercepted != 0 {
+		return len(b), nil
+	}
+	return w.responseWriter.Write(b)
+}
+
+// Flush passes flushes of responses that are passed through.
+func (w *interceptingResponseWriter) Flush() {
+	if !w.wroteHeader || w.intercepted != 0 {
+		return
+	}
+	if f, ok := w.responseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
@@ -0,0 +1,106 @@
+/*
+Copyright 2016 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package router
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+
+	"github.com/fission/fission"
+)
+
+func TestTriggerFallback(t *testing.T) {
+	primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Primary", "yes")
+		if r.URL.Query().Get("fail") != "" {
+			http.Error(w, "broken", http.StatusInternalServerError)
+			return
+		}
+		w.Write([]byte("ok"))
+	}))
+	defer primary.Close()
+	fallback := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		fmt.Fprintf(w, "fallback %v %v", r.Header.Get(HEADER_FALLBACK), string(body))
+	}))
+	defer fallback.Close()
+	primaryURL, _ := url.Parse(primary.URL)
+	fallbackURL, _ := url.Parse(fallback.URL)
+
+	fmap := makeFunctionServiceMap(0)
+	fn := fission.Metadata{Name: "hello", Uid: "v1"}
+	fallbackFn := fission.Metadata{Name: "sorry", Uid: "s1"}
+	fmap.assign(&fn, []*url.URL{primaryURL})
+	fmap.assign(&fallbackFn, []*url.URL{fallbackURL})
+	functions := map[string]functionRoute{"hello": {uid: fn.Uid}, "sorry": {uid: fallbackFn.Uid}}
+
+	triggers := makeHTTPTriggerSet(fmap, nil, nil, nil, nil, nil)
+	triggers.mutableRouter = NewMutableRouter(mux.NewRouter())
+	triggers.update([]fission.HTTPTrigger{
+		{
+			Metadata:   fission.Metadata{Name: "static"},
+			UrlPattern: "/static",
+			Function:   fission.Metadata{Name: "hello"},
+			Fallback: &fission.FallbackPolicy{
+				ContentType: "application/json",
+				Body:        `{"error":"try later"}`,
+			},
+		},
+		{
+			Metadata:   fission.Metadata{Name: "function"},
+			UrlPattern: "/function",
+			Function:   fission.Metadata{Name: "hello"},
+			Fallback: &fission.FallbackPolicy{
+				Function: &fission.Metadata{Name: "sorry"},
+			},
+		},
+	}, functions)
+
+	tests := []struct {
+		path          string
+		status        int
+		body          string
+		contentType   string
+		fallbackUsed  string
+		primaryHeader string
+	}{
+		{"/static", http.StatusOK, "ok", "text/plain; charset=utf-8", "", "yes"},
+		{"/static?fail=1", http.StatusServiceUnavailable, `{"error":"try later"}`, "application/json", "500", ""},
+		{"/function", http.StatusOK, "ok", "text/plain; charset=utf-8", "", "yes"},
+		{"/function?fail=1", http.StatusOK, "fallback 500 payload", "text/plain; charset=utf-8", "500", ""},
+	}
+	for _, test := range tests {
+		req := httptest.NewRequest("POST", "http://example.com"+test.path, strings.NewReader("payload"))
+		req.Header.Set(HEADER_FALLBACK, "spoofed")
+		w := httptest.NewRecorder()
+		triggers.mutableRouter.ServeHTTP(w, req)
+		h := w.Header()
+		if w.Code != test.status || w.Body.String() != test.body || h.Get("Content-Type") != test.contentType ||
+			h.Get(HEADER_FALLBACK) != test.fallbackUsed || h.Get("X-Primary") != test.primaryHeader {
+			t.Errorf("%v: expected %v %q (%v, fallback %q), got %v %q (%v, fallback %q, headers %v)",
+				test.path, test.status, test.body, test.contentType, test.fallbackUsed,
+				w.Code, w.Body.String(), h.Get("Content-Type"), h.Get(HEADER_FALLBACK), h)
+		}
+	}
+}
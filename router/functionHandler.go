@@ -17,67 +17,129 @@ limitations under the License.
 package router
 
 import (
+	"context"
 	"fmt"
 	"log"
-	"net"
 	"net/http"
-	"net/http/httputil"
 	"net/url"
+	"strings"
+	"sync"
 	"time"
 
+	"github.com/gorilla/mux"
+
 	"github.com/fission/fission"
 	poolmgrClient "github.com/fission/fission/poolmgr/client"
+	"github.com/fission/fission/tracing"
+)
+
+// Headers the router adds to proxied requests, so that functions can
+// see how they were invoked.  Environments expose these through
+// their own APIs (e.g. the Go environment's context package).
+const (
+	// Request path before it was rewritten to "/"
+	HEADER_ORIGINAL_PATH = "X-Fission-Original-Path"
+	// Name of the HTTP trigger that matched; absent when the
+	// function is invoked through its internal route
+	HEADER_TRIGGER_NAME = "X-Fission-Trigger-Name"
+	// Route variables extracted from the trigger's URL pattern,
+	// form-encoded (e.g. "id=42&name=foo")
+	HEADER_ROUTE_PARAMS = "X-Fission-Route-Params"
 )
 
 type functionHandler struct {
-	fmap     *functionServiceMap
-	poolmgr  *poolmgrClient.Client
-	Function fission.Metadata
+	fmap        *functionServiceMap
+	poolmgr     *poolmgrClient.Client
+	Function    fission.Metadata
+	triggerName string        // empty for internal function routes
+	timeout     time.Duration // max execution time of the function; zero means no limit
+	coldStarts  *coldStartQueue
+	balancer    *loadBalancer
+	invoker     *asyncInvoker    // nil if async invocation is disabled
+	rateLimiter *rateLimiter     // nil if the trigger isn't rate limited
+	auth        *triggerAuth     // nil if the trigger doesn't require auth
+	cors        *corsPolicy      // nil if the trigger doesn't allow CORS
+	cache       *triggerCache    // nil if the trigger's responses aren't cached
+	shadow      *shadowMirror    // nil if the trigger's requests aren't mirrored
+	retry       *triggerRetry    // nil if the trigger's requests aren't retried
+	fallback    *triggerFallback // nil if the trigger has no fallback
+
+	proxyLock sync.Mutex
+	proxies   map[string]*functionProxy // proxies to the function's instances, by host
 }
 
-func (fh *functionHandler) getServiceForFunction() (*url.URL, error) {
-	// call poolmgr, get a url for a function
-	svcName, err := fh.poolmgr.GetServiceForFunction(&fh.Function)
+// getServicesForFunction asks poolmgr for the function's instances.
+// inFlight is the number of requests outstanding to the function.
+func (fh *functionHandler) getServicesForFunction(ctx context.Context, inFlight int) ([]*url.URL, error) {
+	span, ctx := tracing.StartSpan(ctx, "router.poolmgr.getServicesForFunction")
+	defer span.Finish()
+	span.SetAttribute("inFlight", inFlight)
+
+	// call poolmgr, get urls for a function
+	svcNames, err := fh.poolmgr.GetServicesForFunction(ctx, &fh.Function, inFlight)
 	if err != nil {
+		span.SetError(err)
 		return nil, err
 	}
-	svcUrl, err := url.Parse(fmt.Sprintf("http://%v", svcName))
-	if err != nil {
-		return nil, err
+	if len(svcNames) == 0 {
+		return nil, fmt.Errorf("no instances of function %v", fh.Function)
+	}
+	svcUrls := make([]*url.URL, 0, len(svcNames))
+	for _, svcName := range svcNames {
+		svcUrl, err := url.Parse(fmt.Sprintf("http://%v", svcName))
+		if err != nil {
+			return nil, err
+		}
+		svcUrls = append(svcUrls, svcUrl)
 	}
-	return svcUrl, nil
+	return svcUrls, nil
 }
 
-// A layer on top of http.DefaultTransport, with retries.
-type RetryingRoundTripper struct {
-	maxRetries    int
-	initalTimeout time.Duration
+// scaleUp tells poolmgr the function is busy, and caches the
+// instances it returns.  Poolmgr adds an instance in the background,
+// which this router picks up on a later call.
+func (fh *functionHandler) scaleUp(inFlight int) {
+	instances, err := fh.getServicesForFunction(context.Background(), inFlight)
+	if err != nil {
+		log.Printf("Failed to get instances of function %v: %v", fh.Function, err)
+		return
+	}
+	fh.fmap.assign(&fh.Function, instances)
 }
 
-func (rrt RetryingRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
-	timeout := rrt.initalTimeout
-	transport := http.DefaultTransport.(*http.Transport)
-
-	// Do max-1 retries; the last one uses default transport timeouts
-	for i := rrt.maxRetries - 1; i > 0; i-- {
-		// update timeout in transport
-		transport.DialContext = (&net.Dialer{
-			Timeout:   timeout,
-			KeepAlive: 30 * time.Second,
-		}).DialContext
-
-		resp, err := transport.RoundTrip(req)
-		if err == nil {
-			return resp, nil
-		}
+// getProxy returns the cached proxy for an instance of the function,
+// and closes those of instances that are gone.
+func (fh *functionHandler) getProxy(serviceUrl *url.URL, instances []*url.URL) *functionProxy {
+	fh.proxyLock.Lock()
+	defer fh.proxyLock.Unlock()
 
-		timeout *= time.Duration(2)
-		log.Printf("Retrying request to %v in %v", req.URL.Host, timeout)
-		time.Sleep(timeout)
+	if fh.proxies == nil {
+		fh.proxies = make(map[string]*functionProxy)
+	}
+	for host, fp := range fh.proxies {
+		found := false
+		for _, u := range instances {
+			if u.Host == host {
+				found = true
+				break
+			}
+		}
+		if !found {
+			fp.close()
+			delete(fh.proxies, host)
+		}
 	}
 
-	// finally, one more retry with the default timeout
-	return http.DefaultTransport.RoundTrip(req)
+	fp, ok := fh.proxies[serviceUrl.Host]
+	if ok && *fp.serviceUrl == *serviceUrl {
+		return fp
+	}
+	if ok {
+		fp.close()
+	}
+	fp = makeFunctionProxy(serviceUrl, fh.proxyErrorHandler)
+	fh.proxies[serviceUrl.Host] = fp
+	return fp
 }
 
 func (fh *functionHandler) tapService(serviceUrl *url.URL) {
@@ -90,70 +152,256 @@ func (fh *functionHandler) tapService(serviceUrl *url.URL) {
 	}
 }
 
-func (fh *functionHandler) handler(responseWriter http.ResponseWriter, request *http.Request) {
-	reqStartTime := time.Now()
-
+// resolveService returns the function's instances, and whether they
+// were found in the router's cache rather than just obtained from
+// poolmgr.
+func (fh *functionHandler) resolveService(ctx context.Context) ([]*url.URL, bool, error) {
 	// cache lookup
-	serviceUrl, err := fh.fmap.lookup(&fh.Function)
-	if err != nil {
-		// Cache miss: request the Pool Manager to make a new service.
-		log.Printf("Not cached, getting new service for %v", fh.Function)
+	span, _ := tracing.StartSpan(ctx, "router.fmap.lookup")
+	instances, err := fh.fmap.lookup(&fh.Function)
+	span.SetAttribute("hit", err == nil)
+	span.Finish()
+	if err == nil {
+		return instances, true, nil
+	}
 
-		var poolErr error
-		serviceUrl, poolErr = fh.getServiceForFunction()
-		if poolErr != nil {
-			log.Printf("Failed to get service for function (%v,%v): %v",
-				fh.Function.Name, fh.Function.Uid, poolErr)
-			// We might want a specific error code or header for fission
-			// failures as opposed to user function bugs.
-			http.Error(responseWriter, "Internal server error (fission)", 500)
-			return
+	// Cache miss: request the Pool Manager to make a new service.
+	// Callers coalesced onto this lookup share the first one's
+	// trace.
+	lookup := func() ([]*url.URL, error) {
+		log.Printf("Not cached, getting new service for %v", fh.Function)
+		instances, err := fh.getServicesForFunction(ctx, 0)
+		if err != nil {
+			return nil, err
 		}
 
 		// add it to the map
-		fh.fmap.assign(&fh.Function, serviceUrl)
+		fh.fmap.assign(&fh.Function, instances)
+		return instances, nil
+	}
+	if fh.coldStarts != nil {
+		instances, err = fh.coldStarts.get(ctx, fh.Function, lookup)
 	} else {
+		instances, err = lookup()
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	return instances, false, nil
+}
+
+// pickInstance chooses the instance to send a request to, and
+// returns a function to call when the request is done.
+func (fh *functionHandler) pickInstance(instances []*url.URL, cached bool) (*url.URL, func()) {
+	serviceUrl, outstanding, release := fh.balancer.pick(instances)
+	if cached {
 		// if we're using our cache, asynchronously tell
 		// poolmgr we're using this service
 		go fh.tapService(serviceUrl)
 	}
+	if fh.balancer.shouldScaleUp(fh.Function, len(instances), outstanding) {
+		go fh.scaleUp(outstanding)
+	}
+	return serviceUrl, release
+}
 
-	// Proxy off our request to the serviceUrl, and send the response back.
-	// TODO: As an optimization we may want to cache proxies too -- this might get us
-	// connection reuse and possibly better performance
-	director := func(req *http.Request) {
-		log.Printf("Proxying request for %v to %v", req.URL, serviceUrl.Host)
-
-		// send this request to serviceurl
-		req.URL.Scheme = serviceUrl.Scheme
-		req.URL.Host = serviceUrl.Host
+// serviceError reports a failure to get a service for the function.
+func (fh *functionHandler) serviceError(responseWriter http.ResponseWriter, err error) {
+	log.Printf("Failed to get service for function (%v,%v): %v",
+		fh.Function.Name, fh.Function.Uid, err)
+	if err == errColdStartQueueFull || err == errColdStartTimeout {
+		responseWriter.Header().Set("Retry-After", fmt.Sprintf("%v", COLD_START_RETRY_AFTER))
+		http.Error(responseWriter, "Service unavailable: "+err.Error()+" (fission)",
+			http.StatusServiceUnavailable)
+		return
+	}
+	// We might want a specific error code or header for fission
+	// failures as opposed to user function bugs.
+	http.Error(responseWriter, "Internal server error (fission)", 500)
+}
 
-		// To keep the function run container simple, it
-		// doesn't do any routing.  In the future if we have
-		// multiple functions per container, we could use the
-		// function metadata here.
-		req.URL.Path = "/"
+// setInvocationHeaders tells the function how it was invoked.  Any
+// of these headers set by the client are dropped, so they can't be
+// spoofed.
+func (fh *functionHandler) setInvocationHeaders(request *http.Request) {
+	// Route variables are only available on the incoming request.
+	originalPath := request.URL.Path
+	params := url.Values{}
+	for k, v := range mux.Vars(request) {
+		params.Set(k, v)
+	}
 
-		// leave the query string intact (req.URL.RawQuery)
+	request.Header.Del(HEADER_TRIGGER_NAME)
+	request.Header.Del(HEADER_ROUTE_PARAMS)
+	request.Header.Del(HEADER_SHADOW)
+	request.Header.Del(HEADER_FALLBACK)
+	removeAuthHeaders(request)
+	request.Header.Set(HEADER_ORIGINAL_PATH, originalPath)
+	if len(fh.triggerName) > 0 {
+		request.Header.Set(HEADER_TRIGGER_NAME, fh.triggerName)
+	}
+	if len(params) > 0 {
+		request.Header.Set(HEADER_ROUTE_PARAMS, params.Encode())
+	}
+}
 
-		if _, ok := req.Header["User-Agent"]; !ok {
-			// explicitly disable User-Agent so it's not set to default value
-			req.Header.Set("User-Agent", "")
+func (fh *functionHandler) handler(responseWriter http.ResponseWriter, request *http.Request) {
+	// CORS headers go on errors from the router too, so that
+	// browser clients can read them.
+	if fh.cors != nil {
+		request = fh.cors.apply(responseWriter, request)
+	}
+	// Reject requests over the limit before they can cause a cold
+	// start.
+	if fh.rateLimiter != nil && !fh.rateLimiter.allow(responseWriter, request) {
+		return
+	}
+	fh.setInvocationHeaders(request)
+	if fh.auth != nil && !fh.auth.authenticate(responseWriter, request) {
+		return
+	}
+	if fh.invoker != nil && strings.ToLower(request.Header.Get(HEADER_INVOKE)) == INVOKE_ASYNC {
+		fh.invoker.invoke(fh, responseWriter, request)
+		return
+	}
+	// Responses from the cache aren't compared with the shadow's.
+	// The shadow gets each request once, and is compared with the
+	// function's last attempt, not the fallback.
+	serve := fh.serve
+	if fh.retry != nil {
+		serve = func(responseWriter http.ResponseWriter, request *http.Request) {
+			fh.retry.serve(responseWriter, request, fh.serve)
 		}
 	}
+	if fh.shadow != nil {
+		next := serve
+		serve = func(responseWriter http.ResponseWriter, request *http.Request) {
+			fh.shadow.serve(responseWriter, request, next)
+		}
+	}
+	if fh.fallback != nil {
+		next := serve
+		serve = func(responseWriter http.ResponseWriter, request *http.Request) {
+			fh.fallback.serve(responseWriter, request, next)
+		}
+	}
+	if fh.cache != nil {
+		fh.cache.serve(responseWriter, request, serve)
+		return
+	}
+	serve(responseWriter, request)
+}
+
+// asyncHandler runs every request in the background.
+func (fh *functionHandler) asyncHandler(responseWriter http.ResponseWriter, request *http.Request) {
+	fh.setInvocationHeaders(request)
+	fh.invoker.invoke(fh, responseWriter, request)
+}
+
+// serve proxies a request to the function.
+// The request's trace context is passed on to the function, and
+// the router's spans for it are children of the caller's, if any.
+func (fh *functionHandler) serve(responseWriter http.ResponseWriter, request *http.Request) {
+	reqStartTime := time.Now()
 
-	// Initial requests to new k8s services sometimes seem to
-	// fail, but retries work.  So use a transport that does retries.
-	proxy := &httputil.ReverseProxy{
-		Director: director,
-		Transport: RetryingRoundTripper{
-			maxRetries:    10,
-			initalTimeout: 50 * time.Millisecond,
-		},
+	span, ctx := tracing.StartSpan(tracing.Extract(request.Context(), request.Header), "router.serve")
+	defer span.Finish()
+	span.SetAttribute("function", fh.Function.Name)
+	span.SetAttribute("functionUid", fh.Function.Uid)
+	span.SetAttribute("trigger", fh.triggerName)
+	span.SetAttribute("http.method", request.Method)
+	request = request.WithContext(ctx)
+
+	instances, cached, err := fh.resolveService(request.Context())
+	if err != nil {
+		span.SetError(err)
+		fh.serviceError(responseWriter, err)
+		return
 	}
+	serviceUrl, release := fh.pickInstance(instances, cached)
+	defer release()
+
 	delay := time.Now().Sub(reqStartTime)
 	if delay > 100*time.Millisecond {
 		log.Printf("Request delay for %v: %v", serviceUrl, delay)
 	}
-	proxy.ServeHTTP(responseWriter, request)
+
+	// Bound the function's execution time.  This doesn't include
+	// the time taken above to get a service for the function.
+	if fh.timeout > 0 {
+		ctx, cancel := context.WithTimeout(request.Context(), fh.timeout)
+		defer cancel()
+		request = request.WithContext(ctx)
+	}
+
+	// Proxy off our request to the serviceUrl, and send the
+	// response back.  A cached service may be gone (e.g. its pod
+	// was reaped), so don't wait long to connect to it.
+	attempt := &proxyAttempt{cached: cached}
+	fh.proxy(responseWriter, attempt.withRequest(request), serviceUrl, instances)
+	if !attempt.connectFailed {
+		return
+	}
+
+	// Forget the stale instance, and use another one or get a
+	// fresh one from poolmgr.
+	log.Printf("Cannot connect to cached service %v for %v, evicting it", serviceUrl, fh.Function)
+	fh.fmap.remove(&fh.Function, serviceUrl)
+	if !isReplayable(request) {
+		http.Error(responseWriter, "Bad gateway (fission)", http.StatusBadGateway)
+		return
+	}
+	release()
+	instances, _, err = fh.resolveService(request.Context())
+	if err != nil {
+		span.SetError(err)
+		fh.serviceError(responseWriter, err)
+		return
+	}
+	serviceUrl, release = fh.pickInstance(instances, false)
+	defer release()
+	log.Printf("Replaying request for %v to %v", fh.Function, serviceUrl)
+	fh.proxy(responseWriter, request, serviceUrl, instances)
+}
+
+// proxy sends a request to an instance of the function, in a span
+// that the function's own spans become children of.
+func (fh *functionHandler) proxy(responseWriter http.ResponseWriter, request *http.Request, serviceUrl *url.URL, instances []*url.URL) {
+	span, ctx := tracing.StartSpan(request.Context(), "router.proxy")
+	defer span.Finish()
+	span.SetAttribute("instance", serviceUrl.Host)
+
+	request = request.WithContext(ctx)
+	tracing.Inject(ctx, request.Header)
+	fh.getProxy(serviceUrl, instances).ServeHTTP(responseWriter, request)
+}
+
+// isReplayable returns true if a request can safely be sent again:
+// its method is idempotent and it has no body (which the first
+// attempt may have consumed).
+func isReplayable(request *http.Request) bool {
+	return isIdempotent(request.Method) && request.ContentLength == 0
+}
+
+// proxyErrorHandler reports errors proxying to the function.
+// Timeouts get a 504 and the X-Fission-Error header, so clients can
+// tell them apart from errors returned by the function itself.
+func (fh *functionHandler) proxyErrorHandler(responseWriter http.ResponseWriter, request *http.Request, err error) {
+	// Let the handler retry with a fresh service
+	tracing.FromContext(request.Context()).SetError(err)
+	attempt := getProxyAttempt(request.Context())
+	if attempt != nil && attempt.cached && isConnectError(err) {
+		attempt.connectFailed = true
+		return
+	}
+	if request.Context().Err() == context.DeadlineExceeded {
+		log.Printf("Function %v timed out after %v", fh.Function, fh.timeout)
+		responseWriter.Header().Set("X-Fission-Error", "timeout")
+		http.Error(responseWriter,
+			fmt.Sprintf("Function timed out after %v (fission)", fh.timeout),
+			http.StatusGatewayTimeout)
+		return
+	}
+	log.Printf("Error proxying request for %v: %v", fh.Function, err)
+	http.Error(responseWriter, "Bad gateway (fission)", http.StatusBadGateway)
 }
@@ -22,8 +22,12 @@ import (
 	"net/http/httptest"
 	"net/url"
 	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
 
 	"github.com/fission/fission"
+	"github.com/fission/fission/tracing"
 )
 
 func createBackendService(testResponseString string) *url.URL {
@@ -51,7 +55,7 @@ func TestFunctionProxying(t *testing.T) {
 
 	fn := &fission.Metadata{Name: "foo", Uid: "xxx"}
 	fmap := makeFunctionServiceMap(0)
-	fmap.assign(fn, backendURL)
+	fmap.assign(fn, []*url.URL{backendURL})
 
 	fh := &functionHandler{fmap: fmap, Function: *fn}
 	functionHandlerServer := httptest.NewServer(http.HandlerFunc(fh.handler))
@@ -59,3 +63,139 @@ func TestFunctionProxying(t *testing.T) {
 
 	testRequest(fhURL, testResponseString)
 }
+
+func TestFunctionTimeout(t *testing.T) {
+	backendServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(500 * time.Millisecond)
+		w.Write([]byte("too late"))
+	}))
+	defer backendServer.Close()
+	backendURL, err := url.Parse(backendServer.URL)
+	if err != nil {
+		t.Fatalf("error parsing url: %v", err)
+	}
+
+	fn := &fission.Metadata{Name: "slow", Uid: "xxx"}
+	fmap := makeFunctionServiceMap(0)
+	fmap.assign(fn, []*url.URL{backendURL})
+
+	fh := &functionHandler{fmap: fmap, Function: *fn, timeout: 50 * time.Millisecond}
+	functionHandlerServer := httptest.NewServer(http.HandlerFunc(fh.handler))
+	defer functionHandlerServer.Close()
+
+	resp, err := http.Get(functionHandlerServer.URL)
+	if err != nil {
+		t.Fatalf("failed to make get request: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusGatewayTimeout {
+		t.Errorf("expected status %v, got %v", http.StatusGatewayTimeout, resp.StatusCode)
+	}
+	if resp.Header.Get("X-Fission-Error") != "timeout" {
+		t.Errorf("expected timeout error header, got '%v'", resp.Header.Get("X-Fission-Error"))
+	}
+}
+
+func TestFunctionRequestHeaders(t *testing.T) {
+	headers := make(chan http.Header, 1)
+	backendServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			t.Errorf("expected path to be rewritten to /, got %v", r.URL.Path)
+		}
+		headers <- r.Header
+	}))
+	defer backendServer.Close()
+	backendURL, err := url.Parse(backendServer.URL)
+	if err != nil {
+		t.Fatalf("error parsing url: %v", err)
+	}
+
+	fn := &fission.Metadata{Name: "users", Uid: "xxx"}
+	fmap := makeFunctionServiceMap(0)
+	fmap.assign(fn, []*url.URL{backendURL})
+
+	fh := &functionHandler{fmap: fmap, Function: *fn, triggerName: "users-trigger"}
+	muxRouter := mux.NewRouter()
+	muxRouter.HandleFunc("/users/{id}/{item}", fh.handler)
+	functionHandlerServer := httptest.NewServer(muxRouter)
+	defer functionHandlerServer.Close()
+
+	req, err := http.NewRequest("GET", functionHandlerServer.URL+"/users/42/a%20b", nil)
+	if err != nil {
+		t.Fatalf("failed to create request: %v", err)
+	}
+	// spoofed headers must be replaced
+	req.Header.Set(HEADER_TRIGGER_NAME, "other")
+	req.Header.Set(HEADER_ROUTE_PARAMS, "id=1")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("failed to make get request: %v", err)
+	}
+	resp.Body.Close()
+
+	h := <-headers
+	if h.Get(HEADER_ORIGINAL_PATH) != "/users/42/a b" {
+		t.Errorf("bad original path '%v'", h.Get(HEADER_ORIGINAL_PATH))
+	}
+	if h.Get(HEADER_TRIGGER_NAME) != "users-trigger" {
+		t.Errorf("bad trigger name '%v'", h.Get(HEADER_TRIGGER_NAME))
+	}
+	params, err := url.ParseQuery(h.Get(HEADER_ROUTE_PARAMS))
+	if err != nil {
+		t.Fatalf("failed to parse route params: %v", err)
+	}
+	if params.Get("id") != "42" || params.Get("item") != "a b" {
+		t.Errorf("bad route params '%v'", h.Get(HEADER_ROUTE_PARAMS))
+	}
+}
+
+func TestFunctionTracePropagation(t *testing.T) {
+	traceparents := make(chan string, 1)
+	backendServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		traceparents <- r.Header.Get(tracing.HEADER_TRACEPARENT)
+	}))
+	defer backendServer.Close()
+	backendURL, err := url.Parse(backendServer.URL)
+	if err != nil {
+		t.Fatalf("error parsing url: %v", err)
+	}
+
+	fn := &fission.Metadata{Name: "foo", Uid: "xxx"}
+	fmap := makeFunctionServiceMap(0)
+	fmap.assign(fn, []*url.URL{backendURL})
+	fh := &functionHandler{fmap: fmap, Function: *fn}
+	functionHandlerServer := httptest.NewServer(http.HandlerFunc(fh.handler))
+	defer functionHandlerServer.Close()
+
+	// the function's request continues the caller's trace
+	incoming := "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	req, err := http.NewRequest("GET", functionHandlerServer.URL, nil)
+	if err != nil {
+		t.Fatalf("failed to create request: %v", err)
+	}
+	req.Header.Set(tracing.HEADER_TRACEPARENT, incoming)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("failed to make get request: %v", err)
+	}
+	resp.Body.Close()
+
+	sc, err := tracing.ParseTraceparent(<-traceparents)
+	if err != nil {
+		t.Fatalf("function got bad traceparent: %v", err)
+	}
+	if sc.TraceId != "4bf92f3577b34da6a3ce929d0e0e4736" || sc.SpanId == "00f067aa0ba902b7" || !sc.Sampled {
+		t.Errorf("function's traceparent isn't a child of the caller's: %v", sc.Traceparent())
+	}
+
+	// untraced requests start a trace
+	resp, err = http.Get(functionHandlerServer.URL)
+	if err != nil {
+		t.Fatalf("failed to make get request: %v", err)
+	}
+	resp.Body.Close()
+	_, err = tracing.ParseTraceparent(<-traceparents)
+	if err != nil {
+		t.Errorf("function got bad traceparent: %v", err)
+	}
+}
@@ -0,0 +1,268 @@
+/*
+Copyright 2016 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package router
+
+import (
+	"context"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"time"
+
+	"github.com/fission/fission/tracing"
+)
+
+const (
+	// Connection attempts to a function service before giving up;
+	// the last one uses DIAL_TIMEOUT.
+	DIAL_MAX_RETRIES = 10
+	// Timeout of the first connection attempt, doubled on each retry.
+	DIAL_INITIAL_TIMEOUT = 50 * time.Millisecond
+	DIAL_TIMEOUT         = 30 * time.Second
+	// Timeout of the only connection attempt to a cached service;
+	// if it fails, the service is presumed gone.
+	CACHED_DIAL_TIMEOUT = 1 * time.Second
+
+	// Idle keep-alive connections kept open to each function service.
+	MAX_IDLE_CONNS_PER_SERVICE = 100
+	IDLE_CONN_TIMEOUT          = 90 * time.Second
+
+	// How often parts of a streamed response (e.g. server-sent
+	// events) are flushed to the client.
+	STREAM_FLUSH_INTERVAL = 50 * time.Millisecond
+)
+
+// functionProxy proxies requests to one function service.  It owns a
+// transport, so connections to the service are pooled and reused
+// across requests.
+type functionProxy struct {
+	serviceUrl   *url.URL
+	transport    *http.Transport
+	proxy        *httputil.ReverseProxy
+	errorHandler func(http.ResponseWriter, *http.Request, error)
+}
+
+func makeFunctionProxy(serviceUrl *url.URL, errorHandler func(http.ResponseWriter, *http.Request, error)) *functionProxy {
+	fp := &functionProxy{
+		serviceUrl:   serviceUrl,
+		transport:    makeFunctionTransport(),
+		errorHandler: errorHandler,
+	}
+	fp.proxy = &httputil.ReverseProxy{
+		Director:       fp.director,
+		Transport:      proxyErrorTransport{fp.transport},
+		ModifyResponse: modifyResponse,
+		FlushInterval:  STREAM_FLUSH_INTERVAL,
+	}
+	return fp
+}
+
+// modifyResponse adds the function's response status to the request's
+// span, drops its CORS headers if the router set them, and caches it
+// if the trigger's cache policy allows.
+func modifyResponse(resp *http.Response) error {
+	tracing.FromContext(resp.Request.Context()).SetAttribute("http.status_code", resp.StatusCode)
+	removeFunctionCORSHeaders(resp)
+	fillCache(resp)
+	return nil
+}
+
+func makeFunctionTransport() *http.Transport {
+	return &http.Transport{
+		DialContext:           retryingDial,
+		MaxIdleConns:          MAX_IDLE_CONNS_PER_SERVICE,
+		MaxIdleConnsPerHost:   MAX_IDLE_CONNS_PER_SERVICE,
+		IdleConnTimeout:       IDLE_CONN_TIMEOUT,
+		ExpectContinueTimeout: 1 * time.Second,
+	}
+}
+
+// proxyAttempt tracks one attempt to proxy a request, through the
+// request's context.
+type proxyAttempt struct {
+	cached        bool  // the service came from the router's cache
+	connectFailed bool  // couldn't connect to the cached service
+	err           error // error sending the request, if any
+}
+
+type proxyAttemptKey struct{}
+
+func (pa *proxyAttempt) withRequest(request *http.Request) *http.Request {
+	return request.WithContext(context.WithValue(request.Context(), proxyAttemptKey{}, pa))
+}
+
+func getProxyAttempt(ctx context.Context) *proxyAttempt {
+	pa, _ := ctx.Value(proxyAttemptKey{}).(*proxyAttempt)
+	return pa
+}
+
+// ReverseProxy only responds with a bare 502 when it can't reach the
+// function (it has no error handler before Go 1.11).  So the
+// transport records the error in the request's proxy attempt, and
+// proxyErrorWriter replaces the 502 with the function handler's
+// response to the error.
+type (
+	proxyErrorTransport struct {
+		http.RoundTripper
+	}
+
+	proxyErrorWriter struct {
+		http.ResponseWriter
+		request      *http.Request
+		attempt      *proxyAttempt
+		errorHandler func(http.ResponseWriter, *http.Request, error)
+		reported     bool // the error handler responded
+	}
+)
+
+func (t proxyErrorTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.RoundTripper.RoundTrip(req)
+	if err != nil {
+		if pa := getProxyAttempt(req.Context()); pa != nil {
+			pa.err = err
+		}
+	}
+	return resp, err
+}
+
+func (w *proxyErrorWriter) WriteHeader(statusCode int) {
+	if statusCode == http.StatusBadGateway && w.attempt.err != nil && !w.reported {
+		w.reported = true
+		w.errorHandler(w.ResponseWriter, w.request, w.attempt.err)
+		return
+	}
+	w.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (w *proxyErrorWriter) Write(b []byte) (int, error) {
+	if w.reported {
+		return len(b), nil
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// Flush passes flushes through, for streamed responses.
+func (w *proxyErrorWriter) Flush() {
+	if w.reported {
+		return
+	}
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// isConnectError returns true if err is a failure to connect, as
+// opposed to a failure after the request was sent.
+func isConnectError(err error) bool {
+	opErr, ok := err.(*net.OpError)
+	return ok && opErr.Op == "dial"
+}
+
+// retryingDial connects to a function service, retrying with
+// exponential backoff.  Initial connections to new k8s services
+// sometimes fail, but retries work.  Only connection establishment is
+// retried, since a request that was sent may have had side effects.
+//
+// Services from the router's cache are tried just once, since they
+// have been reachable before; the caller gets a fresh service
+// instead of retrying.
+func retryingDial(ctx context.Context, network, addr string) (net.Conn, error) {
+	if pa := getProxyAttempt(ctx); pa != nil && pa.cached {
+		dialer := &net.Dialer{
+			Timeout:   CACHED_DIAL_TIMEOUT,
+			KeepAlive: 30 * time.Second,
+		}
+		return dialer.DialContext(ctx, network, addr)
+	}
+
+	timeout := DIAL_INITIAL_TIMEOUT
+	for i := DIAL_MAX_RETRIES - 1; i > 0; i-- {
+		dialer := &net.Dialer{
+			Timeout:   timeout,
+			KeepAlive: 30 * time.Second,
+		}
+		conn, err := dialer.DialContext(ctx, network, addr)
+		if err == nil {
+			return conn, nil
+		}
+
+		timeout *= time.Duration(2)
+		log.Printf("Retrying connection to %v in %v: %v", addr, timeout, err)
+		select {
+		case <-time.After(timeout):
+		case <-ctx.Done():
+			// Don't retry if the request was cancelled or timed out
+			return nil, err
+		}
+	}
+
+	// finally, one more try with the default timeout
+	dialer := &net.Dialer{
+		Timeout:   DIAL_TIMEOUT,
+		KeepAlive: 30 * time.Second,
+	}
+	return dialer.DialContext(ctx, network, addr)
+}
+
+func (fp *functionProxy) director(req *http.Request) {
+	log.Printf("Proxying request for %v to %v", req.URL, fp.serviceUrl.Host)
+
+	// send this request to the function service
+	req.URL.Scheme = fp.serviceUrl.Scheme
+	req.URL.Host = fp.serviceUrl.Host
+
+	// To keep the function run container simple, it
+	// doesn't do any routing.  In the future if we have
+	// multiple functions per container, we could use the
+	// function metadata here.
+	req.URL.Path = "/"
+	req.URL.RawPath = ""
+
+	// leave the query string intact (req.URL.RawQuery)
+
+	if _, ok := req.Header["User-Agent"]; !ok {
+		// explicitly disable User-Agent so it's not set to default value
+		req.Header.Set("User-Agent", "")
+	}
+}
+
+func (fp *functionProxy) ServeHTTP(responseWriter http.ResponseWriter, request *http.Request) {
+	if isUpgrade(request) {
+		fp.serveUpgrade(responseWriter, request)
+		return
+	}
+	attempt := getProxyAttempt(request.Context())
+	if attempt == nil {
+		attempt = &proxyAttempt{}
+		request = attempt.withRequest(request)
+	}
+	attempt.err = nil
+	fp.proxy.ServeHTTP(&proxyErrorWriter{
+		ResponseWriter: responseWriter,
+		request:        request,
+		attempt:        attempt,
+		errorHandler:   fp.errorHandler,
+	}, request)
+}
+
+// close releases the pooled connections of a proxy that is no longer
+// used.  Requests in flight are unaffected.
+func (fp *functionProxy) close() {
+	fp.transport.CloseIdleConnections()
+}
@@ -0,0 +1,217 @@
+/*
+Copyright 2016 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package router
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/fission/fission"
+	poolmgrClient "github.com/fission/fission/poolmgr/client"
+)
+
+// countingBackend is a function service that counts the connections
+// made to it.
+func countingBackend(conns *int32) (*httptest.Server, *url.URL) {
+	backend := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("hi"))
+	}))
+	backend.Config.ConnState = func(c net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			atomic.AddInt32(conns, 1)
+		}
+	}
+	backend.Start()
+	backendURL, err := url.Parse(backend.URL)
+	if err != nil {
+		panic("error parsing url")
+	}
+	return backend, backendURL
+}
+
+func makeTestHandler(backendURL *url.URL) *functionHandler {
+	fn := &fission.Metadata{Name: "foo", Uid: "xxx"}
+	fmap := makeFunctionServiceMap(0)
+	fmap.assign(fn, []*url.URL{backendURL})
+	return &functionHandler{fmap: fmap, Function: *fn}
+}
+
+func TestProxyConnectionReuse(t *testing.T) {
+	var conns int32
+	backend, backendURL := countingBackend(&conns)
+	defer backend.Close()
+
+	fh := makeTestHandler(backendURL)
+	for i := 0; i < 10; i++ {
+		w := httptest.NewRecorder()
+		fh.handler(w, httptest.NewRequest("GET", "http://router/", nil))
+		if w.Code != 200 || w.Body.String() != "hi" {
+			t.Fatalf("unexpected response %v %q", w.Code, w.Body.String())
+		}
+	}
+	if conns != 1 {
+		t.Errorf("expected requests to share one connection, got %v", conns)
+	}
+
+	// A new service for the function gets a new proxy
+	proxy := fh.proxies[backendURL.Host]
+	var conns2 int32
+	backend2, backendURL2 := countingBackend(&conns2)
+	defer backend2.Close()
+	if fh.getProxy(backendURL, []*url.URL{backendURL}) != proxy {
+		t.Errorf("expected cached proxy for unchanged service")
+	}
+	if fh.getProxy(backendURL2, []*url.URL{backendURL2}) == proxy {
+		t.Errorf("expected new proxy for new service")
+	}
+	if len(fh.proxies) != 1 {
+		t.Errorf("expected proxy of removed service to be dropped, have %v", len(fh.proxies))
+	}
+}
+
+func benchmarkHandler(b *testing.B, handler http.HandlerFunc) {
+	log.SetOutput(ioutil.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	server := httptest.NewServer(handler)
+	defer server.Close()
+	client := &http.Client{}
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			resp, err := client.Get(server.URL)
+			if err != nil {
+				b.Fatalf("request failed: %v", err)
+			}
+			ioutil.ReadAll(resp.Body)
+			resp.Body.Close()
+		}
+	})
+}
+
+// BenchmarkCachedProxy measures requests through a function handler,
+// which reuses its proxy and connections.
+func BenchmarkCachedProxy(b *testing.B) {
+	var conns int32
+	backend, backendURL := countingBackend(&conns)
+	defer backend.Close()
+
+	fh := makeTestHandler(backendURL)
+	benchmarkHandler(b, fh.handler)
+	b.Logf("%v requests, %v backend connections", b.N, conns)
+}
+
+// BenchmarkUncachedProxy measures building a proxy and transport for
+// every request, as the router used to.
+func BenchmarkUncachedProxy(b *testing.B) {
+	var conns int32
+	backend, backendURL := countingBackend(&conns)
+	defer backend.Close()
+
+	benchmarkHandler(b, func(w http.ResponseWriter, r *http.Request) {
+		proxy := httputil.NewSingleHostReverseProxy(backendURL)
+		transport := makeFunctionTransport()
+		proxy.Transport = transport
+		proxy.ServeHTTP(w, r)
+		transport.CloseIdleConnections()
+	})
+	b.Logf("%v requests, %v backend connections", b.N, conns)
+}
+
+// fakePoolmgr returns a poolmgr client that always hands out
+// serviceURL.
+func fakePoolmgr(serviceURL *url.URL) (*httptest.Server, *poolmgrClient.Client) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fs := fission.FunctionServices{Addresses: []string{serviceURL.Host}}
+		json.NewEncoder(w).Encode(fs)
+	}))
+	return server, poolmgrClient.MakeClient(server.URL)
+}
+
+func TestStaleServiceEviction(t *testing.T) {
+	// a cached service whose pod is gone
+	dead := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	deadURL, _ := url.Parse(dead.URL)
+	dead.Close()
+
+	var conns int32
+	backend, backendURL := countingBackend(&conns)
+	defer backend.Close()
+	pm, poolmgr := fakePoolmgr(backendURL)
+	defer pm.Close()
+
+	fh := makeTestHandler(deadURL)
+	fh.poolmgr = poolmgr
+
+	// idempotent requests are replayed to a fresh service
+	w := httptest.NewRecorder()
+	fh.handler(w, httptest.NewRequest("GET", "http://router/", nil))
+	if w.Code != 200 || w.Body.String() != "hi" {
+		t.Errorf("expected replayed request to succeed, got %v %q", w.Code, w.Body.String())
+	}
+	u, err := fh.fmap.lookup(&fh.Function)
+	if err != nil || len(u) != 1 || *u[0] != *backendURL {
+		t.Errorf("expected fresh service %v in cache, got %v (%v)", backendURL, u, err)
+	}
+
+	// other requests fail, but the stale service is evicted
+	fh.fmap.assign(&fh.Function, []*url.URL{deadURL})
+	w = httptest.NewRecorder()
+	fh.handler(w, httptest.NewRequest("POST", "http://router/", strings.NewReader("body")))
+	if w.Code != http.StatusBadGateway {
+		t.Errorf("expected %v for non-idempotent request, got %v", http.StatusBadGateway, w.Code)
+	}
+	_, err = fh.fmap.lookup(&fh.Function)
+	if err == nil {
+		t.Errorf("expected stale service to be evicted")
+	}
+}
+
+func TestServiceEvictions(t *testing.T) {
+	fmap := makeFunctionServiceMap(0)
+	u1, _ := url.Parse("http://10.0.0.1:8888")
+	u2, _ := url.Parse("http://10.0.0.2:8888")
+	fmap.assign(&fission.Metadata{Name: "a", Uid: "1"}, []*url.URL{u1})
+	fmap.assign(&fission.Metadata{Name: "b", Uid: "1"}, []*url.URL{u1, u2})
+
+	seq := applyEvictions(fmap, &fission.ServiceEvictions{Seq: 3, Addresses: []string{"10.0.0.1:8888"}})
+	if seq != 3 {
+		t.Errorf("expected seq 3, got %v", seq)
+	}
+	if _, err := fmap.lookup(&fission.Metadata{Name: "a", Uid: "1"}); err == nil {
+		t.Errorf("expected evicted service to be gone")
+	}
+	if urls, err := fmap.lookup(&fission.Metadata{Name: "b", Uid: "1"}); err != nil || len(urls) != 1 || *urls[0] != *u2 {
+		t.Errorf("expected other instance to be kept: %v (%v)", urls, err)
+	}
+
+	applyEvictions(fmap, &fission.ServiceEvictions{Seq: 0, Reset: true})
+	if len(fmap.cache.Copy()) != 0 {
+		t.Errorf("expected reset to forget all services")
+	}
+}
@@ -19,6 +19,7 @@ package router
 import (
 	"log"
 	"net/url"
+	"sync"
 	"time"
 
 	"github.com/fission/fission"
@@ -26,7 +27,8 @@ import (
 )
 
 type functionServiceMap struct {
-	cache *cache.Cache // map[fission.Metadata]*url.URL
+	cache *cache.Cache // map[fission.Metadata][]*url.URL
+	lock  sync.Mutex   // serializes changes, so none are lost
 }
 
 func makeFunctionServiceMap(expiry time.Duration) *functionServiceMap {
@@ -35,19 +37,83 @@ func makeFunctionServiceMap(expiry time.Duration) *functionServiceMap {
 	}
 }
 
-func (fmap *functionServiceMap) lookup(f *fission.Metadata) (*url.URL, error) {
+// lookup returns the instances of a function.
+func (fmap *functionServiceMap) lookup(f *fission.Metadata) ([]*url.URL, error) {
 	item, err := fmap.cache.Get(*f)
 	if err != nil {
 		return nil, err
 	}
-	u := item.(*url.URL)
+	u := item.([]*url.URL)
 	return u, nil
 }
 
-func (fmap *functionServiceMap) assign(f *fission.Metadata, serviceUrl *url.URL) {
-	err, _ := fmap.cache.Set(*f, serviceUrl)
+// assign sets the instances of a function, replacing any it had.
+func (fmap *functionServiceMap) assign(f *fission.Metadata, serviceUrls []*url.URL) {
+	fmap.lock.Lock()
+	defer fmap.lock.Unlock()
+	fmap.set(f, serviceUrls)
+}
+
+// set replaces the instances of a function.  Callers hold fmap.lock.
+func (fmap *functionServiceMap) set(f *fission.Metadata, serviceUrls []*url.URL) {
+	fmap.cache.Delete(*f)
+	err, _ := fmap.cache.Set(*f, serviceUrls)
 	if err != nil {
 		log.Printf("error caching service url for function: %v", err)
 		// ignore error
 	}
 }
+
+// remove forgets one instance of a function.
+func (fmap *functionServiceMap) remove(f *fission.Metadata, serviceUrl *url.URL) {
+	fmap.lock.Lock()
+	defer fmap.lock.Unlock()
+
+	urls, err := fmap.lookup(f)
+	if err != nil {
+		return
+	}
+	remaining := make([]*url.URL, 0, len(urls))
+	for _, u := range urls {
+		if *u != *serviceUrl {
+			remaining = append(remaining, u)
+		}
+	}
+	if len(remaining) == len(urls) {
+		return
+	}
+	if len(remaining) == 0 {
+		fmap.cache.Delete(*f)
+		return
+	}
+	fmap.set(f, remaining)
+}
+
+// evict forgets the services at the given addresses (host:port), and
+// returns the number of instances removed.
+func (fmap *functionServiceMap) evict(addresses []string) int {
+	evicted := make(map[string]bool)
+	for _, a := range addresses {
+		evicted[a] = true
+	}
+	count := 0
+	for fI, urlsI := range fmap.cache.Copy() {
+		f := fI.(fission.Metadata)
+		for _, u := range urlsI.([]*url.URL) {
+			if evicted[u.Host] {
+				fmap.remove(&f, u)
+				count++
+			}
+		}
+	}
+	return count
+}
+
+// reset forgets all services.
+func (fmap *functionServiceMap) reset() {
+	fmap.lock.Lock()
+	defer fmap.lock.Unlock()
+	for f := range fmap.cache.Copy() {
+		fmap.cache.Delete(f)
+	}
+}
@@ -18,6 +18,7 @@ package router
 
 import (
 	"net/url"
+	"sync"
 	"testing"
 
 	"github.com/fission/fission"
@@ -31,19 +32,64 @@ func TestFunctionServiceMap(t *testing.T) {
 		t.Errorf("can't parse url")
 	}
 
-	m.assign(fn, u)
+	u2, err := url.Parse("/foo012-2")
+	if err != nil {
+		t.Errorf("can't parse url")
+	}
+
+	m.assign(fn, []*url.URL{u, u2})
 
 	v, err := m.lookup(fn)
 	if err != nil {
 		t.Errorf("Lookup error: %v", err)
 	}
-	if *v != *u {
+	if len(v) != 2 || *v[0] != *u {
 		t.Errorf("Expected %#v, got %#v", u, v)
 	}
 
+	m.remove(fn, u)
+	v, err = m.lookup(fn)
+	if err != nil || len(v) != 1 || *v[0] != *u2 {
+		t.Errorf("Expected only %#v after remove, got %#v (%v)", u2, v, err)
+	}
+	m.remove(fn, u2)
+	_, err = m.lookup(fn)
+	if err == nil {
+		t.Errorf("Expected no entry after removing all instances")
+	}
+
 	fn.Name = "bar"
 	_, err2 := m.lookup(fn)
 	if err2 == nil {
 		t.Errorf("No error on missing entry")
 	}
 }
+
+func TestFunctionServiceMapConcurrentRemove(t *testing.T) {
+	m := makeFunctionServiceMap(0)
+	fn := &fission.Metadata{Name: "foo", Uid: "012"}
+	u1, _ := url.Parse("http://1")
+	u2, _ := url.Parse("http://2")
+	u3, _ := url.Parse("http://3")
+
+	// Removing an instance mustn't undo a concurrent assign.
+	for i := 0; i < 1000; i++ {
+		m.assign(fn, []*url.URL{u1, u2})
+		var wg sync.WaitGroup
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			m.remove(fn, u1)
+		}()
+		go func() {
+			defer wg.Done()
+			m.assign(fn, []*url.URL{u3})
+		}()
+		wg.Wait()
+
+		v, err := m.lookup(fn)
+		if err != nil || len(v) != 1 || *v[0] != *u3 {
+			t.Fatalf("Expected only %#v, got %#v (%v)", u3, v, err)
+		}
+	}
+}
@@ -19,6 +19,10 @@ package router
 import (
 	"log"
 	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/gorilla/mux"
@@ -28,59 +32,375 @@ import (
 	poolmgrClient "github.com/fission/fission/poolmgr/client"
 )
 
-type HTTPTriggerSet struct {
-	*functionServiceMap
-	*mutableRouter
-	controller *controllerClient.Client
-	poolmgr    *poolmgrClient.Client
-	triggers   []fission.HTTPTrigger
-	functions  []fission.Function
-}
+type (
+	HTTPTriggerSet struct {
+		*functionServiceMap
+		*mutableRouter
+		controller *controllerClient.Client
+		poolmgr    *poolmgrClient.Client
+		coldStarts *coldStartQueue
+		balancer   *loadBalancer
+		invoker    *asyncInvoker  // nil if async invocation is disabled
+		cache      *responseCache // nil if response caching is disabled
+		certs      *certStore     // nil if TLS is disabled
+		apiKeys    *apiKeySet
+		jwks       *jwksCache
+		triggers   []fission.HTTPTrigger
+		functions  map[string]functionRoute // by function name
+
+		// Older function versions that triggers are pinned to; a
+		// version never changes, so each is only fetched once.
+		// Only used by the trigger watcher.
+		pinnedVersions map[fission.Metadata]*fission.Function
+
+		// Handlers of the current routes, by route key; reused
+		// across updates for routes that didn't change.
+		handlers map[string]*functionHandler
+		routes   atomic.Value // *routeTable of the current router
+	}
+
+	// functionRoute is what the router needs to know about the
+	// latest version of a function.
+	functionRoute struct {
+		uid     string
+		timeout time.Duration // max execution time; zero means no limit
+		// Timeouts of older versions that triggers are pinned
+		// to, by uid
+		pinnedTimeouts map[string]time.Duration
+	}
+)
 
-func makeHTTPTriggerSet(fmap *functionServiceMap, controller *controllerClient.Client, poolmgr *poolmgrClient.Client) *HTTPTriggerSet {
+func makeHTTPTriggerSet(fmap *functionServiceMap, controller *controllerClient.Client, poolmgr *poolmgrClient.Client, coldStarts *coldStartQueue, invoker *asyncInvoker, cache *responseCache) *HTTPTriggerSet {
 	triggers := make([]fission.HTTPTrigger, 1)
 	return &HTTPTriggerSet{
 		functionServiceMap: fmap,
 		triggers:           triggers,
+		functions:          make(map[string]functionRoute),
+		pinnedVersions:     make(map[fission.Metadata]*fission.Function),
+		handlers:           make(map[string]*functionHandler),
 		controller:         controller,
 		poolmgr:            poolmgr,
+		coldStarts:         coldStarts,
+		balancer:           makeLoadBalancer(),
+		invoker:            invoker,
+		cache:              cache,
+		apiKeys:            makeAPIKeySet(),
+		jwks:               makeJWKSCache(),
 	}
 }
 
-func (ts *HTTPTriggerSet) subscribeRouter(mr *mutableRouter) {
+// subscribeRouter sets the router that serves the triggers, and keeps
+// it up to date until stop is closed.
+func (ts *HTTPTriggerSet) subscribeRouter(mr *mutableRouter, stop <-chan struct{}) {
 	ts.mutableRouter = mr
 	mr.updateRouter(ts.getRouter())
-	go ts.watchTriggers()
+	go ts.watchTriggers(stop)
 }
 
 func defaultHomeHandler(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 }
 
+// makeFunctionRoutes returns the latest version and timeout of each
+// function, and the timeouts of the pinned older versions.
+func makeFunctionRoutes(functions []fission.Function, pinned []*fission.Function, environments []fission.Environment) map[string]functionRoute {
+	envs := make(map[string]*fission.Environment)
+	for i := range environments {
+		envs[environments[i].Metadata.Name] = &environments[i]
+	}
+	routes := make(map[string]functionRoute)
+	for i := range functions {
+		f := &functions[i]
+		routes[f.Metadata.Name] = functionRoute{
+			uid:     f.Metadata.Uid,
+			timeout: fission.FunctionTimeout(f, envs[f.Environment.Name]),
+		}
+	}
+	for _, f := range pinned {
+		fr, ok := routes[f.Metadata.Name]
+		if !ok {
+			continue
+		}
+		if fr.pinnedTimeouts == nil {
+			fr.pinnedTimeouts = make(map[string]time.Duration)
+		}
+		fr.pinnedTimeouts[f.Metadata.Uid] = fission.FunctionTimeout(f, envs[f.Environment.Name])
+		routes[f.Metadata.Name] = fr
+	}
+	return routes
+}
+
+// timeoutOf returns the timeout of version uid of the function, or of
+// the latest version if uid is empty or unknown.
+func (fr functionRoute) timeoutOf(uid string) time.Duration {
+	if timeout, ok := fr.pinnedTimeouts[uid]; ok {
+		return timeout
+	}
+	return fr.timeout
+}
+
+// getPinnedVersions returns the older function versions that
+// triggers, their shadows or their fallbacks are pinned to.  Versions
+// that can't be fetched are left out, and use the latest version's
+// timeout.
+func (ts *HTTPTriggerSet) getPinnedVersions(triggers []fission.HTTPTrigger, functions []fission.Function) []*fission.Function {
+	latest := make(map[fission.Metadata]bool)
+	for _, f := range functions {
+		latest[f.Metadata] = true
+	}
+	pinned := make(map[fission.Metadata]*fission.Function)
+	for i := range triggers {
+		t := &triggers[i]
+		versions := []fission.Metadata{t.Function}
+		if t.Shadow != nil {
+			versions = append(versions, t.Shadow.Function)
+		}
+		if t.Fallback != nil && t.Fallback.Function != nil {
+			versions = append(versions, *t.Fallback.Function)
+		}
+		for _, m := range versions {
+			if len(m.Uid) == 0 || latest[m] {
+				continue
+			}
+			f, ok := ts.pinnedVersions[m]
+			if !ok {
+				var err error
+				f, err = ts.controller.FunctionGet(&m)
+				if err != nil {
+					log.Printf("Failed to get function %v pinned by trigger %v: %v", m, t.Metadata.Name, err)
+					continue
+				}
+			}
+			pinned[m] = f
+		}
+	}
+	ts.pinnedVersions = pinned
+
+	versions := make([]*fission.Function, 0, len(pinned))
+	for _, f := range pinned {
+		versions = append(versions, f)
+	}
+	return versions
+}
+
+// getHandler returns the handler for route key, reusing the current
+// one while the function and the trigger's policies are unchanged, so
+// that state such as rate limit counts is kept.  trigger is nil for
+// internal function routes.
+func (ts *HTTPTriggerSet) getHandler(handlers map[string]*functionHandler, key string, trigger *fission.HTTPTrigger, m fission.Metadata, timeout time.Duration) *functionHandler {
+	var triggerName string
+	var rateLimit *fission.RateLimit
+	var auth *fission.AuthPolicy
+	var cors *fission.CORSPolicy
+	var cachePolicy *fission.CachePolicy
+	var shadow *fission.ShadowPolicy
+	var shadowTimeout time.Duration
+	var retry *fission.RetryPolicy
+	var fallback *fission.FallbackPolicy
+	var fallbackTimeout time.Duration
+	var methods []string
+	if trigger != nil {
+		triggerName = trigger.Metadata.Name
+		rateLimit = trigger.RateLimit
+		auth = trigger.Auth
+		cors = trigger.CORS
+		retry = trigger.Retry
+		methods = fission.TriggerMethods(trigger)
+		if ts.cache != nil {
+			cachePolicy = trigger.Cache
+		}
+		shadow, shadowTimeout = ts.resolveShadow(trigger)
+		fallback, fallbackTimeout = ts.resolveFallback(trigger)
+	}
+
+	fh, ok := ts.handlers[key]
+	if !ok || fh.Function != m || fh.timeout != timeout ||
+		!sameRateLimit(fh.rateLimiter, rateLimit) || !sameAuth(fh.auth, auth) ||
+		!sameCORS(fh.cors, cors, methods) || !sameCachePolicy(fh.cache, cachePolicy) ||
+		!sameShadow(fh.shadow, shadow, m, shadowTimeout) || !sameRetryPolicy(fh.retry, retry) ||
+		!sameFallback(fh.fallback, fallback, fallbackTimeout) {
+		if ok && fh.Function != m && fh.cache != nil {
+			// responses of the old function version are stale
+			ts.cache.purge(triggerName)
+		}
+		var mirror *shadowMirror
+		if ok && sameShadow(fh.shadow, shadow, m, shadowTimeout) {
+			mirror = fh.shadow
+		}
+		fh = &functionHandler{
+			fmap:        ts.functionServiceMap,
+			Function:    m,
+			poolmgr:     ts.poolmgr,
+			coldStarts:  ts.coldStarts,
+			balancer:    ts.balancer,
+			invoker:     ts.invoker,
+			triggerName: triggerName,
+			timeout:     timeout,
+		}
+		if rateLimit != nil {
+			fh.rateLimiter = makeRateLimiter(rateLimit)
+		}
+		if auth != nil {
+			fh.auth = makeTriggerAuth(auth, ts.apiKeys, ts.jwks)
+		}
+		if cors != nil {
+			fh.cors = makeCORSPolicy(cors, methods)
+		}
+		if cachePolicy != nil {
+			fh.cache = makeTriggerCache(cachePolicy, triggerName, ts.cache)
+		}
+		if mirror == nil && shadow != nil {
+			mirror = makeShadowMirror(shadow, triggerName, m, &functionHandler{
+				fmap:        ts.functionServiceMap,
+				Function:    shadow.Function,
+				poolmgr:     ts.poolmgr,
+				coldStarts:  ts.coldStarts,
+				balancer:    ts.balancer,
+				triggerName: triggerName,
+				timeout:     shadowTimeout,
+			})
+		}
+		fh.shadow = mirror
+		if retry != nil {
+			fh.retry = makeTriggerRetry(retry, triggerName)
+		}
+		if fallback != nil {
+			var ffh *functionHandler
+			if fallback.Function != nil {
+				ffh = &functionHandler{
+					fmap:        ts.functionServiceMap,
+					Function:    *fallback.Function,
+					poolmgr:     ts.poolmgr,
+					coldStarts:  ts.coldStarts,
+					balancer:    ts.balancer,
+					triggerName: triggerName,
+					timeout:     fallbackTimeout,
+				}
+			}
+			fh.fallback = makeTriggerFallback(fallback, triggerName, ffh)
+		}
+	}
+	handlers[key] = fh
+	return fh
+}
+
+// resolveShadow returns a trigger's shadow policy with the shadow's
+// uid set, and the shadow's timeout.  It returns nil if the trigger
+// has no shadow, or its shadow function doesn't exist.
+func (ts *HTTPTriggerSet) resolveShadow(trigger *fission.HTTPTrigger) (*fission.ShadowPolicy, time.Duration) {
+	if trigger.Shadow == nil {
+		return nil, 0
+	}
+	fr, ok := ts.functions[trigger.Shadow.Function.Name]
+	if !ok {
+		log.Printf("Shadow function %v of trigger %v not found, not mirroring requests",
+			trigger.Shadow.Function.Name, trigger.Metadata.Name)
+		return nil, 0
+	}
+	policy := *trigger.Shadow
+	if len(policy.Function.Uid) == 0 {
+		policy.Function.Uid = fr.uid
+	}
+	return &policy, fr.timeoutOf(policy.Function.Uid)
+}
+
+// resolveFallback returns a trigger's fallback policy with the
+// fallback function's uid set, and the function's timeout.  It
+// returns nil if the trigger has no fallback, or its fallback
+// function doesn't exist.
+func (ts *HTTPTriggerSet) resolveFallback(trigger *fission.HTTPTrigger) (*fission.FallbackPolicy, time.Duration) {
+	if trigger.Fallback == nil {
+		return nil, 0
+	}
+	policy := *trigger.Fallback
+	if policy.Function == nil {
+		return &policy, 0
+	}
+	fr, ok := ts.functions[policy.Function.Name]
+	if !ok {
+		log.Printf("Fallback function %v of trigger %v not found, not falling back",
+			policy.Function.Name, trigger.Metadata.Name)
+		return nil, 0
+	}
+	m := *policy.Function
+	if len(m.Uid) == 0 {
+		m.Uid = fr.uid
+	}
+	policy.Function = &m
+	return &policy, fr.timeoutOf(m.Uid)
+}
+
+func sameRateLimit(rl *rateLimiter, rateLimit *fission.RateLimit) bool {
+	if rl == nil || rateLimit == nil {
+		return rl == nil && rateLimit == nil
+	}
+	return rl.limit == *rateLimit
+}
+
+func sameAuth(ta *triggerAuth, policy *fission.AuthPolicy) bool {
+	if ta == nil || policy == nil {
+		return ta == nil && policy == nil
+	}
+	return reflect.DeepEqual(ta.policy, *policy)
+}
+
 func (ts *HTTPTriggerSet) getRouter() *mux.Router {
 	muxRouter := mux.NewRouter()
-
-	// make a function name -> latest version map
-	latestVersions := make(map[string]string)
-	for _, f := range ts.functions {
-		latestVersions[f.Metadata.Name] = f.Metadata.Uid
+	handlers := make(map[string]*functionHandler)
+	rt := &routeTable{
+		router: muxRouter,
+		routes: make([]fission.RouteInfo, 0, len(ts.triggers)+len(ts.functions)),
+		byName: make(map[string]int),
 	}
 
 	// HTTP triggers setup by the user
 	homeHandled := false
-	for _, trigger := range ts.triggers {
+	cachedTriggers := make(map[string]bool)
+	for _, trigger := range sortTriggers(ts.triggers) {
 		m := trigger.Function
 		if len(m.Uid) == 0 {
 			// explicitly use the latest function version
-			m.Uid = latestVersions[m.Name]
+			m.Uid = ts.functions[m.Name].uid
 		}
-		fh := &functionHandler{
-			fmap:     ts.functionServiceMap,
-			Function: m,
-			poolmgr:  ts.poolmgr,
+		key := "trigger/" + trigger.Metadata.Name
+		fh := ts.getHandler(handlers, key, &trigger, m, ts.functions[m.Name].timeoutOf(m.Uid))
+
+		// Preflights are answered by the router, whatever the
+		// trigger's methods.
+		if fh.cors != nil {
+			triggerRoute(muxRouter, &trigger).Methods(http.MethodOptions).
+				MatcherFunc(fh.cors.matchPreflight).HandlerFunc(fh.cors.preflight)
+		}
+
+		if ts.certs != nil && len(trigger.Host) > 0 && !strings.Contains(trigger.Host, "{") &&
+			!ts.certs.hasCertificate(trigger.Host) {
+			log.Printf("No TLS certificate for host %v of trigger %v; HTTPS clients get the default certificate",
+				trigger.Host, trigger.Metadata.Name)
+		}
+
+		route := triggerRoute(muxRouter, &trigger)
+		methods := fission.TriggerMethods(&trigger)
+		if len(methods) > 0 {
+			route = route.Methods(methods...)
+		}
+		route.HandlerFunc(fh.handler).Name(key)
+		rt.add(key, fission.RouteInfo{
+			Trigger:    trigger.Metadata.Name,
+			Host:       trigger.Host,
+			UrlPattern: trigger.UrlPattern,
+			PathPrefix: trigger.PathPrefix,
+			Methods:    methods,
+			Function:   m,
+		})
+		if fh.cache != nil {
+			cachedTriggers[trigger.Metadata.Name] = true
+		}
+		if fh.shadow != nil {
+			rt.shadows = append(rt.shadows, fh.shadow)
 		}
-		muxRouter.HandleFunc(trigger.UrlPattern, fh.handler).Methods(trigger.Method)
-		if trigger.UrlPattern == "/" && trigger.Method == "GET" {
+
+		if len(trigger.Host) == 0 && (trigger.UrlPattern == "/" || trigger.PathPrefix == "/") &&
+			(methods == nil || containsMethod(methods, "GET")) {
 			homeHandled = true
 		}
 	}
@@ -95,53 +415,166 @@ func (ts *HTTPTriggerSet) getRouter() *mux.Router {
 		muxRouter.HandleFunc("/", defaultHomeHandler).Methods("GET")
 	}
 
-	// Internal triggers for (the latest version of) each function
-	for _, function := range ts.functions {
-		m := fission.Metadata{Name: function.Metadata.Name}
-		fh := &functionHandler{
-			fmap:     ts.functionServiceMap,
-			Function: function.Metadata,
-			poolmgr:  ts.poolmgr,
+	// Internal triggers for (the latest version of) each function,
+	// only served on the internal port
+	names := make([]string, 0, len(ts.functions))
+	for name := range ts.functions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fr := ts.functions[name]
+		m := fission.Metadata{Name: name}
+		key := "function/" + name
+		fh := ts.getHandler(handlers, key, nil, fission.Metadata{Name: name, Uid: fr.uid}, fr.timeout)
+		muxRouter.HandleFunc(fission.UrlForFunction(&m), fh.handler).MatcherFunc(isInternalRequest).Name(key)
+		rt.add(key, fission.RouteInfo{
+			UrlPattern: fission.UrlForFunction(&m),
+			Function:   fh.Function,
+		})
+		if ts.invoker != nil {
+			muxRouter.HandleFunc(ASYNC_URL_PREFIX+"/"+name, fh.asyncHandler).MatcherFunc(isInternalRequest)
 		}
-		muxRouter.HandleFunc(fission.UrlForFunction(&m), fh.handler)
 	}
+	if ts.invoker != nil {
+		muxRouter.HandleFunc(INVOCATIONS_URL_PREFIX+"/{id}", ts.invoker.statusHandler).Methods("GET")
+	}
+	if ts.cache != nil {
+		ts.cache.retain(cachedTriggers)
+	}
+	rt.cachedTriggers = cachedTriggers
 
+	ts.handlers = handlers
+	ts.routes.Store(rt)
 	return muxRouter
 }
 
-func (ts *HTTPTriggerSet) watchTriggers() {
+// triggerRoute adds a route matching a trigger's host and path.
+func triggerRoute(muxRouter *mux.Router, trigger *fission.HTTPTrigger) *mux.Route {
+	route := muxRouter.NewRoute()
+	if len(trigger.Host) > 0 {
+		route = route.Host(trigger.Host)
+	}
+	if len(trigger.PathPrefix) > 0 {
+		return route.PathPrefix(trigger.PathPrefix)
+	}
+	return route.Path(trigger.UrlPattern)
+}
+
+// sortTriggers orders triggers from most to least specific, since mux
+// uses the first matching route: triggers for a host before those for
+// any host, path patterns before path prefixes, and longer prefixes
+// first.
+func sortTriggers(triggers []fission.HTTPTrigger) []fission.HTTPTrigger {
+	sorted := make([]fission.HTTPTrigger, len(triggers))
+	copy(sorted, triggers)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		a, b := &sorted[i], &sorted[j]
+		if (len(a.Host) > 0) != (len(b.Host) > 0) {
+			return len(a.Host) > 0
+		}
+		if (len(a.PathPrefix) > 0) != (len(b.PathPrefix) > 0) {
+			return len(a.PathPrefix) == 0
+		}
+		return len(a.PathPrefix) > len(b.PathPrefix)
+	})
+	return sorted
+}
+
+func containsMethod(methods []string, method string) bool {
+	for _, m := range methods {
+		if strings.ToUpper(m) == method {
+			return true
+		}
+	}
+	return false
+}
+
+// update sets the triggers and functions to route, and swaps in a
+// new router if they changed.  It returns true if it did.
+func (ts *HTTPTriggerSet) update(triggers []fission.HTTPTrigger, functions map[string]functionRoute) bool {
+	if reflect.DeepEqual(triggers, ts.triggers) && reflect.DeepEqual(functions, ts.functions) {
+		return false
+	}
+	ts.triggers = triggers
+	ts.functions = functions
+	ts.mutableRouter.updateRouter(ts.getRouter())
+	return true
+}
+
+// fetch gets a consistent snapshot of triggers, functions and API
+// keys from the controller.
+func (ts *HTTPTriggerSet) fetch() ([]fission.HTTPTrigger, map[string]functionRoute, []fission.APIKey, error) {
+	triggers, err := ts.controller.HTTPTriggerList()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	functions, err := ts.controller.FunctionList()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	environments, err := ts.controller.EnvironmentList()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	apiKeys, err := ts.controller.APIKeyList()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	pinned := ts.getPinnedVersions(triggers, functions)
+	return triggers, makeFunctionRoutes(functions, pinned, environments), apiKeys, nil
+}
+
+func (ts *HTTPTriggerSet) watchTriggers(stop <-chan struct{}) {
 	if ts.controller == nil {
 		return
 	}
 
-	// the number of connection failures we'll accept before quitting
-	maxFailures := 5
-
 	// amount of time to sleep between polling calls
 	pollSleepDuration := 3 * time.Second
 
 	// Watch controller for updates to triggers and update the router accordingly.
 	// TODO change this to use a watch API; or maybe even watch etcd directly.
+	//
+	// If the controller is unreachable, keep serving the last
+	// known routes until it's back.
 	failureCount := 0
 	for {
-		triggers, err := ts.controller.HTTPTriggerList()
+		triggers, functions, apiKeys, err := ts.fetch()
 		if err != nil {
-			failureCount += 1
-			if failureCount >= maxFailures {
-				log.Fatalf("Failed to connect to controller after %v retries: %v", failureCount, err)
+			if failureCount == 0 {
+				log.Printf("Failed to get routes from controller, serving last known routes (generation %v): %v",
+					ts.mutableRouter.getGeneration(), err)
+			}
+			failureCount++
+			if !sleepUntilStopped(pollSleepDuration, stop) {
+				return
 			}
-			time.Sleep(pollSleepDuration)
 			continue
 		}
-		ts.triggers = triggers
+		if failureCount > 0 {
+			log.Printf("Controller is reachable again after %v failures", failureCount)
+			failureCount = 0
+		}
 
-		functions, err := ts.controller.FunctionList()
-		if err != nil {
-			log.Fatalf("Failed to get function list")
+		ts.apiKeys.set(apiKeys)
+		if ts.update(triggers, functions) {
+			log.Printf("Updated routes: %v triggers, %v functions (generation %v)",
+				len(triggers), len(functions), ts.mutableRouter.getGeneration())
 		}
-		ts.functions = functions
+		if !sleepUntilStopped(pollSleepDuration, stop) {
+			return
+		}
+	}
+}
 
-		ts.mutableRouter.updateRouter(ts.getRouter())
-		time.Sleep(pollSleepDuration)
+// sleepUntilStopped sleeps for d, or until stop is closed.  It returns
+// false if stop was closed.
+func sleepUntilStopped(d time.Duration, stop <-chan struct{}) bool {
+	select {
+	case <-stop:
+		return false
+	case <-time.After(d):
+		return true
 	}
 }
@@ -0,0 +1,399 @@
+/*
+Copyright 2016 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package router
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rsa"
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fission/fission"
+)
+
+const (
+	// Clock skew allowed when checking token expiry
+	JWT_LEEWAY = 1 * time.Minute
+
+	// JWKS are refetched after JWKS_REFRESH_INTERVAL, or when a
+	// token has an unknown key id, at most every
+	// JWKS_MIN_REFRESH_INTERVAL (so that bad tokens can't make the
+	// router hammer the JWKS server).
+	JWKS_REFRESH_INTERVAL     = 10 * time.Minute
+	JWKS_MIN_REFRESH_INTERVAL = 1 * time.Minute
+	JWKS_FETCH_TIMEOUT        = 5 * time.Second
+	JWKS_MAX_SIZE             = 1024 * 1024
+)
+
+var (
+	errTokenInvalid = errors.New("invalid token")
+	errTokenExpired = errors.New("token expired")
+	// The JWKS couldn't be fetched, so no token can be verified
+	errJWKSUnavailable = errors.New("signing keys unavailable")
+)
+
+// jwtAlgorithm is a supported JWS signing algorithm.
+type jwtAlgorithm struct {
+	hash  crypto.Hash
+	kind  string         // "HS", "RS" or "ES"
+	curve elliptic.Curve // for ES
+}
+
+var jwtAlgorithms = map[string]jwtAlgorithm{
+	"HS256": {crypto.SHA256, "HS", nil},
+	"HS384": {crypto.SHA384, "HS", nil},
+	"HS512": {crypto.SHA512, "HS", nil},
+	"RS256": {crypto.SHA256, "RS", nil},
+	"RS384": {crypto.SHA384, "RS", nil},
+	"RS512": {crypto.SHA512, "RS", nil},
+	"ES256": {crypto.SHA256, "ES", elliptic.P256()},
+	"ES384": {crypto.SHA384, "ES", elliptic.P384()},
+	"ES512": {crypto.SHA512, "ES", elliptic.P521()},
+}
+
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+// verifyJWT checks a token's signature and standard claims against a
+// policy, and returns its claims.
+func verifyJWT(token string, policy *fission.JWTPolicy, jwks *jwksCache) (map[string]interface{}, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errTokenInvalid
+	}
+	headerJson, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, errTokenInvalid
+	}
+	header := jwtHeader{}
+	err = json.Unmarshal(headerJson, &header)
+	if err != nil {
+		return nil, errTokenInvalid
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, errTokenInvalid
+	}
+
+	// The algorithm must match how the policy's keys are given,
+	// so that e.g. a public key can't be used as an HMAC secret.
+	alg, ok := jwtAlgorithms[header.Alg]
+	if !ok || (alg.kind == "HS") != (len(policy.Secret) > 0) {
+		return nil, errTokenInvalid
+	}
+	h := alg.hash.New()
+	h.Write([]byte(parts[0] + "." + parts[1]))
+	digest := h.Sum(nil)
+
+	if alg.kind == "HS" {
+		mac := hmac.New(alg.hash.New, []byte(policy.Secret))
+		mac.Write([]byte(parts[0] + "." + parts[1]))
+		if !hmac.Equal(sig, mac.Sum(nil)) {
+			return nil, errTokenInvalid
+		}
+	} else {
+		key, err := jwks.get(policy.JWKSUrl, header.Kid)
+		if err != nil {
+			return nil, err
+		}
+		if !verifySignature(alg, key, digest, sig) {
+			return nil, errTokenInvalid
+		}
+	}
+
+	claimsJson, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, errTokenInvalid
+	}
+	claims := make(map[string]interface{})
+	decoder := json.NewDecoder(bytes.NewReader(claimsJson))
+	decoder.UseNumber()
+	err = decoder.Decode(&claims)
+	if err != nil {
+		return nil, errTokenInvalid
+	}
+
+	now := time.Now()
+	if exp, ok := numericClaim(claims, "exp"); ok && now.After(exp.Add(JWT_LEEWAY)) {
+		return nil, errTokenExpired
+	}
+	if nbf, ok := numericClaim(claims, "nbf"); ok && now.Add(JWT_LEEWAY).Before(nbf) {
+		return nil, errTokenInvalid
+	}
+	return claims, nil
+}
+
+func verifySignature(alg jwtAlgorithm, key crypto.PublicKey, digest []byte, sig []byte) bool {
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		return alg.kind == "RS" && rsa.VerifyPKCS1v15(k, alg.hash, digest, sig) == nil
+	case *ecdsa.PublicKey:
+		if alg.kind != "ES" || k.Curve != alg.curve {
+			return false
+		}
+		size := (k.Curve.Params().BitSize + 7) / 8
+		if len(sig) != 2*size {
+			return false
+		}
+		r := new(big.Int).SetBytes(sig[:size])
+		s := new(big.Int).SetBytes(sig[size:])
+		return ecdsa.Verify(k, digest, r, s)
+	}
+	return false
+}
+
+// numericClaim returns a NumericDate claim such as "exp" as a time.
+func numericClaim(claims map[string]interface{}, name string) (time.Time, bool) {
+	n, ok := claims[name].(json.Number)
+	if !ok {
+		return time.Time{}, false
+	}
+	f, err := n.Float64()
+	if err != nil {
+		return time.Time{}, false
+	}
+	return time.Unix(int64(f), 0), true
+}
+
+// checkClaims returns an error if claims don't meet the policy's
+// requirements.
+func checkClaims(claims map[string]interface{}, policy *fission.JWTPolicy) error {
+	if len(policy.Issuer) > 0 && !claimMatches(claims["iss"], policy.Issuer) {
+		return fmt.Errorf("issuer must be '%v'", policy.Issuer)
+	}
+	if len(policy.Audience) > 0 && !claimMatches(claims["aud"], policy.Audience) {
+		return fmt.Errorf("audience must include '%v'", policy.Audience)
+	}
+	for name, value := range policy.RequiredClaims {
+		if !claimMatches(claims[name], value) {
+			return fmt.Errorf("claim '%v' must be '%v'", name, value)
+		}
+	}
+	return nil
+}
+
+// claimMatches returns true if a claim is value, or is an array
+// containing value.
+func claimMatches(claim interface{}, value string) bool {
+	switch c := claim.(type) {
+	case string:
+		return c == value
+	case json.Number:
+		return c.String() == value
+	case bool:
+		return fmt.Sprintf("%v", c) == value
+	case []interface{}:
+		for _, elem := range c {
+			if claimMatches(elem, value) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+type (
+	// jwksCache keeps the signing keys of JWKS URLs.
+	jwksCache struct {
+		lock   sync.Mutex
+		sets   map[string]*jwks // by URL
+		client *http.Client
+	}
+
+	jwks struct {
+		lock        sync.Mutex // held while fetching
+		keys        map[string]crypto.PublicKey
+		fetched     time.Time
+		lastAttempt time.Time
+	}
+
+	// JSON Web Key; only the members of RSA and EC keys are used.
+	jwk struct {
+		Kty string `json:"kty"`
+		Kid string `json:"kid"`
+		Use string `json:"use"`
+		N   string `json:"n"`
+		E   string `json:"e"`
+		Crv string `json:"crv"`
+		X   string `json:"x"`
+		Y   string `json:"y"`
+	}
+)
+
+func makeJWKSCache() *jwksCache {
+	return &jwksCache{
+		sets:   make(map[string]*jwks),
+		client: &http.Client{Timeout: JWKS_FETCH_TIMEOUT},
+	}
+}
+
+// get returns the key with id kid from the JWKS at url.  If the
+// token has no key id, the JWKS must have a single key.
+func (c *jwksCache) get(url string, kid string) (crypto.PublicKey, error) {
+	c.lock.Lock()
+	set, ok := c.sets[url]
+	if !ok {
+		set = &jwks{}
+		c.sets[url] = set
+	}
+	c.lock.Unlock()
+
+	set.lock.Lock()
+	defer set.lock.Unlock()
+
+	key, found := set.find(kid)
+	now := time.Now()
+	stale := now.Sub(set.fetched) > JWKS_REFRESH_INTERVAL
+	if (stale || !found) && now.Sub(set.lastAttempt) > JWKS_MIN_REFRESH_INTERVAL {
+		set.lastAttempt = now
+		keys, err := c.fetch(url)
+		if err != nil {
+			// keep using the keys we have, if any
+			log.Printf("Failed to fetch JWKS %v: %v", url, err)
+		} else {
+			set.keys = keys
+			set.fetched = now
+		}
+		key, found = set.find(kid)
+	}
+	if set.keys == nil {
+		return nil, errJWKSUnavailable
+	}
+	if !found {
+		return nil, errTokenInvalid
+	}
+	return key, nil
+}
+
+func (set *jwks) find(kid string) (crypto.PublicKey, bool) {
+	if len(kid) == 0 {
+		if len(set.keys) != 1 {
+			return nil, false
+		}
+		for _, key := range set.keys {
+			return key, true
+		}
+	}
+	key, ok := set.keys[kid]
+	return key, ok
+}
+
+func (c *jwksCache) fetch(url string) (map[string]crypto.PublicKey, error) {
+	resp, err := c.client.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return nil, fmt.Errorf("JWKS server returned %v", resp.Status)
+	}
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, JWKS_MAX_SIZE))
+	if err != nil {
+		return nil, err
+	}
+	return parseJWKS(body)
+}
+
+// parseJWKS returns the signing keys in a JWKS, by key id.  Keys of
+// unsupported types are skipped.
+func parseJWKS(body []byte) (map[string]crypto.PublicKey, error) {
+	set := struct {
+		Keys []jwk `json:"keys"`
+	}{}
+	err := json.Unmarshal(body, &set)
+	if err != nil {
+		return nil, err
+	}
+	keys := make(map[string]crypto.PublicKey)
+	for _, k := range set.Keys {
+		if len(k.Use) > 0 && k.Use != "sig" {
+			continue
+		}
+		key, err := k.publicKey()
+		if err != nil {
+			continue
+		}
+		keys[k.Kid] = key
+	}
+	return keys, nil
+}
+
+func (k *jwk) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil || e.Sign() <= 0 || e.BitLen() > 31 {
+			return nil, errors.New("invalid RSA exponent")
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %v", k.Crv)
+		}
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		if !curve.IsOnCurve(x, y) {
+			return nil, errors.New("invalid EC key")
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	}
+	return nil, fmt.Errorf("unsupported key type %v", k.Kty)
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(b), nil
+}
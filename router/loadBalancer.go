@@ -0,0 +1,104 @@
+/*
+Copyright 2016 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package router
+
+import (
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/fission/fission"
+)
+
+// Minimum time between requests to poolmgr for more instances of a
+// function.
+const SCALE_UP_INTERVAL = 1 * time.Second
+
+// loadBalancer spreads requests across the instances of a function,
+// sending each to the instance with the fewest outstanding requests
+// from this router.
+type loadBalancer struct {
+	lock        sync.Mutex
+	outstanding map[string]int                 // by instance host
+	scaleUps    map[fission.Metadata]time.Time // last time more instances were requested
+}
+
+func makeLoadBalancer() *loadBalancer {
+	return &loadBalancer{
+		outstanding: make(map[string]int),
+		scaleUps:    make(map[fission.Metadata]time.Time),
+	}
+}
+
+// pick chooses an instance and counts a request to it, returning
+// the instance, the requests outstanding to all instances (including
+// this one), and a function to call when the request is done.
+// Ties go to the earliest instance, so that later ones become idle
+// and are reaped when load drops.
+func (lb *loadBalancer) pick(instances []*url.URL) (*url.URL, int, func()) {
+	if lb == nil {
+		return instances[0], 1, func() {}
+	}
+
+	lb.lock.Lock()
+	defer lb.lock.Unlock()
+
+	chosen := instances[0]
+	total := 0
+	for _, u := range instances {
+		n := lb.outstanding[u.Host]
+		total += n
+		if n < lb.outstanding[chosen.Host] {
+			chosen = u
+		}
+	}
+	lb.outstanding[chosen.Host]++
+	host := chosen.Host
+
+	released := false
+	release := func() {
+		lb.lock.Lock()
+		defer lb.lock.Unlock()
+		if released {
+			return
+		}
+		released = true
+		lb.outstanding[host]--
+		if lb.outstanding[host] <= 0 {
+			delete(lb.outstanding, host)
+		}
+	}
+	return chosen, total + 1, release
+}
+
+// shouldScaleUp returns true if a function with the given number of
+// instances and outstanding requests needs more instances, and more
+// haven't been requested recently.
+func (lb *loadBalancer) shouldScaleUp(m fission.Metadata, instances int, outstanding int) bool {
+	if lb == nil || outstanding <= fission.TARGET_INFLIGHT_PER_INSTANCE*instances {
+		return false
+	}
+
+	lb.lock.Lock()
+	defer lb.lock.Unlock()
+
+	if time.Now().Sub(lb.scaleUps[m]) < SCALE_UP_INTERVAL {
+		return false
+	}
+	lb.scaleUps[m] = time.Now()
+	return true
+}
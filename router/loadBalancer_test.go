@@ -0,0 +1,64 @@
+/*
+Copyright 2016 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package router
+
+import (
+	"net/url"
+	"testing"
+
+	"github.com/fission/fission"
+)
+
+func TestLoadBalancer(t *testing.T) {
+	lb := makeLoadBalancer()
+	u1, _ := url.Parse("http://10.0.0.1:8888")
+	u2, _ := url.Parse("http://10.0.0.2:8888")
+	instances := []*url.URL{u1, u2}
+
+	// ties go to the first instance
+	u, total, release1 := lb.pick(instances)
+	if u != u1 || total != 1 {
+		t.Errorf("expected first instance with 1 outstanding, got %v with %v", u, total)
+	}
+	// the busy instance is avoided
+	u, total, release2 := lb.pick(instances)
+	if u != u2 || total != 2 {
+		t.Errorf("expected second instance with 2 outstanding, got %v with %v", u, total)
+	}
+	release1()
+	release1() // releasing twice is harmless
+	u, _, release3 := lb.pick(instances)
+	if u != u1 {
+		t.Errorf("expected first instance after release, got %v", u)
+	}
+	release2()
+	release3()
+	if len(lb.outstanding) != 0 {
+		t.Errorf("expected no outstanding requests, got %v", lb.outstanding)
+	}
+
+	m := fission.Metadata{Name: "foo", Uid: "xxx"}
+	if lb.shouldScaleUp(m, 2, 2*fission.TARGET_INFLIGHT_PER_INSTANCE) {
+		t.Errorf("expected no scale up at target load")
+	}
+	if !lb.shouldScaleUp(m, 2, 2*fission.TARGET_INFLIGHT_PER_INSTANCE+1) {
+		t.Errorf("expected scale up above target load")
+	}
+	if lb.shouldScaleUp(m, 2, 2*fission.TARGET_INFLIGHT_PER_INSTANCE+1) {
+		t.Errorf("expected scale ups to be rate limited")
+	}
+}
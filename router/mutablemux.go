@@ -20,16 +20,21 @@ import (
 	"github.com/gorilla/mux"
 	"log"
 	"net/http"
+	"strconv"
 	"sync/atomic"
 )
 
 //
 // mutableRouter wraps the mux router, and allows the router to be
-// atomically changed.
+// atomically changed.  Each change increments the route generation,
+// which is returned in a response header for debugging.
 //
 
+const ROUTER_GENERATION_HEADER = "X-Fission-Router-Generation"
+
 type mutableRouter struct {
-	router atomic.Value // mux.Router
+	router     atomic.Value // mux.Router
+	generation uint64
 }
 
 func NewMutableRouter(handler *mux.Router) *mutableRouter {
@@ -45,9 +50,16 @@ func (mr *mutableRouter) ServeHTTP(responseWriter http.ResponseWriter, request *
 	if !ok {
 		log.Panic("Invalid router type")
 	}
+	responseWriter.Header().Set(ROUTER_GENERATION_HEADER, strconv.FormatUint(mr.getGeneration(), 10))
 	router.ServeHTTP(responseWriter, request)
 }
 
 func (mr *mutableRouter) updateRouter(newHandler *mux.Router) {
 	mr.router.Store(newHandler)
+	atomic.AddUint64(&mr.generation, 1)
+}
+
+// getGeneration returns the number of times the router was changed.
+func (mr *mutableRouter) getGeneration() uint64 {
+	return atomic.LoadUint64(&mr.generation)
 }
@@ -0,0 +1,143 @@
+/*
+Copyright 2016 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package router
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/fission/fission"
+)
+
+// Rate limit response headers
+const (
+	HEADER_RATE_LIMIT_LIMIT     = "X-RateLimit-Limit"
+	HEADER_RATE_LIMIT_REMAINING = "X-RateLimit-Remaining"
+	HEADER_RATE_LIMIT_RESET     = "X-RateLimit-Reset" // seconds until the client's limit is fully restored
+)
+
+// How often buckets of clients that stopped sending requests are
+// dropped.
+const RATE_LIMIT_SWEEP_INTERVAL = 1 * time.Minute
+
+// rateLimiter enforces a trigger's rate limit, with a token bucket
+// per client.  Limits are per router: with several routers, clients
+// can make up to that many times as many requests.
+type rateLimiter struct {
+	lock      sync.Mutex
+	limit     fission.RateLimit
+	rate      float64 // tokens added per second
+	burst     float64 // bucket size
+	buckets   map[string]*tokenBucket
+	lastSweep time.Time
+}
+
+type tokenBucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+func makeRateLimiter(limit *fission.RateLimit) *rateLimiter {
+	return &rateLimiter{
+		limit:     *limit,
+		rate:      limit.RequestsPerSecond,
+		burst:     float64(fission.RateLimitBurst(limit)),
+		buckets:   make(map[string]*tokenBucket),
+		lastSweep: time.Now(),
+	}
+}
+
+// clientKey returns the key a request is counted under.  Requests
+// without the header of a header-keyed limit share one bucket.
+func (rl *rateLimiter) clientKey(request *http.Request) string {
+	switch rl.limit.Key {
+	case fission.RATE_LIMIT_KEY_GLOBAL:
+		return ""
+	case fission.RATE_LIMIT_KEY_HEADER:
+		return request.Header.Get(rl.limit.Header)
+	}
+	host, _, err := net.SplitHostPort(request.RemoteAddr)
+	if err != nil {
+		return request.RemoteAddr
+	}
+	return host
+}
+
+// take counts a request from client key at time now.  It returns
+// whether the request is allowed, the requests the client has left,
+// and the time until its bucket is full (or, if the request isn't
+// allowed, until it can make another).
+func (rl *rateLimiter) take(key string, now time.Time) (bool, int, time.Duration) {
+	rl.lock.Lock()
+	defer rl.lock.Unlock()
+
+	if now.Sub(rl.lastSweep) > RATE_LIMIT_SWEEP_INTERVAL {
+		rl.sweep(now)
+	}
+
+	b, ok := rl.buckets[key]
+	if !ok {
+		b = &tokenBucket{tokens: rl.burst, updated: now}
+		rl.buckets[key] = b
+	}
+	b.tokens = math.Min(rl.burst, b.tokens+now.Sub(b.updated).Seconds()*rl.rate)
+	b.updated = now
+
+	if b.tokens < 1 {
+		return false, 0, rl.timeToFill(1 - b.tokens)
+	}
+	b.tokens--
+	return true, int(b.tokens), rl.timeToFill(rl.burst - b.tokens)
+}
+
+func (rl *rateLimiter) timeToFill(tokens float64) time.Duration {
+	return time.Duration(tokens / rl.rate * float64(time.Second))
+}
+
+// sweep drops the buckets that have filled up, since they're the
+// same as new ones.
+func (rl *rateLimiter) sweep(now time.Time) {
+	for key, b := range rl.buckets {
+		if b.tokens+now.Sub(b.updated).Seconds()*rl.rate >= rl.burst {
+			delete(rl.buckets, key)
+		}
+	}
+	rl.lastSweep = now
+}
+
+// allow counts a request and sets the rate limit headers on its
+// response.  If the client is over its limit, it responds with 429
+// and returns false.
+func (rl *rateLimiter) allow(responseWriter http.ResponseWriter, request *http.Request) bool {
+	allowed, remaining, reset := rl.take(rl.clientKey(request), time.Now())
+
+	seconds := int(math.Ceil(reset.Seconds()))
+	h := responseWriter.Header()
+	h.Set(HEADER_RATE_LIMIT_LIMIT, fmt.Sprintf("%v", int(rl.burst)))
+	h.Set(HEADER_RATE_LIMIT_REMAINING, fmt.Sprintf("%v", remaining))
+	h.Set(HEADER_RATE_LIMIT_RESET, fmt.Sprintf("%v", seconds))
+	if allowed {
+		return true
+	}
+	h.Set("Retry-After", fmt.Sprintf("%v", seconds))
+	http.Error(responseWriter, "Too many requests (fission)", http.StatusTooManyRequests)
+	return false
+}
@@ -0,0 +1,119 @@
+/*
+Copyright 2016 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package router
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/fission/fission"
+)
+
+func TestRateLimiterTokenBucket(t *testing.T) {
+	rl := makeRateLimiter(&fission.RateLimit{RequestsPerSecond: 2, Burst: 3})
+	now := time.Now()
+
+	for i := 2; i >= 0; i-- {
+		allowed, remaining, _ := rl.take("a", now)
+		if !allowed || remaining != i {
+			t.Fatalf("request within burst: allowed %v, remaining %v", allowed, remaining)
+		}
+	}
+	allowed, _, wait := rl.take("a", now)
+	if allowed {
+		t.Fatalf("request over burst was allowed")
+	}
+	if wait != 500*time.Millisecond {
+		t.Fatalf("expected to wait 500ms, got %v", wait)
+	}
+
+	// other clients have their own buckets
+	allowed, _, _ = rl.take("b", now)
+	if !allowed {
+		t.Fatalf("other client's request wasn't allowed")
+	}
+
+	// tokens are refilled at the rate
+	allowed, remaining, _ := rl.take("a", now.Add(time.Second))
+	if !allowed || remaining != 1 {
+		t.Fatalf("request after refill: allowed %v, remaining %v", allowed, remaining)
+	}
+
+	// full buckets are dropped
+	rl.take("c", now.Add(time.Minute+time.Second))
+	if len(rl.buckets) != 1 {
+		t.Fatalf("expected idle buckets to be dropped, have %v", len(rl.buckets))
+	}
+}
+
+func TestRateLimitedTrigger(t *testing.T) {
+	backendURL := createBackendService("hi")
+	fn := &fission.Metadata{Name: "foo", Uid: "xxx"}
+	fmap := makeFunctionServiceMap(0)
+	fmap.assign(fn, []*url.URL{backendURL})
+
+	fh := &functionHandler{
+		fmap:     fmap,
+		Function: *fn,
+		rateLimiter: makeRateLimiter(&fission.RateLimit{
+			RequestsPerSecond: 0.001,
+			Burst:             1,
+			Key:               fission.RATE_LIMIT_KEY_HEADER,
+			Header:            "X-Api-Key",
+		}),
+	}
+	server := httptest.NewServer(http.HandlerFunc(fh.handler))
+	defer server.Close()
+
+	get := func(apiKey string) *http.Response {
+		req, err := http.NewRequest("GET", server.URL, nil)
+		if err != nil {
+			t.Fatalf("failed to create request: %v", err)
+		}
+		req.Header.Set("X-Api-Key", apiKey)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("failed to make request: %v", err)
+		}
+		resp.Body.Close()
+		return resp
+	}
+
+	resp := get("key1")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200, got %v", resp.StatusCode)
+	}
+	if resp.Header.Get(HEADER_RATE_LIMIT_LIMIT) != "1" || resp.Header.Get(HEADER_RATE_LIMIT_REMAINING) != "0" {
+		t.Errorf("bad rate limit headers: %v", resp.Header)
+	}
+
+	resp = get("key1")
+	if resp.StatusCode != http.StatusTooManyRequests {
+		t.Fatalf("expected 429, got %v", resp.StatusCode)
+	}
+	if resp.Header.Get("Retry-After") != "1000" || resp.Header.Get(HEADER_RATE_LIMIT_RESET) != "1000" {
+		t.Errorf("bad Retry-After %v", resp.Header.Get("Retry-After"))
+	}
+
+	resp = get("key2")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200 for another API key, got %v", resp.StatusCode)
+	}
+}
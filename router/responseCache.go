@@ -0,0 +1,384 @@
+/*
+Copyright 2016 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package router
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"io"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fission/fission"
+)
+
+const (
+	// Response header telling whether a response came from the
+	// router's cache: "HIT" or "MISS".
+	HEADER_CACHE = "X-Fission-Cache"
+
+	// Responses with larger bodies aren't cached.
+	MAX_CACHED_RESPONSE_SIZE = 1024 * 1024
+)
+
+type (
+	// responseCache keeps responses of all triggers with a cache
+	// policy, evicting the least recently used when it's full.
+	responseCache struct {
+		lock     sync.Mutex
+		maxBytes int64
+		bytes    int64
+		entries  map[cacheKey]*list.Element
+		lru      *list.List // of *cachedResponse, most recently used first
+	}
+
+	cacheKey struct {
+		trigger string
+		request string
+	}
+
+	cachedResponse struct {
+		key        cacheKey
+		statusCode int
+		header     http.Header
+		body       []byte
+		stored     time.Time
+		expires    time.Time
+		size       int64
+	}
+
+	// triggerCache applies a trigger's cache policy.
+	triggerCache struct {
+		policy  fission.CachePolicy
+		trigger string
+		store   *responseCache
+	}
+
+	// cacheFill is put in the context of a request that missed the
+	// cache, to store the function's response.
+	cacheFill struct {
+		tc  *triggerCache
+		key string
+	}
+	cacheFillKey struct{}
+
+	// cacheFillBody buffers a response body as the proxy copies it
+	// to the client, and stores the response when it's complete.
+	cacheFillBody struct {
+		io.ReadCloser
+		fill     *cacheFill
+		resp     *http.Response
+		ttl      time.Duration
+		buf      bytes.Buffer
+		eof      bool
+		overflow bool
+	}
+)
+
+// Statuses cacheable by default (RFC 7231 section 6.1)
+var cacheableStatus = map[int]bool{
+	http.StatusOK:                   true,
+	http.StatusNonAuthoritativeInfo: true,
+	http.StatusNoContent:            true,
+	http.StatusMovedPermanently:     true,
+	http.StatusNotFound:             true,
+	http.StatusGone:                 true,
+}
+
+// makeResponseCache creates a cache of up to maxBytes of responses.
+func makeResponseCache(maxBytes int64) *responseCache {
+	return &responseCache{
+		maxBytes: maxBytes,
+		entries:  make(map[cacheKey]*list.Element),
+		lru:      list.New(),
+	}
+}
+
+func (rc *responseCache) get(key cacheKey, now time.Time) *cachedResponse {
+	rc.lock.Lock()
+	defer rc.lock.Unlock()
+	elem, ok := rc.entries[key]
+	if !ok {
+		return nil
+	}
+	cr := elem.Value.(*cachedResponse)
+	if now.After(cr.expires) {
+		rc.remove(elem)
+		return nil
+	}
+	rc.lru.MoveToFront(elem)
+	return cr
+}
+
+func (rc *responseCache) set(cr *cachedResponse) {
+	rc.lock.Lock()
+	defer rc.lock.Unlock()
+	if cr.size > rc.maxBytes {
+		return
+	}
+	if elem, ok := rc.entries[cr.key]; ok {
+		rc.remove(elem)
+	}
+	rc.entries[cr.key] = rc.lru.PushFront(cr)
+	rc.bytes += cr.size
+	for rc.bytes > rc.maxBytes {
+		rc.remove(rc.lru.Back())
+	}
+}
+
+func (rc *responseCache) remove(elem *list.Element) {
+	cr := rc.lru.Remove(elem).(*cachedResponse)
+	delete(rc.entries, cr.key)
+	rc.bytes -= cr.size
+}
+
+// purge drops the responses of a trigger, and returns how many there
+// were.
+func (rc *responseCache) purge(trigger string) int {
+	return rc.removeIf(func(key cacheKey) bool { return key.trigger == trigger })
+}
+
+// retain drops the responses of triggers that are no longer cached.
+func (rc *responseCache) retain(triggers map[string]bool) {
+	rc.removeIf(func(key cacheKey) bool { return !triggers[key.trigger] })
+}
+
+func (rc *responseCache) removeIf(match func(cacheKey) bool) int {
+	rc.lock.Lock()
+	defer rc.lock.Unlock()
+	n := 0
+	for key, elem := range rc.entries {
+		if match(key) {
+			rc.remove(elem)
+			n++
+		}
+	}
+	return n
+}
+
+func makeTriggerCache(policy *fission.CachePolicy, trigger string, store *responseCache) *triggerCache {
+	return &triggerCache{
+		policy:  *policy,
+		trigger: trigger,
+		store:   store,
+	}
+}
+
+// key identifies the response to a request: the host, path, query
+// (or the policy's query parameters), the client the router
+// authenticated, and the policy's headers.
+func (tc *triggerCache) key(request *http.Request) string {
+	query := request.URL.Query()
+	if len(tc.policy.VaryQuery) > 0 {
+		q := url.Values{}
+		for _, name := range tc.policy.VaryQuery {
+			if values, ok := query[name]; ok {
+				q[name] = values
+			}
+		}
+		query = q
+	}
+	parts := []string{
+		request.Host,
+		request.URL.Path,
+		query.Encode(),
+		request.Header.Get(HEADER_AUTH_APIKEY),
+		request.Header.Get(HEADER_AUTH_SUBJECT),
+	}
+	for _, h := range tc.policy.VaryHeaders {
+		parts = append(parts, strings.Join(request.Header[http.CanonicalHeaderKey(h)], ","))
+	}
+	return strings.Join(parts, "\n")
+}
+
+// serve responds from the cache if it has a fresh response for the
+// request, or else calls next and caches its response if allowed.
+// Only GET and HEAD requests may be served from the cache, and only
+// responses to GET requests are stored.
+func (tc *triggerCache) serve(responseWriter http.ResponseWriter, request *http.Request, next http.HandlerFunc) {
+	if (request.Method != http.MethodGet && request.Method != http.MethodHead) || isUpgrade(request) {
+		next(responseWriter, request)
+		return
+	}
+	directives := parseCacheControl(request.Header)
+	if _, ok := directives["no-store"]; ok {
+		next(responseWriter, request)
+		return
+	}
+
+	key := tc.key(request)
+	_, noCache := directives["no-cache"]
+	noCache = noCache || directives["max-age"] == "0" ||
+		strings.Contains(strings.ToLower(request.Header.Get("Pragma")), "no-cache")
+	if !noCache {
+		now := time.Now()
+		if cr := tc.store.get(cacheKey{tc.trigger, key}, now); cr != nil {
+			h := responseWriter.Header()
+			for k, v := range cr.header {
+				h[k] = append(h[k], v...)
+			}
+			h.Set(HEADER_CACHE, "HIT")
+			h.Set("Age", strconv.Itoa(int(now.Sub(cr.stored).Seconds())))
+			responseWriter.WriteHeader(cr.statusCode)
+			if request.Method != http.MethodHead {
+				responseWriter.Write(cr.body)
+			}
+			return
+		}
+	}
+
+	responseWriter.Header().Set(HEADER_CACHE, "MISS")
+	if request.Method == http.MethodGet {
+		fill := &cacheFill{tc: tc, key: key}
+		request = request.WithContext(context.WithValue(request.Context(), cacheFillKey{}, fill))
+	}
+	next(responseWriter, request)
+}
+
+// ttl returns how long a function's response may be cached, or zero
+// if it mustn't be.
+func (tc *triggerCache) ttl(resp *http.Response) time.Duration {
+	if !cacheableStatus[resp.StatusCode] || len(resp.Header["Set-Cookie"]) > 0 {
+		return 0
+	}
+	for _, value := range resp.Header["Vary"] {
+		for _, h := range strings.Split(value, ",") {
+			h = strings.TrimSpace(h)
+			if len(h) > 0 && !containsHeader(tc.policy.VaryHeaders, h) {
+				// the key doesn't tell apart responses that
+				// depend on this header
+				return 0
+			}
+		}
+	}
+
+	directives := parseCacheControl(resp.Header)
+	for _, d := range []string{"no-store", "no-cache", "private"} {
+		if _, ok := directives[d]; ok {
+			return 0
+		}
+	}
+	for _, d := range []string{"s-maxage", "max-age"} {
+		if value, ok := directives[d]; ok {
+			seconds, err := strconv.Atoi(value)
+			if err != nil || seconds <= 0 {
+				return 0
+			}
+			return time.Duration(seconds) * time.Second
+		}
+	}
+	return time.Duration(tc.policy.TTL) * time.Second
+}
+
+// fillCache arranges for a function's response to be cached, if the
+// request missed the cache and the response can be cached.
+func fillCache(resp *http.Response) {
+	fill, ok := resp.Request.Context().Value(cacheFillKey{}).(*cacheFill)
+	if !ok || fill == nil {
+		return
+	}
+	ttl := fill.tc.ttl(resp)
+	if ttl == 0 || resp.ContentLength > MAX_CACHED_RESPONSE_SIZE {
+		return
+	}
+	resp.Body = &cacheFillBody{
+		ReadCloser: resp.Body,
+		fill:       fill,
+		resp:       resp,
+		ttl:        ttl,
+	}
+}
+
+func (b *cacheFillBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if n > 0 && !b.overflow {
+		if b.buf.Len()+n > MAX_CACHED_RESPONSE_SIZE {
+			b.overflow = true
+			b.buf = bytes.Buffer{}
+		} else {
+			b.buf.Write(p[:n])
+		}
+	}
+	if err == io.EOF {
+		b.eof = true
+	}
+	return n, err
+}
+
+// Close stores the response if its whole body was read.
+func (b *cacheFillBody) Close() error {
+	err := b.ReadCloser.Close()
+	if !b.eof || b.overflow {
+		return err
+	}
+
+	header := make(http.Header, len(b.resp.Header))
+	size := int64(b.buf.Len() + len(b.fill.key))
+	for k, v := range b.resp.Header {
+		header[k] = v
+		size += int64(len(k))
+		for _, s := range v {
+			size += int64(len(s))
+		}
+	}
+	now := time.Now()
+	b.fill.tc.store.set(&cachedResponse{
+		key:        cacheKey{b.fill.tc.trigger, b.fill.key},
+		statusCode: b.resp.StatusCode,
+		header:     header,
+		body:       b.buf.Bytes(),
+		stored:     now,
+		expires:    now.Add(b.ttl),
+		size:       size,
+	})
+	return err
+}
+
+// parseCacheControl returns the directives of a Cache-Control header,
+// with their values if any.
+func parseCacheControl(header http.Header) map[string]string {
+	directives := make(map[string]string)
+	for _, value := range header["Cache-Control"] {
+		for _, d := range strings.Split(value, ",") {
+			d = strings.TrimSpace(d)
+			if len(d) == 0 {
+				continue
+			}
+			kv := strings.SplitN(d, "=", 2)
+			name := strings.ToLower(kv[0])
+			if len(kv) == 2 {
+				directives[name] = strings.Trim(kv[1], "\"")
+			} else {
+				directives[name] = ""
+			}
+		}
+	}
+	return directives
+}
+
+func sameCachePolicy(tc *triggerCache, policy *fission.CachePolicy) bool {
+	if tc == nil || policy == nil {
+		return tc == nil && policy == nil
+	}
+	return reflect.DeepEqual(tc.policy, *policy)
+}
@@ -0,0 +1,156 @@
+/*
+Copyright 2016 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package router
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.com/fission/fission"
+)
+
+func TestResponseCacheEviction(t *testing.T) {
+	rc := makeResponseCache(100)
+	now := time.Now()
+	for i := 0; i < 3; i++ {
+		rc.set(&cachedResponse{
+			key:     cacheKey{"t", fmt.Sprintf("%v", i)},
+			expires: now.Add(time.Minute),
+			size:    40,
+		})
+	}
+	if rc.get(cacheKey{"t", "0"}, now) != nil {
+		t.Errorf("least recently used response wasn't evicted")
+	}
+	if rc.get(cacheKey{"t", "1"}, now) == nil || rc.get(cacheKey{"t", "2"}, now) == nil {
+		t.Errorf("recent responses were evicted")
+	}
+	if rc.get(cacheKey{"t", "1"}, now.Add(2*time.Minute)) != nil {
+		t.Errorf("expired response was returned")
+	}
+	if rc.purge("t") != 1 || rc.bytes != 0 {
+		t.Errorf("expected one response to be purged, %v bytes left", rc.bytes)
+	}
+}
+
+func TestCachedTrigger(t *testing.T) {
+	var calls int32
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&calls, 1)
+		if r.URL.Query().Get("private") != "" {
+			w.Header().Set("Cache-Control", "private")
+		}
+		fmt.Fprintf(w, "%v %v", n, r.Header.Get("Accept-Language"))
+	}))
+	defer backend.Close()
+	backendURL, _ := url.Parse(backend.URL)
+
+	fmap := makeFunctionServiceMap(0)
+	fn := &fission.Metadata{Name: "news", Uid: "news1"}
+	fmap.assign(fn, []*url.URL{backendURL})
+	functions := map[string]functionRoute{"news": {uid: fn.Uid}}
+
+	triggers := makeHTTPTriggerSet(fmap, nil, nil, nil, nil, makeResponseCache(1024*1024))
+	triggers.mutableRouter = NewMutableRouter(mux.NewRouter())
+	triggers.update([]fission.HTTPTrigger{
+		{
+			Metadata:   fission.Metadata{Name: "t1"},
+			UrlPattern: "/news",
+			Methods:    []string{"GET", "HEAD", "POST"},
+			Function:   fission.Metadata{Name: "news"},
+			Cache: &fission.CachePolicy{
+				TTL:         60,
+				VaryHeaders: []string{"Accept-Language"},
+				VaryQuery:   []string{"page", "private"},
+			},
+		},
+	}, functions)
+
+	serve := func(method, path string, header map[string]string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "http://example.com"+path, nil)
+		for k, v := range header {
+			req.Header.Set(k, v)
+		}
+		w := httptest.NewRecorder()
+		triggers.mutableRouter.ServeHTTP(w, req)
+		return w
+	}
+
+	tests := []struct {
+		method, path, lang string
+		body, cache        string
+	}{
+		{"GET", "/news?page=1", "en", "1 en", "MISS"},
+		{"GET", "/news?page=1&utm=x", "en", "1 en", "HIT"},
+		{"HEAD", "/news?page=1", "en", "", "HIT"},
+		{"GET", "/news?page=1", "fr", "2 fr", "MISS"},
+		{"GET", "/news?page=2", "en", "3 en", "MISS"},
+		{"POST", "/news?page=1", "en", "4 en", ""},
+		{"GET", "/news?private=1", "en", "5 en", "MISS"},
+		{"GET", "/news?private=1", "en", "6 en", "MISS"},
+	}
+	for _, test := range tests {
+		w := serve(test.method, test.path, map[string]string{"Accept-Language": test.lang})
+		if w.Code != http.StatusOK || w.Body.String() != test.body || w.Header().Get(HEADER_CACHE) != test.cache {
+			t.Fatalf("%v %v (%v): expected %q %v, got %v %q %v", test.method, test.path, test.lang,
+				test.body, test.cache, w.Code, w.Body.String(), w.Header().Get(HEADER_CACHE))
+		}
+	}
+
+	// clients can ask for a fresh response
+	w := serve("GET", "/news?page=1", map[string]string{"Accept-Language": "en", "Cache-Control": "no-cache"})
+	if w.Body.String() != "7 en" {
+		t.Fatalf("expected a fresh response, got %q", w.Body.String())
+	}
+	w = serve("GET", "/news?page=1", map[string]string{"Accept-Language": "en"})
+	if w.Body.String() != "7 en" || w.Header().Get(HEADER_CACHE) != "HIT" {
+		t.Fatalf("expected the refreshed response to be cached, got %q", w.Body.String())
+	}
+
+	// purging is part of the admin API
+	admin := triggers.adminHandler("secret", triggers.mutableRouter)
+	purge := func(trigger string, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("DELETE", ADMIN_URL_PREFIX+"/cache/"+trigger, nil)
+		if len(token) > 0 {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		admin.ServeHTTP(w, req)
+		return w
+	}
+	if w := purge("t1", ""); w.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 purging without the admin token, got %v", w.Code)
+	}
+	w = purge("t1", "secret")
+	if w.Code != http.StatusOK || w.Body.String() != `{"trigger":"t1","purged":3}` {
+		t.Fatalf("expected 3 responses to be purged, got %v %q", w.Code, w.Body.String())
+	}
+	w = serve("GET", "/news?page=1", map[string]string{"Accept-Language": "en"})
+	if w.Body.String() != "8 en" {
+		t.Fatalf("expected a fresh response after purging, got %q", w.Body.String())
+	}
+	if w := purge("nonexistent", "secret"); w.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 purging an unknown trigger, got %v", w.Code)
+	}
+}
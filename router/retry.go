@@ -0,0 +1,166 @@
+/*
+Copyright 2016 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package router
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"reflect"
+	"strconv"
+	"time"
+
+	"github.com/fission/fission"
+)
+
+const (
+	// Response header with the number of times the router sent the
+	// request to the function, on responses of triggers with a
+	// retry policy.
+	HEADER_ATTEMPTS = "X-Fission-Attempts"
+
+	// Requests with larger bodies aren't retried.
+	RETRY_MAX_BODY_SIZE = 1024 * 1024
+	// Retry backoff, unless the policy sets it
+	RETRY_INITIAL_BACKOFF = 100 * time.Millisecond
+	RETRY_MAX_BACKOFF     = 5 * time.Second
+)
+
+// Statuses retried unless the policy lists others: the function
+// couldn't be reached, started, or didn't respond in time.
+var defaultRetryStatuses = []int{
+	http.StatusBadGateway,
+	http.StatusServiceUnavailable,
+	http.StatusGatewayTimeout,
+}
+
+// triggerRetry retries a trigger's failed requests.  This is on top of
+// retrying connections to function services, which is always done
+// since the request hasn't been sent yet.
+type triggerRetry struct {
+	policy         fission.RetryPolicy
+	trigger        string
+	initialBackoff time.Duration
+	maxBackoff     time.Duration
+	retryOn        map[int]bool
+}
+
+func makeTriggerRetry(policy *fission.RetryPolicy, trigger string) *triggerRetry {
+	tr := &triggerRetry{
+		policy:         *policy,
+		trigger:        trigger,
+		initialBackoff: time.Duration(policy.InitialBackoffMs) * time.Millisecond,
+		maxBackoff:     time.Duration(policy.MaxBackoffMs) * time.Millisecond,
+		retryOn:        make(map[int]bool),
+	}
+	if tr.initialBackoff == 0 {
+		tr.initialBackoff = RETRY_INITIAL_BACKOFF
+	}
+	if tr.maxBackoff == 0 {
+		tr.maxBackoff = RETRY_MAX_BACKOFF
+	}
+	if tr.maxBackoff < tr.initialBackoff {
+		tr.maxBackoff = tr.initialBackoff
+	}
+	statuses := policy.RetryOn
+	if len(statuses) == 0 {
+		statuses = defaultRetryStatuses
+	}
+	for _, status := range statuses {
+		tr.retryOn[status] = true
+	}
+	return tr
+}
+
+// serve calls next to serve a request, and calls it again while the
+// response has a retryable status, up to the policy's max attempts.
+// Only the last attempt's response is sent to the client.
+func (tr *triggerRetry) serve(responseWriter http.ResponseWriter, request *http.Request, next http.HandlerFunc) {
+	responseWriter.Header().Set(HEADER_ATTEMPTS, "1")
+	if isUpgrade(request) || (!tr.policy.RetryNonIdempotent && !isIdempotent(request.Method)) {
+		next(responseWriter, request)
+		return
+	}
+
+	var body []byte
+	if request.Body != nil && request.Body != http.NoBody {
+		var err error
+		body, err = ioutil.ReadAll(io.LimitReader(request.Body, RETRY_MAX_BODY_SIZE+1))
+		if err != nil || len(body) > RETRY_MAX_BODY_SIZE {
+			request.Body = struct {
+				io.Reader
+				io.Closer
+			}{io.MultiReader(bytes.NewReader(body), request.Body), request.Body}
+			next(responseWriter, request)
+			return
+		}
+	}
+
+	backoff := tr.initialBackoff
+	for attempt := 1; ; attempt++ {
+		// Each attempt gets the request as the client sent it.
+		req := *request
+		req.Header = cloneHeader(request.Header)
+		if body != nil {
+			req.Body = ioutil.NopCloser(bytes.NewReader(body))
+			req.ContentLength = int64(len(body))
+		}
+
+		last := attempt >= tr.policy.MaxAttempts
+		responseWriter.Header().Set(HEADER_ATTEMPTS, strconv.Itoa(attempt))
+		w := makeInterceptingResponseWriter(responseWriter, func(statusCode int) bool {
+			return !last && tr.retryOn[statusCode]
+		})
+		next(w, &req)
+		if w.intercepted == 0 {
+			return
+		}
+
+		log.Printf("Request to trigger %v got status %v on attempt %v, retrying in %v",
+			tr.trigger, w.intercepted, attempt, backoff)
+		select {
+		case <-time.After(backoff):
+		case <-request.Context().Done():
+			// the client has gone away
+			return
+		}
+		backoff *= 2
+		if backoff > tr.maxBackoff {
+			backoff = tr.maxBackoff
+		}
+	}
+}
+
+// isIdempotent returns true for methods that can be sent more than
+// once with the same effect (RFC 7231 section 4.2.2).
+func isIdempotent(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace,
+		http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return false
+}
+
+func sameRetryPolicy(tr *triggerRetry, policy *fission.RetryPolicy) bool {
+	if tr == nil || policy == nil {
+		return tr == nil && policy == nil
+	}
+	return reflect.DeepEqual(tr.policy, *policy)
+}
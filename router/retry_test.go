@@ -0,0 +1,97 @@
+/*
+Copyright 2016 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package router
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/gorilla/mux"
+
+	"github.com/fission/fission"
+)
+
+func TestTriggerRetry(t *testing.T) {
+	// Fails the first two requests to each path.
+	var calls int32
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&calls, 1)
+		body, _ := ioutil.ReadAll(r.Body)
+		if n <= 2 {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprintf(w, "%v %v", n, string(body))
+	}))
+	defer backend.Close()
+	backendURL, _ := url.Parse(backend.URL)
+
+	fmap := makeFunctionServiceMap(0)
+	fn := fission.Metadata{Name: "flaky", Uid: "f1"}
+	fmap.assign(&fn, []*url.URL{backendURL})
+	functions := map[string]functionRoute{"flaky": {uid: fn.Uid}}
+
+	triggers := makeHTTPTriggerSet(fmap, nil, nil, nil, nil, nil)
+	triggers.mutableRouter = NewMutableRouter(mux.NewRouter())
+	triggers.update([]fission.HTTPTrigger{
+		{
+			Metadata:   fission.Metadata{Name: "t3"},
+			UrlPattern: "/three",
+			Methods:    []string{"ANY"},
+			Function:   fission.Metadata{Name: "flaky"},
+			Retry:      &fission.RetryPolicy{MaxAttempts: 3, InitialBackoffMs: 1},
+		},
+		{
+			Metadata:   fission.Metadata{Name: "t2"},
+			UrlPattern: "/two",
+			Function:   fission.Metadata{Name: "flaky"},
+			Retry:      &fission.RetryPolicy{MaxAttempts: 2, InitialBackoffMs: 1},
+		},
+	}, functions)
+
+	tests := []struct {
+		method, path, body string
+		status             int
+		response, attempts string
+		calls              int32
+	}{
+		// retried until it succeeds, with the same body each time
+		{"PUT", "/three", "data", http.StatusOK, "3 data", "3", 3},
+		// not idempotent, so not retried
+		{"POST", "/three", "data", http.StatusServiceUnavailable, "unavailable\n", "1", 1},
+		// the last attempt's failure is returned
+		{"GET", "/two", "", http.StatusServiceUnavailable, "unavailable\n", "2", 2},
+	}
+	for _, test := range tests {
+		atomic.StoreInt32(&calls, 0)
+		req := httptest.NewRequest(test.method, "http://example.com"+test.path, strings.NewReader(test.body))
+		w := httptest.NewRecorder()
+		triggers.mutableRouter.ServeHTTP(w, req)
+		if w.Code != test.status || w.Body.String() != test.response ||
+			w.Header().Get(HEADER_ATTEMPTS) != test.attempts || atomic.LoadInt32(&calls) != test.calls {
+			t.Errorf("%v %v: expected %v %q after %v attempts (%v calls), got %v %q after %v attempts (%v calls)",
+				test.method, test.path, test.status, test.response, test.attempts, test.calls,
+				w.Code, w.Body.String(), w.Header().Get(HEADER_ATTEMPTS), atomic.LoadInt32(&calls))
+		}
+	}
+}
@@ -40,6 +40,8 @@ Its job is to:
 package router
 
 import (
+	"context"
+	"crypto/tls"
 	"fmt"
 	"log"
 	"net/http"
@@ -48,7 +50,10 @@ import (
 
 	"github.com/gorilla/handlers"
 	"github.com/gorilla/mux"
+	"k8s.io/client-go/1.5/kubernetes"
+	"k8s.io/client-go/1.5/rest"
 
+	"github.com/fission/fission"
 	controllerClient "github.com/fission/fission/controller/client"
 	poolmgrClient "github.com/fission/fission/poolmgr/client"
 )
@@ -57,25 +62,201 @@ import (
 
 // request url ---[trigger]---> Function(name, deployment) ----[deployment]----> Function(name, uid) ----[pool mgr]---> k8s service url
 
-func router(httpTriggerSet *HTTPTriggerSet) *mutableRouter {
+// Config holds the router's tunables.
+type Config struct {
+	// Max requests per function waiting for it to start; further
+	// requests get a 503.  Zero means no limit.
+	ColdStartQueueDepth int
+	// Max time a request waits for its function to start.  Zero
+	// means no limit.
+	ColdStartTimeout time.Duration
+	// Max bytes of responses cached for triggers with a cache
+	// policy.  Zero disables response caching.
+	ResponseCacheSize int64
+
+	// Port to serve HTTPS on; zero disables TLS.
+	TLSPort int
+	// Directory with certificates: NAME.crt and NAME.key pairs, or
+	// mounted Kubernetes TLS secrets (tls.crt and tls.key).
+	TLSCertDir string
+	// Kubernetes TLS secrets with certificates, as namespace/name.
+	TLSSecrets []string
+	// Redirect plain HTTP requests to HTTPS on this port, e.g. 443
+	// (the port clients see, which may differ from TLSPort).  Zero
+	// disables redirects.
+	HTTPSRedirectPort int
+
+	// Port for callers inside the cluster, such as the kubewatcher.
+	// Besides the triggers, it serves each function's internal
+	// routes, which skip the triggers' policies, so it mustn't be
+	// exposed outside the cluster.  Zero disables it.
+	InternalPort int
+
+	// Max time to wait for in-flight requests when shutting down.
+	ShutdownTimeout time.Duration
+
+	// Bearer token for the admin API; the API is disabled if
+	// empty.
+	AdminToken string
+}
+
+// DefaultConfig returns the router configuration used unless
+// overridden on the command line.
+func DefaultConfig() Config {
+	return Config{
+		ColdStartQueueDepth: 100,
+		ColdStartTimeout:    30 * time.Second,
+		ResponseCacheSize:   64 * 1024 * 1024,
+		InternalPort:        8889,
+		ShutdownTimeout:     fission.DEFAULT_SHUTDOWN_TIMEOUT,
+	}
+}
+
+func router(httpTriggerSet *HTTPTriggerSet, stop <-chan struct{}) *mutableRouter {
 	muxRouter := mux.NewRouter()
 	mr := NewMutableRouter(muxRouter)
-	httpTriggerSet.subscribeRouter(mr)
+	httpTriggerSet.subscribeRouter(mr, stop)
 	return mr
 }
 
-func serve(port int, httpTriggerSet *HTTPTriggerSet) {
-	mr := router(httpTriggerSet)
-	url := fmt.Sprintf(":%v", port)
-	http.ListenAndServe(url, handlers.LoggingHandler(os.Stdout, mr))
+// getKubernetesClient returns a kubernetes client using the pod's
+// service account.
+func getKubernetesClient() (*kubernetes.Clientset, error) {
+	config, err := rest.InClusterConfig()
+	if err != nil {
+		return nil, err
+	}
+	return kubernetes.NewForConfig(config)
+}
+
+// makeConfiguredCertStore loads the certificates the router is
+// configured with.
+func makeConfiguredCertStore(config Config) (*certStore, error) {
+	sources := make([]certSource, 0)
+	if len(config.TLSCertDir) > 0 {
+		sources = append(sources, &fileCertSource{dir: config.TLSCertDir})
+	}
+	if len(config.TLSSecrets) > 0 {
+		client, err := getKubernetesClient()
+		if err != nil {
+			return nil, err
+		}
+		sources = append(sources, &secretCertSource{client: client, secrets: config.TLSSecrets})
+	}
+	certs := makeCertStore(sources)
+	err := certs.reload()
+	if err != nil {
+		return nil, err
+	}
+	return certs, nil
+}
+
+// internalKey marks the context of requests to the internal port.
+type internalKey struct{}
+
+// internalHandler marks requests as coming from inside the cluster,
+// so that they can use internal routes.
+func internalHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(responseWriter http.ResponseWriter, request *http.Request) {
+		ctx := context.WithValue(request.Context(), internalKey{}, true)
+		next.ServeHTTP(responseWriter, request.WithContext(ctx))
+	})
+}
+
+// isInternalRequest is a mux matcher for internal routes.
+func isInternalRequest(request *http.Request, _ *mux.RouteMatch) bool {
+	internal, _ := request.Context().Value(internalKey{}).(bool)
+	return internal
+}
+
+// serve serves HTTP (and HTTPS, if configured) until stop is closed,
+// then drains in-flight requests.  It returns an error if a server
+// couldn't be started or didn't drain in time.
+func serve(port int, httpTriggerSet *HTTPTriggerSet, config Config, stop <-chan struct{}) error {
+	var handler http.Handler = router(httpTriggerSet, stop)
+	if len(config.AdminToken) > 0 {
+		handler = httpTriggerSet.adminHandler(config.AdminToken, handler)
+	}
+	handler = handlers.LoggingHandler(os.Stdout, handler)
+
+	servers := make([]*http.Server, 0, 3)
+	if config.InternalPort > 0 {
+		servers = append(servers, &http.Server{
+			Addr:    fmt.Sprintf(":%v", config.InternalPort),
+			Handler: internalHandler(handler),
+		})
+		log.Printf("Serving internal routes at port %v", config.InternalPort)
+	}
+	if config.TLSPort > 0 {
+		servers = append(servers, &http.Server{
+			Addr:    fmt.Sprintf(":%v", config.TLSPort),
+			Handler: handler,
+			TLSConfig: &tls.Config{
+				GetCertificate: httpTriggerSet.certs.getCertificate,
+				MinVersion:     tls.VersionTLS12,
+			},
+		})
+		log.Printf("Serving HTTPS at port %v", config.TLSPort)
+		if config.HTTPSRedirectPort > 0 {
+			handler = handlers.LoggingHandler(os.Stdout, httpsRedirectHandler(config.HTTPSRedirectPort))
+		}
+	}
+	servers = append(servers, &http.Server{
+		Addr:    fmt.Sprintf(":%v", port),
+		Handler: handler,
+	})
+
+	errs := make(chan error, len(servers))
+	for _, server := range servers {
+		go func(server *http.Server) {
+			errs <- fission.ServeUntilStopped(server, stop, config.ShutdownTimeout)
+		}(server)
+	}
+	for range servers {
+		err := <-errs
+		if err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
-func Start(port int, controllerUrl string, poolmgrUrl string) {
+// Start runs the router until the process gets SIGTERM, and then
+// stops gracefully: no new connections are accepted, in-flight
+// requests get up to config.ShutdownTimeout to finish, and the
+// background watchers are stopped.
+func Start(port int, controllerUrl string, poolmgrUrl string, config Config) error {
+	stop := fission.ShutdownSignal()
+
 	fmap := makeFunctionServiceMap(time.Minute)
 	controller := controllerClient.MakeClient(controllerUrl)
 	poolmgr := poolmgrClient.MakeClient(poolmgrUrl)
 
-	triggers := makeHTTPTriggerSet(fmap, controller, poolmgr)
+	coldStarts := makeColdStartQueue(config.ColdStartQueueDepth, config.ColdStartTimeout)
+
+	invoker := makeAsyncInvoker(makeMemoryInvocationStore(INVOCATION_EXPIRY, INVOCATION_STORE_SIZE))
+
+	var cache *responseCache
+	if config.ResponseCacheSize > 0 {
+		cache = makeResponseCache(config.ResponseCacheSize)
+	}
+
+	triggers := makeHTTPTriggerSet(fmap, controller, poolmgr, coldStarts, invoker, cache)
+	if config.TLSPort > 0 {
+		certs, err := makeConfiguredCertStore(config)
+		if err != nil {
+			return fmt.Errorf("failed to load TLS certificates: %v", err)
+		}
+		go certs.watch(TLS_RELOAD_INTERVAL, stop)
+		triggers.certs = certs
+	}
+
+	go watchEvictions(fmap, poolmgr, stop)
 	log.Printf("Starting router at port %v\n", port)
-	serve(port, triggers)
+	err := serve(port, triggers, config, stop)
+	if err != nil {
+		return err
+	}
+	log.Printf("Router stopped")
+	return nil
 }
@@ -17,11 +17,19 @@ limitations under the License.
 package router
 
 import (
+	"encoding/json"
 	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
 	"testing"
 	"time"
 
+	"github.com/gorilla/mux"
+
 	"github.com/fission/fission"
+	controllerClient "github.com/fission/fission/controller/client"
 )
 
 func TestRouter(t *testing.T) {
@@ -31,16 +39,230 @@ func TestRouter(t *testing.T) {
 	testResponseString := "hi"
 	testServiceUrl := createBackendService(testResponseString)
 
-	fmap.assign(fn, testServiceUrl)
+	fmap.assign(fn, []*url.URL{testServiceUrl})
 
-	triggers := makeHTTPTriggerSet(fmap, nil, nil)
+	triggers := makeHTTPTriggerSet(fmap, nil, nil, nil, nil, nil)
 	triggerUrl := "/foo"
 	triggers.triggers = append(triggers.triggers, fission.HTTPTrigger{UrlPattern: triggerUrl, Function: *fn, Method: "GET"})
+	triggers.functions = map[string]functionRoute{"foo": {uid: "xxx"}}
 
 	port := 4242
-	go serve(port, triggers)
+	config := DefaultConfig()
+	config.InternalPort = 4244
+	go serve(port, triggers, config, nil)
 	time.Sleep(100 * time.Millisecond)
 
 	testUrl := fmt.Sprintf("http://localhost:%v%v", port, triggerUrl)
 	testRequest(testUrl, testResponseString)
+
+	// Function routes are only served on the internal port.
+	testRequest(fmt.Sprintf("http://localhost:%v/fission-function/foo", config.InternalPort), testResponseString)
+	resp, err := http.Get(fmt.Sprintf("http://localhost:%v/fission-function/foo", port))
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("expected function routes not to be served publicly, got %v", resp.StatusCode)
+	}
+}
+
+func TestRouteUpdates(t *testing.T) {
+	triggers := makeHTTPTriggerSet(makeFunctionServiceMap(0), nil, nil, nil, nil, nil)
+	triggers.mutableRouter = NewMutableRouter(mux.NewRouter())
+
+	httpTriggers := []fission.HTTPTrigger{
+		{Metadata: fission.Metadata{Name: "t1"}, UrlPattern: "/foo", Method: "GET", Function: fission.Metadata{Name: "foo"}},
+		{Metadata: fission.Metadata{Name: "t2"}, UrlPattern: "/bar", Method: "GET", Function: fission.Metadata{Name: "bar"}},
+	}
+	functions := map[string]functionRoute{
+		"foo": {uid: "foo1"},
+		"bar": {uid: "bar1"},
+	}
+	if !triggers.update(httpTriggers, functions) {
+		t.Fatalf("first update must change the router")
+	}
+	gen := triggers.mutableRouter.getGeneration()
+	fooHandler := triggers.handlers["trigger/t1"]
+	if fooHandler.Function.Uid != "foo1" {
+		t.Fatalf("trigger must route to the latest version, got %v", fooHandler.Function)
+	}
+
+	// same routes: no new router
+	if triggers.update(httpTriggers, map[string]functionRoute{"foo": {uid: "foo1"}, "bar": {uid: "bar1"}}) {
+		t.Fatalf("update without changes must not change the router")
+	}
+	if triggers.mutableRouter.getGeneration() != gen {
+		t.Fatalf("generation changed without route changes")
+	}
+
+	// new version of bar: foo's handler is kept
+	if !triggers.update(httpTriggers, map[string]functionRoute{"foo": {uid: "foo1"}, "bar": {uid: "bar2"}}) {
+		t.Fatalf("new function version must change the router")
+	}
+	if triggers.mutableRouter.getGeneration() != gen+1 {
+		t.Fatalf("generation must increase on route changes")
+	}
+	if triggers.handlers["trigger/t1"] != fooHandler {
+		t.Fatalf("unchanged route must keep its handler")
+	}
+	if triggers.handlers["trigger/t2"].Function.Uid != "bar2" {
+		t.Fatalf("changed route must use the new version")
+	}
+}
+
+func TestHostAndPrefixRouting(t *testing.T) {
+	fmap := makeFunctionServiceMap(0)
+	functions := make(map[string]functionRoute)
+	for _, name := range []string{"orders", "special", "admin", "any"} {
+		fn := &fission.Metadata{Name: name, Uid: name + "1"}
+		fmap.assign(fn, []*url.URL{createBackendService(name)})
+		functions[name] = functionRoute{uid: fn.Uid}
+	}
+
+	triggers := makeHTTPTriggerSet(fmap, nil, nil, nil, nil, nil)
+	triggers.mutableRouter = NewMutableRouter(mux.NewRouter())
+	triggers.update([]fission.HTTPTrigger{
+		{Metadata: fission.Metadata{Name: "t1"}, PathPrefix: "/v2/orders/", Methods: []string{"GET", "POST"}, Function: fission.Metadata{Name: "orders"}},
+		{Metadata: fission.Metadata{Name: "t2"}, UrlPattern: "/v2/orders/special", Method: "GET", Function: fission.Metadata{Name: "special"}},
+		{Metadata: fission.Metadata{Name: "t3"}, Host: "admin.example.com", PathPrefix: "/", Function: fission.Metadata{Name: "admin"}},
+		{Metadata: fission.Metadata{Name: "t4"}, UrlPattern: "/any", Methods: []string{"ANY"}, Function: fission.Metadata{Name: "any"}},
+	}, functions)
+
+	tests := []struct {
+		method, host, path string
+		body               string // empty if no trigger matches
+	}{
+		{"GET", "api.example.com", "/v2/orders/42", "orders"},
+		{"POST", "api.example.com", "/v2/orders/42/items", "orders"},
+		{"DELETE", "api.example.com", "/v2/orders/42", ""},
+		{"GET", "api.example.com", "/v2/orders/special", "special"},
+		{"GET", "admin.example.com", "/v2/orders/42", "admin"},
+		{"PATCH", "api.example.com", "/any", "any"},
+		{"GET", "api.example.com", "/none", ""},
+	}
+	for _, test := range tests {
+		req := httptest.NewRequest(test.method, "http://"+test.host+test.path, nil)
+		w := httptest.NewRecorder()
+		triggers.mutableRouter.ServeHTTP(w, req)
+		if len(test.body) == 0 {
+			// 404 or 405, depending on the mux version
+			if w.Code < 400 {
+				t.Errorf("%v %v%v: expected no match, got status %v", test.method, test.host, test.path, w.Code)
+			}
+			continue
+		}
+		if w.Code != 200 || w.Body.String() != test.body {
+			t.Errorf("%v %v%v: expected %q, got %v %q", test.method, test.host, test.path, test.body, w.Code, w.Body.String())
+		}
+	}
+}
+
+func TestGracefulShutdown(t *testing.T) {
+	started := make(chan bool, 1)
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		started <- true
+		time.Sleep(200 * time.Millisecond)
+		w.Write([]byte("done"))
+	}))
+	defer backend.Close()
+	backendUrl, _ := url.Parse(backend.URL)
+
+	fmap := makeFunctionServiceMap(0)
+	fn := &fission.Metadata{Name: "slow", Uid: "xxx"}
+	fmap.assign(fn, []*url.URL{backendUrl})
+	triggers := makeHTTPTriggerSet(fmap, nil, nil, nil, nil, nil)
+	triggers.triggers = append(triggers.triggers, fission.HTTPTrigger{UrlPattern: "/slow", Function: *fn, Method: "GET"})
+
+	port := 4243
+	stop := make(chan struct{})
+	served := make(chan error)
+	config := DefaultConfig()
+	config.InternalPort = 0
+	go func() {
+		served <- serve(port, triggers, config, stop)
+	}()
+	time.Sleep(100 * time.Millisecond)
+
+	testUrl := fmt.Sprintf("http://localhost:%v/slow", port)
+	responses := make(chan string)
+	go func() {
+		resp, err := http.Get(testUrl)
+		if err != nil {
+			responses <- err.Error()
+			return
+		}
+		defer resp.Body.Close()
+		body, _ := ioutil.ReadAll(resp.Body)
+		responses <- string(body)
+	}()
+
+	// The in-flight request finishes, and the router stops once
+	// it has.
+	<-started
+	close(stop)
+	if body := <-responses; body != "done" {
+		t.Fatalf("in-flight request must finish, got %q", body)
+	}
+	if err := <-served; err != nil {
+		t.Fatalf("shutdown failed: %v", err)
+	}
+	if _, err := http.Get(testUrl); err == nil {
+		t.Fatalf("stopped router must not accept connections")
+	}
+
+	// Requests still in flight after the shutdown timeout are cut off.
+	config.ShutdownTimeout = 50 * time.Millisecond
+	stop = make(chan struct{})
+	go func() {
+		served <- serve(port, triggers, config, stop)
+	}()
+	time.Sleep(100 * time.Millisecond)
+	go func() {
+		resp, err := http.Get(testUrl)
+		if err == nil {
+			resp.Body.Close()
+		}
+	}()
+	<-started
+	close(stop)
+	if err := <-served; err == nil {
+		t.Fatalf("expected an error when requests don't finish in time")
+	}
+}
+
+func TestPinnedVersionTimeout(t *testing.T) {
+	gets := 0
+	controller := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gets++
+		if r.URL.Path != "/v1/functions/foo" || r.URL.Query().Get("uid") != "foo1" {
+			http.NotFound(w, r)
+			return
+		}
+		json.NewEncoder(w).Encode(fission.Function{Metadata: fission.Metadata{Name: "foo", Uid: "foo1"}, Timeout: 5})
+	}))
+	defer controller.Close()
+
+	triggers := makeHTTPTriggerSet(makeFunctionServiceMap(0), controllerClient.MakeClient(controller.URL), nil, nil, nil, nil)
+	triggers.mutableRouter = NewMutableRouter(mux.NewRouter())
+	httpTriggers := []fission.HTTPTrigger{
+		{Metadata: fission.Metadata{Name: "t1"}, UrlPattern: "/old", Function: fission.Metadata{Name: "foo", Uid: "foo1"}},
+		{Metadata: fission.Metadata{Name: "t2"}, UrlPattern: "/new", Function: fission.Metadata{Name: "foo"}},
+	}
+	functions := []fission.Function{{Metadata: fission.Metadata{Name: "foo", Uid: "foo2"}, Timeout: 60}}
+
+	routes := makeFunctionRoutes(functions, triggers.getPinnedVersions(httpTriggers, functions), nil)
+	triggers.update(httpTriggers, routes)
+	if timeout := triggers.handlers["trigger/t1"].timeout; timeout != 5*time.Second {
+		t.Errorf("expected the pinned version's timeout, got %v", timeout)
+	}
+	if timeout := triggers.handlers["trigger/t2"].timeout; timeout != 60*time.Second {
+		t.Errorf("expected the latest version's timeout, got %v", timeout)
+	}
+
+	// Versions don't change, so they're only fetched once.
+	triggers.getPinnedVersions(httpTriggers, functions)
+	if gets != 1 {
+		t.Errorf("expected the pinned version to be fetched once, got %v", gets)
+	}
 }
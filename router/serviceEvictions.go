@@ -0,0 +1,64 @@
+/*
+Copyright 2016 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package router
+
+import (
+	"log"
+	"time"
+
+	"github.com/fission/fission"
+	poolmgrClient "github.com/fission/fission/poolmgr/client"
+)
+
+// applyEvictions removes the services poolmgr has deleted from the
+// router's cache, and returns the sequence number to poll from next.
+func applyEvictions(fmap *functionServiceMap, ev *fission.ServiceEvictions) uint64 {
+	if ev.Reset {
+		log.Printf("Lost track of poolmgr service evictions, forgetting all services")
+		fmap.reset()
+		return ev.Seq
+	}
+	if len(ev.Addresses) > 0 {
+		count := fmap.evict(ev.Addresses)
+		log.Printf("Evicted %v services removed by poolmgr (%v cached)", len(ev.Addresses), count)
+	}
+	return ev.Seq
+}
+
+// watchEvictions polls poolmgr for function services it has removed
+// (e.g. idle pods that were reaped), so that requests aren't sent to
+// them until their cache entries expire.  It stops when stop is
+// closed.
+func watchEvictions(fmap *functionServiceMap, poolmgr *poolmgrClient.Client, stop <-chan struct{}) {
+	// amount of time to sleep between polling calls
+	pollSleepDuration := 1 * time.Second
+
+	var seq uint64
+	failureCount := 0
+	for sleepUntilStopped(pollSleepDuration, stop) {
+		ev, err := poolmgr.ServiceEvictions(seq)
+		if err != nil {
+			if failureCount == 0 {
+				log.Printf("Failed to get service evictions from poolmgr: %v", err)
+			}
+			failureCount++
+			continue
+		}
+		failureCount = 0
+		seq = applyEvictions(fmap, ev)
+	}
+}
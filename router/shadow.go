@@ -0,0 +1,268 @@
+/*
+Copyright 2016 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package router
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/fission/fission"
+)
+
+const (
+	// Request header telling a shadow function that the request
+	// was mirrored, and its response will be discarded.
+	HEADER_SHADOW = "X-Fission-Shadow"
+
+	// Requests with larger bodies aren't mirrored.
+	SHADOW_MAX_BODY_SIZE = 1024 * 1024
+	// Max mirrored requests in flight per trigger; requests
+	// sampled while that many are running aren't mirrored.
+	SHADOW_MAX_IN_FLIGHT = 100
+	// Max time a mirrored request may take, including getting a
+	// service for the shadow function.
+	SHADOW_TIMEOUT = 1 * time.Minute
+)
+
+type (
+	// shadowMirror mirrors a sample of a trigger's requests to its
+	// shadow function, and compares the responses.  Mirrored
+	// requests run in the background, so they don't delay the
+	// trigger's function.
+	shadowMirror struct {
+		policy  fission.ShadowPolicy // with the shadow's uid resolved
+		trigger string
+		primary fission.Metadata
+		fh      *functionHandler // serves the shadow function
+		running chan bool        // semaphore, SHADOW_MAX_IN_FLIGHT slots
+
+		lock           sync.Mutex
+		stats          fission.ShadowStats
+		primaryLatency time.Duration // totals, for the means
+		shadowLatency  time.Duration
+	}
+
+	// shadowResult is the response status of a function, and the
+	// time it took to respond.
+	shadowResult struct {
+		statusCode int
+		latency    time.Duration
+	}
+
+	// statusRecorder passes a response through, recording its
+	// status.
+	statusRecorder struct {
+		http.ResponseWriter
+		statusCode int
+	}
+
+	// discardResponseWriter drops a response, except for its
+	// status.
+	discardResponseWriter struct {
+		header     http.Header
+		statusCode int
+	}
+)
+
+func makeShadowMirror(policy *fission.ShadowPolicy, trigger string, primary fission.Metadata, fh *functionHandler) *shadowMirror {
+	return &shadowMirror{
+		policy:  *policy,
+		trigger: trigger,
+		primary: primary,
+		fh:      fh,
+		running: make(chan bool, SHADOW_MAX_IN_FLIGHT),
+		stats: fission.ShadowStats{
+			Trigger:          trigger,
+			Function:         primary,
+			Shadow:           policy.Function,
+			Since:            time.Now(),
+			StatusMismatches: make(map[string]int64),
+		},
+	}
+}
+
+// serve calls next to serve a request with the trigger's function,
+// and if the request is sampled, sends a copy of it to the shadow
+// function.  Connection upgrades aren't mirrored.
+func (sm *shadowMirror) serve(responseWriter http.ResponseWriter, request *http.Request, next http.HandlerFunc) {
+	if isUpgrade(request) || rand.Float64() >= sm.policy.SampleRate {
+		next(responseWriter, request)
+		return
+	}
+	select {
+	case sm.running <- true:
+	default:
+		sm.drop()
+		next(responseWriter, request)
+		return
+	}
+
+	shadowRequest, ok := sm.copyRequest(request)
+	if !ok {
+		<-sm.running
+		sm.drop()
+		next(responseWriter, request)
+		return
+	}
+
+	primaryDone := make(chan shadowResult, 1)
+	go sm.run(shadowRequest, primaryDone)
+
+	start := time.Now()
+	recorder := &statusRecorder{ResponseWriter: responseWriter, statusCode: http.StatusOK}
+	defer func() {
+		primaryDone <- shadowResult{recorder.statusCode, time.Since(start)}
+	}()
+	next(recorder, request)
+}
+
+// copyRequest returns a copy of request for the shadow function,
+// which doesn't depend on the client's request.  The request's body
+// is buffered so both functions can read it; if it's too large, the
+// request isn't copied.
+func (sm *shadowMirror) copyRequest(request *http.Request) (*http.Request, bool) {
+	var body []byte
+	if request.Body != nil && request.Body != http.NoBody {
+		var err error
+		body, err = ioutil.ReadAll(io.LimitReader(request.Body, SHADOW_MAX_BODY_SIZE+1))
+		// The function gets the whole body, whether or not it
+		// was mirrored.
+		request.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(body), request.Body), request.Body}
+		if err != nil || len(body) > SHADOW_MAX_BODY_SIZE {
+			return nil, false
+		}
+	}
+
+	req, err := http.NewRequest(request.Method, request.URL.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, false
+	}
+	req.Host = request.Host
+	req.RemoteAddr = request.RemoteAddr
+	req.Header = cloneHeader(request.Header)
+	req.Header.Set(HEADER_SHADOW, "true")
+	req.ContentLength = int64(len(body))
+	return req, true
+}
+
+// run sends a mirrored request to the shadow function, and records
+// how its response compares to the trigger function's.
+func (sm *shadowMirror) run(request *http.Request, primaryDone <-chan shadowResult) {
+	defer func() { <-sm.running }()
+
+	ctx, cancel := context.WithTimeout(context.Background(), SHADOW_TIMEOUT)
+	defer cancel()
+
+	start := time.Now()
+	w := &discardResponseWriter{header: make(http.Header), statusCode: http.StatusOK}
+	sm.fh.serve(w, request.WithContext(ctx))
+	shadow := shadowResult{w.statusCode, time.Since(start)}
+
+	sm.record(<-primaryDone, shadow)
+}
+
+func (sm *shadowMirror) record(primary shadowResult, shadow shadowResult) {
+	sm.lock.Lock()
+	defer sm.lock.Unlock()
+
+	s := &sm.stats
+	s.Mirrored++
+	if primary.statusCode == shadow.statusCode {
+		s.StatusMatches++
+	} else {
+		s.StatusMismatches[fmt.Sprintf("%v->%v", primary.statusCode, shadow.statusCode)]++
+	}
+	sm.primaryLatency += primary.latency
+	sm.shadowLatency += shadow.latency
+	s.FunctionLatency = updateLatencyStats(s.FunctionLatency, sm.primaryLatency, primary.latency, s.Mirrored)
+	s.ShadowLatency = updateLatencyStats(s.ShadowLatency, sm.shadowLatency, shadow.latency, s.Mirrored)
+}
+
+func updateLatencyStats(ls fission.LatencyStats, total time.Duration, latency time.Duration, count int64) fission.LatencyStats {
+	ls.MeanMs = durationMs(total) / float64(count)
+	if ms := durationMs(latency); ms > ls.MaxMs {
+		ls.MaxMs = ms
+	}
+	return ls
+}
+
+func durationMs(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+func (sm *shadowMirror) drop() {
+	sm.lock.Lock()
+	defer sm.lock.Unlock()
+	sm.stats.Dropped++
+}
+
+// getStats returns a copy of the mirror's stats.
+func (sm *shadowMirror) getStats() fission.ShadowStats {
+	sm.lock.Lock()
+	defer sm.lock.Unlock()
+	stats := sm.stats
+	stats.StatusMismatches = make(map[string]int64, len(sm.stats.StatusMismatches))
+	for k, v := range sm.stats.StatusMismatches {
+		stats.StatusMismatches[k] = v
+	}
+	return stats
+}
+
+// sameShadow returns true if a mirror compares function primary to
+// the shadow of policy, with the shadow's uid resolved, running
+// with the given timeout.
+func sameShadow(sm *shadowMirror, policy *fission.ShadowPolicy, primary fission.Metadata, timeout time.Duration) bool {
+	if sm == nil || policy == nil {
+		return sm == nil && policy == nil
+	}
+	return sm.policy == *policy && sm.primary == primary && sm.fh.timeout == timeout
+}
+
+func (w *statusRecorder) WriteHeader(statusCode int) {
+	w.statusCode = statusCode
+	w.ResponseWriter.WriteHeader(statusCode)
+}
+
+// Flush passes flushes through, so streamed responses aren't held
+// back by mirroring.
+func (w *statusRecorder) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *discardResponseWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *discardResponseWriter) WriteHeader(statusCode int) {
+	w.statusCode = statusCode
+}
+
+func (w *discardResponseWriter) Write(b []byte) (int, error) {
+	return len(b), nil
+}
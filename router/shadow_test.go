@@ -0,0 +1,132 @@
+/*
+Copyright 2016 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package router
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.com/fission/fission"
+)
+
+func TestShadowTrigger(t *testing.T) {
+	type received struct {
+		shadow string
+		body   string
+	}
+	var lock sync.Mutex
+	var shadowRequests []received
+	shadowDone := make(chan bool, 10)
+
+	primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("v1"))
+	}))
+	defer primary.Close()
+	shadow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		lock.Lock()
+		shadowRequests = append(shadowRequests, received{r.Header.Get(HEADER_SHADOW), string(body)})
+		lock.Unlock()
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("v2"))
+		shadowDone <- true
+	}))
+	defer shadow.Close()
+	primaryURL, _ := url.Parse(primary.URL)
+	shadowURL, _ := url.Parse(shadow.URL)
+
+	fmap := makeFunctionServiceMap(0)
+	v1 := fission.Metadata{Name: "hello", Uid: "v1"}
+	v2 := fission.Metadata{Name: "hello-next", Uid: "v2"}
+	fmap.assign(&v1, []*url.URL{primaryURL})
+	fmap.assign(&v2, []*url.URL{shadowURL})
+	functions := map[string]functionRoute{"hello": {uid: v1.Uid}, "hello-next": {uid: v2.Uid}}
+
+	triggers := makeHTTPTriggerSet(fmap, nil, nil, nil, nil, nil)
+	triggers.mutableRouter = NewMutableRouter(mux.NewRouter())
+	trigger := fission.HTTPTrigger{
+		Metadata:   fission.Metadata{Name: "t1"},
+		UrlPattern: "/hello",
+		Methods:    []string{"POST"},
+		Function:   fission.Metadata{Name: "hello"},
+		Shadow: &fission.ShadowPolicy{
+			Function:   fission.Metadata{Name: "hello-next"},
+			SampleRate: 1,
+		},
+	}
+	triggers.update([]fission.HTTPTrigger{trigger}, functions)
+
+	// The client gets the function's response, and the shadow gets
+	// the same request.
+	for i := 0; i < 2; i++ {
+		req := httptest.NewRequest("POST", "http://example.com/hello", strings.NewReader("payload"))
+		req.Header.Set(HEADER_SHADOW, "spoofed")
+		w := httptest.NewRecorder()
+		triggers.mutableRouter.ServeHTTP(w, req)
+		if w.Code != http.StatusOK || w.Body.String() != "v1" {
+			t.Fatalf("expected the function's response, got %v %q", w.Code, w.Body.String())
+		}
+		select {
+		case <-shadowDone:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("request wasn't mirrored to the shadow")
+		}
+	}
+	lock.Lock()
+	for _, r := range shadowRequests {
+		if r.shadow != "true" || r.body != "payload" {
+			t.Errorf("unexpected mirrored request %+v", r)
+		}
+	}
+	lock.Unlock()
+
+	// Stats are recorded once both functions have responded.
+	var stats fission.ShadowStats
+	for i := 0; i < 50; i++ {
+		stats = triggers.currentRoutes().shadows[0].getStats()
+		if stats.Mirrored == 2 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if stats.Mirrored != 2 || stats.StatusMatches != 0 || stats.StatusMismatches["200->500"] != 2 ||
+		stats.Shadow != v2 || stats.Function != v1 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+
+	// Stats are kept across updates that don't change the versions
+	// compared, and reset when they do.
+	functions["unrelated"] = functionRoute{uid: "u1"}
+	triggers.update([]fission.HTTPTrigger{trigger}, functions)
+	if triggers.currentRoutes().shadows[0].getStats().Mirrored != 2 {
+		t.Errorf("stats were reset by an unrelated update")
+	}
+	functions = map[string]functionRoute{"hello": {uid: v1.Uid}, "hello-next": {uid: "v3"}}
+	triggers.update([]fission.HTTPTrigger{trigger}, functions)
+	stats = triggers.currentRoutes().shadows[0].getStats()
+	if stats.Mirrored != 0 || stats.Shadow.Uid != "v3" {
+		t.Errorf("stats weren't reset for a new shadow version: %+v", stats)
+	}
+}
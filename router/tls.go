@@ -0,0 +1,287 @@
+/*
+Copyright 2016 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package router
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"k8s.io/client-go/1.5/kubernetes"
+)
+
+// How often certificates are reloaded from their source.
+const TLS_RELOAD_INTERVAL = 30 * time.Second
+
+type (
+	// certPair is a PEM encoded certificate chain and its key.
+	certPair struct {
+		name string // where it came from, for logs
+		cert []byte
+		key  []byte
+	}
+
+	// certSource loads certificates.
+	certSource interface {
+		load() ([]certPair, error)
+	}
+
+	// fileCertSource loads certificates from a directory, which has
+	// NAME.crt and NAME.key file pairs, or tls.crt and tls.key (the
+	// layout of a mounted Kubernetes TLS secret) in it or in its
+	// subdirectories.
+	fileCertSource struct {
+		dir string
+	}
+
+	// secretCertSource loads certificates from Kubernetes TLS
+	// secrets.
+	secretCertSource struct {
+		client  *kubernetes.Clientset
+		secrets []string // namespace/name
+	}
+
+	// certStore has the router's certificates, and picks one for
+	// each TLS connection by server name (SNI).
+	certStore struct {
+		sources []certSource
+
+		lock        sync.RWMutex
+		pairs       []certPair
+		byName      map[string]*tls.Certificate // by lowercase DNS name, e.g. "*.example.com"
+		defaultCert *tls.Certificate            // for clients without SNI, or unknown names
+	}
+)
+
+func (s *fileCertSource) load() ([]certPair, error) {
+	pairs := make([]certPair, 0)
+	dirs := []string{s.dir}
+	entries, err := ioutil.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		path := filepath.Join(s.dir, name)
+		if strings.HasPrefix(name, "..") {
+			// kubernetes' atomic update directories
+			continue
+		}
+		if info, err := os.Stat(path); err == nil && info.IsDir() {
+			dirs = append(dirs, path)
+			continue
+		}
+		if strings.HasSuffix(name, ".crt") && name != "tls.crt" {
+			pair, err := readCertPair(path, strings.TrimSuffix(path, ".crt")+".key")
+			if err != nil {
+				return nil, err
+			}
+			pairs = append(pairs, *pair)
+		}
+	}
+	for _, dir := range dirs {
+		certFile := filepath.Join(dir, "tls.crt")
+		if _, err := os.Stat(certFile); err != nil {
+			continue
+		}
+		pair, err := readCertPair(certFile, filepath.Join(dir, "tls.key"))
+		if err != nil {
+			return nil, err
+		}
+		pairs = append(pairs, *pair)
+	}
+	return pairs, nil
+}
+
+func readCertPair(certFile, keyFile string) (*certPair, error) {
+	cert, err := ioutil.ReadFile(certFile)
+	if err != nil {
+		return nil, err
+	}
+	key, err := ioutil.ReadFile(keyFile)
+	if err != nil {
+		return nil, err
+	}
+	return &certPair{name: certFile, cert: cert, key: key}, nil
+}
+
+func (s *secretCertSource) load() ([]certPair, error) {
+	pairs := make([]certPair, 0, len(s.secrets))
+	for _, secret := range s.secrets {
+		parts := strings.SplitN(secret, "/", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid secret '%v', use namespace/name", secret)
+		}
+		sec, err := s.client.Core().Secrets(parts[0]).Get(parts[1])
+		if err != nil {
+			return nil, fmt.Errorf("failed to get secret %v: %v", secret, err)
+		}
+		pairs = append(pairs, certPair{
+			name: "secret " + secret,
+			cert: sec.Data["tls.crt"],
+			key:  sec.Data["tls.key"],
+		})
+	}
+	return pairs, nil
+}
+
+func makeCertStore(sources []certSource) *certStore {
+	return &certStore{sources: sources}
+}
+
+// reload loads the certificates from the sources, and starts using
+// them if they changed.  If any can't be loaded, the current ones are
+// kept.
+func (cs *certStore) reload() error {
+	pairs := make([]certPair, 0)
+	for _, source := range cs.sources {
+		p, err := source.load()
+		if err != nil {
+			return err
+		}
+		pairs = append(pairs, p...)
+	}
+
+	cs.lock.RLock()
+	changed := !sameCertPairs(pairs, cs.pairs)
+	cs.lock.RUnlock()
+	if !changed {
+		return nil
+	}
+
+	byName := make(map[string]*tls.Certificate)
+	var defaultCert *tls.Certificate
+	for _, pair := range pairs {
+		cert, err := tls.X509KeyPair(pair.cert, pair.key)
+		if err != nil {
+			return fmt.Errorf("invalid certificate %v: %v", pair.name, err)
+		}
+		cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0])
+		if err != nil {
+			return fmt.Errorf("invalid certificate %v: %v", pair.name, err)
+		}
+		names := cert.Leaf.DNSNames
+		if len(names) == 0 && len(cert.Leaf.Subject.CommonName) > 0 {
+			names = []string{cert.Leaf.Subject.CommonName}
+		}
+		for _, name := range names {
+			byName[strings.ToLower(name)] = &cert
+		}
+		if defaultCert == nil {
+			defaultCert = &cert
+		}
+	}
+	if defaultCert == nil {
+		return errors.New("no TLS certificates found")
+	}
+
+	cs.lock.Lock()
+	defer cs.lock.Unlock()
+	cs.pairs = pairs
+	cs.byName = byName
+	cs.defaultCert = defaultCert
+	log.Printf("Loaded %v TLS certificates", len(pairs))
+	return nil
+}
+
+func sameCertPairs(a, b []certPair) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].name != b[i].name || !bytes.Equal(a[i].cert, b[i].cert) || !bytes.Equal(a[i].key, b[i].key) {
+			return false
+		}
+	}
+	return true
+}
+
+// watch reloads certificates every interval, so that renewed ones are
+// used without restarting the router, until stop is closed.
+func (cs *certStore) watch(interval time.Duration, stop <-chan struct{}) {
+	for sleepUntilStopped(interval, stop) {
+		err := cs.reload()
+		if err != nil {
+			log.Printf("Failed to reload TLS certificates, keeping the current ones: %v", err)
+		}
+	}
+}
+
+// lookup returns the certificate for a server name: one for that
+// name, or a wildcard certificate for its parent domain.
+func (cs *certStore) lookup(serverName string) (*tls.Certificate, bool) {
+	cs.lock.RLock()
+	defer cs.lock.RUnlock()
+	name := strings.ToLower(strings.TrimSuffix(serverName, "."))
+	if cert, ok := cs.byName[name]; ok {
+		return cert, true
+	}
+	if i := strings.Index(name, "."); i > 0 {
+		if cert, ok := cs.byName["*"+name[i:]]; ok {
+			return cert, true
+		}
+	}
+	return cs.defaultCert, false
+}
+
+// getCertificate is the tls.Config callback picking the certificate
+// of a connection.
+func (cs *certStore) getCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	cert, _ := cs.lookup(hello.ServerName)
+	if cert == nil {
+		return nil, errors.New("no TLS certificates")
+	}
+	return cert, nil
+}
+
+// hasCertificate returns true if there's a certificate for host
+// (which may have a port).
+func (cs *certStore) hasCertificate(host string) bool {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	_, ok := cs.lookup(host)
+	return ok
+}
+
+// httpsRedirectHandler redirects plain HTTP requests to the router's
+// HTTPS port.  Callers inside the cluster use the internal port, which
+// isn't redirected.
+func httpsRedirectHandler(tlsPort int) http.Handler {
+	return http.HandlerFunc(func(responseWriter http.ResponseWriter, request *http.Request) {
+		host := request.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if tlsPort != 443 {
+			host = net.JoinHostPort(host, fmt.Sprintf("%v", tlsPort))
+		}
+		target := "https://" + host + request.URL.RequestURI()
+		http.Redirect(responseWriter, request, target, http.StatusPermanentRedirect)
+	})
+}
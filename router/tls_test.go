@@ -0,0 +1,172 @@
+/*
+Copyright 2016 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package router
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeTestCert writes a self-signed certificate for names to
+// dir/file.crt and dir/file.key.
+func writeTestCert(t *testing.T, dir string, file string, names ...string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: names[0]},
+		DNSNames:     names,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("failed to create certificate: %v", err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("failed to marshal key: %v", err)
+	}
+	err = ioutil.WriteFile(filepath.Join(dir, file+".crt"), pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)
+	if err == nil {
+		err = ioutil.WriteFile(filepath.Join(dir, file+".key"), pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600)
+	}
+	if err != nil {
+		t.Fatalf("failed to write certificate: %v", err)
+	}
+}
+
+func TestCertStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "router-tls")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	// a NAME.crt pair, and a mounted secret
+	writeTestCert(t, dir, "api", "api.example.com")
+	err = os.Mkdir(filepath.Join(dir, "wildcard"), 0700)
+	if err != nil {
+		t.Fatalf("failed to create dir: %v", err)
+	}
+	writeTestCert(t, filepath.Join(dir, "wildcard"), "tls", "*.example.org")
+
+	cs := makeCertStore([]certSource{&fileCertSource{dir: dir}})
+	err = cs.reload()
+	if err != nil {
+		t.Fatalf("failed to load certificates: %v", err)
+	}
+
+	tests := []struct {
+		serverName string
+		name       string // of the certificate expected
+		found      bool
+	}{
+		{"api.example.com", "api.example.com", true},
+		{"API.example.com", "api.example.com", true},
+		{"www.example.org", "*.example.org", true},
+		{"a.b.example.org", "api.example.com", false},
+		{"", "api.example.com", false},
+	}
+	for _, test := range tests {
+		cert, found := cs.lookup(test.serverName)
+		if found != test.found || cert.Leaf.DNSNames[0] != test.name {
+			t.Errorf("%v: expected %v (found %v), got %v (found %v)",
+				test.serverName, test.name, test.found, cert.Leaf.DNSNames[0], found)
+		}
+	}
+
+	// certificates are picked by SNI during the handshake
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	server.TLS = &tls.Config{GetCertificate: cs.getCertificate}
+	server.StartTLS()
+	defer server.Close()
+	conn, err := tls.Dial("tcp", server.Listener.Addr().String(), &tls.Config{
+		ServerName:         "www.example.org",
+		InsecureSkipVerify: true,
+	})
+	if err != nil {
+		t.Fatalf("TLS handshake failed: %v", err)
+	}
+	peerName := conn.ConnectionState().PeerCertificates[0].DNSNames[0]
+	conn.Close()
+	if peerName != "*.example.org" {
+		t.Errorf("expected the wildcard certificate, got %v", peerName)
+	}
+
+	// renewed certificates are picked up
+	writeTestCert(t, dir, "api", "api.example.com", "api2.example.com")
+	err = cs.reload()
+	if err != nil {
+		t.Fatalf("failed to reload certificates: %v", err)
+	}
+	if !cs.hasCertificate("api2.example.com:443") {
+		t.Errorf("renewed certificate wasn't loaded")
+	}
+
+	// broken certificates don't replace working ones
+	err = ioutil.WriteFile(filepath.Join(dir, "api.key"), []byte("garbage"), 0600)
+	if err != nil {
+		t.Fatalf("failed to write key: %v", err)
+	}
+	if cs.reload() == nil {
+		t.Errorf("expected an invalid key to fail")
+	}
+	if !cs.hasCertificate("api2.example.com") {
+		t.Errorf("certificates were dropped after a failed reload")
+	}
+}
+
+func TestHTTPSRedirect(t *testing.T) {
+	handler := httpsRedirectHandler(8443)
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("POST", "http://api.example.com:8080/orders?id=1", nil))
+	if w.Code != http.StatusPermanentRedirect || w.Header().Get("Location") != "https://api.example.com:8443/orders?id=1" {
+		t.Errorf("expected a redirect to HTTPS, got %v %v", w.Code, w.Header().Get("Location"))
+	}
+
+	// The admin token mustn't be sent in cleartext, and function
+	// routes are only served on the internal port, which doesn't
+	// redirect.
+	for _, path := range []string{ADMIN_URL_PREFIX + "/routes", "/fission-function/foo"} {
+		w = httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("GET", "http://router.fission"+path, nil))
+		if w.Code != http.StatusPermanentRedirect {
+			t.Errorf("expected %v to be redirected, got %v", path, w.Code)
+		}
+	}
+}
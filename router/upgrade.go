@@ -0,0 +1,159 @@
+/*
+Copyright 2016 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package router
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// isUpgrade returns true if request asks to switch protocols, e.g. to
+// WebSocket.
+func isUpgrade(request *http.Request) bool {
+	if len(request.Header.Get("Upgrade")) == 0 {
+		return false
+	}
+	for _, value := range request.Header["Connection"] {
+		for _, token := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(token), "upgrade") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// dialAddress returns the host:port to connect to for a service URL.
+func dialAddress(u *url.URL) string {
+	if _, _, err := net.SplitHostPort(u.Host); err == nil {
+		return u.Host
+	}
+	if u.Scheme == "https" {
+		return net.JoinHostPort(u.Host, "443")
+	}
+	return net.JoinHostPort(u.Host, "80")
+}
+
+// serveUpgrade proxies a protocol upgrade request, which
+// httputil.ReverseProxy can't.  If the function switches protocols,
+// the client's connection is hijacked and bytes are copied both ways
+// until either side closes.  The function's execution timeout only
+// applies to the handshake.
+func (fp *functionProxy) serveUpgrade(responseWriter http.ResponseWriter, request *http.Request) {
+	hijacker, ok := responseWriter.(http.Hijacker)
+	if !ok {
+		// e.g. asynchronous invocations
+		http.Error(responseWriter, "Connection upgrade not supported (fission)", http.StatusBadRequest)
+		return
+	}
+
+	outreq := request.WithContext(request.Context())
+	outreq.Header = make(http.Header, len(request.Header))
+	for k, v := range request.Header {
+		outreq.Header[k] = v
+	}
+	fp.director(outreq)
+	outreq.Header.Set("Connection", "Upgrade")
+
+	conn, err := retryingDial(request.Context(), "tcp", dialAddress(fp.serviceUrl))
+	if err != nil {
+		fp.errorHandler(responseWriter, request, err)
+		return
+	}
+	if deadline, ok := request.Context().Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	err = outreq.Write(conn)
+	if err != nil {
+		conn.Close()
+		fp.errorHandler(responseWriter, request, err)
+		return
+	}
+	serviceReader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(serviceReader, outreq)
+	if err != nil {
+		conn.Close()
+		fp.errorHandler(responseWriter, request, err)
+		return
+	}
+	modifyResponse(resp)
+
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		// The function declined; pass its response on.
+		defer conn.Close()
+		defer resp.Body.Close()
+		for k, v := range resp.Header {
+			responseWriter.Header()[k] = v
+		}
+		responseWriter.WriteHeader(resp.StatusCode)
+		io.Copy(responseWriter, resp.Body)
+		return
+	}
+	conn.SetDeadline(time.Time{})
+
+	clientConn, clientBuf, err := hijacker.Hijack()
+	if err != nil {
+		conn.Close()
+		log.Printf("Failed to hijack connection for %v: %v", request.URL, err)
+		return
+	}
+	defer clientConn.Close()
+	defer conn.Close()
+	clientConn.SetDeadline(time.Time{})
+
+	// Keep headers the router set, e.g. rate limit headers.
+	for k, v := range responseWriter.Header() {
+		if _, ok := resp.Header[k]; !ok {
+			resp.Header[k] = v
+		}
+	}
+	_, err = fmt.Fprintf(clientBuf, "HTTP/1.1 %v\r\n", resp.Status)
+	if err == nil {
+		err = resp.Header.Write(clientBuf)
+	}
+	if err == nil {
+		_, err = clientBuf.WriteString("\r\n")
+	}
+	if err == nil {
+		err = clientBuf.Flush()
+	}
+	if err != nil {
+		log.Printf("Failed to switch protocols for %v: %v", request.URL, err)
+		return
+	}
+
+	// Either side may have sent data right after the handshake,
+	// which is in the buffered readers.
+	done := make(chan struct{}, 2)
+	go func() {
+		io.Copy(conn, clientBuf)
+		done <- struct{}{}
+	}()
+	go func() {
+		io.Copy(clientConn, serviceReader)
+		done <- struct{}{}
+	}()
+	<-done
+}
@@ -0,0 +1,146 @@
+/*
+Copyright 2016 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package router
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fission/fission"
+)
+
+// makeTestFunctionServer serves a function handler for a backend.
+func makeTestFunctionServer(backend *httptest.Server) *httptest.Server {
+	backendURL, _ := url.Parse(backend.URL)
+	fn := &fission.Metadata{Name: "foo", Uid: "xxx"}
+	fmap := makeFunctionServiceMap(0)
+	fmap.assign(fn, []*url.URL{backendURL})
+	fh := &functionHandler{fmap: fmap, Function: *fn, timeout: time.Second}
+	return httptest.NewServer(http.HandlerFunc(fh.handler))
+}
+
+func TestUpgrade(t *testing.T) {
+	// The backend switches to a line echo protocol.
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Upgrade") != "echo" {
+			http.Error(w, "echo only", http.StatusBadRequest)
+			return
+		}
+		conn, buf, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		buf.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: echo\r\nConnection: Upgrade\r\n\r\n")
+		buf.Flush()
+		for {
+			line, err := buf.ReadString('\n')
+			if err != nil {
+				return
+			}
+			buf.WriteString("echo " + line)
+			buf.Flush()
+		}
+	}))
+	defer backend.Close()
+	server := makeTestFunctionServer(backend)
+	defer server.Close()
+
+	dial := func(upgrade string) (net.Conn, *bufio.Reader, *http.Response) {
+		conn, err := net.Dial("tcp", strings.TrimPrefix(server.URL, "http://"))
+		if err != nil {
+			t.Fatalf("failed to connect: %v", err)
+		}
+		fmt.Fprintf(conn, "GET /chat HTTP/1.1\r\nHost: example.com\r\nConnection: keep-alive, Upgrade\r\nUpgrade: %v\r\n\r\n", upgrade)
+		reader := bufio.NewReader(conn)
+		resp, err := http.ReadResponse(reader, nil)
+		if err != nil {
+			t.Fatalf("failed to read response: %v", err)
+		}
+		return conn, reader, resp
+	}
+
+	conn, reader, resp := dial("echo")
+	defer conn.Close()
+	if resp.StatusCode != http.StatusSwitchingProtocols || resp.Header.Get("Upgrade") != "echo" {
+		t.Fatalf("expected 101 to echo, got %v %v", resp.Status, resp.Header)
+	}
+	// the connection outlives the function's timeout
+	time.Sleep(1100 * time.Millisecond)
+	for _, msg := range []string{"hello", "world"} {
+		fmt.Fprintf(conn, "%v\n", msg)
+		line, err := reader.ReadString('\n')
+		if err != nil || line != "echo "+msg+"\n" {
+			t.Fatalf("expected 'echo %v', got '%v' (%v)", msg, line, err)
+		}
+	}
+
+	// refused upgrades get the function's response
+	conn2, _, resp := dial("websocket")
+	defer conn2.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("expected 400 for a refused upgrade, got %v", resp.Status)
+	}
+}
+
+func TestStreamingResponse(t *testing.T) {
+	next := make(chan struct{})
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		for i := 0; i < 2; i++ {
+			fmt.Fprintf(w, "data: %v\n\n", i)
+			w.(http.Flusher).Flush()
+			<-next
+		}
+	}))
+	defer backend.Close()
+	server := makeTestFunctionServer(backend)
+	defer server.Close()
+
+	resp, err := http.Get(server.URL)
+	if err != nil {
+		t.Fatalf("failed to make request: %v", err)
+	}
+	defer resp.Body.Close()
+	reader := bufio.NewReader(resp.Body)
+
+	// each event arrives while the function is still running
+	for i := 0; i < 2; i++ {
+		events := make(chan string)
+		go func() {
+			line, _ := reader.ReadString('\n')
+			reader.ReadString('\n')
+			events <- line
+		}()
+		select {
+		case line := <-events:
+			if line != fmt.Sprintf("data: %v\n", i) {
+				t.Fatalf("expected event %v, got '%v'", i, line)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("event %v wasn't flushed", i)
+		}
+		next <- struct{}{}
+	}
+}
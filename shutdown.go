@@ -0,0 +1,81 @@
+/*
+Copyright 2016 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package fission
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// Default time servers wait for in-flight requests when shutting
+// down.  It's below Kubernetes' default termination grace period
+// (30s), after which the pod is killed.
+const DEFAULT_SHUTDOWN_TIMEOUT = 25 * time.Second
+
+// ShutdownSignal returns a channel that's closed when the process
+// gets SIGTERM (as Kubernetes sends when deleting a pod) or SIGINT.
+// Only the first signal is caught; a second one kills the process.
+func ShutdownSignal() <-chan struct{} {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, os.Interrupt)
+	stop := make(chan struct{})
+	go func() {
+		<-signals
+		signal.Stop(signals)
+		close(stop)
+	}()
+	return stop
+}
+
+// ServeUntilStopped serves HTTP (or, if server has a TLS config,
+// HTTPS) until stop is closed.  It then stops accepting connections,
+// closes idle ones and waits up to timeout for in-flight requests to
+// finish, before closing the rest.  It returns an error if the server
+// couldn't be started or didn't drain in time.
+//
+// Hijacked connections, e.g. WebSockets, aren't waited for.
+func ServeUntilStopped(server *http.Server, stop <-chan struct{}, timeout time.Duration) error {
+	errChan := make(chan error, 1)
+	go func() {
+		if server.TLSConfig != nil {
+			errChan <- server.ListenAndServeTLS("", "")
+		} else {
+			errChan <- server.ListenAndServe()
+		}
+	}()
+
+	select {
+	case err := <-errChan:
+		return err
+	case <-stop:
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	err := server.Shutdown(ctx)
+	if err != nil {
+		server.Close()
+		err = fmt.Errorf("requests still in flight after %v", timeout)
+	}
+	<-errChan // http.ErrServerClosed
+	return err
+}
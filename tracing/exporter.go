@@ -0,0 +1,201 @@
+/*
+Copyright 2016 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tracing
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Timeout of requests to the OTLP collector
+const OTLP_EXPORT_TIMEOUT = 10 * time.Second
+
+type (
+	// LogExporter writes spans as JSON, one per line, for local
+	// testing.
+	LogExporter struct {
+		lock sync.Mutex
+		w    io.Writer
+	}
+
+	// OTLPExporter sends spans to an OpenTelemetry collector, using
+	// the OTLP/HTTP protocol with JSON encoding.
+	OTLPExporter struct {
+		url    string
+		client *http.Client
+	}
+
+	// OTLP/HTTP JSON request body.  Only the fields fission sets
+	// are defined.
+	otlpRequest struct {
+		ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+	}
+	otlpResourceSpans struct {
+		Resource   otlpResource     `json:"resource"`
+		ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+	}
+	otlpResource struct {
+		Attributes []otlpAttribute `json:"attributes"`
+	}
+	otlpScopeSpans struct {
+		Scope otlpScope  `json:"scope"`
+		Spans []otlpSpan `json:"spans"`
+	}
+	otlpScope struct {
+		Name string `json:"name"`
+	}
+	otlpSpan struct {
+		TraceId           string          `json:"traceId"`
+		SpanId            string          `json:"spanId"`
+		ParentSpanId      string          `json:"parentSpanId,omitempty"`
+		Name              string          `json:"name"`
+		Kind              int             `json:"kind"`
+		StartTimeUnixNano string          `json:"startTimeUnixNano"`
+		EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+		Attributes        []otlpAttribute `json:"attributes,omitempty"`
+		Status            otlpStatus      `json:"status"`
+	}
+	otlpAttribute struct {
+		Key   string    `json:"key"`
+		Value otlpValue `json:"value"`
+	}
+	otlpValue struct {
+		StringValue string `json:"stringValue"`
+	}
+	otlpStatus struct {
+		Code    int    `json:"code,omitempty"`
+		Message string `json:"message,omitempty"`
+	}
+)
+
+// OTLP span kind and status codes
+const (
+	otlpSpanKindInternal = 1
+	otlpStatusError      = 2
+)
+
+func MakeLogExporter(w io.Writer) *LogExporter {
+	return &LogExporter{w: w}
+}
+
+func (e *LogExporter) Export(spans []*Span) error {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	for _, s := range spans {
+		line, err := json.Marshal(s)
+		if err != nil {
+			return err
+		}
+		_, err = e.w.Write(append(line, '\n'))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// MakeOTLPExporter creates an exporter for the collector at endpoint,
+// e.g. "http://otel-collector:4318".
+func MakeOTLPExporter(endpoint string) *OTLPExporter {
+	return &OTLPExporter{
+		url:    strings.TrimSuffix(endpoint, "/") + "/v1/traces",
+		client: &http.Client{Timeout: OTLP_EXPORT_TIMEOUT},
+	}
+}
+
+func (e *OTLPExporter) Export(spans []*Span) error {
+	body, err := json.Marshal(makeOTLPRequest(spans))
+	if err != nil {
+		return err
+	}
+	resp, err := e.client.Post(e.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	ioutil.ReadAll(resp.Body)
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("OTLP collector returned %v", resp.Status)
+	}
+	return nil
+}
+
+// makeOTLPRequest groups spans by the service that recorded them.
+func makeOTLPRequest(spans []*Span) *otlpRequest {
+	byService := make(map[string][]otlpSpan)
+	services := make([]string, 0)
+	for _, s := range spans {
+		if _, ok := byService[s.Service]; !ok {
+			services = append(services, s.Service)
+		}
+		byService[s.Service] = append(byService[s.Service], makeOTLPSpan(s))
+	}
+
+	req := &otlpRequest{ResourceSpans: make([]otlpResourceSpans, 0, len(services))}
+	for _, service := range services {
+		req.ResourceSpans = append(req.ResourceSpans, otlpResourceSpans{
+			Resource: otlpResource{
+				Attributes: []otlpAttribute{makeOTLPAttribute("service.name", service)},
+			},
+			ScopeSpans: []otlpScopeSpans{{
+				Scope: otlpScope{Name: "github.com/fission/fission/tracing"},
+				Spans: byService[service],
+			}},
+		})
+	}
+	return req
+}
+
+func makeOTLPSpan(s *Span) otlpSpan {
+	keys := make([]string, 0, len(s.Attributes))
+	for k := range s.Attributes {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	attrs := make([]otlpAttribute, 0, len(keys))
+	for _, k := range keys {
+		attrs = append(attrs, makeOTLPAttribute(k, s.Attributes[k]))
+	}
+
+	os := otlpSpan{
+		TraceId:           s.TraceId,
+		SpanId:            s.SpanId,
+		ParentSpanId:      s.ParentId,
+		Name:              s.Name,
+		Kind:              otlpSpanKindInternal,
+		StartTimeUnixNano: strconv.FormatInt(s.Start.UnixNano(), 10),
+		EndTimeUnixNano:   strconv.FormatInt(s.End.UnixNano(), 10),
+		Attributes:        attrs,
+	}
+	if len(s.Error) > 0 {
+		os.Status = otlpStatus{Code: otlpStatusError, Message: s.Error}
+	}
+	return os
+}
+
+func makeOTLPAttribute(key, value string) otlpAttribute {
+	return otlpAttribute{Key: key, Value: otlpValue{StringValue: value}}
+}
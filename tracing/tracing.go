@@ -0,0 +1,367 @@
+/*
+Copyright 2016 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package tracing records spans of work done for a request across
+// fission's services, and propagates trace context between them (and
+// to functions) with the W3C traceparent header.
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// W3C trace context header, e.g.
+	// "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	HEADER_TRACEPARENT = "traceparent"
+
+	// Environment variables configuring the exporter
+	ENV_EXPORTER      = "FISSION_TRACE_EXPORTER"      // "otlp", "log" or unset
+	ENV_OTLP_ENDPOINT = "OTEL_EXPORTER_OTLP_ENDPOINT" // e.g. "http://otel-collector:4318"
+
+	DEFAULT_OTLP_ENDPOINT = "http://localhost:4318"
+
+	// Finished spans are exported in batches of up to
+	// EXPORT_BATCH_SIZE, at least every EXPORT_INTERVAL.  Spans
+	// are dropped if more than MAX_QUEUED_SPANS are waiting.
+	EXPORT_BATCH_SIZE = 100
+	EXPORT_INTERVAL   = 5 * time.Second
+	MAX_QUEUED_SPANS  = 2048
+)
+
+const flagSampled = 0x01
+
+type (
+	// SpanContext identifies a span within a trace.
+	SpanContext struct {
+		TraceId string `json:"traceId"` // 32 lowercase hex digits
+		SpanId  string `json:"spanId"`  // 16 lowercase hex digits
+		Sampled bool   `json:"-"`
+	}
+
+	// Span is a timed piece of work.  Spans are safe for
+	// concurrent use.
+	Span struct {
+		tracer *Tracer
+		lock   sync.Mutex
+		ended  bool
+
+		SpanContext
+		ParentId   string            `json:"parentId,omitempty"`
+		Name       string            `json:"name"`
+		Service    string            `json:"service"`
+		Start      time.Time         `json:"start"`
+		End        time.Time         `json:"end"`
+		Attributes map[string]string `json:"attributes,omitempty"`
+		Error      string            `json:"error,omitempty"`
+	}
+
+	// Exporter sends finished spans to a tracing backend.
+	Exporter interface {
+		Export(spans []*Span) error
+	}
+
+	// Tracer creates spans for a service and exports them in the
+	// background.
+	Tracer struct {
+		service  string
+		exporter Exporter // nil if spans are only propagated
+		spans    chan *Span
+	}
+
+	spanKey       struct{}
+	remoteSpanKey struct{}
+)
+
+// Until Init is called, trace context is propagated but spans aren't
+// exported.
+var (
+	defaultTracerLock sync.RWMutex
+	defaultTracer     = MakeTracer("", nil)
+)
+
+// MakeTracer creates a tracer for a service.  With a nil exporter,
+// spans are created and their context propagated, but they aren't
+// recorded anywhere.
+func MakeTracer(service string, exporter Exporter) *Tracer {
+	t := &Tracer{
+		service:  service,
+		exporter: exporter,
+	}
+	if exporter != nil {
+		t.spans = make(chan *Span, MAX_QUEUED_SPANS)
+		go t.exportLoop()
+	}
+	return t
+}
+
+// Init sets the tracer used by StartSpan.
+func Init(service string, exporter Exporter) {
+	defaultTracerLock.Lock()
+	defer defaultTracerLock.Unlock()
+	defaultTracer = MakeTracer(service, exporter)
+}
+
+// InitFromEnv sets up tracing for a service with the exporter named
+// by FISSION_TRACE_EXPORTER: "otlp" to send spans to an OTLP/HTTP
+// collector at OTEL_EXPORTER_OTLP_ENDPOINT, or "log" to write them
+// to stdout.
+func InitFromEnv(service string) {
+	var exporter Exporter
+	switch strings.ToLower(os.Getenv(ENV_EXPORTER)) {
+	case "":
+	case "otlp":
+		endpoint := os.Getenv(ENV_OTLP_ENDPOINT)
+		if len(endpoint) == 0 {
+			endpoint = DEFAULT_OTLP_ENDPOINT
+		}
+		exporter = MakeOTLPExporter(endpoint)
+		log.Printf("Exporting traces to %v", endpoint)
+	case "log":
+		exporter = MakeLogExporter(os.Stdout)
+		log.Printf("Logging traces to stdout")
+	default:
+		log.Printf("Unknown trace exporter '%v', not exporting traces", os.Getenv(ENV_EXPORTER))
+	}
+	Init(service, exporter)
+}
+
+// StartSpan starts a span with the default tracer.
+func StartSpan(ctx context.Context, name string) (*Span, context.Context) {
+	defaultTracerLock.RLock()
+	t := defaultTracer
+	defaultTracerLock.RUnlock()
+	return t.StartSpan(ctx, name)
+}
+
+// StartSpan starts a span as a child of the span in ctx, or of the
+// remote span extracted into ctx, or else a new trace.  It returns
+// the span and a context carrying it; callers must Finish the span.
+func (t *Tracer) StartSpan(ctx context.Context, name string) (*Span, context.Context) {
+	span := &Span{
+		tracer:     t,
+		Name:       name,
+		Service:    t.service,
+		Start:      time.Now(),
+		Attributes: make(map[string]string),
+	}
+	span.SpanId = randomHex(8)
+
+	if parent, ok := parentContext(ctx); ok {
+		span.TraceId = parent.TraceId
+		span.ParentId = parent.SpanId
+		span.Sampled = parent.Sampled
+	} else {
+		span.TraceId = randomHex(16)
+		span.Sampled = t.exporter != nil
+	}
+	return span, context.WithValue(ctx, spanKey{}, span)
+}
+
+func parentContext(ctx context.Context) (SpanContext, bool) {
+	if span := FromContext(ctx); span != nil {
+		return span.SpanContext, true
+	}
+	sc, ok := ctx.Value(remoteSpanKey{}).(SpanContext)
+	return sc, ok
+}
+
+// FromContext returns the span in ctx, or nil.
+func FromContext(ctx context.Context) *Span {
+	span, _ := ctx.Value(spanKey{}).(*Span)
+	return span
+}
+
+// SetAttribute records a key-value pair describing the span.  It's
+// a no-op on a nil or finished span.
+func (s *Span) SetAttribute(key string, value interface{}) {
+	if s == nil {
+		return
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.ended {
+		return
+	}
+	s.Attributes[key] = fmt.Sprintf("%v", value)
+}
+
+// SetError marks the span as failed.  It's a no-op on a nil or
+// finished span, or a nil error.
+func (s *Span) SetError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.ended {
+		return
+	}
+	s.Error = err.Error()
+}
+
+// Finish ends the span and queues it for export.  Only the first
+// call has any effect, and the span can't be changed after it.
+func (s *Span) Finish() {
+	if s == nil {
+		return
+	}
+	s.lock.Lock()
+	if s.ended {
+		s.lock.Unlock()
+		return
+	}
+	s.ended = true
+	s.End = time.Now()
+	s.lock.Unlock()
+	s.tracer.export(s)
+}
+
+// Traceparent returns the span's context as a traceparent header
+// value.
+func (sc SpanContext) Traceparent() string {
+	flags := 0
+	if sc.Sampled {
+		flags = flagSampled
+	}
+	return fmt.Sprintf("00-%v-%v-%02x", sc.TraceId, sc.SpanId, flags)
+}
+
+// ParseTraceparent parses a traceparent header value.  Versions other
+// than 00 are accepted as long as they start with the 00 fields, as
+// the spec requires.
+func ParseTraceparent(s string) (SpanContext, error) {
+	sc := SpanContext{}
+	parts := strings.Split(strings.TrimSpace(s), "-")
+	if len(parts) < 4 {
+		return sc, fmt.Errorf("invalid traceparent '%v'", s)
+	}
+	version, traceId, spanId, flags := parts[0], parts[1], parts[2], parts[3]
+	if !isHex(version, 2) || version == "ff" || (version == "00" && len(parts) != 4) ||
+		!isHex(traceId, 32) || !isHex(spanId, 16) || !isHex(flags, 2) ||
+		traceId == strings.Repeat("0", 32) || spanId == strings.Repeat("0", 16) {
+		return sc, fmt.Errorf("invalid traceparent '%v'", s)
+	}
+	flagBytes, _ := hex.DecodeString(flags)
+	sc.TraceId = traceId
+	sc.SpanId = spanId
+	sc.Sampled = flagBytes[0]&flagSampled != 0
+	return sc, nil
+}
+
+// Extract returns a context carrying the remote span identified by
+// the traceparent header, if it has a valid one, so that spans
+// started from it continue the caller's trace.
+func Extract(ctx context.Context, header http.Header) context.Context {
+	tp := header.Get(HEADER_TRACEPARENT)
+	if len(tp) == 0 {
+		return ctx
+	}
+	sc, err := ParseTraceparent(tp)
+	if err != nil {
+		return ctx
+	}
+	return context.WithValue(ctx, remoteSpanKey{}, sc)
+}
+
+// Inject sets the traceparent header to the span in ctx, so that the
+// recipient's spans become its children.  An invalid traceparent is
+// dropped rather than forwarded.
+func Inject(ctx context.Context, header http.Header) {
+	sc, ok := parentContext(ctx)
+	if !ok {
+		header.Del(HEADER_TRACEPARENT)
+		return
+	}
+	header.Set(HEADER_TRACEPARENT, sc.Traceparent())
+}
+
+func (t *Tracer) export(s *Span) {
+	if t.exporter == nil || !s.Sampled {
+		return
+	}
+	select {
+	case t.spans <- s:
+	default:
+		// don't slow down requests if the exporter can't keep up
+	}
+}
+
+// exportLoop batches finished spans and exports them.
+func (t *Tracer) exportLoop() {
+	ticker := time.NewTicker(EXPORT_INTERVAL)
+	defer ticker.Stop()
+
+	batch := make([]*Span, 0, EXPORT_BATCH_SIZE)
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		err := t.exporter.Export(batch)
+		if err != nil {
+			log.Printf("Failed to export %v spans: %v", len(batch), err)
+		}
+		batch = make([]*Span, 0, EXPORT_BATCH_SIZE)
+	}
+	for {
+		select {
+		case s := <-t.spans:
+			batch = append(batch, s)
+			if len(batch) >= EXPORT_BATCH_SIZE {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		}
+	}
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	for {
+		_, err := rand.Read(b)
+		if err != nil {
+			panic(fmt.Sprintf("failed to generate span id: %v", err))
+		}
+		// all-zero ids are invalid
+		for _, c := range b {
+			if c != 0 {
+				return hex.EncodeToString(b)
+			}
+		}
+	}
+}
+
+func isHex(s string, n int) bool {
+	if len(s) != n {
+		return false
+	}
+	for _, c := range s {
+		if !(c >= '0' && c <= '9' || c >= 'a' && c <= 'f') {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,207 @@
+/*
+Copyright 2016 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestTraceparent(t *testing.T) {
+	tp := "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	sc, err := ParseTraceparent(tp)
+	if err != nil {
+		t.Fatalf("Failed to parse %v: %v", tp, err)
+	}
+	if sc.TraceId != "4bf92f3577b34da6a3ce929d0e0e4736" || sc.SpanId != "00f067aa0ba902b7" || !sc.Sampled {
+		t.Fatalf("Wrong span context: %v", sc)
+	}
+	if sc.Traceparent() != tp {
+		t.Fatalf("Expected %v, got %v", tp, sc.Traceparent())
+	}
+
+	// later versions may add fields
+	_, err = ParseTraceparent("01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00-extra")
+	if err != nil {
+		t.Fatalf("Failed to parse future version: %v", err)
+	}
+
+	invalid := []string{
+		"",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra",
+		"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e47-00f067aa0ba902b7-01",
+	}
+	for _, s := range invalid {
+		_, err := ParseTraceparent(s)
+		if err == nil {
+			t.Errorf("Expected error parsing '%v'", s)
+		}
+	}
+}
+
+type testExporter struct {
+	spans chan *Span
+}
+
+func (e *testExporter) Export(spans []*Span) error {
+	for _, s := range spans {
+		e.spans <- s
+	}
+	return nil
+}
+
+func TestPropagation(t *testing.T) {
+	tracer := MakeTracer("test", nil)
+
+	// continue a remote trace
+	header := http.Header{}
+	header.Set(HEADER_TRACEPARENT, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	parent, ctx := tracer.StartSpan(Extract(context.Background(), header), "parent")
+	if parent.TraceId != "4bf92f3577b34da6a3ce929d0e0e4736" || parent.ParentId != "00f067aa0ba902b7" {
+		t.Fatalf("Span didn't continue remote trace: %v %v", parent.TraceId, parent.ParentId)
+	}
+	child, ctx := tracer.StartSpan(ctx, "child")
+	if child.TraceId != parent.TraceId || child.ParentId != parent.SpanId {
+		t.Fatalf("Span isn't a child of its parent")
+	}
+
+	out := http.Header{}
+	Inject(ctx, out)
+	if out.Get(HEADER_TRACEPARENT) != child.Traceparent() {
+		t.Fatalf("Expected traceparent %v, got %v", child.Traceparent(), out.Get(HEADER_TRACEPARENT))
+	}
+
+	// invalid traceparents start a new trace and aren't forwarded
+	header.Set(HEADER_TRACEPARENT, "garbage")
+	span, ctx := tracer.StartSpan(Extract(context.Background(), header), "root")
+	if len(span.ParentId) != 0 || span.TraceId == parent.TraceId {
+		t.Fatalf("Invalid traceparent wasn't ignored")
+	}
+	out.Set(HEADER_TRACEPARENT, "garbage")
+	Inject(context.Background(), out)
+	if len(out.Get(HEADER_TRACEPARENT)) != 0 {
+		t.Fatalf("Traceparent without a span wasn't removed")
+	}
+}
+
+func TestExport(t *testing.T) {
+	exporter := &testExporter{spans: make(chan *Span, 10)}
+	tracer := MakeTracer("test", exporter)
+
+	span, _ := tracer.StartSpan(context.Background(), "op")
+	span.SetAttribute("pod", "p1")
+	span.SetError(errors.New("failed"))
+	span.Finish()
+	span.SetAttribute("late", "ignored")
+	span.Finish()
+
+	// batches are flushed when full or on a timer; fill one up
+	for i := 1; i < EXPORT_BATCH_SIZE; i++ {
+		s, _ := tracer.StartSpan(context.Background(), "filler")
+		s.Finish()
+	}
+	got := <-exporter.spans
+	if got.Name != "op" || got.Service != "test" || got.Attributes["pod"] != "p1" || got.Error != "failed" {
+		t.Fatalf("Wrong span exported: %+v", got)
+	}
+	if _, ok := got.Attributes["late"]; ok {
+		t.Fatalf("Span changed after it finished")
+	}
+
+	// unsampled traces aren't exported
+	header := http.Header{}
+	header.Set(HEADER_TRACEPARENT, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00")
+	unsampled, _ := tracer.StartSpan(Extract(context.Background(), header), "unsampled")
+	if unsampled.Sampled {
+		t.Fatalf("Sampled flag not propagated")
+	}
+}
+
+func TestLogExporter(t *testing.T) {
+	var buf bytes.Buffer
+	span, _ := MakeTracer("test", nil).StartSpan(context.Background(), "op")
+	span.Finish()
+
+	err := MakeLogExporter(&buf).Export([]*Span{span, span})
+	if err != nil {
+		t.Fatalf("Export failed: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("Expected 2 lines, got %v", len(lines))
+	}
+	m := make(map[string]interface{})
+	err = json.Unmarshal([]byte(lines[0]), &m)
+	if err != nil {
+		t.Fatalf("Invalid JSON %v: %v", lines[0], err)
+	}
+	if m["traceId"] != span.TraceId || m["spanId"] != span.SpanId || m["name"] != "op" {
+		t.Fatalf("Wrong span logged: %v", lines[0])
+	}
+}
+
+func TestOTLPExporter(t *testing.T) {
+	bodies := make(chan []byte, 1)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/traces" {
+			http.Error(w, "not found", 404)
+			return
+		}
+		body, _ := ioutil.ReadAll(r.Body)
+		bodies <- body
+	}))
+	defer ts.Close()
+
+	span, _ := MakeTracer("fission-router", nil).StartSpan(context.Background(), "router.serve")
+	span.SetAttribute("function", "hello")
+	span.Finish()
+
+	err := MakeOTLPExporter(ts.URL + "/").Export([]*Span{span})
+	if err != nil {
+		t.Fatalf("Export failed: %v", err)
+	}
+
+	req := otlpRequest{}
+	err = json.Unmarshal(<-bodies, &req)
+	if err != nil {
+		t.Fatalf("Invalid OTLP request: %v", err)
+	}
+	if len(req.ResourceSpans) != 1 || len(req.ResourceSpans[0].ScopeSpans) != 1 {
+		t.Fatalf("Wrong OTLP request: %+v", req)
+	}
+	rs := req.ResourceSpans[0]
+	if rs.Resource.Attributes[0].Value.StringValue != "fission-router" {
+		t.Fatalf("Wrong service name: %+v", rs.Resource)
+	}
+	s := rs.ScopeSpans[0].Spans[0]
+	if s.TraceId != span.TraceId || s.SpanId != span.SpanId || s.Name != "router.serve" ||
+		s.Attributes[0].Key != "function" || s.Attributes[0].Value.StringValue != "hello" {
+		t.Fatalf("Wrong OTLP span: %+v", s)
+	}
+}
@@ -16,6 +16,8 @@ limitations under the License.
 
 package fission
 
+import "time"
+
 type (
 	// Metadata is used as the general identifier for all kinds of
 	// resources managed by the controller.
@@ -31,26 +33,378 @@ type (
 		Metadata    `json:"metadata"`
 		Environment Metadata `json:"environment"`
 		Code        string   `json:"code"`
+
+		// Configuration passed to the function when its
+		// container is specialized.  Secrets and ConfigMaps
+		// are names of Kubernetes objects in the function
+		// namespace; all their keys are exposed to the
+		// function as environment variables.  Only these
+		// references are stored by the controller, never the
+		// secret contents.  EnvVars take precedence over keys
+		// from Secrets and ConfigMaps.
+		EnvVars    []EnvVar `json:"envVars,omitempty"`
+		Secrets    []string `json:"secrets,omitempty"`
+		ConfigMaps []string `json:"configMaps,omitempty"`
+
+		// Resources and Timeout (the maximum execution time
+		// of one call, in seconds) override the defaults of
+		// the function's environment.
+		Resources Resources `json:"resources"`
+		Timeout   int       `json:"timeout,omitempty"`
+	}
+
+	// EnvVar is an environment variable set in a function's
+	// container.
+	EnvVar struct {
+		Name  string `json:"name"`
+		Value string `json:"value"`
 	}
 
 	// Environment identifies the language and OS specific
-	// resources that a function depends on.  For now this
-	// includes only the function run container image.  Later,
-	// this will also include build containers, as well as support
-	// tools like debuggers, profilers, etc.
+	// resources that a function depends on: the function run
+	// container image and, optionally, a builder image that
+	// compiles function source into a deployable package.
+	// Later, this will also include support tools like
+	// debuggers, profilers, etc.
 	Environment struct {
 		Metadata             `json:"metadata"`
 		RunContainerImageUrl string `json:"runContainerImageUrl"`
+		BuilderImageUrl      string `json:"builderImageUrl,omitempty"`
+
+		// Defaults for functions using this environment.
+		Resources Resources `json:"resources"`
+		Timeout   int       `json:"timeout,omitempty"`
+
+		// Pool of generic pods for this environment.  Zero
+		// values use the poolmgr defaults.  Times are in
+		// seconds.
+		PoolSize        int `json:"poolSize,omitempty"`        // number of idle pods
+		PodReadyTimeout int `json:"podReadyTimeout,omitempty"` // max wait for a ready pod
+		IdlePodReapTime int `json:"idlePodReapTime,omitempty"` // specialized pods idle this long are deleted
+
+		// Runtime container settings.  ImagePullPolicy is
+		// one of Always, IfNotPresent (the default) or Never;
+		// ImagePullSecrets are names of Kubernetes secrets in
+		// the function namespace.
+		ImagePullPolicy  string   `json:"imagePullPolicy,omitempty"`
+		ImagePullSecrets []string `json:"imagePullSecrets,omitempty"`
+		ContainerEnvVars []EnvVar `json:"containerEnvVars,omitempty"`
+	}
+
+	// Resources are the CPU and memory requests and limits of a
+	// function container, as Kubernetes quantities (e.g. "250m",
+	// "128Mi").  Empty fields are unset.
+	Resources struct {
+		CpuRequest    string `json:"cpuRequest,omitempty"`
+		CpuLimit      string `json:"cpuLimit,omitempty"`
+		MemoryRequest string `json:"memoryRequest,omitempty"`
+		MemoryLimit   string `json:"memoryLimit,omitempty"`
+	}
+
+	// Build compiles function source into a deployable package
+	// with the builder image of the function's environment.  A
+	// successful build creates (or updates) Function with the
+	// package as its code; Function.Uid is then the new version.
+	// Source is the base64-encoded source, sent only when the
+	// build is created; it is never returned.
+	Build struct {
+		Metadata `json:"metadata"`
+		Function Function    `json:"function"`
+		Source   string      `json:"source,omitempty"`
+		Status   BuildStatus `json:"status"`
+		Log      string      `json:"log,omitempty"`
+		// Update is true if the build makes a new version of an
+		// existing function; otherwise it creates the function,
+		// which mustn't exist.
+		Update bool `json:"update,omitempty"`
+	}
+
+	BuildStatus string
+
+	// PoolStatus describes a pool of generic pods kept by poolmgr
+	// for an environment.  When an environment is updated, a pool
+	// for the new version is created and the old version's pool
+	// is draining until its pods are gone.
+	PoolStatus struct {
+		Environment     Metadata  `json:"environment"`
+		Resources       Resources `json:"resources"`
+		State           PoolState `json:"state"`
+		Replicas        int       `json:"replicas"`        // idle pods wanted
+		ReadyReplicas   int       `json:"readyReplicas"`   // idle pods ready
+		SpecializedPods int       `json:"specializedPods"` // pods running functions
+	}
+
+	PoolState string
+
+	// FunctionServices lists the addresses of the instances
+	// (specialized pods) poolmgr runs for a function version.
+	FunctionServices struct {
+		Function  Metadata `json:"function"`
+		Addresses []string `json:"addresses"`
+	}
+
+	// FunctionServiceRequest asks poolmgr for the instances of a
+	// function.  InFlight is the number of requests the caller
+	// has outstanding to the function; poolmgr adds instances if
+	// it is high.
+	FunctionServiceRequest struct {
+		Function Metadata `json:"function"`
+		InFlight int      `json:"inFlight"`
+	}
+
+	// RouteInfo is a route of a router.  Trigger is empty for the
+	// internal route of each function.
+	RouteInfo struct {
+		Trigger    string   `json:"trigger,omitempty"`
+		Host       string   `json:"host,omitempty"`
+		UrlPattern string   `json:"urlPattern,omitempty"`
+		PathPrefix string   `json:"pathPrefix,omitempty"`
+		Methods    []string `json:"methods,omitempty"` // any method if empty
+		Function   Metadata `json:"function"`          // resolved version
+	}
+
+	// RouteTable lists a router's routes, in the order it tries
+	// them, and the generation of its route table.
+	RouteTable struct {
+		Generation uint64      `json:"generation"`
+		Routes     []RouteInfo `json:"routes"`
+	}
+
+	// RouteResolution is the route a router would use for a
+	// request.  Route is nil if no route matched, or if the
+	// request matched a route that isn't for a function, such as
+	// the default handler of "/".
+	RouteResolution struct {
+		Generation uint64     `json:"generation"`
+		Matched    bool       `json:"matched"`
+		Route      *RouteInfo `json:"route,omitempty"`
+	}
+
+	// CachePurge is the result of purging a trigger's cached
+	// responses from a router.
+	CachePurge struct {
+		Trigger string `json:"trigger"`
+		Purged  int    `json:"purged"`
+	}
+
+	// RouterFunctionServices is a router's cache of the instances
+	// of a function version.
+	RouterFunctionServices struct {
+		Function  Metadata  `json:"function"`
+		Addresses []string  `json:"addresses"`
+		Created   time.Time `json:"created"`
+		LastUsed  time.Time `json:"lastUsed"`
+	}
+
+	// ServiceEvictions lists the addresses of function services
+	// poolmgr removed after sequence number Since, so that routers
+	// stop sending requests to them.  Reset is set if some
+	// evictions are no longer known (or poolmgr restarted), in
+	// which case routers should forget all their services.
+	ServiceEvictions struct {
+		Since     uint64   `json:"since"`
+		Seq       uint64   `json:"seq"`
+		Addresses []string `json:"addresses"`
+		Reset     bool     `json:"reset,omitempty"`
 	}
 
 	// HTTPTrigger maps URL patterns to functions.  Function.UID
 	// is optional; if absent, the latest version of the function
 	// will automatically be selected.
+	//
+	// A trigger matches either the path template UrlPattern or
+	// any path under PathPrefix, optionally only for requests to
+	// Host (which may be a template too, e.g.
+	// "{tenant}.example.com").  Methods lists the HTTP methods
+	// matched, or "ANY"; triggers that only set Method match that
+	// single method, and triggers with neither match any method.
+	//
+	// RateLimit, if set, limits the requests each router accepts
+	// for the trigger.  Auth, if set, makes the router reject
+	// requests without valid credentials.  Shadow, if set, mirrors
+	// requests to another function version.  Retry, if set, has
+	// the router retry failed requests, and Fallback is used when
+	// the function still fails.
 	HTTPTrigger struct {
 		Metadata   `json:"metadata"`
-		UrlPattern string   `json:"urlpattern"`
-		Method     string   `json:"method"`
-		Function   Metadata `json:"function"`
+		UrlPattern string          `json:"urlpattern"`
+		Method     string          `json:"method"`
+		Methods    []string        `json:"methods,omitempty"`
+		Host       string          `json:"host,omitempty"`
+		PathPrefix string          `json:"pathPrefix,omitempty"`
+		RateLimit  *RateLimit      `json:"rateLimit,omitempty"`
+		Auth       *AuthPolicy     `json:"auth,omitempty"`
+		CORS       *CORSPolicy     `json:"cors,omitempty"`
+		Cache      *CachePolicy    `json:"cache,omitempty"`
+		Shadow     *ShadowPolicy   `json:"shadow,omitempty"`
+		Retry      *RetryPolicy    `json:"retry,omitempty"`
+		Fallback   *FallbackPolicy `json:"fallback,omitempty"`
+		Function   Metadata        `json:"function"`
+	}
+
+	// RetryPolicy is how the router retries requests to a
+	// trigger's function that fail with a retryable status: 502 and
+	// 503 (the function couldn't be reached or started) and 504
+	// (it timed out) by default.  Request bodies are buffered so
+	// they can be sent again; requests with larger bodies than the
+	// router buffers are only tried once.
+	RetryPolicy struct {
+		// Attempts in all, including the first
+		MaxAttempts int `json:"maxAttempts"`
+		// Delay before the first retry, doubled for each one
+		// after up to MaxBackoffMs; defaults to 100ms and 5s
+		InitialBackoffMs int `json:"initialBackoffMs,omitempty"`
+		MaxBackoffMs     int `json:"maxBackoffMs,omitempty"`
+		// Response statuses retried, if not the defaults
+		RetryOn []int `json:"retryOn,omitempty"`
+		// Unless set, only requests with idempotent methods
+		// (GET, HEAD, OPTIONS, TRACE, PUT and DELETE) are retried
+		RetryNonIdempotent bool `json:"retryNonIdempotent,omitempty"`
+	}
+
+	// FallbackPolicy is how the router responds when a trigger's
+	// function can't be started, times out or responds with a 5xx
+	// status: with another function, or with a static response.
+	// Requests with bodies too large to buffer for the fallback
+	// function, and connection upgrades, get the function's error.
+	FallbackPolicy struct {
+		// Function called with the failed request; the latest
+		// version if its Uid is empty.  If nil, the router
+		// responds with the static response below.
+		Function *Metadata `json:"function,omitempty"`
+		// Static response; StatusCode defaults to 503 and
+		// ContentType to "text/plain; charset=utf-8"
+		StatusCode  int    `json:"statusCode,omitempty"`
+		ContentType string `json:"contentType,omitempty"`
+		Body        string `json:"body,omitempty"`
+	}
+
+	// ShadowPolicy has the router mirror a sample of a trigger's
+	// requests to another function, usually a new version of the
+	// trigger's function, to see how it handles real traffic.
+	// The shadow's responses are discarded; routers only compare
+	// their status and latency with the function's.
+	ShadowPolicy struct {
+		// Shadow function; the latest version if Uid is empty
+		Function Metadata `json:"function"`
+		// Fraction of requests mirrored, more than 0 and up to 1
+		SampleRate float64 `json:"sampleRate"`
+	}
+
+	// ShadowStats compares the responses of a trigger's function
+	// and its shadow to the requests a router mirrored, since
+	// Since (when the router started mirroring between these
+	// versions).
+	ShadowStats struct {
+		Trigger  string    `json:"trigger"`
+		Function Metadata  `json:"function"`
+		Shadow   Metadata  `json:"shadow"`
+		Since    time.Time `json:"since"`
+		// Requests both functions responded to
+		Mirrored int64 `json:"mirrored"`
+		// Sampled requests that weren't mirrored, because their
+		// body was too large or too many were in flight
+		Dropped       int64 `json:"dropped"`
+		StatusMatches int64 `json:"statusMatches"`
+		// Requests with different statuses, by function and
+		// shadow status, e.g. "200->500"
+		StatusMismatches map[string]int64 `json:"statusMismatches,omitempty"`
+		FunctionLatency  LatencyStats     `json:"functionLatency"`
+		ShadowLatency    LatencyStats     `json:"shadowLatency"`
+	}
+
+	LatencyStats struct {
+		MeanMs float64 `json:"meanMs"`
+		MaxMs  float64 `json:"maxMs"`
+	}
+
+	// CachePolicy has the router cache a trigger's responses to GET
+	// requests.  Responses are cached separately for each host,
+	// path, query and authenticated client, and for each value of
+	// VaryHeaders.  The function's Cache-Control header overrides
+	// TTL, or prevents caching.
+	CachePolicy struct {
+		// Seconds responses are cached for
+		TTL int `json:"ttl"`
+		// Request headers responses depend on, e.g.
+		// "Accept-Language"
+		VaryHeaders []string `json:"varyHeaders,omitempty"`
+		// Query parameters responses depend on; if empty, they
+		// depend on the whole query string.
+		VaryQuery []string `json:"varyQuery,omitempty"`
+	}
+
+	// CORSPolicy lets browsers call an HTTP trigger from other
+	// origins.  The router answers preflight requests itself, and
+	// adds the CORS headers to the function's responses.
+	CORSPolicy struct {
+		// Origins allowed, e.g. "https://app.example.com" or
+		// "https://*.example.com", or "*" for any origin.
+		AllowOrigins []string `json:"allowOrigins"`
+		// Methods allowed; defaults to the trigger's methods.
+		AllowMethods []string `json:"allowMethods,omitempty"`
+		// Request headers allowed, or "*" for any.
+		AllowHeaders []string `json:"allowHeaders,omitempty"`
+		// Response headers scripts can read, besides the
+		// CORS-safelisted ones.
+		ExposeHeaders []string `json:"exposeHeaders,omitempty"`
+		// Whether requests may carry cookies or HTTP auth; not
+		// allowed with the "*" origin.
+		AllowCredentials bool `json:"allowCredentials,omitempty"`
+		// Seconds browsers may cache a preflight response; zero
+		// leaves it to the browser.
+		MaxAge int `json:"maxAge,omitempty"`
+	}
+
+	// AuthPolicy is how clients of an HTTP trigger authenticate:
+	// with an API key (AUTH_TYPE_APIKEY) or a JWT bearer token
+	// (AUTH_TYPE_JWT).
+	AuthPolicy struct {
+		Type string `json:"type"`
+
+		// Names of the APIKeys accepted; empty accepts any key.
+		APIKeys []string `json:"apiKeys,omitempty"`
+		// Request header carrying the API key; defaults to
+		// X-Api-Key.
+		APIKeyHeader string `json:"apiKeyHeader,omitempty"`
+
+		JWT *JWTPolicy `json:"jwt,omitempty"`
+	}
+
+	// JWTPolicy validates tokens signed with one of the keys at
+	// JWKSUrl (RS256/384/512, ES256/384/512), or with the shared
+	// Secret (HS256/384/512).  Expiry and not-before times are
+	// always checked.
+	JWTPolicy struct {
+		JWKSUrl  string `json:"jwksUrl,omitempty"`
+		Secret   string `json:"secret,omitempty"`
+		Issuer   string `json:"issuer,omitempty"`   // required "iss", if set
+		Audience string `json:"audience,omitempty"` // required "aud", if set
+		// Claims that must have the given values; for array
+		// claims (e.g. "groups"), the value must be an element.
+		RequiredClaims map[string]string `json:"requiredClaims,omitempty"`
+	}
+
+	// APIKey is a credential for HTTP triggers with an API key
+	// auth policy.  Only a SHA-256 hash of the key is stored:
+	// clients set Value when creating or rotating it, and it's
+	// never returned.
+	APIKey struct {
+		Metadata    `json:"metadata"`
+		Value       string `json:"value,omitempty"`
+		KeyHash     string `json:"keyHash,omitempty"` // hex SHA-256 of Value
+		Description string `json:"description,omitempty"`
+	}
+
+	// RateLimit is a token bucket: clients may make Burst requests
+	// at once, refilled at RequestsPerSecond.  Requests are counted
+	// per client, as identified by Key; clients over the limit get
+	// 429 Too Many Requests.
+	RateLimit struct {
+		RequestsPerSecond float64 `json:"requestsPerSecond"`
+		Burst             int     `json:"burst,omitempty"`  // defaults to RequestsPerSecond, rounded up
+		Key               string  `json:"key,omitempty"`    // one of RATE_LIMIT_KEY_*; defaults to client IP
+		Header            string  `json:"header,omitempty"` // request header identifying clients, for RATE_LIMIT_KEY_HEADER
 	}
 
 	// Watch is a specification of Kubernetes watch along with a URL to post events to.
@@ -76,6 +430,18 @@ type (
 	errorCode int
 )
 
+const (
+	BuildStatusPending   BuildStatus = "pending"
+	BuildStatusRunning   BuildStatus = "running"
+	BuildStatusSucceeded BuildStatus = "succeeded"
+	BuildStatusFailed    BuildStatus = "failed"
+)
+
+const (
+	PoolStateActive   PoolState = "active"
+	PoolStateDraining PoolState = "draining"
+)
+
 const (
 	ErrorInternal = iota
 